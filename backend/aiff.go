@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// ErrAIFFUnsupported is wrapped by the DecodeAIFF error for a well-formed
+// AIFF file in an encoding it does not decode.
+var ErrAIFFUnsupported = errors.New("aiff: unsupported encoding")
+
+// isAIFF reports whether b starts with an AIFF or AIFF-C file header.
+func isAIFF(b []byte) bool {
+	return len(b) >= 12 && string(b[0:4]) == "FORM" && (string(b[8:12]) == "AIFF" || string(b[8:12]) == "AIFC")
+}
+
+// aifcFloat32Name is the compression name EncodeAIFF gives float samples.
+const aifcFloat32Name = "32-bit floating point"
+
+// aifcVersion is the FVER timestamp of the AIFF-C specification.
+const aifcVersion = 0xA2805140
+
+// DecodeAIFF decodes an AIFF or AIFF-C file from r into interleaved
+// samples in [-1.0, +1.0] and a header giving its sample rate, channel
+// count and sample width. AIFF-C files may hold big-endian ("NONE",
+// "twos") or little-endian ("sowt") integer PCM, or 32- or 64-bit float
+// ("fl32", "fl64"); other compression types wrap ErrAIFFUnsupported.
+func DecodeAIFF(r io.Reader) ([]float64, *WAVHeader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("aiff: %w", err)
+	}
+	if !isAIFF(data) {
+		return nil, nil, errors.New("aiff: not an AIFF file")
+	}
+	aifc := string(data[8:12]) == "AIFC"
+
+	var comm, ssnd []byte
+	for off := 12; off+8 <= len(data); {
+		id := string(data[off : off+4])
+		size := int(binary.BigEndian.Uint32(data[off+4 : off+8]))
+		body := data[off+8:]
+		if size > len(body) {
+			if id != "SSND" {
+				return nil, nil, fmt.Errorf("aiff: %q chunk overruns the file", id)
+			}
+			size = len(body) // truncated sound data
+		}
+		switch id {
+		case "COMM":
+			comm = body[:size]
+		case "SSND":
+			ssnd = body[:size]
+		}
+		off += 8 + size + size%2
+	}
+	if comm == nil || len(comm) < 18 {
+		return nil, nil, errors.New("aiff: missing COMM chunk")
+	}
+	if ssnd == nil || len(ssnd) < 8 {
+		return nil, nil, errors.New("aiff: missing SSND chunk")
+	}
+
+	header := &WAVHeader{
+		NumChannels:   int(binary.BigEndian.Uint16(comm[0:2])),
+		BitsPerSample: int(binary.BigEndian.Uint16(comm[6:8])),
+		SampleRate:    int(math.Round(decodeExtended(comm[8:18]))),
+	}
+	if header.NumChannels < 1 {
+		return nil, nil, errors.New("aiff: invalid channel count 0")
+	}
+	if header.SampleRate <= 0 {
+		return nil, nil, fmt.Errorf("aiff: invalid sample rate %d", header.SampleRate)
+	}
+	compression := "NONE"
+	if aifc {
+		if len(comm) < 22 {
+			return nil, nil, errors.New("aiff: COMM chunk too short for AIFF-C")
+		}
+		compression = string(comm[18:22])
+	}
+
+	// Convert the sound data to the little-endian layout decodeSamples
+	// reads: swap byte order, and shift 8-bit samples from signed to
+	// unsigned. Samples narrower than their container are left-justified,
+	// so decode at the container width.
+	decode := WAVHeader{NumChannels: header.NumChannels}
+	swap := true
+	switch compression {
+	case "NONE", "twos":
+	case "sowt":
+		swap = false
+	case "fl32", "FL32":
+		decode.Float, header.Float, header.BitsPerSample = true, true, 32
+	case "fl64", "FL64":
+		decode.Float, header.Float, header.BitsPerSample = true, true, 64
+	default:
+		return nil, nil, fmt.Errorf("%w %q", ErrAIFFUnsupported, compression)
+	}
+	decode.BitsPerSample = (header.BitsPerSample + 7) / 8 * 8
+	if !decode.Float && !slices.Contains(decodableBits, decode.BitsPerSample) {
+		return nil, nil, fmt.Errorf("%w: %d bits per sample", ErrAIFFUnsupported, header.BitsPerSample)
+	}
+
+	offset := int(binary.BigEndian.Uint32(ssnd[0:4]))
+	if offset > len(ssnd)-8 {
+		return nil, nil, errors.New("aiff: SSND offset beyond its data")
+	}
+	width := decode.BitsPerSample / 8
+	sound := slices.Clone(ssnd[8+offset:])
+	sound = sound[:len(sound)-len(sound)%(width*header.NumChannels)]
+	for i := 0; i < len(sound); i += width {
+		if swap {
+			slices.Reverse(sound[i : i+width])
+		}
+		if width == 1 {
+			sound[i] ^= 0x80
+		}
+	}
+	return decodeSamples(sound, &decode), header, nil
+}
+
+// EncodeAIFF encodes interleaved samples of numChannels channels as an
+// AIFF file in the given sample format, or as AIFF-C for Float32.
+// Integer formats clamp samples to [-1.0, +1.0].
+func EncodeAIFF(samples []float64, sampleRate, numChannels int, format SampleFormat) ([]byte, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("aiff: invalid sample rate %d", sampleRate)
+	}
+	if numChannels < 1 {
+		return nil, fmt.Errorf("aiff: invalid channel count %d", numChannels)
+	}
+	if len(samples)%numChannels != 0 {
+		return nil, fmt.Errorf("aiff: %d samples do not divide into %d channels", len(samples), numChannels)
+	}
+	// Encode as WAV and convert the samples to big-endian, with 8-bit
+	// samples signed.
+	sound := encodeWAV(samples, sampleRate, numChannels, format)[format.headerSize():]
+	width := format.BitsPerSample() / 8
+	for i := 0; i < len(sound); i += width {
+		slices.Reverse(sound[i : i+width])
+		if width == 1 {
+			sound[i] ^= 0x80
+		}
+	}
+	return encodeAIFFChunks(sound, sampleRate, numChannels, format), nil
+}
+
+// encodeAIFFChunks wraps big-endian sound data in an AIFF file.
+func encodeAIFFChunks(sound []byte, sampleRate, numChannels int, format SampleFormat) []byte {
+	form := "AIFF"
+	comm := binary.BigEndian.AppendUint16(nil, uint16(numChannels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(len(sound)/numChannels/(format.BitsPerSample()/8)))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(format.BitsPerSample()))
+	comm = appendExtended(comm, uint64(sampleRate))
+	if format.isFloat() {
+		form = "AIFC"
+		comm = append(comm, "fl32"...)
+		comm = append(comm, byte(len(aifcFloat32Name)))
+		comm = append(comm, aifcFloat32Name...) // even with its count byte
+	}
+
+	b := make([]byte, 12, aiffSize(len(sound), format))
+	copy(b, "FORM")
+	copy(b[8:], form)
+	if format.isFloat() {
+		b = append(b, "FVER"...)
+		b = binary.BigEndian.AppendUint32(b, 4)
+		b = binary.BigEndian.AppendUint32(b, aifcVersion)
+	}
+	b = append(b, "COMM"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(comm)))
+	b = append(b, comm...)
+	b = append(b, "SSND"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(8+len(sound)))
+	b = append(b, make([]byte, 8)...) // offset and block size
+	b = append(b, sound...)
+	if len(sound)%2 != 0 {
+		b = append(b, 0) // padding byte
+	}
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	return b
+}
+
+// aiffSize returns the size of the file EncodeAIFF produces for
+// soundBytes bytes of samples.
+func aiffSize(soundBytes int, format SampleFormat) int {
+	size := 12 + 8 + 18 + 8 + 8 + soundBytes + soundBytes%2
+	if format.isFloat() {
+		size += 12 + 4 + 1 + len(aifcFloat32Name)
+	}
+	return size
+}
+
+// decodeExtended decodes an 80-bit IEEE 754 extended precision number,
+// as AIFF stores sample rates.
+func decodeExtended(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b[0:2]))
+	mantissa := binary.BigEndian.Uint64(b[2:10])
+	v := math.Ldexp(float64(mantissa), exp&0x7FFF-16383-63)
+	if exp&0x8000 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// appendExtended appends n as an 80-bit IEEE 754 extended precision
+// number.
+func appendExtended(b []byte, n uint64) []byte {
+	if n == 0 {
+		return append(b, make([]byte, 10)...)
+	}
+	shift := 64 - bits.Len64(n)
+	b = binary.BigEndian.AppendUint16(b, uint16(16383+63-shift))
+	return binary.BigEndian.AppendUint64(b, n<<shift)
+}
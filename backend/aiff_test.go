@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// aiffFile builds an AIFF-C file holding sound, with the given COMM fields.
+func aiffFile(channels, bits, sampleRate int, compression string, sound []byte) []byte {
+	comm := binary.BigEndian.AppendUint16(nil, uint16(channels))
+	comm = binary.BigEndian.AppendUint32(comm, uint32(len(sound)/channels/((bits+7)/8)))
+	comm = binary.BigEndian.AppendUint16(comm, uint16(bits))
+	comm = appendExtended(comm, uint64(sampleRate))
+	comm = append(comm, compression...)
+	comm = append(comm, 0, 0) // empty name, padded
+	b := append([]byte("FORM\x00\x00\x00\x00AIFC"), "COMM"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(comm)))
+	b = append(b, comm...)
+	b = append(b, "SSND"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(8+len(sound)))
+	b = append(b, make([]byte, 8)...)
+	b = append(b, sound...)
+	binary.BigEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	return b
+}
+
+func TestAIFFRoundtrip(t *testing.T) {
+	stereo := pseudoNoise(2*1001, 7, 0.9)
+	for _, f := range sampleFormatNames {
+		// The samples come back as they do from the same WAV file.
+		r, err := NewWAVReader(bytes.NewReader(encodeWAV(stereo, 44100, 2, f.format)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := r.ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := EncodeAIFF(stereo, 44100, 2, f.format)
+		if err != nil {
+			t.Fatalf("%s: EncodeAIFF: %v", f.name, err)
+		}
+		if len(data) != aiffSize(len(stereo)*f.format.BitsPerSample()/8, f.format) {
+			t.Fatalf("%s: %d bytes, aiffSize says %d", f.name, len(data), aiffSize(len(stereo)*f.format.BitsPerSample()/8, f.format))
+		}
+		got, header, err := DecodeAIFF(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: DecodeAIFF: %v", f.name, err)
+		}
+		wantHeader := WAVHeader{SampleRate: 44100, NumChannels: 2, BitsPerSample: f.format.BitsPerSample(), Float: f.format.isFloat()}
+		if *header != wantHeader || !slices.Equal(got, want) {
+			t.Fatalf("%s: roundtrip differs: header %+v", f.name, header)
+		}
+	}
+}
+
+func TestDecodeAIFFC(t *testing.T) {
+	// Little-endian 16-bit and left-justified 12-bit samples.
+	for name, tc := range map[string]struct {
+		bits        int
+		compression string
+		sound       []byte
+	}{
+		"sowt": {16, "sowt", []byte{0x00, 0x40, 0x00, 0xC0}},
+		"12":   {12, "NONE", []byte{0x40, 0x00, 0xC0, 0x00}},
+	} {
+		got, header, err := DecodeAIFF(bytes.NewReader(aiffFile(1, tc.bits, 22050, tc.compression, tc.sound)))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if header.SampleRate != 22050 || header.BitsPerSample != tc.bits || !slices.Equal(got, []float64{0.5, -0.5}) {
+			t.Fatalf("%s: got %v at %d Hz, %d bits", name, got, header.SampleRate, header.BitsPerSample)
+		}
+	}
+
+	_, _, err := DecodeAIFF(bytes.NewReader(aiffFile(1, 16, 8000, "ima4", make([]byte, 68))))
+	if !errors.Is(err, ErrAIFFUnsupported) {
+		t.Fatalf("ima4: expected ErrAIFFUnsupported, got %v", err)
+	}
+	if _, _, err := DecodeAIFF(bytes.NewReader(WriteWAV(make([]float64, 10), 8000))); err == nil {
+		t.Fatal("expected an error for a WAV file")
+	}
+}
+
+func TestHandleDenoiseAIFF(t *testing.T) {
+	wav := toneWAV(16000, 0.5)
+	samples, _, err := ReadWAV(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aiff, err := EncodeAIFF(samples, 16000, 1, PCM16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	convert := func(method string, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+		fields["passthrough"] = "1"
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, method, "/denoise", data, fields))
+		return rec
+	}
+	// An AIFF upload converts to the same WAV as the WAV it was made from.
+	if got, want := convert(http.MethodPost, aiff, map[string]string{}), convert(http.MethodPost, wav, map[string]string{}); !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+		t.Fatalf("AIFF upload converts differently from the same WAV (%d)", got.Code)
+	}
+
+	rec := convert(http.MethodPost, wav, map[string]string{"out_format": "aiff", "out_bits": "24"})
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/aiff" {
+		t.Fatalf("expected 200 audio/aiff, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	got, header, err := DecodeAIFF(rec.Body)
+	if err != nil || header.BitsPerSample != 24 || !slices.Equal(got, samples) {
+		t.Fatalf("AIFF output: %v, header %+v", err, header)
+	}
+	head := convert(http.MethodHead, wav, map[string]string{"out_format": "aiff", "out_bits": "24"})
+	if size := head.Header().Get("Content-Length"); size != strconv.Itoa(aiffSize(len(samples)*3, PCM24)) {
+		t.Fatalf("HEAD Content-Length %s", size)
+	}
+
+	if rec := convert(http.MethodPost, wav, map[string]string{"out_format": "mp3"}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("out_format=mp3: expected 400, got %d", rec.Code)
+	}
+	if rec := convert(http.MethodPost, wav, map[string]string{"out_format": "opus"}); rec.Code != http.StatusNotImplemented {
+		t.Fatalf("out_format=opus: expected 501, got %d", rec.Code)
+	}
+}
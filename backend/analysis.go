@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// DominantFrequency returns the frequency in Hz of the strongest spectral
+// peak in samples. The signal is Hann-windowed, zero-padded to a power of 2
+// and transformed; the peak bin is refined by parabolic interpolation of the
+// log magnitudes of its neighbors, giving sub-bin accuracy.
+// Returns 0 for empty or silent input.
+func DominantFrequency(samples []float64, sampleRate int) float64 {
+	if len(samples) == 0 || sampleRate <= 0 {
+		return 0
+	}
+
+	size := NextPowerOf2(len(samples))
+	frame := make([]float64, size)
+	copy(frame, samples)
+	applyWindow(frame[:len(samples)], HannWindow(len(samples)))
+
+	mag := magnitude(FFT(realToComplex(frame)))
+
+	// Search positive frequencies, skipping DC.
+	peak := 0
+	for k := 1; k < size/2; k++ {
+		if peak == 0 || mag[k] > mag[peak] {
+			peak = k
+		}
+	}
+	if peak == 0 || mag[peak] < 1e-12 {
+		return 0
+	}
+
+	// Parabolic interpolation on log magnitude.
+	offset := 0.0
+	if peak > 1 && peak < size/2-1 {
+		a := math.Log(mag[peak-1] + 1e-20)
+		b := math.Log(mag[peak] + 1e-20)
+		c := math.Log(mag[peak+1] + 1e-20)
+		if denom := a - 2*b + c; denom != 0 {
+			offset = 0.5 * (a - c) / denom
+		}
+	}
+
+	return (float64(peak) + offset) * float64(sampleRate) / float64(size)
+}
+
+// SpectralFlatness returns the mean spectral flatness (Wiener entropy) of
+// samples: the ratio of geometric to arithmetic mean of the power spectrum,
+// averaged over Hann-windowed frames of frameSize with 50% overlap. Values
+// near 1 indicate a flat, noise-like spectrum; values near 0 indicate
+// tonal peaks or deep holes. DC and Nyquist are excluded.
+func SpectralFlatness(samples []float64, frameSize int) float64 {
+	if frameSize < 4 || !isPowerOf2(frameSize) || len(samples) < frameSize {
+		return 0
+	}
+	window := HannWindow(frameSize)
+
+	var total float64
+	frames := 0
+	for start := 0; start+frameSize <= len(samples); start += frameSize / 2 {
+		frame := extractFrame(samples, start, frameSize)
+		applyWindow(frame, window)
+		mag := magnitude(FFT(realToComplex(frame)))
+
+		var logSum, sum float64
+		bins := frameSize/2 - 1
+		for k := 1; k <= bins; k++ {
+			p := mag[k]*mag[k] + 1e-20
+			logSum += math.Log(p)
+			sum += p
+		}
+		total += math.Exp(logSum/float64(bins)) / (sum / float64(bins))
+		frames++
+	}
+	return total / float64(frames)
+}
+
+// MusicalNoiseScore measures how much of the "musical noise" left by
+// spectral subtraction samples contain: isolated time-frequency peaks that
+// sound like brief tones. It is the kurtosis of the power spectrum over
+// Hann-windowed frames of frameSize with 50% overlap, after each bin is
+// scaled to unit mean power so the noise's color does not count. Gaussian
+// noise scores about 9; musical noise, being sparse, scores higher. DC and
+// Nyquist are excluded. Returns 0 if there are too few samples or only
+// silence.
+func MusicalNoiseScore(samples []float64, frameSize int) float64 {
+	if frameSize < 4 || !isPowerOf2(frameSize) || len(samples) < frameSize {
+		return 0
+	}
+	window := HannWindow(frameSize)
+
+	var powers [][]float64
+	for start := 0; start+frameSize <= len(samples); start += frameSize / 2 {
+		frame := extractFrame(samples, start, frameSize)
+		applyWindow(frame, window)
+		mag := magnitude(FFT(realToComplex(frame)))
+		p := make([]float64, frameSize/2-1)
+		for k := range p {
+			p[k] = mag[k+1] * mag[k+1]
+		}
+		powers = append(powers, p)
+	}
+
+	// Central moments of the unit-mean powers, pooled over all bins.
+	var m2, m4 float64
+	count := 0
+	for k := range powers[0] {
+		var mean float64
+		for _, p := range powers {
+			mean += p[k] / float64(len(powers))
+		}
+		if mean == 0 {
+			continue
+		}
+		for _, p := range powers {
+			d := p[k]/mean - 1
+			m2 += d * d
+			m4 += d * d * d * d
+			count++
+		}
+	}
+	if m2 == 0 {
+		return 0
+	}
+	return m4 * float64(count) / (m2 * m2)
+}
+
+const (
+	// bandwidthFloorDB is how far below the strongest bin a bin's mean
+	// power may fall and still count toward the effective bandwidth.
+	bandwidthFloorDB = -60
+	// dcHeavyFraction is the DC fraction above which an input counts as
+	// DC-heavy.
+	dcHeavyFraction = 0.1
+	// bandlimitedFraction is the fraction of the Nyquist frequency below
+	// which an input's effective bandwidth counts as band-limited.
+	bandlimitedFraction = 0.5
+	// adaptHighPassHz is the high-pass cutoff AdaptToInput applies to
+	// DC-heavy input.
+	adaptHighPassHz = 20
+)
+
+// InputAnalysis describes properties of an input that affect how it
+// should be denoised.
+type InputAnalysis struct {
+	// SampleRate is the rate the input was analyzed at.
+	SampleRate int `json:"sample_rate"`
+	// EffectiveBandwidthHz is the highest frequency with content within
+	// 60 dB of the strongest; a file upsampled from a lower rate reports
+	// roughly the original Nyquist frequency.
+	EffectiveBandwidthHz float64 `json:"effective_bandwidth_hz"`
+	// DCFraction is the fraction of the input's power in its DC offset.
+	DCFraction float64 `json:"dc_fraction"`
+}
+
+// DCHeavy reports whether a significant part of the input's power is DC.
+func (a InputAnalysis) DCHeavy() bool {
+	return a.DCFraction > dcHeavyFraction
+}
+
+// Bandlimited reports whether the input's content stops well short of the
+// Nyquist frequency.
+func (a InputAnalysis) Bandlimited() bool {
+	return a.EffectiveBandwidthHz < bandlimitedFraction*float64(a.SampleRate)/2
+}
+
+// AnalyzeInput estimates the effective bandwidth and DC fraction of
+// samples. The bandwidth comes from the mean power spectrum of
+// Hann-windowed FrameSize frames (or one zero-padded frame for shorter
+// input) with the DC offset removed.
+func AnalyzeInput(samples []float64, sampleRate int) InputAnalysis {
+	a := InputAnalysis{SampleRate: sampleRate}
+	if len(samples) == 0 || sampleRate <= 0 {
+		return a
+	}
+
+	var mean, power float64
+	for _, s := range samples {
+		mean += s
+		power += s * s
+	}
+	mean /= float64(len(samples))
+	power /= float64(len(samples))
+	if power < 1e-20 {
+		return a // silence
+	}
+	a.DCFraction = mean * mean / power
+
+	frameSize := FrameSize
+	if len(samples) < frameSize {
+		frameSize = NextPowerOf2(len(samples))
+	}
+	centered := make([]float64, max(len(samples), frameSize))
+	for i, s := range samples {
+		centered[i] = s - mean
+	}
+	window := HannWindow(frameSize)
+
+	half := frameSize / 2
+	spectrum := make([]float64, half+1)
+	for start := 0; start+frameSize <= len(centered); start += frameSize / 2 {
+		frame := extractFrame(centered, start, frameSize)
+		applyWindow(frame, window)
+		mag := magnitude(FFT(realToComplex(frame)))
+		for k := range spectrum {
+			spectrum[k] += mag[k] * mag[k]
+		}
+	}
+
+	var peak float64
+	for _, p := range spectrum[1:] {
+		peak = math.Max(peak, p)
+	}
+	if peak == 0 {
+		return a // DC only
+	}
+	threshold := peak * math.Pow(10, bandwidthFloorDB/10.0)
+	top := 0
+	for k := half; k > 0; k-- {
+		if spectrum[k] >= threshold {
+			top = k
+			break
+		}
+	}
+	a.EffectiveBandwidthHz = float64(top) * float64(sampleRate) / float64(frameSize)
+	return a
+}
+
+// AdaptToInput returns a copy of c adjusted for an input with the given
+// analysis: DC-heavy input gets a high-pass (unless one is already set),
+// and band-limited input gets frames twice as long, so the occupied band
+// keeps its frequency resolution.
+func (c DenoiseConfig) AdaptToInput(a InputAnalysis) DenoiseConfig {
+	if a.DCHeavy() && c.HighPassHz == 0 {
+		c.HighPassHz = adaptHighPassHz
+	}
+	if a.Bandlimited() {
+		c.FrameSize *= 2
+		c.HopSize *= 2
+	}
+	return c
+}
+
+// ComputePeaks splits samples into buckets equal-length ranges and returns
+// the [min, max] of each, for drawing a waveform overview. buckets is
+// capped at len(samples); empty input or buckets < 1 yields nil.
+func ComputePeaks(samples []float64, buckets int) [][2]float64 {
+	n := len(samples)
+	if n == 0 || buckets < 1 {
+		return nil
+	}
+	if buckets > n {
+		buckets = n
+	}
+
+	peaks := make([][2]float64, buckets)
+	for b := range peaks {
+		start := b * n / buckets
+		end := (b + 1) * n / buckets
+		lo, hi := samples[start], samples[start]
+		for _, s := range samples[start+1 : end] {
+			lo = math.Min(lo, s)
+			hi = math.Max(hi, s)
+		}
+		peaks[b] = [2]float64{lo, hi}
+	}
+	return peaks
+}
+
+// CrossCorrelate returns the lag in [-maxLag, maxLag] at which b best
+// matches a, i.e. that maximizes sum a[i]*b[i+lag]: positive when b is a
+// delayed copy of a. The correlation is computed by FFT over the whole of
+// both signals. Returns 0 for empty input or a negative maxLag.
+func CrossCorrelate(a, b []float64, maxLag int) int {
+	if len(a) == 0 || len(b) == 0 || maxLag < 0 {
+		return 0
+	}
+
+	// Zero-padding to len(a)+len(b) keeps the circular correlation from
+	// wrapping onto itself.
+	size := NextPowerOf2(len(a) + len(b))
+	fa := make([]complex128, size)
+	fb := make([]complex128, size)
+	for i, v := range a {
+		fa[i] = complex(v, 0)
+	}
+	for i, v := range b {
+		fb[i] = complex(v, 0)
+	}
+	A, B := FFT(fa), FFT(fb)
+	for k := range A {
+		A[k] = cmplx.Conj(A[k]) * B[k]
+	}
+	corr := IFFT(A) // corr[lag mod size] = sum a[i]*b[i+lag]
+
+	best, bestLag := math.Inf(-1), 0
+	for lag := -min(maxLag, len(a)-1); lag <= min(maxLag, len(b)-1); lag++ {
+		if c := real(corr[(lag+size)%size]); c > best {
+			best, bestLag = c, lag
+		}
+	}
+	return bestLag
+}
+
+// alignTo returns x shifted earlier by lag samples (later for a negative
+// lag), zero-filled and truncated to n samples, so that a copy of a signal
+// delayed by lag lines up with the original.
+func alignTo(x []float64, lag, n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		if j := i + lag; j >= 0 && j < len(x) {
+			out[i] = x[j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDominantFrequency(t *testing.T) {
+	sampleRate := 44100
+	samples := make([]float64, sampleRate)
+	for i := range samples {
+		samples[i] = 0.8 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	got := DominantFrequency(samples, sampleRate)
+	t.Logf("dominant frequency: %.4f Hz", got)
+	if math.Abs(got-440) > 1 {
+		t.Fatalf("expected 440 Hz within 1 Hz, got %.4f", got)
+	}
+
+	if f := DominantFrequency(make([]float64, 1000), sampleRate); f != 0 {
+		t.Fatalf("expected 0 for silence, got %f", f)
+	}
+}
+
+func TestDenoisePreservesFrequency(t *testing.T) {
+	sampleRate := 48000
+	n := sampleRate * 2
+	noise := pseudoNoise(n, 777, 0.05)
+	samples := make([]float64, n)
+	for i := sampleRate / 2; i < n; i++ {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	for i := range samples {
+		samples[i] += noise[i]
+	}
+
+	cleaned := Denoise(samples, sampleRate)
+	got := DominantFrequency(cleaned[sampleRate/2:], sampleRate)
+	if math.Abs(got-440) > 1 {
+		t.Fatalf("denoised tone drifted: expected 440 Hz, got %.4f", got)
+	}
+}
+
+func TestSpectralFlatness(t *testing.T) {
+	noise := pseudoNoise(16384, 3, 0.5)
+	tone := make([]float64, 16384)
+	for i := range tone {
+		tone[i] = math.Sin(2 * math.Pi * 1000 * float64(i) / 44100)
+	}
+
+	noiseFlat := SpectralFlatness(noise, 1024)
+	toneFlat := SpectralFlatness(tone, 1024)
+	t.Logf("flatness: noise=%.3f, tone=%.3f", noiseFlat, toneFlat)
+	if noiseFlat < 0.4 || toneFlat > 0.1 {
+		t.Fatalf("unexpected flatness: noise=%.3f, tone=%.3f", noiseFlat, toneFlat)
+	}
+}
+
+// bandlimitedNoise returns n (a power of 2) samples of noise containing
+// only frequencies below cutoffHz.
+func bandlimitedNoise(n, sampleRate int, cutoffHz float64) []float64 {
+	spectrum := FFT(realToComplex(pseudoNoise(n, 5, 0.5)))
+	for k := 1; k < n/2; k++ {
+		if float64(k)*float64(sampleRate)/float64(n) > cutoffHz {
+			spectrum[k], spectrum[n-k] = 0, 0
+		}
+	}
+	spectrum[n/2] = 0
+	out := make([]float64, n)
+	for i, v := range IFFT(spectrum) {
+		out[i] = real(v)
+	}
+	return out
+}
+
+func TestMusicalNoiseScore(t *testing.T) {
+	sampleRate := 16000
+	noise := pseudoNoise(4*sampleRate, 3, 0.1)
+	gaussian := MusicalNoiseScore(noise, 512)
+	if gaussian < 7 || gaussian > 11 {
+		t.Fatalf("expected about 9 for plain noise, got %.2f", gaussian)
+	}
+
+	// Subtraction leaves sparse peaks behind.
+	// A fixed noise window keeps the score about subtraction rather than
+	// the rate-scaled default estimate.
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	cfg.NoiseFrames = 2 * NoiseFrames
+	cleaned, err := DenoiseWithConfig(noise, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	residual := MusicalNoiseScore(cleaned[2*sampleRate:3*sampleRate], 512)
+	t.Logf("musical noise score: noise %.2f, residual %.2f", gaussian, residual)
+	if residual < 2*gaussian {
+		t.Fatalf("expected the residual to score well above plain noise: %.2f vs %.2f", residual, gaussian)
+	}
+
+	if got := MusicalNoiseScore(make([]float64, 2048), 512); got != 0 {
+		t.Fatalf("expected 0 for silence, got %.2f", got)
+	}
+}
+
+func TestAnalyzeInputBandwidth(t *testing.T) {
+	sampleRate := 44100
+	a := AnalyzeInput(bandlimitedNoise(65536, sampleRate, 4000), sampleRate)
+	t.Logf("effective bandwidth %.0f Hz, DC fraction %.4f", a.EffectiveBandwidthHz, a.DCFraction)
+	if math.Abs(a.EffectiveBandwidthHz-4000) > 200 {
+		t.Fatalf("expected effective bandwidth ~4000 Hz, got %.0f", a.EffectiveBandwidthHz)
+	}
+	if !a.Bandlimited() || a.DCHeavy() {
+		t.Fatalf("expected band-limited, not DC-heavy: %+v", a)
+	}
+
+	full := AnalyzeInput(pseudoNoise(65536, 5, 0.5), sampleRate)
+	if full.EffectiveBandwidthHz < 20000 || full.Bandlimited() {
+		t.Fatalf("expected full-band noise to reach Nyquist, got %.0f Hz", full.EffectiveBandwidthHz)
+	}
+}
+
+func TestAnalyzeInputDCFraction(t *testing.T) {
+	sampleRate := 16000
+	samples := make([]float64, sampleRate)
+	for i := range samples {
+		// Power: 0.3² DC + 0.3²/2 tone, so DC holds 2/3.
+		samples[i] = 0.3 + 0.3*math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	a := AnalyzeInput(samples, sampleRate)
+	if math.Abs(a.DCFraction-2.0/3) > 0.01 || !a.DCHeavy() {
+		t.Fatalf("expected DC fraction ~0.667, got %.4f", a.DCFraction)
+	}
+
+	cfg := DefaultDenoiseConfig().AdaptToInput(a)
+	if cfg.HighPassHz != adaptHighPassHz {
+		t.Fatalf("expected a %d Hz high-pass for DC-heavy input, got %g", adaptHighPassHz, cfg.HighPassHz)
+	}
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mean float64
+	for _, s := range cleaned[FrameSize : len(samples)-FrameSize] {
+		mean += s
+	}
+	mean /= float64(len(samples) - 2*FrameSize)
+	if math.Abs(mean) > 0.01 {
+		t.Fatalf("expected the high-pass to remove the DC offset, mean %.4f", mean)
+	}
+}
+
+func TestComputePeaksBoundsSamples(t *testing.T) {
+	samples := pseudoNoise(10007, 42, 0.8)
+	for _, buckets := range []int{1, 7, 100, 10007, 20000} {
+		peaks := ComputePeaks(samples, buckets)
+		want := buckets
+		if want > len(samples) {
+			want = len(samples)
+		}
+		if len(peaks) != want {
+			t.Fatalf("buckets=%d: expected %d peaks, got %d", buckets, want, len(peaks))
+		}
+
+		// Every sample lies within its bucket's envelope, and each
+		// envelope edge is attained by some sample.
+		for b, p := range peaks {
+			start, end := b*len(samples)/len(peaks), (b+1)*len(samples)/len(peaks)
+			sawMin, sawMax := false, false
+			for _, s := range samples[start:end] {
+				if s < p[0] || s > p[1] {
+					t.Fatalf("buckets=%d bucket %d: sample %f outside [%f, %f]", buckets, b, s, p[0], p[1])
+				}
+				sawMin = sawMin || s == p[0]
+				sawMax = sawMax || s == p[1]
+			}
+			if !sawMin || !sawMax {
+				t.Fatalf("buckets=%d bucket %d: envelope [%f, %f] not tight", buckets, b, p[0], p[1])
+			}
+		}
+	}
+
+	if ComputePeaks(nil, 10) != nil {
+		t.Fatal("expected nil peaks for empty input")
+	}
+}
+
+func TestCrossCorrelateFindsDelay(t *testing.T) {
+	signal := pseudoNoise(8000, 21, 0.5)
+	for _, delay := range []int{0, 1, 37, 500, -120} {
+		delayed := alignTo(signal, -delay, len(signal))
+		if got := CrossCorrelate(signal, delayed, 1024); got != delay {
+			t.Fatalf("delay %d: detected lag %d", delay, got)
+		}
+
+		// Realigning the delayed copy recovers the original.
+		aligned := alignTo(delayed, delay, len(signal))
+		for i := max(0, -delay); i < len(signal)-max(0, delay); i++ {
+			if aligned[i] != signal[i] {
+				t.Fatalf("delay %d: sample %d misaligned", delay, i)
+			}
+		}
+	}
+
+	// A delay beyond maxLag is not found.
+	if got := CrossCorrelate(signal, alignTo(signal, -500, len(signal)), 100); got == 500 {
+		t.Fatal("lag found beyond maxLag")
+	}
+	if got := CrossCorrelate(nil, signal, 10); got != 0 {
+		t.Fatalf("expected 0 for empty input, got %d", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import "fmt"
+
+// Default adaptive filter parameters for CancelReference.
+const (
+	// DefaultReferenceTaps covers 16 ms at 16 kHz: a few metres of
+	// difference between the paths from the noise source to the two
+	// microphones.
+	DefaultReferenceTaps = 256
+	// DefaultReferenceStepSize adapts within a second or so while
+	// leaving little misadjustment noise.
+	DefaultReferenceStepSize = 0.05
+)
+
+// ReferenceConfig configures CancelReference.
+type ReferenceConfig struct {
+	// Taps is the length of the adaptive filter in samples, which bounds
+	// the delay and reverberation between the reference and primary
+	// microphones that it can model.
+	Taps int
+
+	// StepSize is the normalized LMS step size, in (0, 2). Larger values
+	// track a changing noise path faster but cancel less once converged,
+	// and let speech in the primary channel disturb the filter more.
+	StepSize float64
+}
+
+// DefaultReferenceConfig returns DefaultReferenceTaps and
+// DefaultReferenceStepSize.
+func DefaultReferenceConfig() ReferenceConfig {
+	return ReferenceConfig{Taps: DefaultReferenceTaps, StepSize: DefaultReferenceStepSize}
+}
+
+// Validate checks that the filter length is positive and the step size
+// in the range where the filter converges.
+func (c ReferenceConfig) Validate() error {
+	if c.Taps < 1 {
+		return fmt.Errorf("denoise: reference taps %d must be positive", c.Taps)
+	}
+	if c.StepSize <= 0 || c.StepSize >= 2 {
+		return fmt.Errorf("denoise: reference step size %g must be in (0, 2)", c.StepSize)
+	}
+	return nil
+}
+
+// referenceRegularization keeps the NLMS update finite while the
+// reference is silent.
+const referenceRegularization = 1e-6
+
+// CancelReference removes from primary the noise it shares with reference,
+// a second microphone pointed at the noise source (a fan or air
+// conditioner, say), and returns the result. A normalized LMS filter
+// learns the path from the reference to the primary microphone sample by
+// sample and subtracts the noise it predicts, so it tracks a noise path
+// that changes as people move. Speech that reaches the reference
+// microphone is cancelled along with the noise, so it should pick up as
+// little of the talker as possible. The inputs must be the same length.
+func CancelReference(primary, reference []float64, cfg ReferenceConfig) ([]float64, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(primary) != len(reference) {
+		return nil, fmt.Errorf("denoise: primary and reference differ in length (%d and %d samples)", len(primary), len(reference))
+	}
+
+	taps := cfg.Taps
+	weights := make([]float64, taps)
+	// history holds the last taps reference samples twice over, so the
+	// newest taps of them are always contiguous: history[pos:pos+taps],
+	// newest first.
+	history := make([]float64, 2*taps)
+	pos := 0
+	energy := 0.0 // of the samples in the window
+
+	out := make([]float64, len(primary))
+	for n, d := range primary {
+		pos = (pos - 1 + taps) % taps
+		oldest := history[pos]
+		history[pos], history[pos+taps] = reference[n], reference[n]
+		energy = max(0, energy+reference[n]*reference[n]-oldest*oldest)
+		x := history[pos : pos+taps]
+
+		var y float64
+		for k, w := range weights {
+			y += w * x[k]
+		}
+		e := d - y
+		out[n] = e
+
+		g := cfg.StepSize * e / (energy + referenceRegularization)
+		for k := range weights {
+			weights[k] += g * x[k]
+		}
+	}
+	return out, nil
+}
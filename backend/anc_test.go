@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// referenceRecording returns a primary channel holding a tone plus the
+// noise of reference after an acoustic path of a delay and an echo, and
+// the reference, for 4 seconds at 16 kHz.
+func referenceRecording() (tone, primary, reference []float64) {
+	const sampleRate, n = 16000, 4 * 16000
+	reference = pseudoNoise(n, 7, 0.3)
+	tone = make([]float64, n)
+	primary = make([]float64, n)
+	for i := range primary {
+		tone[i] = 0.2 * math.Sin(2*math.Pi*440*float64(i)/sampleRate)
+		primary[i] = tone[i]
+		if i >= 5 {
+			primary[i] += 0.6 * reference[i-5]
+		}
+		if i >= 40 {
+			primary[i] -= 0.25 * reference[i-40]
+		}
+	}
+	return tone, primary, reference
+}
+
+func TestCancelReferenceRemovesCorrelatedNoise(t *testing.T) {
+	tone, primary, reference := referenceRecording()
+	out, err := CancelReference(primary, reference, DefaultReferenceConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(primary) {
+		t.Fatalf("got %d samples, want %d", len(out), len(primary))
+	}
+
+	// Once the filter has converged, over the last second, the noise left
+	// should be well below the noise that came in. The tone keeps the
+	// filter from settling exactly, so it does not cancel completely.
+	tail := len(out) - 16000
+	var noiseIn, noiseOut float64
+	for i := tail; i < len(out); i++ {
+		noiseIn += (primary[i] - tone[i]) * (primary[i] - tone[i])
+		noiseOut += (out[i] - tone[i]) * (out[i] - tone[i])
+	}
+	if reduction := 10 * math.Log10(noiseIn/noiseOut); reduction < 10 {
+		t.Fatalf("noise reduced by %.1f dB, want at least 10", reduction)
+	}
+}
+
+func TestCancelReferenceInvalid(t *testing.T) {
+	x := make([]float64, 100)
+	for name, tc := range map[string]struct {
+		primary, reference []float64
+		cfg                ReferenceConfig
+	}{
+		"length mismatch": {x, x[:50], DefaultReferenceConfig()},
+		"no taps":         {x, x, ReferenceConfig{Taps: 0, StepSize: 0.1}},
+		"step size 0":     {x, x, ReferenceConfig{Taps: 16, StepSize: 0}},
+		"step size 2":     {x, x, ReferenceConfig{Taps: 16, StepSize: 2}},
+	} {
+		if _, err := CancelReference(tc.primary, tc.reference, tc.cfg); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
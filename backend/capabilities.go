@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"math"
+	"slices"
+)
+
+// capabilities describes what the server accepts, for clients building
+// UIs: the input and output formats, and the range and default of every
+// denoise parameter. It is built from the tables the decoder and
+// DenoiseConfig.Validate check against, so it cannot go stale.
+func capabilities() map[string]any {
+	// The defaults, by JSON name.
+	var defaults map[string]any
+	b, _ := json.Marshal(DefaultDenoiseConfig())
+	json.Unmarshal(b, &defaults)
+
+	params := make(map[string]any, len(configRanges)+3)
+	for name, r := range configRanges {
+		p := r.describe()
+		p["default"] = defaults[name]
+		params[name] = p
+	}
+	params["floor_mode"] = map[string]any{"values": floorModes, "default": defaults["floor_mode"]}
+	params["normalize_mode"] = map[string]any{"values": normalizeModes, "default": defaults["normalize_mode"]}
+	params["window"] = map[string]any{"values": slices.Sorted(maps.Keys(windowRegistry)), "default": defaults["window"]}
+
+	outBits := make([]string, len(sampleFormatNames))
+	for i, f := range sampleFormatNames {
+		outBits[i] = f.name
+	}
+
+	channels := make([]string, len(channelSelectNames))
+	for i, c := range channelSelectNames {
+		channels[i] = c.name
+	}
+
+	dithers := make([]string, len(ditherNames))
+	for i, d := range ditherNames {
+		dithers[i] = d.name
+	}
+
+	return map[string]any{
+		"input": map[string]any{
+			"formats":               []string{"wav", "flac", "aiff"},
+			"encodings":             []string{"pcm", "float", string(ALaw), string(MuLaw), "ima_adpcm"},
+			"bits_per_sample":       decodableBits,
+			"float_bits_per_sample": decodableFloatBits,
+			"sample_rate":           map[string]any{"min": 1},
+			"channels":              map[string]any{"min": 1},
+			"raw":                   map[string]any{"bits": outBits, "endian": []string{"little", "big"}},
+			"max_upload_bytes":      maxUploadSize,
+			"max_duration_seconds":  maxAudioDuration.Seconds(),
+		},
+		"output": map[string]any{
+			"formats":  []string{"wav", "aiff", "flac"},
+			"out_bits": outBits,
+			"dither":   dithers,
+			"out_rate": map[string]any{"min": 1, "max": maxResampleRate},
+		},
+		"form": map[string]any{
+			"amount":       amountRange.describe(),
+			"channel":      channels,
+			"process_rate": map[string]any{"min": 1, "max": maxResampleRate},
+			"normalize":    normalizeModes,
+		},
+		"parameters": params,
+	}
+}
+
+// describe returns r as JSON fields: min and max, omitting an infinite
+// max, and min_exclusive and max_exclusive when a bound is excluded.
+func (r paramRange) describe() map[string]any {
+	d := map[string]any{"min": r.min}
+	if !math.IsInf(r.max, 1) {
+		d["max"] = r.max
+	}
+	if r.minOpen {
+		d["min_exclusive"] = true
+	}
+	if r.maxOpen {
+		d["max_exclusive"] = true
+	}
+	return d
+}
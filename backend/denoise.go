@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"math"
 	"math/cmplx"
+	"slices"
+	"time"
 )
 
 const (
@@ -18,7 +23,9 @@ const (
 	// NoiseFrames is the number of initial frames used to estimate
 	// the noise profile. The beginning of the recording is assumed
 	// to contain only background noise / silence.
-	// 10 frames * 1024 hop ≈ 230 ms at 44.1 kHz.
+	// 10 frames span 11264 samples, ≈ 255 ms at 44.1 kHz; Denoise
+	// rescales the count to cover the same time at other rates (see
+	// ForSampleRate).
 	NoiseFrames = 10
 
 	// SpectralFloor prevents magnitude bins from being driven to zero,
@@ -30,127 +37,772 @@ const (
 	// Subtracting more than the estimated noise compensates for
 	// estimation variance. Typical range: 1.0–4.0.
 	OverSubtract = 2.0
+
+	// ReferenceSampleRate is the rate the defaults above are tuned for.
+	ReferenceSampleRate = 44100
+
+	// MaxSamples caps the input length, bounding the memory a single
+	// call can allocate: one hour at 48 kHz.
+	MaxSamples = 60 * 60 * 48000
+
+	// PeakTarget is the output peak level for NormalizePeak, and the peak
+	// ceiling for NormalizeRMS.
+	PeakTarget = 0.95
+
+	// RMSTarget is the output RMS level for NormalizeRMS (-20 dBFS).
+	RMSTarget = 0.1
+
+	// minFrameSize is the smallest usable FrameSize: shorter windows are
+	// mostly taper and leave too few bins to separate voice from noise.
+	minFrameSize = 4
+
+	// defaultSeed is the default DenoiseConfig.Seed.
+	defaultSeed = 1
 )
 
-// Denoise performs spectral-subtraction noise cancellation on mono audio samples.
-// samples should be normalized to [-1.0, +1.0]. sampleRate is preserved for
-// potential future use but the algorithm is rate-independent.
-func Denoise(samples []float64, sampleRate int) []float64 {
-	n := len(samples)
-	if n == 0 {
-		return nil
+// WhiteningFilter is a precomputed whitening filter for
+// DenoiseConfig.Whitening: a positive gain for each bin 0..FrameSize/2,
+// typically the inverse of a measured noise magnitude spectrum. The gains
+// are applied to the signal and the noise estimate before the per-bin
+// gains are computed and undone afterwards, so the stages that work
+// across bins (the comfort-noise level, the adaptive floor's SNR) see
+// noise that is flat even when it is strongly colored.
+type WhiteningFilter struct {
+	Gains []float64 `json:"gains"`
+}
+
+// Output normalization modes accepted by DenoiseConfig.NormalizeMode.
+const (
+	// NormalizePeak scales the output so its peak is PeakTarget.
+	NormalizePeak = "peak"
+	// NormalizeRMS scales the output to RMSTarget, limited so the peak
+	// does not exceed PeakTarget. Gives consistent loudness across clips.
+	NormalizeRMS = "rms"
+	// NormalizeRolling applies a causal automatic gain control that steers
+	// the output towards RMSTarget using only the audio seen so far, with
+	// bounded gain changes, so it suits streaming where the global peak is
+	// never known. The peak never exceeds PeakTarget.
+	NormalizeRolling = "rolling"
+	// NormalizeNone leaves the output level as denoising left it.
+	NormalizeNone = "none"
+)
+
+// Spectral floor strategies accepted by DenoiseConfig.FloorMode.
+const (
+	// FloorConstant keeps SpectralFloor times each bin's own magnitude.
+	FloorConstant = "constant"
+	// FloorNoiseShaped keeps SpectralFloor times the bin's noise estimate,
+	// so the residual follows the noise spectrum.
+	FloorNoiseShaped = "noise"
+	// FloorComfortNoise fills floored bins with low-level random-phase
+	// noise at SpectralFloor times the average noise magnitude, so the
+	// result does not sound unnaturally dead.
+	FloorComfortNoise = "comfort"
+)
+
+// DenoiseConfig holds the tunable parameters of the spectral-subtraction
+// denoiser. Use DefaultDenoiseConfig and override individual fields.
+type DenoiseConfig struct {
+	// FrameSize is the FFT frame length in samples (power of 2).
+	FrameSize int `json:"frame_size"`
+	// HopSize is the step between consecutive frames in samples.
+	HopSize int `json:"hop_size"`
+	// NoiseFrames is the number of leading frames used for the noise estimate.
+	NoiseFrames int `json:"noise_frames"`
+	// SpectralFloor is the minimum fraction of each bin's magnitude retained
+	// (or of the noise magnitude, depending on FloorMode).
+	SpectralFloor float64 `json:"spectral_floor"`
+	// FloorMode selects how floored bins are filled (FloorConstant,
+	// FloorNoiseShaped or FloorComfortNoise).
+	FloorMode string `json:"floor_mode"`
+	// ShapeComfortNoise fills FloorComfortNoise bins at SpectralFloor times
+	// each bin's own noise estimate instead of the flat average, so the
+	// residual sounds like a faint copy of the original ambience rather
+	// than hiss.
+	ShapeComfortNoise bool `json:"shape_comfort_noise"`
+	// AdaptiveFloorMin and AdaptiveFloorMax, when AdaptiveFloorMax > 0,
+	// replace SpectralFloor with a per-frame floor that follows the
+	// frame's estimated SNR: AdaptiveFloorMin at or below
+	// adaptiveFloorLowSNRDB (more reduction in noise-only passages),
+	// AdaptiveFloorMax at or above adaptiveFloorHighSNRDB (more
+	// preservation while voiced), interpolated geometrically between
+	// (linearly if AdaptiveFloorMin is 0).
+	AdaptiveFloorMin float64 `json:"adaptive_floor_min"`
+	AdaptiveFloorMax float64 `json:"adaptive_floor_max"`
+	// OverSubtract is the over-subtraction factor (alpha).
+	OverSubtract float64 `json:"over_subtract"`
+	// SubtractionExponent is the exponent gamma of generalized spectral
+	// subtraction, cleanMag = (mag^gamma - alpha*noise^gamma)^(1/gamma):
+	// 1 subtracts magnitudes, 2 subtracts powers.
+	SubtractionExponent float64 `json:"subtraction_exponent"`
+	// GateBelowNoise sends bins whose magnitude is below alpha times the
+	// noise magnitude straight to the floor, and subtracts only the bins
+	// above it. With a SubtractionExponent above 1, plain subtraction lets
+	// through bins from sqrt-alpha times the noise up, which flicker in
+	// and out from frame to frame as musical noise; gating holds them at
+	// the floor. At an exponent of 1 the two coincide.
+	GateBelowNoise bool `json:"gate_below_noise"`
+	// NoiseSmoothingBins, when positive, smooths the noise estimate across
+	// frequency with a moving average over this many bins either side of
+	// each bin, so a spiky estimate from few noise frames does not carve
+	// deep notches into the output. This smooths the estimate only, not the
+	// spectrum being cleaned.
+	NoiseSmoothingBins int `json:"noise_smoothing_bins"`
+	// GainSmoothing is the weight (0..1) of the previous frame's gain in
+	// each bin's gain, smoothing it over time. 0 disables smoothing.
+	GainSmoothing float64 `json:"gain_smoothing"`
+	// MaskSmoothingFrames and MaskSmoothingBins smooth the gain mask over a
+	// time-by-frequency box ahead of GainSmoothing: each bin's gain becomes
+	// the mean, in dB, over this frame and the MaskSmoothingFrames-1
+	// before it, and MaskSmoothingBins bins either side. Isolated gain
+	// peaks, heard as musical noise, are flattened, while the sustained
+	// gain of voice passes. A zero (or for frames, one) disables that
+	// dimension.
+	MaskSmoothingFrames int `json:"mask_smoothing_frames"`
+	MaskSmoothingBins   int `json:"mask_smoothing_bins"`
+	// LookAheadMs, when positive, watches this far ahead for loud onsets
+	// (a hop-length block at least 12 dB louder than the one before) and
+	// suspends GainSmoothing from that far before each, so the smoothed
+	// gain is already open when a transient such as a cough or door slam
+	// arrives rather than clipping its start.
+	LookAheadMs float64 `json:"look_ahead_ms"`
+	// LowLatency marks a configuration tuned by WithLowLatency for
+	// near-real-time use. Validate rejects look-ahead, which would add
+	// delay, while it is set.
+	LowLatency bool `json:"low_latency"`
+	// WarmUp primes the stateful stages before output starts, so the
+	// first frames are already at steady-state quality: gain smoothing is
+	// run over the noise region first, and NormalizeRolling starts from
+	// the level of the opening rollingWindowMs instead of unity gain.
+	WarmUp bool `json:"warm_up"`
+	// Window selects the analysis/synthesis window (see NewWindow).
+	Window string `json:"window"`
+	// TukeyAlpha is the taper fraction used when Window is WindowTukey.
+	TukeyAlpha float64 `json:"tukey_alpha"`
+	// ConfidenceWeighting scales OverSubtract per bin by how stable the
+	// noise estimate was, so erratic bins are subtracted more cautiously.
+	ConfidenceWeighting bool `json:"confidence_weighting"`
+	// FadeInMs and FadeOutMs apply raised-cosine fades of this length to
+	// the start and end of the output. Zero disables the fade.
+	FadeInMs  float64 `json:"fade_in_ms"`
+	FadeOutMs float64 `json:"fade_out_ms"`
+	// NegligibleNoiseDB is the noise level (see DenoiseReport.NoiseDB)
+	// below which subtraction is skipped. Re-denoising already-cleaned
+	// audio would otherwise mistake the residual for noise.
+	NegligibleNoiseDB float64 `json:"negligible_noise_db"`
+	// NormalizeMode selects the output level normalization (NormalizePeak,
+	// NormalizeRMS, NormalizeRolling or NormalizeNone).
+	NormalizeMode string `json:"normalize_mode"`
+	// TruePeak measures the peak for NormalizePeak, and for NormalizeRMS's
+	// peak limit, at truePeakOversample times the sample rate, so peaks
+	// between samples also stay within PeakTarget once converted to
+	// analog, as broadcast delivery specs require.
+	TruePeak bool `json:"true_peak"`
+	// PreserveHeadroom replaces NormalizeMode: the output is never
+	// boosted, and is scaled down only as far as needed to keep its peak
+	// at or below the input's (after InputGainDB), so headroom left in
+	// the input survives while overshoot from denoising is still caught.
+	PreserveHeadroom bool `json:"preserve_headroom"`
+	// PhaseEstimate replaces the noisy phase of noise-dominated bins, those
+	// whose magnitude is less than PhaseSNRThresholdDB above the noise
+	// estimate, with the phase predicted from the previous frame (its
+	// output phase advanced by the bin's frequency over one hop).
+	// Signal-dominated bins keep their noisy phase.
+	PhaseEstimate       bool    `json:"phase_estimate"`
+	PhaseSNRThresholdDB float64 `json:"phase_snr_threshold_db"`
+	// ProcessBandLow and ProcessBandHigh limit spectral subtraction to the
+	// bins between these frequencies in Hz; bins outside pass through with
+	// unity gain, e.g. to clean hiss above 2 kHz without touching the body
+	// of the voice. A ProcessBandHigh of zero means no upper limit.
+	ProcessBandLow  float64 `json:"process_band_low"`
+	ProcessBandHigh float64 `json:"process_band_high"`
+	// Whitening, if set, moves subtraction into a whitened domain (see
+	// WhiteningFilter).
+	Whitening *WhiteningFilter `json:"whitening,omitempty"`
+	// PreserveDC excludes the input's DC offset from subtraction, so it
+	// passes through unchanged; remove DC explicitly with HighPassHz
+	// instead. It is ignored when HighPassHz is set.
+	PreserveDC bool `json:"preserve_dc"`
+	// HighPassHz removes all content below this frequency (including DC).
+	// Zero disables the high-pass.
+	HighPassHz float64 `json:"high_pass_hz"`
+	// Seed seeds every randomized stage (such as the comfort-noise phase),
+	// so the same input and configuration always produce the same output.
+	Seed int64 `json:"seed"`
+	// MaxSamples is the longest input, in samples, that will be processed;
+	// longer inputs are rejected with an error. Zero removes the limit.
+	MaxSamples int `json:"max_samples"`
+	// InputGainDB is applied to the input before framing, so quiet
+	// recordings reach the range the thresholds are tuned for. The gain is
+	// reduced if it would push the input peak past full scale.
+	InputGainDB float64 `json:"input_gain_db"`
+}
+
+// DefaultDenoiseConfig returns the configuration used by Denoise.
+func DefaultDenoiseConfig() DenoiseConfig {
+	return DenoiseConfig{
+		FrameSize:     FrameSize,
+		HopSize:       HopSize,
+		NoiseFrames:   NoiseFrames,
+		SpectralFloor: SpectralFloor,
+		FloorMode:     FloorConstant,
+		OverSubtract:  OverSubtract,
+		Window:        WindowHann,
+		TukeyAlpha:    0.5,
+
+		SubtractionExponent: 1,
+		Seed:                defaultSeed,
+		MaxSamples:          MaxSamples,
+		NegligibleNoiseDB:   -40,
+		NormalizeMode:       NormalizePeak,
 	}
+}
 
-	// If the audio is shorter than one frame, zero-pad it.
-	if n < FrameSize {
-		padded := make([]float64, FrameSize)
-		copy(padded, samples)
-		samples = padded
-		n = FrameSize
+// ForSampleRate returns a copy of c, assumed tuned for ReferenceSampleRate,
+// with its frame-counted parameters rescaled so they cover the same time at
+// sampleRate: NoiseFrames keeps the duration of the noise estimate, and
+// GainSmoothing its time constant. Frame and hop sizes are left alone.
+func (c DenoiseConfig) ForSampleRate(sampleRate int) DenoiseConfig {
+	if sampleRate <= 0 || c.HopSize <= 0 || c.NoiseFrames < 1 {
+		return c
 	}
+	ratio := float64(sampleRate) / ReferenceSampleRate
+	span := float64((c.NoiseFrames-1)*c.HopSize+c.FrameSize) * ratio
+	c.NoiseFrames = max(1, int(math.Round((span-float64(c.FrameSize))/float64(c.HopSize)))+1)
 
-	// How many frames fit?
-	totalFrames := (n-FrameSize)/HopSize + 1
-	if totalFrames < 1 {
-		totalFrames = 1
+	// Frames come ratio times as often, so the per-frame weight must
+	// decay ratio times more slowly.
+	if c.GainSmoothing > 0 {
+		c.GainSmoothing = math.Pow(c.GainSmoothing, 1/ratio)
 	}
+	return c
+}
 
-	// Cap noise frames to available frames.
-	noiseFrames := NoiseFrames
-	if noiseFrames > totalFrames {
-		noiseFrames = totalFrames
+// forRate returns c adapted to sampleRate by ForSampleRate if the fields
+// ForSampleRate rescales are still at their DefaultDenoiseConfig values, so
+// callers passing the defaults get the same durations at every rate.
+// Configurations that set any of them are used as given.
+func (c DenoiseConfig) forRate(sampleRate int) DenoiseConfig {
+	d := DefaultDenoiseConfig()
+	if c.FrameSize == d.FrameSize && c.HopSize == d.HopSize && c.NoiseFrames == d.NoiseFrames && c.GainSmoothing == d.GainSmoothing {
+		return c.ForSampleRate(sampleRate)
 	}
+	return c
+}
 
-	// Generate window once.
-	window := HannWindow(FrameSize)
+// WithAmount returns a copy of c with the subtraction parameters set from a
+// single "noise reduction amount" in 0..100 (clamped), for simple UIs:
+//
+//	OverSubtract  = 4 * a                (0 → none, 50 → 2, 100 → 4)
+//	SpectralFloor = 10^(-2a)             (0 → 1, 50 → 0.1, 100 → 0.01)
+//	GainSmoothing = 0.5 * a
+//
+// where a = amount/100. Amount 0 is a passthrough; higher amounts remove
+// more noise at the cost of more artifacts.
+func (c DenoiseConfig) WithAmount(amount float64) DenoiseConfig {
+	a := math.Max(0, math.Min(100, amount)) / 100
+	c.OverSubtract = 4 * a
+	c.SpectralFloor = math.Pow(10, -2*a)
+	c.GainSmoothing = 0.5 * a
+	return c
+}
 
-	// ---------------------------------------------------------------
-	// Step 1: Estimate noise magnitude spectrum from initial frames.
-	// ---------------------------------------------------------------
-	noiseMag := make([]float64, FrameSize)
+// Low-latency framing used by WithLowLatency.
+const (
+	lowLatencyFrameSize = 512
+	lowLatencyHopSize   = lowLatencyFrameSize / 4
+	lowLatencySmoothing = 0.2
+)
+
+// WithLowLatency returns a copy of c tuned for near-real-time use: 512-sample
+// frames with 75% overlap, no look-ahead, GainSmoothing capped at 0.2 so
+// the gain follows the signal quickly, and NormalizeRolling, which needs no
+// view of the whole signal. NoiseFrames is scaled so the noise estimate
+// still covers the same stretch of audio. The algorithmic latency (see
+// AlgorithmicLatency) is one frame, 512 samples (about 11.6 ms at 44.1 kHz,
+// against 46 ms for the default 2048), at the cost of coarser frequency
+// resolution and so somewhat more musical noise.
+func (c DenoiseConfig) WithLowLatency() DenoiseConfig {
+	span := (c.NoiseFrames-1)*c.HopSize + c.FrameSize
+	c.FrameSize = lowLatencyFrameSize
+	c.HopSize = lowLatencyHopSize
+	c.NoiseFrames = max(1, (span-c.FrameSize)/c.HopSize+1)
+	c.LookAheadMs = 0
+	c.GainSmoothing = math.Min(c.GainSmoothing, lowLatencySmoothing)
+	if c.NormalizeMode != NormalizeNone {
+		c.NormalizeMode = NormalizeRolling
+	}
+	c.LowLatency = true
+	return c
+}
 
-	for fi := 0; fi < noiseFrames; fi++ {
-		start := fi * HopSize
-		frame := extractFrame(samples, start, FrameSize)
-		applyWindow(frame, window)
+// AlgorithmicLatency returns the delay cfg imposes on audio at sampleRate
+// when processed as a stream: a whole frame must arrive before it can be
+// transformed, and LookAheadMs (rounded up to whole hops) must be buffered
+// on top. Processing time is not included.
+func AlgorithmicLatency(cfg DenoiseConfig, sampleRate int) time.Duration {
+	if sampleRate <= 0 {
+		return 0
+	}
+	samples := cfg.FrameSize
+	if cfg.LookAheadMs > 0 && cfg.HopSize > 0 {
+		samples += lookAheadFrames(cfg, sampleRate) * cfg.HopSize
+	}
+	return time.Duration(float64(samples) / float64(sampleRate) * float64(time.Second))
+}
 
-		cx := realToComplex(frame)
-		spectrum := FFT(cx)
+// RecommendFrameSize returns the largest power-of-2 FrameSize whose
+// AlgorithmicLatency at sampleRate, without look-ahead, fits within
+// maxLatency: larger frames resolve frequency more finely, so this is the
+// best quality the budget allows. Budgets too small for any frame yield
+// the minimum frame size.
+func RecommendFrameSize(maxLatency time.Duration, sampleRate int) int {
+	if maxLatency <= 0 || sampleRate <= 0 {
+		return minFrameSize
+	}
+	budget := int(maxLatency.Seconds() * float64(sampleRate))
+	cfg := DenoiseConfig{FrameSize: NextPowerOf2(max(budget, 1))}
+	for cfg.FrameSize > minFrameSize && AlgorithmicLatency(cfg, sampleRate) > maxLatency {
+		cfg.FrameSize /= 2
+	}
+	return cfg.FrameSize
+}
+
+// paramRange is the interval of values Validate accepts for a numeric
+// DenoiseConfig field. max may be +Inf.
+type paramRange struct {
+	min, max         float64
+	minOpen, maxOpen bool // exclude the bound itself
+}
+
+// contains reports whether v is in r. NaN never is.
+func (r paramRange) contains(v float64) bool {
+	return (v > r.min || !r.minOpen && v == r.min) && (v < r.max || !r.maxOpen && v == r.max)
+}
 
-		for k := 0; k < FrameSize; k++ {
-			noiseMag[k] += cmplx.Abs(spectrum[k])
+func (r paramRange) String() string {
+	lo, hi := "[", "]"
+	if r.minOpen {
+		lo = "("
+	}
+	if r.maxOpen || math.IsInf(r.max, 1) {
+		hi = ")"
+	}
+	return fmt.Sprintf("%s%g, %g%s", lo, r.min, r.max, hi)
+}
+
+// nonNegative is the range of fields that only need to be at least zero.
+var nonNegative = paramRange{min: 0, max: math.Inf(1)}
+
+// configRanges holds the ranges of DenoiseConfig's numeric fields with
+// fixed bounds, by JSON name. Validate checks against it and
+// /capabilities reports it, so the two cannot drift apart. FrameSize must
+// also be a power of two, and HopSize is bounded by FrameSize.
+var configRanges = map[string]paramRange{
+	"frame_size":            {min: minFrameSize, max: math.Inf(1)},
+	"hop_size":              {min: 1, max: math.Inf(1)},
+	"noise_frames":          {min: 1, max: math.Inf(1)},
+	"spectral_floor":        {min: 0, max: 1},
+	"adaptive_floor_min":    {min: 0, max: 1},
+	"adaptive_floor_max":    {min: 0, max: 1},
+	"over_subtract":         nonNegative,
+	"subtraction_exponent":  {min: 0, max: math.Inf(1), minOpen: true},
+	"noise_smoothing_bins":  nonNegative,
+	"gain_smoothing":        {min: 0, max: 1, maxOpen: true},
+	"mask_smoothing_frames": nonNegative,
+	"mask_smoothing_bins":   nonNegative,
+	"look_ahead_ms":         nonNegative,
+	"tukey_alpha":           {min: 0, max: 1},
+	"fade_in_ms":            nonNegative,
+	"fade_out_ms":           nonNegative,
+	"process_band_low":      nonNegative,
+	"process_band_high":     nonNegative,
+	"high_pass_hz":          nonNegative,
+	"max_samples":           nonNegative,
+}
+
+// checkRange returns an error naming what, for the field with JSON name
+// field, if v is outside its configRanges entry.
+func checkRange(field, what string, v float64) error {
+	if r := configRanges[field]; !r.contains(v) {
+		return fmt.Errorf("denoise: %s %g out of range %s", what, v, r)
+	}
+	return nil
+}
+
+// Enumerated DenoiseConfig values, for Validate and /capabilities.
+var (
+	floorModes     = []string{FloorConstant, FloorNoiseShaped, FloorComfortNoise}
+	normalizeModes = []string{NormalizePeak, NormalizeRMS, NormalizeRolling, NormalizeNone}
+)
+
+// Validate reports whether the configuration can be used for processing.
+func (c DenoiseConfig) Validate() error {
+	if !isPowerOf2(c.FrameSize) {
+		return fmt.Errorf("denoise: frame size %d is not a power of 2", c.FrameSize)
+	}
+	if c.FrameSize < minFrameSize {
+		return fmt.Errorf("denoise: frame size %d is below the minimum of %d", c.FrameSize, minFrameSize)
+	}
+	if c.HopSize <= 0 || c.HopSize > c.FrameSize {
+		return fmt.Errorf("denoise: hop size %d out of range (1..%d)", c.HopSize, c.FrameSize)
+	}
+	for _, f := range []struct {
+		field, what string
+		v           float64
+	}{
+		{"noise_frames", "noise frames", float64(c.NoiseFrames)},
+		{"spectral_floor", "spectral floor", c.SpectralFloor},
+		{"over_subtract", "over-subtraction", c.OverSubtract},
+		{"subtraction_exponent", "subtraction exponent", c.SubtractionExponent},
+		{"noise_smoothing_bins", "noise smoothing bins", float64(c.NoiseSmoothingBins)},
+		{"mask_smoothing_frames", "mask smoothing frames", float64(c.MaskSmoothingFrames)},
+		{"mask_smoothing_bins", "mask smoothing bins", float64(c.MaskSmoothingBins)},
+		{"gain_smoothing", "gain smoothing", c.GainSmoothing},
+		{"tukey_alpha", "tukey alpha", c.TukeyAlpha},
+		{"max_samples", "max samples", float64(c.MaxSamples)},
+		{"look_ahead_ms", "look-ahead ms", c.LookAheadMs},
+		{"process_band_low", "processing band low edge", c.ProcessBandLow},
+		{"process_band_high", "processing band high edge", c.ProcessBandHigh},
+		{"high_pass_hz", "high-pass cutoff", c.HighPassHz},
+		{"fade_in_ms", "fade-in ms", c.FadeInMs},
+		{"fade_out_ms", "fade-out ms", c.FadeOutMs},
+	} {
+		if err := checkRange(f.field, f.what, f.v); err != nil {
+			return err
+		}
+	}
+	if c.AdaptiveFloorMax > 0 {
+		if err := checkRange("adaptive_floor_max", "adaptive floor max", c.AdaptiveFloorMax); err != nil {
+			return err
+		}
+		if err := checkRange("adaptive_floor_min", "adaptive floor min", c.AdaptiveFloorMin); err != nil {
+			return err
 		}
+		if c.AdaptiveFloorMin > c.AdaptiveFloorMax {
+			return fmt.Errorf("denoise: adaptive floor min %g is above max %g", c.AdaptiveFloorMin, c.AdaptiveFloorMax)
+		}
+	}
+	if !slices.Contains(floorModes, c.FloorMode) {
+		return fmt.Errorf("denoise: unknown floor mode %q", c.FloorMode)
+	}
+	if _, ok := windowRegistry[c.Window]; !ok {
+		return fmt.Errorf("denoise: unknown window %q", c.Window)
+	}
+	if !slices.Contains(normalizeModes, c.NormalizeMode) {
+		return fmt.Errorf("denoise: unknown normalize mode %q", c.NormalizeMode)
 	}
+	if c.LowLatency && c.LookAheadMs > 0 {
+		return errors.New("denoise: look-ahead is not available in low-latency mode")
+	}
+	if c.ProcessBandHigh > 0 && c.ProcessBandHigh <= c.ProcessBandLow {
+		return fmt.Errorf("denoise: processing band %g..%g Hz is empty", c.ProcessBandLow, c.ProcessBandHigh)
+	}
+	if c.Whitening != nil {
+		if n := len(c.Whitening.Gains); n != c.FrameSize/2+1 {
+			return fmt.Errorf("denoise: %d whitening gains for frame size %d (want %d)", n, c.FrameSize, c.FrameSize/2+1)
+		}
+		for k, g := range c.Whitening.Gains {
+			if !(g > 0) || math.IsInf(g, 0) {
+				return fmt.Errorf("denoise: whitening gain %g for bin %d must be positive", g, k)
+			}
+		}
+	}
+	return nil
+}
 
-	// Average.
-	for k := range noiseMag {
-		noiseMag[k] /= float64(noiseFrames)
+// checkLength reports an error if an input of n samples exceeds
+// c.MaxSamples.
+func (c DenoiseConfig) checkLength(n int) error {
+	if c.MaxSamples > 0 && n > c.MaxSamples {
+		return fmt.Errorf("denoise: input of %d samples exceeds the maximum of %d", n, c.MaxSamples)
+	}
+	return nil
+}
+
+// Denoise performs spectral-subtraction noise cancellation on mono audio samples
+// using DefaultDenoiseConfig adapted to sampleRate (see ForSampleRate).
+// samples should be normalized to [-1.0, +1.0].
+func Denoise(samples []float64, sampleRate int) []float64 {
+	out, _ := DenoiseWithConfig(samples, sampleRate, DefaultDenoiseConfig().ForSampleRate(sampleRate))
+	return out
+}
+
+// DenoiseReport describes the noise Denoise found in its input.
+type DenoiseReport struct {
+	// NoiseDB is the estimated noise power relative to the mean frame power
+	// of the input, in dB (clamped at -120).
+	NoiseDB float64
+	// NoiseNegligible reports that NoiseDB was below cfg.NegligibleNoiseDB,
+	// so subtraction was skipped (e.g. the input was already denoised).
+	NoiseNegligible bool
+}
+
+// DenoiseWithConfig is like Denoise but uses the supplied configuration.
+// It returns an error if cfg is invalid.
+func DenoiseWithConfig(samples []float64, sampleRate int, cfg DenoiseConfig) ([]float64, error) {
+	out, _, err := DenoiseWithReport(samples, sampleRate, cfg)
+	return out, err
+}
+
+// DenoiseToFloat is DenoiseWithConfig under a name for chaining into
+// further processing: it returns the cleaned samples as float64, with no
+// WAV encoding or quantization. The output has len(samples) samples, and
+// its range depends on cfg.NormalizeMode:
+//
+//   - NormalizePeak, NormalizeRMS and NormalizeRolling keep every sample
+//     within ±PeakTarget.
+//   - NormalizeNone applies no bound: input beyond [-1, +1] is processed
+//     at full scale and returned at its own level, and subtraction can
+//     overshoot full scale even for input within it. Clamp or scale the
+//     output before encoding to a fixed-point format.
+func DenoiseToFloat(samples []float64, sampleRate int, cfg DenoiseConfig) ([]float64, error) {
+	return DenoiseWithConfig(samples, sampleRate, cfg)
+}
+
+// DenoiseProgress is called as denoising proceeds with the number of frames
+// done out of the total.
+type DenoiseProgress func(done, total int)
+
+// DenoiseContext is like DenoiseWithConfig but stops early, returning
+// ctx.Err(), once ctx is done.
+func DenoiseContext(ctx context.Context, samples []float64, sampleRate int, cfg DenoiseConfig) ([]float64, error) {
+	return DenoiseWithProgress(ctx, samples, sampleRate, cfg, nil)
+}
+
+// DenoiseWithProgress is like DenoiseContext but also calls progress (if
+// non-nil) after each frame is processed.
+func DenoiseWithProgress(ctx context.Context, samples []float64, sampleRate int, cfg DenoiseConfig, progress DenoiseProgress) ([]float64, error) {
+	out, _, err := denoiseWithReport(ctx, samples, sampleRate, cfg, progress)
+	return out, err
+}
+
+// DenoiseWithReport is like DenoiseWithConfig but also reports the noise
+// level it detected.
+func DenoiseWithReport(samples []float64, sampleRate int, cfg DenoiseConfig) ([]float64, DenoiseReport, error) {
+	return denoiseWithReport(context.Background(), samples, sampleRate, cfg, nil)
+}
+
+func denoiseWithReport(ctx context.Context, samples []float64, sampleRate int, cfg DenoiseConfig, progress DenoiseProgress) ([]float64, DenoiseReport, error) {
+	cfg = cfg.forRate(sampleRate)
+	if err := cfg.Validate(); err != nil {
+		return nil, DenoiseReport{}, err
+	}
+	if err := cfg.checkLength(len(samples)); err != nil {
+		return nil, DenoiseReport{}, err
+	}
+	if len(samples) == 0 {
+		return nil, DenoiseReport{}, nil
+	}
+
+	fitted, level := fitInputRange(samples)
+	samples = padToFrame(applyInputGain(fitted[0], cfg.InputGainDB), cfg.FrameSize)
+
+	// Generate window once.
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return nil, DenoiseReport{}, err
 	}
 
 	// ---------------------------------------------------------------
-	// Step 2: Process every frame via spectral subtraction.
+	// Step 1: Estimate noise magnitude spectrum from initial frames.
 	// ---------------------------------------------------------------
-	output := make([]float64, n)
-	windowSum := make([]float64, n) // for overlap-add normalization
+	noise := estimateNoise(samples[:leadingNoiseEnd(len(samples), cfg)], window, cfg)
+
+	out, report, err := subtractNoise(ctx, samples, sampleRate, noise, window, cfg, progress)
+	if err != nil {
+		return nil, report, err
+	}
+	out = fitLength(out, len(fitted[0]))
+	restoreInputLevel([][]float64{out}, level, cfg)
+	return out, report, nil
+}
 
-	for fi := 0; fi < totalFrames; fi++ {
-		start := fi * HopSize
+// DenoiseResidual returns what denoising removes from samples: the input
+// minus the cleaned output, sample for sample, for listening to whether
+// voice is being taken along with the noise. The cleaned output is
+// computed with cfg's level stages off (no input gain, fades or
+// normalization), so the residual is at the input's level and adding it
+// to DenoiseWithConfig's output restores the input up to that output's
+// normalization gain.
+func DenoiseResidual(samples []float64, sampleRate int, cfg DenoiseConfig) ([]float64, error) {
+	cfg.InputGainDB, cfg.FadeInMs, cfg.FadeOutMs = 0, 0, 0
+	cfg.NormalizeMode, cfg.PreserveHeadroom = NormalizeNone, false
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range samples {
+		cleaned[i] = s - cleaned[i]
+	}
+	return cleaned, nil
+}
 
-		// Extract and window the frame.
-		frame := extractFrame(samples, start, FrameSize)
-		applyWindow(frame, window)
+// DenoiseEstimate predicts the effect of denoising an input without
+// running the subtraction.
+type DenoiseEstimate struct {
+	DenoiseReport
+	// SNRDB is the estimated signal-to-noise ratio of the input in dB,
+	// clamped to ±120 dB.
+	SNRDB float64
+	// ReductionDB is the estimated drop in overall level once the noise is
+	// removed, in dB (0 when the noise is negligible).
+	ReductionDB float64
+}
 
-		// Forward FFT.
-		cx := realToComplex(frame)
-		spectrum := FFT(cx)
+// EstimateDenoise estimates the noise in samples as DenoiseWithConfig would
+// and predicts the result from its level alone: only the noise frames are
+// transformed, so it costs a small fraction of denoising.
+func EstimateDenoise(samples []float64, sampleRate int, cfg DenoiseConfig) (DenoiseEstimate, error) {
+	cfg = cfg.forRate(sampleRate)
+	if err := cfg.Validate(); err != nil {
+		return DenoiseEstimate{}, err
+	}
+	if err := cfg.checkLength(len(samples)); err != nil {
+		return DenoiseEstimate{}, err
+	}
+	if len(samples) == 0 {
+		return DenoiseEstimate{}, nil
+	}
 
-		// Spectral subtraction.
-		for k := 0; k < FrameSize; k++ {
-			mag := cmplx.Abs(spectrum[k])
-			phase := cmplx.Phase(spectrum[k])
+	fitted, _ := fitInputRange(samples)
+	samples = padToFrame(applyInputGain(fitted[0], cfg.InputGainDB), cfg.FrameSize)
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return DenoiseEstimate{}, err
+	}
+	noise := estimateNoise(samples[:leadingNoiseEnd(len(samples), cfg)], window, cfg)
+	_, report := guardNoise(noise, samples, window, cfg)
 
-			// Subtract over-estimated noise.
-			cleanMag := mag - OverSubtract*noiseMag[k]
+	// The noise's share of the input power; subtraction removes it, but
+	// never more than the spectral floor lets through.
+	const maxSNRDB = 120
+	est := DenoiseEstimate{DenoiseReport: report}
+	share := math.Pow(10, report.NoiseDB/10)
+	if share < 1 {
+		est.SNRDB = math.Min(maxSNRDB, 10*math.Log10((1-share)/share))
+	} else {
+		est.SNRDB = -maxSNRDB
+	}
+	if !report.NoiseNegligible {
+		removed := math.Min(share, 1-cfg.SpectralFloor*cfg.SpectralFloor)
+		est.ReductionDB = -10 * math.Log10(1-removed)
+	}
+	return est, nil
+}
 
-			// Gain floor: keep at least SpectralFloor * original magnitude.
-			floor := SpectralFloor * mag
-			if cleanMag < floor {
-				cleanMag = floor
-			}
+// DenoiseWithNoiseRegion is like DenoiseWithConfig but estimates the noise
+// profile from samples[noiseStart:noiseEnd] instead of the leading frames.
+// Use it when the noise-only part of a recording is in the middle or at the end.
+func DenoiseWithNoiseRegion(samples []float64, sampleRate int, noiseStart, noiseEnd int, cfg DenoiseConfig) ([]float64, error) {
+	return DenoiseWithNoiseRegions(samples, sampleRate, [][2]int{{noiseStart, noiseEnd}}, cfg)
+}
 
-			// Reconstruct with original phase.
-			spectrum[k] = cmplx.Rect(cleanMag, phase)
+// DenoiseWithNoiseRegions is like DenoiseWithNoiseRegion but estimates the
+// noise profile from several [start, end) sample ranges, pooling their
+// frames. Use it when a recording has several short pauses.
+func DenoiseWithNoiseRegions(samples []float64, sampleRate int, regions [][2]int, cfg DenoiseConfig) ([]float64, error) {
+	cfg = cfg.forRate(sampleRate)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.checkLength(len(samples)); err != nil {
+		return nil, err
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("denoise: no noise regions")
+	}
+	for _, r := range regions {
+		if r[0] < 0 || r[1] > len(samples) || r[0] >= r[1] {
+			return nil, fmt.Errorf("denoise: noise region [%d, %d) out of range for %d samples",
+				r[0], r[1], len(samples))
 		}
+	}
 
-		// Inverse FFT.
-		cleaned := IFFT(spectrum)
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-		// Overlap-add with synthesis window.
-		for j := 0; j < FrameSize; j++ {
-			idx := start + j
-			if idx < n {
-				output[idx] += real(cleaned[j]) * window[j]
-				windowSum[idx] += window[j] * window[j]
-			}
-		}
+	fitted, level := fitInputRange(samples)
+	samples = applyInputGain(fitted[0], cfg.InputGainDB)
+	parts := make([][]float64, len(regions))
+	for i, r := range regions {
+		parts[i] = samples[r[0]:r[1]]
 	}
+	noise := estimateNoiseRegions(parts, window, cfg)
 
-	// ---------------------------------------------------------------
-	// Step 3: Normalize by the accumulated window energy.
-	// ---------------------------------------------------------------
-	for i := 0; i < n; i++ {
-		if windowSum[i] > 1e-8 {
-			output[i] /= windowSum[i]
+	out, _, err := subtractNoise(context.Background(), padToFrame(samples, cfg.FrameSize), sampleRate, noise, window, cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	out = fitLength(out, len(samples))
+	restoreInputLevel([][]float64{out}, level, cfg)
+	return out, nil
+}
+
+// subtractNoise runs spectralSubtract unless the noise profile is negligible
+// relative to samples, in which case only the reconstruction, fades and
+// normalization are applied so already-clean audio is not degraded further.
+func subtractNoise(ctx context.Context, samples []float64, sampleRate int, noise *NoiseProfile, window []float64, cfg DenoiseConfig, progress DenoiseProgress) ([]float64, DenoiseReport, error) {
+	noise, report := guardNoise(noise, samples, window, cfg)
+	out, err := spectralSubtract(ctx, samples, sampleRate, noise, window, cfg, progress)
+	return out, report, err
+}
+
+// guardNoise reports the level of noise relative to samples and, if it is
+// below cfg.NegligibleNoiseDB, replaces it with a silent profile.
+func guardNoise(noise *NoiseProfile, samples, window []float64, cfg DenoiseConfig) (*NoiseProfile, DenoiseReport) {
+	report := DenoiseReport{NoiseDB: noise.levelDB(samples, window)}
+	if report.NoiseDB < cfg.NegligibleNoiseDB {
+		report.NoiseNegligible = true
+		noise = &NoiseProfile{
+			Mean:     make([]float64, len(noise.Mean)),
+			Variance: make([]float64, len(noise.Variance)),
 		}
 	}
+	return noise, report
+}
 
-	// ---------------------------------------------------------------
-	// Step 4: Peak normalization — scale so the loudest sample hits
-	// the target level, maximizing voice volume without clipping.
-	// ---------------------------------------------------------------
-	normalize(output, 0.95)
+// leadingNoiseEnd returns the end of the leading region of an n-sample
+// input used for the noise estimate: cfg.NoiseFrames frames, capped to the
+// frames available.
+func leadingNoiseEnd(n int, cfg DenoiseConfig) int {
+	noiseFrames := cfg.NoiseFrames
+	if total := frameCount(n, cfg); noiseFrames > total {
+		noiseFrames = total
+	}
+	return (noiseFrames-1)*cfg.HopSize + cfg.FrameSize
+}
+
+// padToFrame zero-pads samples to one frame if it is shorter than that.
+func padToFrame(samples []float64, frameSize int) []float64 {
+	if len(samples) >= frameSize {
+		return samples
+	}
+	padded := make([]float64, frameSize)
+	copy(padded, samples)
+	return padded
+}
 
-	return output
+// fitLength returns out cut or zero-padded to n samples, the length of the
+// input it was made from. Padding added for framing (see padToFrame) is
+// cut off; padding with silence guards against any stage losing samples,
+// so callers can rely on getting as many samples as they passed in.
+func fitLength(out []float64, n int) []float64 {
+	if len(out) >= n {
+		return out[:n]
+	}
+	return append(out, make([]float64, n-len(out))...)
+}
+
+// frameCount returns how many frames of cfg.FrameSize, stepped by
+// cfg.HopSize, fit into n samples (at least 1).
+func frameCount(n int, cfg DenoiseConfig) int {
+	if n < cfg.FrameSize {
+		return 1
+	}
+	return (n-cfg.FrameSize)/cfg.HopSize + 1
 }
 
 // extractFrame copies FrameSize samples starting at `start` from src.
@@ -190,25 +842,288 @@ func magnitude(x []complex128) []float64 {
 	return m
 }
 
-// normalize scales samples so the peak amplitude equals targetLevel.
-// If the signal is silent (all zeros), it does nothing.
-func normalize(samples []float64, targetLevel float64) {
-	// Find peak absolute value.
+// applyInputGain returns samples scaled by gainDB, limited so the peak does
+// not exceed full scale. The input slice is not modified; a gain of 0 dB
+// returns it as is.
+func applyInputGain(samples []float64, gainDB float64) []float64 {
+	if gainDB == 0 {
+		return samples
+	}
+	gain := math.Pow(10, gainDB/20)
+
 	var peak float64
 	for _, s := range samples {
-		a := math.Abs(s)
-		if a > peak {
-			peak = a
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak*gain > 1 {
+		gain = 1 / peak
+	}
+
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		out[i] = s * gain
+	}
+	return out
+}
+
+// fitInputRange returns the channels scaled by a common factor so their
+// peak is at most full scale, the range the level-dependent stages are
+// tuned for, along with the factor restoreInputLevel needs to undo it.
+// Channels already in range are returned as is, with a factor of 1.
+func fitInputRange(channels ...[]float64) ([][]float64, float64) {
+	peak := peakLevel(channels...)
+	if peak <= 1 {
+		return channels, 1
+	}
+	fitted := make([][]float64, len(channels))
+	for c, ch := range channels {
+		fitted[c] = make([]float64, len(ch))
+		for i, v := range ch {
+			fitted[c][i] = v / peak
 		}
 	}
+	return fitted, peak
+}
+
+// restoreInputLevel scales outputs by level, the factor fitInputRange
+// returned, so they keep the input's level. Only NormalizeNone and
+// PreserveHeadroom output is scaled: the other modes set the level
+// themselves.
+func restoreInputLevel(outputs [][]float64, level float64, cfg DenoiseConfig) {
+	if level != 1 && (cfg.NormalizeMode == NormalizeNone || cfg.PreserveHeadroom) {
+		scale(level, outputs...)
+	}
+}
+
+// applyFade ramps the first fadeIn samples up from zero and the last fadeOut
+// samples down to zero with a raised-cosine curve. Fades longer than the
+// signal are clipped to its length.
+func applyFade(samples []float64, fadeIn, fadeOut int) {
+	n := len(samples)
+	if fadeIn > n {
+		fadeIn = n
+	}
+	if fadeOut > n {
+		fadeOut = n
+	}
+	for i := 0; i < fadeIn; i++ {
+		samples[i] *= 0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(fadeIn)))
+	}
+	for i := 0; i < fadeOut; i++ {
+		samples[n-1-i] *= 0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(fadeOut)))
+	}
+}
+
+// normalize scales samples so the peak amplitude, the true peak if
+// truePeak is set, equals targetLevel.
+// If the signal is silent (all zeros), it does nothing.
+func normalize(samples []float64, targetLevel float64, truePeak bool) {
+	scale(peakGain(targetLevel, truePeak, samples), samples)
+}
 
+// normalizeRMS scales samples so their RMS equals targetRMS, reducing the
+// gain if needed so the peak does not exceed maxPeak.
+// If the signal is silent (all zeros), it does nothing.
+func normalizeRMS(samples []float64, targetRMS, maxPeak float64) {
+	scale(rmsGain(targetRMS, maxPeak, false, samples), samples)
+}
+
+// peakGain returns the gain that brings the peak across all channels, the
+// true peak if truePeak is set, to targetLevel, or 1 if they are silent.
+func peakGain(targetLevel float64, truePeak bool, channels ...[]float64) float64 {
+	peak := measurePeak(truePeak, channels...)
 	if peak < 1e-10 {
-		return // silence — nothing to amplify
+		return 1 // silence — nothing to amplify
+	}
+	return targetLevel / peak
+}
+
+// rmsGain returns the gain that brings the RMS across all channels to
+// targetRMS without the peak (the true peak if truePeak is set) exceeding
+// maxPeak, or 1 if they are silent.
+func rmsGain(targetRMS, maxPeak float64, truePeak bool, channels ...[]float64) float64 {
+	var sum float64
+	var count int
+	for _, ch := range channels {
+		for _, v := range ch {
+			sum += v * v
+		}
+		count += len(ch)
+	}
+	if count == 0 {
+		return 1
 	}
+	level := math.Sqrt(sum / float64(count))
+	if level < 1e-10 {
+		return 1 // silence — nothing to amplify
+	}
+	return math.Min(targetRMS/level, maxPeak/measurePeak(truePeak, channels...))
+}
 
-	gain := targetLevel / peak
-	for i := range samples {
-		samples[i] *= gain
+// Rolling normalization (NormalizeRolling) parameters.
+const (
+	// rollingWindowMs is the time constant of the level tracked by
+	// rollingNormalizer.
+	rollingWindowMs = 300
+	// rollingMaxGainDB bounds the gain, so silence and near-silence are
+	// not boosted into audible noise.
+	rollingMaxGainDB = 20
+	// rollingSlewDB is the most the gain may rise per second; it falls as
+	// fast as needed to keep the peak within PeakTarget.
+	rollingSlewDB = 20
+)
+
+// rollingNormalizer is the causal gain control of NormalizeRolling. It
+// carries its state between calls, so audio may be fed through it block by
+// block with the same result as in one piece.
+type rollingNormalizer struct {
+	decay   float64 // per-sample smoothing of the tracked power
+	maxStep float64 // largest per-sample gain increase, as a ratio
+	level   float64 // tracked mean power across channels
+	gain    float64
+}
+
+func newRollingNormalizer(sampleRate int) *rollingNormalizer {
+	return &rollingNormalizer{
+		decay:   math.Exp(-1000 / (rollingWindowMs * float64(sampleRate))),
+		maxStep: math.Pow(10, rollingSlewDB/20.0/float64(sampleRate)),
+		gain:    1,
+	}
+}
+
+// prime sets the tracked level and gain to their steady state for the
+// rollingWindowMs of the channels from skip on, as if the same audio had
+// been playing before them. skip leaves out the opening samples that only
+// one frame's window tail covers, which overlap-add can leave unreliable.
+func (n *rollingNormalizer) prime(skip int, channels ...[]float64) {
+	skip = min(skip, len(channels[0]))
+	window := min(len(channels[0])-skip, int(-1/math.Log(n.decay)))
+	if window == 0 {
+		return
+	}
+	var power float64
+	for _, ch := range channels {
+		for _, v := range ch[skip : skip+window] {
+			power += v * v
+		}
+	}
+	n.level = power / float64(window*len(channels))
+	if n.level > 0 {
+		n.gain = math.Min(math.Pow(10, rollingMaxGainDB/20.0), RMSTarget/math.Sqrt(n.level))
+	}
+}
+
+// process scales the channels, of equal length, in place. Every channel
+// gets the same gain so their balance is preserved.
+func (n *rollingNormalizer) process(channels ...[]float64) {
+	maxGain := math.Pow(10, rollingMaxGainDB/20.0)
+	for i := range channels[0] {
+		var power, peak float64
+		for _, ch := range channels {
+			power += ch[i] * ch[i]
+			peak = math.Max(peak, math.Abs(ch[i]))
+		}
+		n.level = n.decay*n.level + (1-n.decay)*power/float64(len(channels))
+
+		target := maxGain
+		if n.level > 0 {
+			target = math.Min(maxGain, RMSTarget/math.Sqrt(n.level))
+		}
+		n.gain = math.Min(target, n.gain*n.maxStep)
+		if peak*n.gain > PeakTarget {
+			n.gain = PeakTarget / peak
+		}
+		for _, ch := range channels {
+			ch[i] *= n.gain
+		}
+	}
+}
+
+// peakLevel returns the largest absolute sample value across channels.
+func peakLevel(channels ...[]float64) float64 {
+	var peak float64
+	for _, ch := range channels {
+		for _, s := range ch {
+			peak = math.Max(peak, math.Abs(s))
+		}
+	}
+	return peak
+}
+
+// truePeakOversample is the oversampling factor truePeakLevel measures
+// at, and truePeakTaps the number of input samples each interpolated
+// sample is computed from.
+const (
+	truePeakOversample = 4
+	truePeakTaps       = 16
+)
+
+// truePeakPhases holds the polyphase interpolation filter truePeakLevel
+// uses: for each fractional position p/truePeakOversample between two
+// samples, a Hann-windowed sinc over the truePeakTaps nearest samples.
+var truePeakPhases = func() [][]float64 {
+	phases := make([][]float64, truePeakOversample)
+	half := truePeakTaps / 2
+	for p := range phases {
+		frac := float64(p) / truePeakOversample
+		phases[p] = make([]float64, truePeakTaps)
+		for j := range phases[p] {
+			// Tap j weights sample i+j-half+1 for the point i+frac.
+			x := frac + float64(half-1-j)
+			w := 0.5 * (1 + math.Cos(math.Pi*x/float64(half)))
+			phases[p][j] = sinc(x) * w
+		}
+	}
+	return phases
+}()
+
+// sinc is the normalized sinc function, sin(πx)/(πx).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// truePeakLevel returns the largest absolute value across channels of the
+// signal they sample, estimated by interpolating truePeakOversample points
+// per sample. It is at least peakLevel, and higher where the waveform
+// peaks between samples, as it will after digital-to-analog conversion.
+// Samples beyond each end are taken as zero.
+func truePeakLevel(channels ...[]float64) float64 {
+	peak := peakLevel(channels...)
+	half := truePeakTaps / 2
+	for _, ch := range channels {
+		for i := range ch {
+			for _, taps := range truePeakPhases[1:] {
+				var v float64
+				for j, h := range taps {
+					if k := i + j - half + 1; k >= 0 && k < len(ch) {
+						v += h * ch[k]
+					}
+				}
+				peak = math.Max(peak, math.Abs(v))
+			}
+		}
+	}
+	return peak
+}
+
+// measurePeak returns truePeakLevel if truePeak is set, and peakLevel
+// otherwise.
+func measurePeak(truePeak bool, channels ...[]float64) float64 {
+	if truePeak {
+		return truePeakLevel(channels...)
+	}
+	return peakLevel(channels...)
+}
+
+// scale multiplies every sample of every channel by gain.
+func scale(gain float64, channels ...[]float64) {
+	for _, ch := range channels {
+		for i := range ch {
+			ch[i] *= gain
+		}
 	}
 }
 
@@ -223,3 +1138,15 @@ func rms(x []float64) float64 {
 	}
 	return math.Sqrt(sum / float64(len(x)))
 }
+
+// mean returns the arithmetic mean of a float64 slice.
+func mean(x []float64) float64 {
+	if len(x) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range x {
+		sum += v
+	}
+	return sum / float64(len(x))
+}
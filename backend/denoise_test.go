@@ -0,0 +1,1078 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// correlation returns the Pearson correlation coefficient of a and b.
+func correlation(a, b []float64) float64 {
+	var ab, aa, bb float64
+	for i := range a {
+		ab += a[i] * b[i]
+		aa += a[i] * a[i]
+		bb += b[i] * b[i]
+	}
+	if aa == 0 || bb == 0 {
+		return 0
+	}
+	return ab / math.Sqrt(aa*bb)
+}
+
+func TestDenoiseWithNoiseRegion(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 3
+
+	// Tone from the very start, except a noise-only gap in the middle,
+	// so the leading frames are a poor noise estimate.
+	gapStart, gapEnd := sampleRate, sampleRate+sampleRate/2
+	noise := pseudoNoise(n, 4242, 0.05)
+	clean := make([]float64, n)
+	samples := make([]float64, n)
+	for i := range samples {
+		if i < gapStart || i >= gapEnd {
+			clean[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+		samples[i] = clean[i] + noise[i]
+	}
+
+	cfg := DefaultDenoiseConfig()
+
+	leading, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithConfig: %v", err)
+	}
+	region, err := DenoiseWithNoiseRegion(samples, sampleRate, gapStart, gapEnd, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithNoiseRegion: %v", err)
+	}
+	if len(region) != n {
+		t.Fatalf("length mismatch: input=%d, cleaned=%d", n, len(region))
+	}
+
+	// Compare voice preservation over the tone after the gap.
+	toneStart, toneEnd := gapEnd+FrameSize, n-FrameSize
+	leadingCorr := correlation(clean[toneStart:toneEnd], leading[toneStart:toneEnd])
+	regionCorr := correlation(clean[toneStart:toneEnd], region[toneStart:toneEnd])
+	t.Logf("tone correlation: leading=%.3f, region=%.3f", leadingCorr, regionCorr)
+
+	if regionCorr <= leadingCorr {
+		t.Fatalf("expected noise region to preserve the tone better: leading=%.3f, region=%.3f",
+			leadingCorr, regionCorr)
+	}
+	if regionCorr < 0.9 {
+		t.Fatalf("tone not preserved with noise region: correlation=%.3f", regionCorr)
+	}
+}
+
+func TestDenoiseWithNoiseRegions(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 3
+
+	// Tone throughout except two short pauses, neither at the start.
+	gaps := [][2]int{
+		{sampleRate, sampleRate + sampleRate/5},
+		{2 * sampleRate, 2*sampleRate + sampleRate/5},
+	}
+	inGap := func(i int) bool {
+		for _, g := range gaps {
+			if i >= g[0] && i < g[1] {
+				return true
+			}
+		}
+		return false
+	}
+	noise := pseudoNoise(n, 99, 0.05)
+	clean := make([]float64, n)
+	samples := make([]float64, n)
+	for i := range samples {
+		if !inGap(i) {
+			clean[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+		samples[i] = clean[i] + noise[i]
+	}
+
+	cleaned, err := DenoiseWithNoiseRegions(samples, sampleRate, gaps, DefaultDenoiseConfig())
+	if err != nil {
+		t.Fatalf("DenoiseWithNoiseRegions: %v", err)
+	}
+
+	// Residual noise in the middle of each gap relative to the tone after
+	// it, before and after denoising.
+	toneStart, toneEnd := gaps[1][1]+FrameSize, n-FrameSize
+	for _, g := range gaps {
+		mid := (g[0] + g[1]) / 2
+		before := rms(samples[mid-FrameSize/2:mid+FrameSize/2]) / rms(samples[toneStart:toneEnd])
+		after := rms(cleaned[mid-FrameSize/2:mid+FrameSize/2]) / rms(cleaned[toneStart:toneEnd])
+		reduction := 20 * math.Log10(before/after)
+		t.Logf("gap %v: reduction %.1f dB", g, reduction)
+		if reduction < 15 {
+			t.Errorf("gap %v: expected at least 15 dB of noise reduction, got %.1f", g, reduction)
+		}
+	}
+	if c := correlation(clean[toneStart:toneEnd], cleaned[toneStart:toneEnd]); c < 0.9 {
+		t.Errorf("tone not preserved: correlation=%.3f", c)
+	}
+
+	if _, err := DenoiseWithNoiseRegions(samples, sampleRate, nil, DefaultDenoiseConfig()); err == nil {
+		t.Error("expected an error for no regions")
+	}
+}
+
+func TestDenoiseWithNoiseRegionInvalid(t *testing.T) {
+	samples := make([]float64, 10000)
+	cfg := DefaultDenoiseConfig()
+	for _, r := range [][2]int{{-1, 100}, {100, 100}, {500, 20000}} {
+		if _, err := DenoiseWithNoiseRegion(samples, 44100, r[0], r[1], cfg); err == nil {
+			t.Fatalf("expected error for region %v", r)
+		}
+	}
+}
+
+func TestDenoiseFades(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.FadeInMs = 50
+	cfg.FadeOutMs = 100
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithConfig: %v", err)
+	}
+	unfaded, _ := DenoiseWithConfig(samples, sampleRate, DefaultDenoiseConfig())
+
+	fadeIn := msToSamples(cfg.FadeInMs, sampleRate)
+	fadeOut := msToSamples(cfg.FadeOutMs, sampleRate)
+
+	if cleaned[0] != 0 || cleaned[n-1] != 0 {
+		t.Fatalf("expected silent endpoints, got %f and %f", cleaned[0], cleaned[n-1])
+	}
+
+	// Inside the fades the output equals the unfaded output times a
+	// monotonic raised-cosine gain.
+	for i := 0; i < fadeIn; i++ {
+		want := 0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(fadeIn)))
+		if math.Abs(cleaned[i]-unfaded[i]*want) > 1e-9 {
+			t.Fatalf("fade-in sample %d: expected %.6f, got %.6f", i, unfaded[i]*want, cleaned[i])
+		}
+	}
+	for i := 0; i < fadeOut; i++ {
+		want := 0.5 * (1 - math.Cos(math.Pi*float64(i)/float64(fadeOut)))
+		j := n - 1 - i
+		if math.Abs(cleaned[j]-unfaded[j]*want) > 1e-9 {
+			t.Fatalf("fade-out sample %d: expected %.6f, got %.6f", j, unfaded[j]*want, cleaned[j])
+		}
+	}
+
+	// Outside the fades nothing changes.
+	mid := n / 2
+	if math.Abs(cleaned[mid]-unfaded[mid]) > 1e-9 {
+		t.Fatalf("mid sample altered by fade: %.6f vs %.6f", cleaned[mid], unfaded[mid])
+	}
+}
+
+func TestDenoiseTwiceIsStable(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+
+	for _, noiseAmp := range []float64{0, 0.05} {
+		samples := pseudoNoise(n, 5, noiseAmp)
+		for i := sampleRate / 2; i < n; i++ {
+			samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+
+		cfg := DefaultDenoiseConfig()
+		once, first, err := DenoiseWithReport(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("first pass: %v", err)
+		}
+		twice, second, err := DenoiseWithReport(once, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("second pass: %v", err)
+		}
+		t.Logf("noise=%.2f: first pass %.1f dB (negligible=%v), second pass %.1f dB (negligible=%v)",
+			noiseAmp, first.NoiseDB, first.NoiseNegligible, second.NoiseDB, second.NoiseNegligible)
+
+		if noiseAmp > 0 && first.NoiseNegligible {
+			t.Fatalf("noise=%.2f: first pass should have found noise", noiseAmp)
+		}
+		if !second.NoiseNegligible {
+			t.Fatalf("noise=%.2f: second pass should report negligible noise, got %.1f dB",
+				noiseAmp, second.NoiseDB)
+		}
+
+		var maxDiff float64
+		for i := range once {
+			maxDiff = math.Max(maxDiff, math.Abs(twice[i]-once[i]))
+		}
+		if maxDiff > 1e-6 {
+			t.Fatalf("noise=%.2f: second pass changed the audio by up to %g", noiseAmp, maxDiff)
+		}
+	}
+}
+
+func TestApplyInputGain(t *testing.T) {
+	samples := []float64{0.1, -0.2, 0.05}
+	boosted := applyInputGain(samples, 6)
+	for i := range samples {
+		ratio := boosted[i] / samples[i]
+		if math.Abs(ratio-2) > 0.01 {
+			t.Fatalf("sample %d: expected ~2x gain at +6 dB, got %.4f", i, ratio)
+		}
+	}
+	if samples[0] != 0.1 {
+		t.Fatal("input slice was modified")
+	}
+
+	// Gain that would clip is limited to full scale.
+	limited := applyInputGain([]float64{0.5, -0.8}, 12)
+	if math.Abs(limited[1]+1) > 1e-12 || math.Abs(limited[0]-0.625) > 1e-12 {
+		t.Fatalf("expected clipping protection, got %v", limited)
+	}
+}
+
+func TestDenoiseInputGainPreservesTone(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+	noise := pseudoNoise(n, 11, 0.002)
+	clean := make([]float64, n)
+	samples := make([]float64, n)
+	for i := range samples {
+		if i >= sampleRate/2 {
+			clean[i] = 0.05 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+		samples[i] = clean[i] + noise[i]
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.InputGainDB = 6
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithConfig: %v", err)
+	}
+
+	start, end := sampleRate/2+FrameSize, n-FrameSize
+	if c := correlation(clean[start:end], cleaned[start:end]); c < 0.95 {
+		t.Fatalf("tone not preserved with input gain: correlation=%.3f", c)
+	}
+}
+
+func TestComfortNoiseFloorIsBroadband(t *testing.T) {
+	sampleRate := 44100
+
+	// Colored noise: an 8-tap moving average of white noise, whose
+	// spectrum has nulls a constant or noise-shaped floor cannot fill.
+	white := pseudoNoise(sampleRate*2, 8080, 0.1)
+	samples := make([]float64, len(white))
+	for i := range samples {
+		for j := 0; j < 8 && i-j >= 0; j++ {
+			samples[i] += white[i-j] / 8
+		}
+	}
+
+	flatness := map[string]float64{}
+	for _, mode := range []string{FloorConstant, FloorNoiseShaped, FloorComfortNoise} {
+		cfg := DefaultDenoiseConfig()
+		cfg.FloorMode = mode
+		cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", mode, err)
+		}
+		flatness[mode] = SpectralFlatness(cleaned[FrameSize:len(cleaned)-FrameSize], FrameSize)
+		t.Logf("%s floor: spectral flatness %.3f", mode, flatness[mode])
+	}
+
+	for _, mode := range []string{FloorConstant, FloorNoiseShaped} {
+		if flatness[FloorComfortNoise] < 1.5*flatness[mode] {
+			t.Fatalf("comfort noise should leave a flatter residual than %s: comfort=%.3f, %s=%.3f",
+				mode, flatness[FloorComfortNoise], mode, flatness[mode])
+		}
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.FloorMode = "silence"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for unknown floor mode")
+	}
+}
+
+func TestWithAmount(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+	toneStart := sampleRate / 2
+
+	samples := pseudoNoise(n, 123, 0.05)
+	for i := toneStart; i < n; i++ {
+		samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	// noiseToTone measures the noise-only lead relative to the tone, which
+	// is independent of the final peak normalization.
+	noiseToTone := func(x []float64) float64 {
+		return rms(x[FrameSize:toneStart-FrameSize]) / rms(x[toneStart+FrameSize:n-FrameSize])
+	}
+	inputRatio := noiseToTone(samples)
+
+	passthrough, err := DenoiseWithConfig(samples, sampleRate, DefaultDenoiseConfig().WithAmount(0))
+	if err != nil {
+		t.Fatalf("amount 0: %v", err)
+	}
+	if c := correlation(samples[FrameSize:n-FrameSize], passthrough[FrameSize:n-FrameSize]); c < 0.9999 {
+		t.Fatalf("amount 0 should be near-passthrough: correlation=%.6f", c)
+	}
+
+	prev := 0.0
+	for _, amount := range []float64{0, 25, 50, 75, 100} {
+		cfg := DefaultDenoiseConfig().WithAmount(amount)
+		cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("amount %.0f: %v", amount, err)
+		}
+		reduction := 20 * math.Log10(inputRatio/noiseToTone(cleaned))
+		t.Logf("amount %3.0f: reduction %.1f dB", amount, reduction)
+		if amount > 0 && reduction <= prev {
+			t.Fatalf("reduction not increasing at amount %.0f: %.1f dB <= %.1f dB", amount, reduction, prev)
+		}
+		prev = reduction
+	}
+	if prev < 20 {
+		t.Fatalf("expected strong reduction at amount 100, got %.1f dB", prev)
+	}
+}
+
+func TestNormalizeRMS(t *testing.T) {
+	samples := make([]float64, 10000)
+	for i := range samples {
+		samples[i] = 0.01 * math.Sin(2*math.Pi*float64(i)/100)
+	}
+	normalizeRMS(samples, RMSTarget, PeakTarget)
+	if got := rms(samples); math.Abs(got-RMSTarget) > 1e-9 {
+		t.Fatalf("expected RMS %.3f, got %.6f", RMSTarget, got)
+	}
+
+	// A spiky signal is limited by the peak ceiling instead.
+	spiky := make([]float64, 10000)
+	spiky[5000] = 0.5
+	normalizeRMS(spiky, RMSTarget, PeakTarget)
+	if math.Abs(spiky[5000]-PeakTarget) > 1e-12 {
+		t.Fatalf("expected peak limited to %.2f, got %.4f", PeakTarget, spiky[5000])
+	}
+}
+
+func TestSubtractionExponentSweep(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+	toneStart := sampleRate / 2
+
+	tone := make([]float64, n)
+	samples := pseudoNoise(n, 77, 0.05)
+	for i := toneStart; i < n; i++ {
+		tone[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		samples[i] += tone[i]
+	}
+	noiseToTone := func(x []float64) float64 {
+		return rms(x[FrameSize:toneStart-FrameSize]) / rms(x[toneStart+FrameSize:n-FrameSize])
+	}
+	inputRatio := noiseToTone(samples)
+
+	var prevReduction, prevCorr float64
+	for i, gamma := range []float64{1, 1.25, 1.5, 1.75, 2} {
+		cfg := DefaultDenoiseConfig()
+		cfg.SubtractionExponent = gamma
+		cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("gamma %.2f: %v", gamma, err)
+		}
+		reduction := 20 * math.Log10(inputRatio/noiseToTone(cleaned))
+		corr := correlation(tone[toneStart+FrameSize:n-FrameSize], cleaned[toneStart+FrameSize:n-FrameSize])
+		t.Logf("gamma %.2f: reduction %.1f dB, tone correlation %.4f", gamma, reduction, corr)
+
+		if reduction < 5 {
+			t.Errorf("gamma %.2f: reduction %.1f dB, want at least 5 dB", gamma, reduction)
+		}
+		if corr < 0.99 {
+			t.Errorf("gamma %.2f: tone correlation %.4f, want at least 0.99", gamma, corr)
+		}
+		// At a fixed alpha, subtracting powers removes less than
+		// subtracting magnitudes; the change should be gradual.
+		if i > 0 && (reduction > prevReduction || prevReduction-reduction > 8 || math.Abs(corr-prevCorr) > 0.01) {
+			t.Errorf("gamma %.2f: metrics jumped from %.1f dB / %.4f to %.1f dB / %.4f",
+				gamma, prevReduction, prevCorr, reduction, corr)
+		}
+		prevReduction, prevCorr = reduction, corr
+	}
+}
+
+func TestSubtractionExponentInvalid(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	cfg.SubtractionExponent = 0
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an error for a zero subtraction exponent")
+	}
+}
+
+func TestDenoiseMaxSamples(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	cfg.MaxSamples = 8000
+	if _, err := DenoiseWithConfig(make([]float64, 8000), 8000, cfg); err != nil {
+		t.Fatalf("input at the limit rejected: %v", err)
+	}
+	if _, err := DenoiseWithConfig(make([]float64, 8001), 8000, cfg); err == nil {
+		t.Fatal("expected an error for input over the limit")
+	}
+
+	// An absurd length is caught by the default limit, which every entry
+	// point checks before allocating.
+	err := DefaultDenoiseConfig().checkLength(1 << 40)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum") {
+		t.Fatalf("expected a length error, got %v", err)
+	}
+}
+
+func TestLookAheadPreservesOnset(t *testing.T) {
+	sampleRate := 44100
+	n := 2 * sampleRate
+	onset := sampleRate + 300 // not aligned to a hop
+
+	// Quiet noise, then a loud decaying burst (a door slam).
+	samples := pseudoNoise(n, 31, 0.02)
+	burst := pseudoNoise(n, 32, 0.8)
+	for i := onset; i < n; i++ {
+		samples[i] += burst[i] * math.Exp(-float64(i-onset)/float64(sampleRate/20))
+	}
+
+	// Energy of the first 10 ms of the burst, output relative to input.
+	onsetDB := func(out []float64) float64 {
+		window := sampleRate / 100
+		return 20 * math.Log10(rms(out[onset:onset+window])/rms(samples[onset:onset+window]))
+	}
+
+	cfg := DefaultDenoiseConfig().WithAmount(100)
+	cfg.NormalizeMode = NormalizeNone
+	plain, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.LookAheadMs = 50
+	ahead, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plainDB, aheadDB := onsetDB(plain), onsetDB(ahead)
+	t.Logf("onset level: without look-ahead %.2f dB, with %.2f dB", plainDB, aheadDB)
+	if aheadDB < -2 {
+		t.Errorf("onset attenuated by %.2f dB with look-ahead, want under 2 dB", -aheadDB)
+	}
+	if aheadDB < plainDB+3 {
+		t.Errorf("look-ahead did not help: %.2f dB vs %.2f dB", aheadDB, plainDB)
+	}
+}
+
+func TestWithLowLatency(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate * 2
+	toneStart := sampleRate / 2
+
+	samples := pseudoNoise(n, 61, 0.05)
+	for i := toneStart; i < n; i++ {
+		samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	def := DefaultDenoiseConfig()
+	cfg := def.WithLowLatency()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("low-latency config invalid: %v", err)
+	}
+	if cfg.FrameSize >= def.FrameSize {
+		t.Fatalf("expected a smaller frame than %d, got %d", def.FrameSize, cfg.FrameSize)
+	}
+	span := func(c DenoiseConfig) int { return (c.NoiseFrames-1)*c.HopSize + c.FrameSize }
+	if span(cfg) != span(def) {
+		t.Fatalf("noise estimate spans %d samples, default %d", span(cfg), span(def))
+	}
+
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	noiseToTone := func(x []float64) float64 {
+		return rms(x[FrameSize:toneStart-FrameSize]) / rms(x[toneStart+FrameSize:n-FrameSize])
+	}
+	reduction := 20 * math.Log10(noiseToTone(samples)/noiseToTone(cleaned))
+	t.Logf("low latency: %d-sample frames, reduction %.1f dB", cfg.FrameSize, reduction)
+	if reduction < 10 {
+		t.Fatalf("expected at least 10 dB of reduction, got %.1f", reduction)
+	}
+
+	cfg.LookAheadMs = 20
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected look-ahead to be rejected in low-latency mode")
+	}
+}
+
+func TestAlgorithmicLatency(t *testing.T) {
+	def := DefaultDenoiseConfig()
+	ahead := def
+	ahead.LookAheadMs = 50 // 2205 samples, rounded up to 3 hops of 1024
+
+	for _, tc := range []struct {
+		name string
+		cfg  DenoiseConfig
+		want time.Duration
+	}{
+		{"default", def, 46439909},                      // 2048 / 44100 s
+		{"low latency", def.WithLowLatency(), 11609977}, // 512 / 44100 s
+		{"look-ahead", ahead, 116099773},                // 5120 / 44100 s
+	} {
+		if got := AlgorithmicLatency(tc.cfg, 44100); (got - tc.want).Abs() > time.Microsecond {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestProcessBand(t *testing.T) {
+	sampleRate := 44100
+	n := 65536
+
+	// Hiss above 3 kHz under a 440 Hz tone.
+	spectrum := FFT(realToComplex(pseudoNoise(n, 71, 0.2)))
+	for k := 0; k <= n/2; k++ {
+		if float64(k)*float64(sampleRate)/float64(n) < 3000 {
+			spectrum[k] = 0
+			spectrum[(n-k)%n] = 0
+		}
+	}
+	tone := make([]float64, n)
+	samples := make([]float64, n)
+	for i, v := range IFFT(spectrum) {
+		tone[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		samples[i] = tone[i] + real(v)
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	cfg.ProcessBandLow = 2000
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tone sits in the leading noise estimate too, yet comes through
+	// unchanged; only the hiss is reduced.
+	span := [2]int{FrameSize, n - FrameSize}
+	residual := make([]float64, 0, span[1]-span[0])
+	noise := make([]float64, 0, span[1]-span[0])
+	var toneAmp float64
+	for i := span[0]; i < span[1]; i++ {
+		residual = append(residual, cleaned[i]-tone[i])
+		noise = append(noise, samples[i]-tone[i])
+		toneAmp += cleaned[i] * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	toneAmp *= 2 / float64(span[1]-span[0])
+	reduction := 20 * math.Log10(rms(noise)/rms(residual))
+	t.Logf("tone amplitude %.4f (input 0.5), hiss reduction %.1f dB", toneAmp, reduction)
+	if math.Abs(toneAmp-0.5) > 0.005 {
+		t.Errorf("tone below the band changed: amplitude %.4f, want 0.5", toneAmp)
+	}
+	if reduction < 10 {
+		t.Errorf("expected in-band hiss reduced by at least 10 dB, got %.1f", reduction)
+	}
+
+	cfg.ProcessBandHigh = 1000 // below ProcessBandLow
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected an error for an empty band")
+	}
+}
+
+func TestEstimateDenoise(t *testing.T) {
+	sampleRate := 16000
+	n := sampleRate * 3
+	tone := make([]float64, n)
+	for i := sampleRate; i < n; i++ { // after the leading noise frames
+		tone[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+
+	var prevSNR float64
+	for i, amp := range []float64{0.01, 0.05, 0.2} {
+		samples := pseudoNoise(n, 12, amp)
+		for j := range samples {
+			samples[j] += tone[j]
+		}
+		est, err := EstimateDenoise(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("EstimateDenoise: %v", err)
+		}
+		cleaned, report, err := DenoiseWithReport(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("DenoiseWithReport: %v", err)
+		}
+		// The edges, covered by a single window tail, are left out.
+		inner := func(x []float64) []float64 { return x[FrameSize : n-FrameSize] }
+		actual := 20 * math.Log10(rms(inner(samples))/rms(inner(cleaned)))
+		t.Logf("noise %.2f: SNR %.1f dB, estimated reduction %.2f dB, actual %.2f dB", amp, est.SNRDB, est.ReductionDB, actual)
+
+		if est.DenoiseReport != report {
+			t.Fatalf("noise %.2f: report %+v differs from denoising's %+v", amp, est.DenoiseReport, report)
+		}
+		if i > 0 && est.SNRDB >= prevSNR {
+			t.Fatalf("noise %.2f: SNR %.1f dB did not fall with more noise", amp, est.SNRDB)
+		}
+		if math.Abs(est.ReductionDB-actual) > 1 {
+			t.Fatalf("noise %.2f: estimated reduction %.2f dB, actual %.2f dB", amp, est.ReductionDB, actual)
+		}
+		prevSNR = est.SNRDB
+	}
+}
+
+func TestRecommendFrameSize(t *testing.T) {
+	for _, tc := range []struct {
+		budget     time.Duration
+		sampleRate int
+		want       int
+	}{
+		{20 * time.Millisecond, 48000, 512}, // 960 samples
+		{50 * time.Millisecond, 44100, 2048},
+		{time.Second, 16000, 8192},
+		{128 * time.Millisecond, 16000, 2048}, // exactly one frame
+		{0, 48000, minFrameSize},
+	} {
+		if got := RecommendFrameSize(tc.budget, tc.sampleRate); got != tc.want {
+			t.Errorf("RecommendFrameSize(%v, %d) = %d, want %d", tc.budget, tc.sampleRate, got, tc.want)
+		}
+	}
+
+	// Tighter budgets never yield larger frames, and every recommendation
+	// is a valid power of 2 within budget.
+	prev := 0
+	for ms := 1; ms <= 200; ms++ {
+		budget := time.Duration(ms) * time.Millisecond
+		got := RecommendFrameSize(budget, 44100)
+		if !isPowerOf2(got) || got < minFrameSize {
+			t.Fatalf("%v: %d is not a valid frame size", budget, got)
+		}
+		if got < prev {
+			t.Fatalf("%v: frame %d smaller than %d for a tighter budget", budget, got, prev)
+		}
+		cfg := DefaultDenoiseConfig()
+		cfg.FrameSize, cfg.HopSize = got, got/2
+		if AlgorithmicLatency(cfg, 44100) > budget {
+			t.Fatalf("%v: frame %d exceeds the budget", budget, got)
+		}
+		prev = got
+	}
+}
+
+func TestSeedMakesComfortNoiseReproducible(t *testing.T) {
+	sampleRate := 16000
+	samples := pseudoNoise(sampleRate*2, 606, 0.1)
+	for i := sampleRate; i < len(samples); i++ {
+		samples[i] += 0.3 * math.Sin(2*math.Pi*300*float64(i)/float64(sampleRate))
+	}
+	render := func(seed int64) []byte {
+		cfg := DefaultDenoiseConfig()
+		cfg.FloorMode = FloorComfortNoise
+		cfg.Seed = seed
+		out, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		return WriteWAVFormat(out, sampleRate, Float32)
+	}
+
+	if !bytes.Equal(render(7), render(7)) {
+		t.Fatal("the same seed produced different output")
+	}
+	if bytes.Equal(render(7), render(8)) {
+		t.Fatal("different seeds produced identical comfort noise")
+	}
+}
+
+func TestRollingNormalizer(t *testing.T) {
+	sampleRate := 16000
+	// Two seconds quiet, two loud, two quiet again.
+	n := 6 * sampleRate
+	samples := make([]float64, n)
+	for i := range samples {
+		amp := 0.05
+		if i >= 2*sampleRate && i < 4*sampleRate {
+			amp = 0.8
+		}
+		samples[i] = amp * math.Sin(2*math.Pi*220*float64(i)/float64(sampleRate))
+	}
+
+	// Fed block by block, as a stream would be.
+	whole := append([]float64(nil), samples...)
+	newRollingNormalizer(sampleRate).process(whole)
+	streamed := append([]float64(nil), samples...)
+	rn := newRollingNormalizer(sampleRate)
+	for start := 0; start < n; start += 333 {
+		rn.process(streamed[start:min(start+333, n)])
+	}
+	if !slices.Equal(whole, streamed) {
+		t.Fatal("block-wise processing differs from one piece")
+	}
+
+	if peak := peakLevel(streamed); peak > PeakTarget+1e-12 {
+		t.Fatalf("peak %.4f exceeds %.2f", peak, PeakTarget)
+	}
+
+	// Once settled, each section sits near RMSTarget despite the 24 dB
+	// step between them.
+	for _, sec := range []int{1, 3, 5} {
+		level := rms(streamed[sec*sampleRate+sampleRate/2 : (sec+1)*sampleRate])
+		t.Logf("second %d: input rms %.4f, output rms %.4f", sec, rms(samples[sec*sampleRate:(sec+1)*sampleRate]), level)
+		if math.Abs(20*math.Log10(level/RMSTarget)) > 3 {
+			t.Fatalf("second %d: output rms %.4f not within 3 dB of %.2f", sec, level, RMSTarget)
+		}
+	}
+
+	if cfg := DefaultDenoiseConfig().WithLowLatency(); cfg.NormalizeMode != NormalizeRolling {
+		t.Fatalf("expected low latency to normalize with %q, got %q", NormalizeRolling, cfg.NormalizeMode)
+	}
+}
+
+func TestForSampleRateKeepsDurations(t *testing.T) {
+	def := DefaultDenoiseConfig()
+	def.GainSmoothing = 0.5
+	const long = 1 << 20 // enough frames for any noise window
+	target := float64(leadingNoiseEnd(long, def)) / ReferenceSampleRate * 1000
+	tau := func(cfg DenoiseConfig, sampleRate int) float64 {
+		return -float64(cfg.HopSize) / float64(sampleRate) / math.Log(cfg.GainSmoothing) * 1000
+	}
+
+	for _, sampleRate := range []int{16000, 44100, 48000, 96000} {
+		cfg := def.ForSampleRate(sampleRate)
+		ms := float64(leadingNoiseEnd(long, cfg)) / float64(sampleRate) * 1000
+		t.Logf("%d Hz: %d noise frames, %.1f ms (target %.1f)", sampleRate, cfg.NoiseFrames, ms, target)
+		if math.Abs(ms-target) > float64(cfg.HopSize)/float64(sampleRate)*1000/2 {
+			t.Fatalf("%d Hz: noise window %.1f ms, want %.1f within half a hop", sampleRate, ms, target)
+		}
+		if got, want := tau(cfg, sampleRate), tau(def, ReferenceSampleRate); math.Abs(got-want) > 1e-9 {
+			t.Fatalf("%d Hz: smoothing time constant %.3f ms, want %.3f", sampleRate, got, want)
+		}
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%d Hz: %v", sampleRate, err)
+		}
+	}
+
+	if cfg := def.ForSampleRate(ReferenceSampleRate); cfg != def {
+		t.Fatalf("expected no change at the reference rate, got %+v", cfg)
+	}
+}
+
+func TestDenoiseContextScalesDefaults(t *testing.T) {
+	const sampleRate = 48000
+	samples := SyntheticNoisyTone(2*sampleRate, sampleRate)
+	want, err := DenoiseWithConfig(samples, sampleRate, DefaultDenoiseConfig().ForSampleRate(sampleRate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DenoiseContext(context.Background(), samples, sampleRate, DefaultDenoiseConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatal("the defaults were not scaled to 48 kHz")
+	}
+}
+
+func TestWarmUpReducesStartupArtifacts(t *testing.T) {
+	sampleRate := 16000
+	preroll := 2 * sampleRate
+	n := 3 * sampleRate
+
+	// Half a second of noise, then a tone in noise. The reference is the
+	// same clip processed after two more seconds of noise, by which point
+	// the gain smoothing has settled.
+	long := pseudoNoise(preroll+n, 314, 0.02)
+	for i := preroll + sampleRate/2; i < len(long); i++ {
+		long[i] += 0.3 * math.Sin(2*math.Pi*300*float64(i)/float64(sampleRate))
+	}
+	clip := long[preroll:]
+
+	cfg := DefaultDenoiseConfig()
+	cfg.GainSmoothing = 0.8
+	cfg.NormalizeMode = NormalizeNone
+	ref, err := DenoiseWithConfig(long, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first 100 ms, skipping the opening 16 ms where only the tail of
+	// the first window contributes.
+	head := func(x []float64) []float64 { return x[256 : sampleRate/10] }
+	refLevel := rms(head(ref[preroll:]))
+
+	// Level error of the first 100 ms against the reference, in dB.
+	startup := func(warm bool) float64 {
+		cfg.WarmUp = warm
+		out, err := DenoiseWithConfig(clip, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return math.Abs(20 * math.Log10(rms(head(out))/refLevel))
+	}
+	cold, warm := startup(false), startup(true)
+	t.Logf("first 100 ms level error: cold %.1f dB, warm %.1f dB", cold, warm)
+	if warm > cold/2 {
+		t.Fatalf("expected warm-up to at least halve the startup level error: cold %.1f dB, warm %.1f dB", cold, warm)
+	}
+
+	// A primed rolling normalizer starts at its steady-state gain instead
+	// of ramping up to it.
+	noise := pseudoNoise(sampleRate, 7, 0.01)
+	gainAt := func(prime bool) float64 {
+		x := slices.Clone(noise)
+		rn := newRollingNormalizer(sampleRate)
+		if prime {
+			rn.prime(0, x)
+		}
+		rn.process(x)
+		return 20 * math.Log10(rms(x[:sampleRate/10])/rms(noise[:sampleRate/10]))
+	}
+	steady := 20 * math.Log10(RMSTarget/rms(noise))
+	steady = math.Min(steady, rollingMaxGainDB)
+	coldGain, warmGain := gainAt(false), gainAt(true)
+	t.Logf("first 100 ms normalizer gain: cold %.1f dB, primed %.1f dB, steady %.1f dB", coldGain, warmGain, steady)
+	if math.Abs(warmGain-steady) > 1 || math.Abs(coldGain-steady) < 3 {
+		t.Fatalf("expected only the primed normalizer to start near %.1f dB: cold %.1f dB, primed %.1f dB", steady, coldGain, warmGain)
+	}
+}
+
+func TestDenoiseToFloatRange(t *testing.T) {
+	sampleRate := 16000
+	n := sampleRate * 2
+	samples := pseudoNoise(n, 11, 0.02)
+	for i := sampleRate / 2; i < n; i++ {
+		samples[i] += 0.9 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	peak := func(x []float64) float64 { return peakLevel(x[64 : len(x)-64]) }
+
+	cfg := DefaultDenoiseConfig()
+	cfg.InputGainDB = 6
+	cfg.NormalizeMode = NormalizeNone
+	out, err := DenoiseToFloat(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != n {
+		t.Fatalf("expected %d samples, got %d", n, len(out))
+	}
+	if p := peak(out); p <= 1 {
+		t.Fatalf("expected NormalizeNone to leave samples above unity, peak %.3f", p)
+	}
+
+	for _, mode := range []string{NormalizePeak, NormalizeRMS, NormalizeRolling} {
+		cfg.NormalizeMode = mode
+		out, err := DenoiseToFloat(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := peakLevel(out); p > PeakTarget+1e-9 {
+			t.Fatalf("%s: expected peak within %.2f, got %.3f", mode, PeakTarget, p)
+		}
+	}
+}
+
+func TestDenoiseOutOfRangeInput(t *testing.T) {
+	sampleRate := 16000
+	unit := SyntheticNoisyTone(sampleRate*2, sampleRate)
+	scale(1/peakLevel(unit), unit)
+	loud := slices.Clone(unit)
+	scale(4, loud)
+
+	for _, mode := range []string{NormalizeNone, NormalizePeak, NormalizeRMS} {
+		cfg := DefaultDenoiseConfig()
+		cfg.FloorMode = FloorComfortNoise
+		cfg.NormalizeMode = mode
+		want, err := DenoiseWithConfig(unit, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DenoiseWithConfig(loud, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Without normalization the level of the input is kept.
+		level := 1.0
+		if mode == NormalizeNone {
+			level = 4
+		}
+		for i := range want {
+			if math.Abs(got[i]-level*want[i]) > 1e-9 {
+				t.Fatalf("%s: sample %d is %v, want %v", mode, i, got[i], level*want[i])
+			}
+		}
+	}
+}
+
+func TestPreserveHeadroom(t *testing.T) {
+	sampleRate := 16000
+	cfg := DefaultDenoiseConfig()
+	cfg.PreserveHeadroom = true
+	for _, inputPeak := range []float64{0.25, 0.9, 4} {
+		samples := SyntheticNoisyTone(sampleRate*2, sampleRate)
+		scale(inputPeak/peakLevel(samples), samples)
+
+		out, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peak := peakLevel(out)
+		t.Logf("input peak %.2f: output peak %.4f", inputPeak, peak)
+		if peak > inputPeak*(1+1e-12) {
+			t.Fatalf("input peak %.2f: output peak %.4f exceeds it", inputPeak, peak)
+		}
+		// Denoising alone overshoots the input peak, so the guard is
+		// what holds it.
+		none := cfg
+		none.PreserveHeadroom, none.NormalizeMode = false, NormalizeNone
+		unguarded, err := DenoiseWithConfig(samples, sampleRate, none)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := peakLevel(unguarded); p <= inputPeak {
+			t.Fatalf("input peak %.2f: expected unguarded output to overshoot, peak %.4f", inputPeak, p)
+		}
+		// Headroom is kept rather than normalized away.
+		if peak < inputPeak/2 {
+			t.Fatalf("input peak %.2f: output peak %.4f, expected the level kept", inputPeak, peak)
+		}
+	}
+}
+
+func TestOutputLengthMatchesInput(t *testing.T) {
+	sampleRate := 16000
+	cfg := DefaultDenoiseConfig()
+	f, h := cfg.FrameSize, cfg.HopSize
+	lengths := []int{1, 2, 3, h - 1, h, h + 1, f - 1, f, f + 1, f + h - 1, f + h + 1, 2*f - 1, 3*f + 7}
+	for _, n := range lengths {
+		samples := SyntheticNoisyTone(n, sampleRate)
+
+		out, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != n {
+			t.Fatalf("Denoise: %d samples in, %d out", n, len(out))
+		}
+		out, err = DenoiseWithNoiseRegion(samples, sampleRate, 0, n, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) != n {
+			t.Fatalf("DenoiseWithNoiseRegion: %d samples in, %d out", n, len(out))
+		}
+		left, right, err := DenoiseStereo(samples, samples, sampleRate, StereoConfig{DenoiseConfig: cfg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(left) != n || len(right) != n {
+			t.Fatalf("DenoiseStereo: %d samples in, %d and %d out", n, len(left), len(right))
+		}
+		clips, err := DenoiseSession([][]float64{samples, SyntheticNoisyTone(n+1, sampleRate)}, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clips[0]) != n || len(clips[1]) != n+1 {
+			t.Fatalf("DenoiseSession: %d and %d samples in, %d and %d out", n, n+1, len(clips[0]), len(clips[1]))
+		}
+
+		// Trimming removes exactly what it reports.
+		trimCfg := DefaultTrimConfig()
+		trimCfg.Internal = true
+		trimmed := TrimSilence(samples, sampleRate, trimCfg)
+		if got, want := len(trimmed.Samples), n-trimmed.Removed(); got != want {
+			t.Fatalf("TrimSilence: %d samples in, %d removed, %d out", n, trimmed.Removed(), got)
+		}
+	}
+}
+
+func TestDenoiseResidual(t *testing.T) {
+	const sampleRate = 16000
+	// A second of noise, then a tone over it.
+	samples := pseudoNoise(3*sampleRate, 7, 0.05)
+	for i := sampleRate; i < len(samples); i++ {
+		samples[i] += 0.3 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	cfg := DefaultDenoiseConfig()
+
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	residual, err := DenoiseResidual(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(residual) != len(samples) {
+		t.Fatalf("%d samples in, %d residual samples out", len(samples), len(residual))
+	}
+
+	// cleaned is peak-normalized: undo its gain, fitted by least squares,
+	// away from the edges.
+	span := [2]int{cfg.FrameSize, len(samples) - cfg.FrameSize}
+	var num, den float64
+	for i := span[0]; i < span[1]; i++ {
+		num += cleaned[i] * (samples[i] - residual[i])
+		den += cleaned[i] * cleaned[i]
+	}
+	gain := num / den
+	diff := make([]float64, 0, span[1]-span[0])
+	for i := span[0]; i < span[1]; i++ {
+		diff = append(diff, gain*cleaned[i]+residual[i]-samples[i])
+	}
+	if e := rms(diff) / rms(samples[span[0]:span[1]]); e > 1e-9 {
+		t.Fatalf("cleaned + residual differs from the input by %.3g relative rms", e)
+	}
+
+	// The residual is the noise: the tone is kept in the cleaned output.
+	if tone := toneAmplitude(residual[sampleRate:2*sampleRate], 440, sampleRate); tone > 0.01 {
+		t.Fatalf("residual carries %.4f of the 0.3 tone", tone)
+	}
+	if r, noise := rms(residual[span[0]:sampleRate]), rms(samples[span[0]:sampleRate]); r < 0.5*noise {
+		t.Fatalf("residual rms %.4f over the noise, which has rms %.4f", r, noise)
+	}
+}
+
+func TestTruePeakNormalize(t *testing.T) {
+	// A quarter-sample-rate sine sampled 45° off its crests: every sample
+	// is at 0.707 of the 0.9 amplitude the waveform reaches between them.
+	// The fades keep the edges from ringing.
+	samples := make([]float64, 4096)
+	for i := range samples {
+		samples[i] = 0.9 * math.Sin(math.Pi/2*float64(i)+math.Pi/4)
+	}
+	applyFade(samples, 256, 256)
+	if got := truePeakLevel(samples); math.Abs(got-0.9) > 0.005 {
+		t.Fatalf("true peak %.4f, want 0.9 (sample peak %.4f)", got, peakLevel(samples))
+	}
+
+	peakFor := func(truePeak bool) float64 {
+		cfg := DefaultDenoiseConfig()
+		cfg.TruePeak = truePeak
+		out := slices.Clone(samples)
+		finishOutput([][]float64{out}, peakLevel(samples), 16000, cfg)
+		return peakLevel(out)
+	}
+	samplePeak, truePeak := peakFor(false), peakFor(true)
+	t.Logf("output sample peak: sample-peak mode %.4f, true-peak mode %.4f", samplePeak, truePeak)
+	if math.Abs(samplePeak-PeakTarget) > 1e-9 {
+		t.Fatalf("sample-peak mode peak %.4f, want %.2f", samplePeak, PeakTarget)
+	}
+	if truePeak > samplePeak*0.75 {
+		t.Fatalf("true-peak mode should scale lower: %.4f vs %.4f", truePeak, samplePeak)
+	}
+}
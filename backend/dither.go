@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+)
+
+// Dither selects how ApplyDither treats samples before they are
+// quantized to an integer sample format.
+type Dither int
+
+const (
+	// NoDither rounds each sample to the nearest level, so the rounding
+	// error follows the signal and quiet passages distort.
+	NoDither Dither = iota
+	// TPDFDither adds triangular-PDF noise of ±1 LSB before rounding,
+	// which makes the error signal-independent white noise.
+	TPDFDither
+	// ShapedDither is TPDFDither with first-order error feedback, which
+	// moves the noise towards high frequencies, where it is less audible.
+	ShapedDither
+)
+
+// ditherNames lists the dither form value of each Dither.
+var ditherNames = []struct {
+	name   string
+	dither Dither
+}{
+	{"none", NoDither},
+	{"tpdf", TPDFDither},
+	{"shaped", ShapedDither},
+}
+
+// ParseDither parses a dither value: "none", "tpdf" or "shaped".
+func ParseDither(s string) (Dither, error) {
+	for _, d := range ditherNames {
+		if d.name == s {
+			return d.dither, nil
+		}
+	}
+	return 0, fmt.Errorf("wav: unsupported dither %q (want none, tpdf or shaped)", s)
+}
+
+// ApplyDither returns interleaved samples of numChannels channels
+// quantized to the levels of format with dither d, as float values the
+// encoder then stores exactly. Samples are clamped to [-1, 1] as the
+// encoder would. Float32 output and NoDither return samples unchanged.
+// The dither noise is drawn from seed (DenoiseConfig.Seed, for denoised
+// audio), so the same input and seed encode to the same file.
+func ApplyDither(samples []float64, numChannels int, format SampleFormat, d Dither, seed int64) []float64 {
+	if d == NoDither || format.isFloat() || numChannels < 1 {
+		return samples
+	}
+	bits := uint(format.BitsPerSample() - 1)
+	lsb := 1 / float64(int64(1)<<bits)
+	rng := rand.New(rand.NewPCG(uint64(seed), 0))
+	errs := make([]float64, numChannels) // last error per channel, for shaping
+
+	out := make([]float64, len(samples))
+	for i, s := range samples {
+		ch := i % numChannels
+		if d == ShapedDither {
+			s -= errs[ch]
+		}
+		s = max(-1, min(1, s))
+		noise := (rng.Float64() - rng.Float64()) * lsb
+		v := max(-1, min(1, s+noise))
+		out[i] = dequantize(quantize(v, bits), bits)
+		// The error fed back is the rounding error plus the dither,
+		// leaving out any clipping, which would otherwise accumulate.
+		errs[ch] = out[i] - v + noise
+	}
+	return out
+}
+
+// dequantize is the inverse of quantize: it maps a level back to [-1, 1].
+func dequantize(v int32, bits uint) float64 {
+	scale := float64(int64(1) << bits)
+	if v >= 0 {
+		return float64(v) / (scale - 1)
+	}
+	return float64(v) / scale
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// quietSine returns a 1 kHz sine at 48 kHz with the given amplitude.
+func quietSine(n int, amp float64) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = amp * math.Sin(2*math.Pi*1000*float64(i)/48000)
+	}
+	return x
+}
+
+func TestApplyDitherKeepsQuietSignal(t *testing.T) {
+	// A sine under half an LSB rounds away to silence without dither;
+	// dithered, it survives on average.
+	in := quietSine(48000, 0.4/32768)
+	undithered, _, err := ReadWAV(WriteWAVFormat(in, 48000, PCM16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rms(undithered) != 0 {
+		t.Fatalf("undithered: expected silence, got rms %g", rms(undithered))
+	}
+
+	dithered := ApplyDither(in, 1, PCM16, TPDFDither, defaultSeed)
+	decoded, _, err := ReadWAV(WriteWAVFormat(dithered, 48000, PCM16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dot, energy float64
+	for i := range in {
+		dot += decoded[i] * in[i]
+		energy += in[i] * in[i]
+	}
+	if gain := dot / energy; math.Abs(gain-1) > 0.1 {
+		t.Fatalf("dithered: sine gain %.3f, want about 1", gain)
+	}
+
+	// The dithered values are on the 16-bit grid, so encoding stores them
+	// unchanged.
+	for i, v := range dithered {
+		if got := quantize(decoded[i], 15); got != quantize(v, 15) || dequantize(got, 15) != v {
+			t.Fatalf("sample %d: %g is not a 16-bit level", i, v)
+		}
+	}
+}
+
+func TestShapedDitherMovesNoiseUp(t *testing.T) {
+	in := quietSine(48000, 0.01)
+	// lowHigh compares the power of the error's lower and upper halves of
+	// the spectrum, split by the sum and difference of adjacent samples.
+	lowHigh := func(d Dither) float64 {
+		out := ApplyDither(in, 1, PCM16, d, defaultSeed)
+		var low, high float64
+		for i := 1; i < len(in); i++ {
+			e0, e1 := out[i-1]-in[i-1], out[i]-in[i]
+			low += (e0 + e1) * (e0 + e1)
+			high += (e0 - e1) * (e0 - e1)
+		}
+		return low / high
+	}
+	if r := lowHigh(TPDFDither); r < 0.8 || r > 1.25 {
+		t.Fatalf("tpdf: low/high noise ratio %.2f, want white", r)
+	}
+	if r := lowHigh(ShapedDither); r > 0.5 {
+		t.Fatalf("shaped: low/high noise ratio %.2f, want noise moved up", r)
+	}
+}
+
+func TestApplyDitherPassThrough(t *testing.T) {
+	in := pseudoNoise(1000, 3, 0.5)
+	if out := ApplyDither(in, 1, PCM16, NoDither, defaultSeed); !slices.Equal(out, in) {
+		t.Fatal("NoDither changed the samples")
+	}
+	if out := ApplyDither(in, 1, Float32, ShapedDither, defaultSeed); !slices.Equal(out, in) {
+		t.Fatal("dither changed float output")
+	}
+	// The dither is seeded, so output is reproducible.
+	if a, b := ApplyDither(in, 2, PCM24, ShapedDither, defaultSeed), ApplyDither(in, 2, PCM24, ShapedDither, defaultSeed); !slices.Equal(a, b) {
+		t.Fatal("dithering the same samples twice differs")
+	}
+	// Another seed draws other noise.
+	if a, b := ApplyDither(in, 2, PCM24, ShapedDither, 1), ApplyDither(in, 2, PCM24, ShapedDither, 2); slices.Equal(a, b) {
+		t.Fatal("different seeds gave the same dither")
+	}
+}
+
+func TestParseDither(t *testing.T) {
+	for _, d := range ditherNames {
+		if got, err := ParseDither(d.name); err != nil || got != d.dither {
+			t.Fatalf("ParseDither(%q) = %v, %v", d.name, got, err)
+		}
+	}
+	if _, err := ParseDither("rpdf"); err == nil {
+		t.Fatal("expected an error for an unknown dither")
+	}
+}
@@ -3,40 +3,74 @@ package main
 import (
 	"math"
 	"math/cmplx"
+	"sync"
 )
 
-// FFT computes the forward discrete Fourier transform using the
-// iterative Cooley-Tukey radix-2 decimation-in-time algorithm.
-// len(x) MUST be a power of 2; panics otherwise.
-func FFT(x []complex128) []complex128 {
-	n := len(x)
-	if n == 0 {
-		return nil
-	}
+// FFTPlan holds the precomputed tables for transforms of one size: the
+// twiddle factors exp(-2πik/n), each computed directly rather than by
+// repeated multiplication (which accumulates rounding error over large
+// frames), and the bit-reversal permutation. A plan is safe for concurrent
+// use.
+type FFTPlan struct {
+	n       int
+	twiddle []complex128 // twiddle[k] = exp(-2πik/n), k < n/2
+	rev     []int        // rev[i] = i with its log2(n) bits reversed
+}
+
+// NewFFTPlan builds a plan for transforms of length n.
+// n MUST be a power of 2; panics otherwise.
+func NewFFTPlan(n int) *FFTPlan {
 	if !isPowerOf2(n) {
 		panic("fft: length must be a power of 2")
 	}
 
-	// Copy input so we don't mutate the caller's slice.
-	out := make([]complex128, n)
-	copy(out, x)
+	p := &FFTPlan{
+		n:       n,
+		twiddle: make([]complex128, n/2),
+		rev:     make([]int, n),
+	}
+	for k := range p.twiddle {
+		sin, cos := math.Sincos(-2 * math.Pi * float64(k) / float64(n))
+		p.twiddle[k] = complex(cos, sin)
+	}
+	bits := int(math.Log2(float64(n)))
+	for i := range p.rev {
+		p.rev[i] = reverseBits(i, bits)
+	}
+	return p
+}
+
+// Size returns the transform length of the plan.
+func (p *FFTPlan) Size() int {
+	return p.n
+}
+
+// Forward computes the forward DFT of x using the iterative Cooley-Tukey
+// radix-2 decimation-in-time algorithm. len(x) MUST equal p.Size().
+func (p *FFTPlan) Forward(x []complex128) []complex128 {
+	n := p.n
+	if len(x) != n {
+		panic("fft: input length does not match plan size")
+	}
 
-	// Bit-reversal permutation.
-	bitReverse(out)
+	// Bit-reversal permutation into a fresh slice, so we don't mutate the
+	// caller's input.
+	out := make([]complex128, n)
+	for i, j := range p.rev {
+		out[j] = x[i]
+	}
 
 	// Butterfly stages.
-	for s := 1; s <= int(math.Log2(float64(n))); s++ {
-		m := 1 << s                                          // butterfly span
-		wm := cmplx.Exp(complex(0, -2*math.Pi/float64(m)))  // twiddle factor (negative for forward)
+	for m := 2; m <= n; m <<= 1 {
+		half := m / 2
+		stride := n / m // twiddle table step for this span
 
 		for k := 0; k < n; k += m {
-			w := complex(1, 0)
-			for j := 0; j < m/2; j++ {
-				t := w * out[k+j+m/2]
+			for j := 0; j < half; j++ {
+				t := p.twiddle[j*stride] * out[k+j+half]
 				u := out[k+j]
 				out[k+j] = u + t
-				out[k+j+m/2] = u - t
-				w *= wm
+				out[k+j+half] = u - t
 			}
 		}
 	}
@@ -44,22 +78,18 @@ func FFT(x []complex128) []complex128 {
 	return out
 }
 
-// IFFT computes the inverse discrete Fourier transform.
+// Inverse computes the inverse DFT of X. len(X) MUST equal p.Size().
 // Uses the conjugate-FFT-conjugate-scale identity:
-//   IFFT(X) = conj(FFT(conj(X))) / N
-// len(X) MUST be a power of 2; panics otherwise.
-func IFFT(X []complex128) []complex128 {
-	n := len(X)
-	if n == 0 {
-		return nil
-	}
-
+//
+//	IFFT(X) = conj(FFT(conj(X))) / N
+func (p *FFTPlan) Inverse(X []complex128) []complex128 {
+	n := p.n
 	conj := make([]complex128, n)
 	for i, v := range X {
 		conj[i] = cmplx.Conj(v)
 	}
 
-	result := FFT(conj)
+	result := p.Forward(conj)
 
 	scale := complex(float64(n), 0)
 	for i := range result {
@@ -69,6 +99,89 @@ func IFFT(X []complex128) []complex128 {
 	return result
 }
 
+// planCache holds one FFTPlan per transform size used by FFT and IFFT.
+var planCache sync.Map // int -> *FFTPlan
+
+// planFor returns the cached plan for length n, building it on first use.
+func planFor(n int) *FFTPlan {
+	if p, ok := planCache.Load(n); ok {
+		return p.(*FFTPlan)
+	}
+	p, _ := planCache.LoadOrStore(n, NewFFTPlan(n))
+	return p.(*FFTPlan)
+}
+
+// FFT computes the forward discrete Fourier transform using a cached
+// FFTPlan for len(x).
+// len(x) MUST be a power of 2; panics otherwise.
+func FFT(x []complex128) []complex128 {
+	n := len(x)
+	if n == 0 {
+		return nil
+	}
+	if !isPowerOf2(n) {
+		panic("fft: length must be a power of 2")
+	}
+	return planFor(n).Forward(x)
+}
+
+// IFFT computes the inverse discrete Fourier transform using a cached
+// FFTPlan for len(X).
+// len(X) MUST be a power of 2; panics otherwise.
+func IFFT(X []complex128) []complex128 {
+	n := len(X)
+	if n == 0 {
+		return nil
+	}
+	if !isPowerOf2(n) {
+		panic("fft: length must be a power of 2")
+	}
+	return planFor(n).Inverse(X)
+}
+
+// FFTConvolve returns the full linear convolution of signal with kernel,
+// len(signal)+len(kernel)-1 samples, computed by overlap-save. The block
+// size follows the kernel (at least four times its length, rounded up to a
+// power of 2), so kernels of any length are handled. Empty input yields nil.
+func FFTConvolve(signal, kernel []float64) []float64 {
+	if len(signal) == 0 || len(kernel) == 0 {
+		return nil
+	}
+	m := len(kernel)
+	size := NextPowerOf2(4 * m)
+	step := size - m + 1 // output samples each block yields
+	n := len(signal) + m - 1
+
+	h := make([]complex128, size)
+	for i, v := range kernel {
+		h[i] = complex(v, 0)
+	}
+	H := FFT(h)
+
+	// Block b covers the signal from b*step-(m-1), zero outside it; the
+	// first m-1 results of each block are wrapped around and discarded.
+	out := make([]float64, n)
+	block := make([]complex128, size)
+	for pos := 0; pos < n; pos += step {
+		for i := range block {
+			v := 0.0
+			if j := pos - (m - 1) + i; j >= 0 && j < len(signal) {
+				v = signal[j]
+			}
+			block[i] = complex(v, 0)
+		}
+		X := FFT(block)
+		for k := range X {
+			X[k] *= H[k]
+		}
+		y := IFFT(X)
+		for i := 0; i < step && pos+i < n; i++ {
+			out[pos+i] = real(y[m-1+i])
+		}
+	}
+	return out
+}
+
 // NextPowerOf2 returns the smallest power of 2 that is >= n.
 func NextPowerOf2(n int) int {
 	if n <= 1 {
@@ -86,19 +199,6 @@ func isPowerOf2(n int) bool {
 	return n > 0 && (n&(n-1)) == 0
 }
 
-// bitReverse reorders elements of x by bit-reversing their indices.
-func bitReverse(x []complex128) {
-	n := len(x)
-	bits := int(math.Log2(float64(n)))
-
-	for i := 0; i < n; i++ {
-		j := reverseBits(i, bits)
-		if j > i {
-			x[i], x[j] = x[j], x[i]
-		}
-	}
-}
-
 // reverseBits reverses the lowest `bits` bits of v.
 func reverseBits(v, bits int) int {
 	r := 0
@@ -187,3 +187,127 @@ func TestFullPipeline(t *testing.T) {
 	t.Logf("pipeline OK: %d input samples -> %d bytes WAV -> %d decoded -> %d cleaned -> %d bytes output",
 		len(samples), len(wavBytes), len(decoded), len(cleaned), len(outputWAV))
 }
+
+// fftIncremental is the previous FFT implementation, which advanced each
+// stage's twiddle factor by repeated multiplication (w *= wm). It is kept
+// here as an accuracy baseline for FFTPlan.
+func fftIncremental(x []complex128) []complex128 {
+	n := len(x)
+	bits := int(math.Log2(float64(n)))
+	out := make([]complex128, n)
+	for i := range x {
+		out[reverseBits(i, bits)] = x[i]
+	}
+	for s := 1; s <= bits; s++ {
+		m := 1 << s
+		wm := cmplx.Exp(complex(0, -2*math.Pi/float64(m)))
+		for k := 0; k < n; k += m {
+			w := complex(1, 0)
+			for j := 0; j < m/2; j++ {
+				t := w * out[k+j+m/2]
+				u := out[k+j]
+				out[k+j] = u + t
+				out[k+j+m/2] = u - t
+				w *= wm
+			}
+		}
+	}
+	return out
+}
+
+func TestFFTPlanAccuracyLargeN(t *testing.T) {
+	n := 32768
+
+	// A complex exponential at bin b has the exact spectrum X[b] = n,
+	// zero elsewhere.
+	b := 12345
+	input := make([]complex128, n)
+	for i := range input {
+		sin, cos := math.Sincos(2 * math.Pi * float64((b*i)%n) / float64(n))
+		input[i] = complex(cos, sin)
+	}
+
+	spectrumError := func(spectrum []complex128) float64 {
+		var worst float64
+		for k, v := range spectrum {
+			want := complex(0, 0)
+			if k == b {
+				want = complex(float64(n), 0)
+			}
+			worst = math.Max(worst, cmplx.Abs(v-want))
+		}
+		return worst
+	}
+
+	planErr := spectrumError(NewFFTPlan(n).Forward(input))
+	incErr := spectrumError(fftIncremental(input))
+	t.Logf("n=%d max spectrum error: table=%e, incremental=%e", n, planErr, incErr)
+	if planErr*10 >= incErr {
+		t.Fatalf("expected table twiddles to be at least 10x more accurate: table=%e, incremental=%e", planErr, incErr)
+	}
+
+	// Round trip through the plan.
+	plan := NewFFTPlan(n)
+	recovered := plan.Inverse(plan.Forward(input))
+	var worst float64
+	for i := range input {
+		worst = math.Max(worst, cmplx.Abs(recovered[i]-input[i]))
+	}
+	if worst > 1e-12 {
+		t.Fatalf("round-trip error too large: %e", worst)
+	}
+}
+
+func TestFFTPlanMatchesFFT(t *testing.T) {
+	n := 256
+	input := make([]complex128, n)
+	for i := range input {
+		input[i] = complex(math.Sin(float64(i)*0.3), math.Cos(float64(i)*0.7))
+	}
+	a := NewFFTPlan(n).Forward(input)
+	b := FFT(input)
+	for k := range a {
+		if a[k] != b[k] {
+			t.Fatalf("bin %d: plan %v, FFT %v", k, a[k], b[k])
+		}
+	}
+	if input[1] != complex(math.Sin(0.3), math.Cos(0.7)) {
+		t.Fatal("Forward mutated its input")
+	}
+}
+
+func TestFFTConvolveMatchesDirect(t *testing.T) {
+	direct := func(x, h []float64) []float64 {
+		out := make([]float64, len(x)+len(h)-1)
+		for i, xv := range x {
+			for j, hv := range h {
+				out[i+j] += xv * hv
+			}
+		}
+		return out
+	}
+
+	signal := pseudoNoise(5000, 17, 0.8)
+	for _, kernel := range [][]float64{
+		{1},
+		{0.25, 0.5, 0.25},
+		pseudoNoise(63, 3, 0.3),
+		pseudoNoise(3000, 9, 0.1),  // longer than a denoising frame
+		pseudoNoise(7000, 11, 0.1), // longer than the signal
+	} {
+		want := direct(signal, kernel)
+		got := FFTConvolve(signal, kernel)
+		if len(got) != len(want) {
+			t.Fatalf("kernel of %d: expected %d samples, got %d", len(kernel), len(want), len(got))
+		}
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > 1e-9 {
+				t.Fatalf("kernel of %d: sample %d is %g, want %g", len(kernel), i, got[i], want[i])
+			}
+		}
+	}
+
+	if FFTConvolve(nil, []float64{1}) != nil || FFTConvolve(signal, nil) != nil {
+		t.Fatal("expected nil for empty input")
+	}
+}
@@ -0,0 +1,950 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// flacMagic starts every FLAC stream.
+const flacMagic = "fLaC"
+
+// isFLAC reports whether b starts with the FLAC stream marker.
+func isFLAC(b []byte) bool {
+	return len(b) >= len(flacMagic) && string(b[:len(flacMagic)]) == flacMagic
+}
+
+// flacStreamInfoSize is the size of the STREAMINFO metadata block.
+const flacStreamInfoSize = 34
+
+// ErrFLACTooLong is wrapped by the DecodeFLAC error for a stream holding
+// more samples than the limit it was given.
+var ErrFLACTooLong = errors.New("flac: stream too long")
+
+// DecodeFLAC decodes a FLAC stream from r into interleaved samples in
+// [-1.0, +1.0) and a header giving its sample rate, channel count and
+// sample width. Metadata blocks other than STREAMINFO are skipped, and
+// every frame's CRCs are checked. If maxSamples is positive, a stream
+// declaring or holding more interleaved samples than that fails with
+// ErrFLACTooLong as soon as that is known, since a few bytes of a frame
+// can decode to many thousands of samples.
+func DecodeFLAC(r io.Reader, maxSamples int) ([]float64, *WAVHeader, error) {
+	br := bufio.NewReader(r)
+	var magic [4]byte
+	if _, err := io.ReadFull(br, magic[:]); err != nil || !isFLAC(magic[:]) {
+		return nil, nil, errors.New("flac: missing fLaC marker")
+	}
+
+	// Metadata blocks: STREAMINFO comes first, the rest are skipped.
+	var header *WAVHeader
+	for last := false; !last; {
+		var block [4]byte
+		if _, err := io.ReadFull(br, block[:]); err != nil {
+			return nil, nil, errors.New("flac: truncated metadata")
+		}
+		last = block[0]&0x80 != 0
+		kind := block[0] & 0x7F
+		size := int64(block[1])<<16 | int64(block[2])<<8 | int64(block[3])
+		if header == nil && kind != 0 {
+			return nil, nil, errors.New("flac: first metadata block is not STREAMINFO")
+		}
+		if kind != 0 {
+			if n, _ := io.CopyN(io.Discard, br, size); n < size {
+				return nil, nil, errors.New("flac: truncated metadata")
+			}
+			continue
+		}
+		if size < flacStreamInfoSize {
+			return nil, nil, fmt.Errorf("flac: STREAMINFO block of %d bytes", size)
+		}
+		info := make([]byte, size)
+		if _, err := io.ReadFull(br, info); err != nil {
+			return nil, nil, errors.New("flac: truncated metadata")
+		}
+		var total int64
+		header, total = parseFLACStreamInfo(info)
+		if header.SampleRate == 0 {
+			return nil, nil, errors.New("flac: invalid sample rate 0")
+		}
+		if maxSamples > 0 && total > int64(maxSamples/header.NumChannels) {
+			return nil, nil, fmt.Errorf("%w: %d samples per channel declared", ErrFLACTooLong, total)
+		}
+	}
+
+	d := &flacDecoder{br: flacBitReader{r: br}, info: header}
+	scale := float64(int64(1) << (header.BitsPerSample - 1))
+	var samples []float64
+	for {
+		channels, err := d.frame()
+		if err == io.EOF {
+			return samples, header, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if maxSamples > 0 && len(samples)+len(channels)*len(channels[0]) > maxSamples {
+			return nil, nil, fmt.Errorf("%w: more than %d samples", ErrFLACTooLong, maxSamples)
+		}
+		for i := range channels[0] {
+			for _, ch := range channels {
+				samples = append(samples, float64(ch[i])/scale)
+			}
+		}
+	}
+}
+
+// parseFLACStreamInfo returns the header a STREAMINFO block describes and
+// the total samples per channel it declares, 0 if unknown.
+func parseFLACStreamInfo(info []byte) (header *WAVHeader, total int64) {
+	// Sample rate (20 bits), channels - 1 (3), bits per sample - 1 (5) and
+	// total samples (36).
+	packed := binary.BigEndian.Uint64(info[10:18])
+	header = &WAVHeader{
+		SampleRate:    int(packed >> 44),
+		NumChannels:   int(packed>>41&0x7) + 1,
+		BitsPerSample: int(packed>>36&0x1F) + 1,
+	}
+	return header, int64(packed & (1<<36 - 1))
+}
+
+// flacDecoder decodes the frames of a FLAC stream.
+type flacDecoder struct {
+	br       flacBitReader
+	info     *WAVHeader
+	channels [][]int64 // decoded samples of the current frame, per channel
+}
+
+// flacBlockSizes maps frame header block size codes 1-5 and 8-15 to block
+// sizes; 6 and 7 read it from the end of the header.
+var flacBlockSizes = [16]int{0, 192, 576, 1152, 2304, 4608, 0, 0, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768}
+
+// flacSampleSizes maps frame header sample size codes to bits per sample;
+// 0 takes it from STREAMINFO and 3 is reserved.
+var flacSampleSizes = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// Channel assignments beyond independent channels (codes 0-7).
+const (
+	flacLeftSide  = 8
+	flacRightSide = 9
+	flacMidSide   = 10
+)
+
+// frame decodes the next frame, returning its samples per channel, or
+// io.EOF at the end of the stream.
+func (d *flacDecoder) frame() ([][]int64, error) {
+	br := &d.br
+	br.crc8, br.crc16 = 0, 0
+	first, err := br.readByte()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	second, err := br.readByte()
+	if err != nil {
+		return nil, flacTruncated(err)
+	}
+	if first != 0xFF || second&0xFE != 0xF8 {
+		return nil, errors.New("flac: lost frame sync")
+	}
+
+	var head [2]byte
+	for i := range head {
+		if head[i], err = br.readByte(); err != nil {
+			return nil, flacTruncated(err)
+		}
+	}
+	blockCode, rateCode := head[0]>>4, head[0]&0xF
+	assignment, sizeCode := int(head[1]>>4), head[1]>>1&0x7
+	if err := br.skipCodedNumber(); err != nil {
+		return nil, err
+	}
+
+	blockSize := flacBlockSizes[blockCode]
+	switch blockCode {
+	case 0:
+		return nil, errors.New("flac: reserved block size")
+	case 6, 7:
+		v, err := br.bits(8 * uint(blockCode-5))
+		if err != nil {
+			return nil, flacTruncated(err)
+		}
+		blockSize = int(v) + 1
+	}
+	switch rateCode {
+	case 12:
+		_, err = br.bits(8)
+	case 13, 14:
+		_, err = br.bits(16)
+	case 15:
+		return nil, errors.New("flac: invalid sample rate code")
+	}
+	if err != nil {
+		return nil, flacTruncated(err)
+	}
+	bps := d.info.BitsPerSample
+	if sizeCode != 0 {
+		if bps = flacSampleSizes[sizeCode]; bps == 0 {
+			return nil, errors.New("flac: reserved sample size")
+		}
+	}
+	numChannels := assignment + 1
+	if assignment > flacMidSide {
+		return nil, errors.New("flac: reserved channel assignment")
+	} else if assignment >= flacLeftSide {
+		numChannels = 2
+	}
+	if numChannels != d.info.NumChannels {
+		return nil, fmt.Errorf("flac: frame has %d channels, stream %d", numChannels, d.info.NumChannels)
+	}
+
+	want := br.crc8
+	if crc, err := br.readByte(); err != nil {
+		return nil, flacTruncated(err)
+	} else if crc != want {
+		return nil, errors.New("flac: frame header CRC mismatch")
+	}
+
+	if len(d.channels) != numChannels {
+		d.channels = make([][]int64, numChannels)
+	}
+	for ch := range d.channels {
+		// The side channel carries one extra bit.
+		sideBits := 0
+		if (assignment == flacLeftSide || assignment == flacMidSide) && ch == 1 ||
+			assignment == flacRightSide && ch == 0 {
+			sideBits = 1
+		}
+		if cap(d.channels[ch]) < blockSize {
+			d.channels[ch] = make([]int64, blockSize)
+		}
+		d.channels[ch] = d.channels[ch][:blockSize]
+		if err := br.subframe(d.channels[ch], uint(bps+sideBits)); err != nil {
+			return nil, err
+		}
+	}
+
+	br.align()
+	want16 := br.crc16
+	crc, err := br.bits(16)
+	if err != nil {
+		return nil, flacTruncated(err)
+	}
+	if uint16(crc) != want16 {
+		return nil, errors.New("flac: frame CRC mismatch")
+	}
+
+	if numChannels == 2 {
+		left, right := d.channels[0], d.channels[1]
+		for i := range left {
+			switch assignment {
+			case flacLeftSide:
+				right[i] = left[i] - right[i]
+			case flacRightSide:
+				left[i] += right[i]
+			case flacMidSide:
+				mid, side := left[i]<<1|right[i]&1, right[i]
+				left[i], right[i] = (mid+side)>>1, (mid-side)>>1
+			}
+		}
+	}
+	return d.channels, nil
+}
+
+// flacTruncated returns the error for a frame cut short by err.
+func flacTruncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errors.New("flac: truncated frame")
+	}
+	return err
+}
+
+// flacFixedCoeffs are the predictor coefficients of the fixed subframe
+// orders, most recent sample first.
+var flacFixedCoeffs = [5][]int64{{}, {1}, {2, -1}, {3, -3, 1}, {4, -6, 4, -1}}
+
+// subframe decodes a subframe of len(dst) samples of the given width into
+// dst.
+func (br *flacBitReader) subframe(dst []int64, bps uint) error {
+	head, err := br.bits(8)
+	if err != nil {
+		return flacTruncated(err)
+	}
+	if head&0x80 != 0 {
+		return errors.New("flac: invalid subframe header")
+	}
+	kind := int(head >> 1 & 0x3F)
+	wasted := uint(0)
+	if head&1 != 0 {
+		k, err := br.unary()
+		if err != nil {
+			return flacTruncated(err)
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return errors.New("flac: invalid wasted bits")
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case kind == 0: // constant
+		v, err := br.signed(bps)
+		if err != nil {
+			return flacTruncated(err)
+		}
+		for i := range dst {
+			dst[i] = v
+		}
+	case kind == 1: // verbatim
+		for i := range dst {
+			if dst[i], err = br.signed(bps); err != nil {
+				return flacTruncated(err)
+			}
+		}
+	case kind >= 8 && kind <= 12, kind >= 32: // fixed or linear predictor
+		order := kind - 8
+		if kind >= 32 {
+			order = kind - 31
+		}
+		if order > len(dst) {
+			return errors.New("flac: predictor order exceeds block size")
+		}
+		for i := range order {
+			if dst[i], err = br.signed(bps); err != nil {
+				return flacTruncated(err)
+			}
+		}
+		coeffs, shift := flacFixedCoeffs[min(order, 4)], uint(0)
+		if kind >= 32 {
+			if coeffs, shift, err = br.lpcCoeffs(order); err != nil {
+				return err
+			}
+		}
+		if err := br.residual(dst, order); err != nil {
+			return err
+		}
+		predict(dst, coeffs, shift)
+	default:
+		return fmt.Errorf("flac: reserved subframe type %d", kind)
+	}
+
+	if wasted > 0 {
+		for i := range dst {
+			dst[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// lpcCoeffs reads the quantized coefficients and shift of a linear
+// predictor of the given order.
+func (br *flacBitReader) lpcCoeffs(order int) ([]int64, uint, error) {
+	precision, err := br.bits(4)
+	if err != nil {
+		return nil, 0, flacTruncated(err)
+	}
+	if precision == 15 {
+		return nil, 0, errors.New("flac: invalid coefficient precision")
+	}
+	shift, err := br.signed(5)
+	if err != nil {
+		return nil, 0, flacTruncated(err)
+	}
+	if shift < 0 {
+		return nil, 0, errors.New("flac: negative predictor shift")
+	}
+	coeffs := make([]int64, order)
+	for i := range coeffs {
+		if coeffs[i], err = br.signed(uint(precision) + 1); err != nil {
+			return nil, 0, flacTruncated(err)
+		}
+	}
+	return coeffs, uint(shift), nil
+}
+
+// predict adds to each residual in dst[len(coeffs):] its prediction from
+// the samples before it.
+func predict(dst, coeffs []int64, shift uint) {
+	for i := len(coeffs); i < len(dst); i++ {
+		var sum int64
+		for j, c := range coeffs {
+			sum += c * dst[i-1-j]
+		}
+		dst[i] += sum >> shift
+	}
+}
+
+// residual decodes the Rice-coded residual of a subframe into
+// dst[order:].
+func (br *flacBitReader) residual(dst []int64, order int) error {
+	method, err := br.bits(2)
+	if err != nil {
+		return flacTruncated(err)
+	}
+	paramBits := uint(4)
+	switch method {
+	case 0:
+	case 1:
+		paramBits = 5
+	default:
+		return errors.New("flac: reserved residual coding method")
+	}
+	escape := uint64(1)<<paramBits - 1
+	partitionOrder, err := br.bits(4)
+	if err != nil {
+		return flacTruncated(err)
+	}
+	partitions := 1 << partitionOrder
+	if len(dst)%partitions != 0 || len(dst)/partitions < order {
+		return errors.New("flac: invalid residual partition order")
+	}
+
+	i := order
+	for p := range partitions {
+		end := (p + 1) * len(dst) / partitions
+		param, err := br.bits(paramBits)
+		if err != nil {
+			return flacTruncated(err)
+		}
+		if param == escape {
+			// Unencoded: each residual is a raw signed n-bit value.
+			n, err := br.bits(5)
+			if err != nil {
+				return flacTruncated(err)
+			}
+			for ; i < end; i++ {
+				if dst[i], err = br.signed(uint(n)); err != nil {
+					return flacTruncated(err)
+				}
+			}
+			continue
+		}
+		for ; i < end; i++ {
+			q, err := br.unary()
+			if err != nil {
+				return flacTruncated(err)
+			}
+			low, err := br.bits(uint(param))
+			if err != nil {
+				return flacTruncated(err)
+			}
+			u := q<<param | low
+			dst[i] = int64(u>>1) ^ -int64(u&1) // zigzag
+		}
+	}
+	return nil
+}
+
+// flacBitReader reads a FLAC frame MSB first, keeping the frame's CRC-8
+// and CRC-16 over the bytes read.
+type flacBitReader struct {
+	r     io.ByteReader
+	cur   uint64 // the low n bits are unread
+	n     uint
+	crc8  byte
+	crc16 uint16
+}
+
+// readByte reads the next byte, on a byte boundary.
+func (br *flacBitReader) readByte() (byte, error) {
+	v, err := br.bits(8)
+	return byte(v), err
+}
+
+// fill buffers the next byte, updating the CRCs.
+func (br *flacBitReader) fill() error {
+	b, err := br.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	br.crc8 = flacCRC8Table[br.crc8^b]
+	br.crc16 = br.crc16<<8 ^ flacCRC16Table[byte(br.crc16>>8)^b]
+	br.cur = br.cur<<8 | uint64(b)
+	br.n += 8
+	return nil
+}
+
+// bits reads n (at most 56) bits as an unsigned value.
+func (br *flacBitReader) bits(n uint) (uint64, error) {
+	for br.n < n {
+		if err := br.fill(); err != nil {
+			return 0, err
+		}
+	}
+	br.n -= n
+	return br.cur >> br.n & (1<<n - 1), nil
+}
+
+// signed reads n bits as a two's complement value.
+func (br *flacBitReader) signed(n uint) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	v, err := br.bits(n)
+	return int64(v<<(64-n)) >> (64 - n), err
+}
+
+// unary reads a run of zero bits ended by a one, returning its length.
+func (br *flacBitReader) unary() (uint64, error) {
+	var q uint64
+	for {
+		if br.n == 0 {
+			if err := br.fill(); err != nil {
+				return 0, err
+			}
+		}
+		v := br.cur & (1<<br.n - 1)
+		if v == 0 {
+			q += uint64(br.n)
+			br.n = 0
+			continue
+		}
+		zeros := br.n - uint(bits.Len64(v))
+		br.n -= zeros + 1
+		return q + uint64(zeros), nil
+	}
+}
+
+// align skips to the next byte boundary.
+func (br *flacBitReader) align() {
+	br.n -= br.n % 8
+}
+
+// skipCodedNumber skips the frame or sample number in a frame header,
+// coded like UTF-8 in up to 7 bytes.
+func (br *flacBitReader) skipCodedNumber() error {
+	first, err := br.readByte()
+	if err != nil {
+		return flacTruncated(err)
+	}
+	extra := bits.LeadingZeros8(^first)
+	if extra == 1 || extra > 7 {
+		return errors.New("flac: invalid frame number")
+	}
+	for range max(extra-1, 0) {
+		b, err := br.readByte()
+		if err != nil {
+			return flacTruncated(err)
+		}
+		if b&0xC0 != 0x80 {
+			return errors.New("flac: invalid frame number")
+		}
+	}
+	return nil
+}
+
+// flacCRC8Table and flacCRC16Table drive the frame header CRC-8
+// (polynomial 0x07) and frame CRC-16 (polynomial 0x8005).
+var flacCRC8Table, flacCRC16Table = flacCRCTables()
+
+func flacCRCTables() (t8 [256]byte, t16 [256]uint16) {
+	for i := range 256 {
+		c8, c16 := byte(i), uint16(i)<<8
+		for range 8 {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		t8[i], t16[i] = c8, c16
+	}
+	return t8, t16
+}
+
+// flacBlockSize is the number of samples per channel in each frame
+// EncodeFLAC writes.
+const flacBlockSize = 4096
+
+// EncodeFLAC encodes interleaved samples of numChannels channels (at most
+// 8) as a FLAC stream in the given integer sample format, clamping samples
+// to [-1.0, +1.0] and quantizing them as WriteWAVFormat does, so decoding
+// either file gives the same samples. Subframes use the fixed predictors,
+// and stereo frames the best of the four channel assignments.
+func EncodeFLAC(samples []float64, sampleRate, numChannels int, format SampleFormat) ([]byte, error) {
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return nil, fmt.Errorf("flac: invalid sample rate %d", sampleRate)
+	}
+	if numChannels < 1 || numChannels > 8 {
+		return nil, fmt.Errorf("flac: invalid channel count %d (want 1 to 8)", numChannels)
+	}
+	if format.isFloat() {
+		return nil, errors.New("flac: float samples are not supported")
+	}
+	if len(samples)%numChannels != 0 {
+		return nil, fmt.Errorf("flac: %d samples do not divide into %d channels", len(samples), numChannels)
+	}
+	bps := format.BitsPerSample()
+	width := bps / 8
+	numFrames := len(samples) / numChannels
+
+	// Quantize, hashing the samples as STREAMINFO's MD5 wants them: signed
+	// little-endian integers, interleaved.
+	channels := make([][]int64, numChannels)
+	for ch := range channels {
+		channels[ch] = make([]int64, numFrames)
+	}
+	hash := md5.New()
+	le := make([]byte, 0, 8)
+	for i, s := range samples {
+		v := quantize(max(-1, min(1, s)), uint(bps-1))
+		channels[i%numChannels][i/numChannels] = int64(v)
+		le = binary.LittleEndian.AppendUint32(le[:0], uint32(v))
+		hash.Write(le[:width])
+	}
+
+	out := make([]byte, 0, len(samples)*width/2)
+	out = append(out, flacMagic...)
+	out = append(out, 0x80, 0, 0, flacStreamInfoSize) // STREAMINFO, the last block
+	info := len(out)
+	out = append(out, make([]byte, flacStreamInfoSize)...)
+
+	minFrame, maxFrame := math.MaxInt, 0
+	block := make([][]int64, numChannels)
+	for number, start := 0, 0; start < numFrames; number, start = number+1, start+flacBlockSize {
+		end := min(start+flacBlockSize, numFrames)
+		for ch := range block {
+			block[ch] = channels[ch][start:end]
+		}
+		frame := encodeFLACFrame(block, number, uint(bps))
+		minFrame, maxFrame = min(minFrame, len(frame)), max(maxFrame, len(frame))
+		out = append(out, frame...)
+	}
+	if numFrames == 0 {
+		minFrame = 0
+	}
+
+	b := out[info:info]
+	b = binary.BigEndian.AppendUint16(b, flacBlockSize)
+	b = binary.BigEndian.AppendUint16(b, flacBlockSize)
+	b = append(b, byte(minFrame>>16), byte(minFrame>>8), byte(minFrame))
+	b = append(b, byte(maxFrame>>16), byte(maxFrame>>8), byte(maxFrame))
+	// Sample rate (20 bits), channels - 1 (3), bits per sample - 1 (5) and
+	// total samples (36).
+	packed := uint64(sampleRate)<<44 | uint64(numChannels-1)<<41 | uint64(bps-1)<<36 | uint64(numFrames)
+	b = binary.BigEndian.AppendUint64(b, packed)
+	hash.Sum(b)
+	return out, nil
+}
+
+// flacSampleSizeCodes maps bits per sample to frame header sample size
+// codes.
+var flacSampleSizeCodes = map[uint]uint64{8: 1, 16: 4, 24: 6, 32: 7}
+
+// encodeFLACFrame encodes a frame holding the samples of each channel.
+func encodeFLACFrame(channels [][]int64, number int, bps uint) []byte {
+	n := len(channels[0])
+	bw := &flacBitWriter{}
+	bw.bits(0xFFF8, 16) // sync, fixed block size
+	blockCode := uint64(7)
+	if n == flacBlockSize {
+		blockCode = 12
+	}
+	bw.bits(blockCode, 4)
+	bw.bits(0, 4) // sample rate from STREAMINFO
+
+	plans := make([]flacSubframePlan, len(channels))
+	assignment := len(channels) - 1
+	for ch, s := range channels {
+		plans[ch] = planFLACSubframe(s, bps)
+	}
+	if len(channels) == 2 {
+		left, right := channels[0], channels[1]
+		mid, side := make([]int64, n), make([]int64, n)
+		for i := range left {
+			mid[i], side[i] = (left[i]+right[i])>>1, left[i]-right[i]
+		}
+		m, s := planFLACSubframe(mid, bps), planFLACSubframe(side, bps+1)
+		best := plans[0].cost + plans[1].cost
+		for _, c := range []struct {
+			assignment int
+			plans      []flacSubframePlan
+		}{
+			{flacLeftSide, []flacSubframePlan{plans[0], s}},
+			{flacRightSide, []flacSubframePlan{s, plans[1]}},
+			{flacMidSide, []flacSubframePlan{m, s}},
+		} {
+			if cost := c.plans[0].cost + c.plans[1].cost; cost < best {
+				best, assignment, plans = cost, c.assignment, c.plans
+			}
+		}
+	}
+	bw.bits(uint64(assignment), 4)
+	bw.bits(flacSampleSizeCodes[bps], 3)
+	bw.bits(0, 1)
+	bw.buf = appendFLACCodedNumber(bw.buf, uint64(number))
+	if blockCode == 7 {
+		bw.bits(uint64(n-1), 16)
+	}
+	bw.bits(uint64(flacCRC8(bw.buf)), 8)
+
+	for _, p := range plans {
+		p.write(bw)
+	}
+	bw.align()
+	bw.bits(uint64(flacCRC16(bw.buf)), 16)
+	return bw.buf
+}
+
+// flacSubframePlan is the cheapest encoding found for a subframe:
+// constant, verbatim, or a fixed predictor with a Rice-coded residual.
+type flacSubframePlan struct {
+	samples []int64
+	bps     uint
+	kind    int // 0 constant, 1 verbatim, 8+order fixed
+	rice    flacRicePlan
+	cost    int // in bits
+}
+
+// planFLACSubframe picks how to encode samples of the given width.
+func planFLACSubframe(samples []int64, bps uint) flacSubframePlan {
+	n := len(samples)
+	if slices.Min(samples) == slices.Max(samples) {
+		return flacSubframePlan{samples: samples, bps: bps, kind: 0, cost: 8 + int(bps)}
+	}
+	plan := flacSubframePlan{samples: samples, bps: bps, kind: 1, cost: 8 + n*int(bps)}
+
+	// Choose the fixed predictor order with the smallest total residual,
+	// then cost its Rice coding.
+	bestOrder, bestSum := -1, uint64(math.MaxUint64)
+	for order := 0; order <= min(4, n-1); order++ {
+		var sum uint64
+		for i := order; i < n; i++ {
+			r := flacFixedResidual(samples, i, order)
+			sum += uint64(max(r, -r))
+		}
+		if sum < bestSum {
+			bestOrder, bestSum = order, sum
+		}
+	}
+	residual := make([]int64, n-bestOrder)
+	for i := range residual {
+		residual[i] = flacFixedResidual(samples, i+bestOrder, bestOrder)
+	}
+	rice := planFLACRice(residual, n, bestOrder)
+	if cost := 8 + bestOrder*int(bps) + rice.cost; cost < plan.cost {
+		plan.kind, plan.rice, plan.cost = 8+bestOrder, rice, cost
+	}
+	return plan
+}
+
+// flacFixedResidual returns the residual of sample i under the fixed
+// predictor of the given order.
+func flacFixedResidual(s []int64, i, order int) int64 {
+	switch order {
+	case 1:
+		return s[i] - s[i-1]
+	case 2:
+		return s[i] - 2*s[i-1] + s[i-2]
+	case 3:
+		return s[i] - 3*s[i-1] + 3*s[i-2] - s[i-3]
+	case 4:
+		return s[i] - 4*s[i-1] + 6*s[i-2] - 4*s[i-3] + s[i-4]
+	}
+	return s[i]
+}
+
+// write writes the subframe p plans.
+func (p flacSubframePlan) write(bw *flacBitWriter) {
+	bw.bits(uint64(p.kind)<<1, 8)
+	switch {
+	case p.kind == 0:
+		bw.signed(p.samples[0], p.bps)
+	case p.kind == 1:
+		for _, v := range p.samples {
+			bw.signed(v, p.bps)
+		}
+	default:
+		order := p.kind - 8
+		for _, v := range p.samples[:order] {
+			bw.signed(v, p.bps)
+		}
+		p.rice.write(bw)
+	}
+}
+
+// flacMaxPartitionOrder bounds the residual partition orders tried.
+const flacMaxPartitionOrder = 8
+
+// flacRicePlan is a partitioned Rice coding of a residual.
+type flacRicePlan struct {
+	residual       []int64
+	blockSize      int
+	order          int // of the predictor
+	partitionOrder int
+	params         []uint
+	cost           int // in bits, estimated
+}
+
+// planFLACRice picks the partition order and Rice parameters for the
+// residual of a predictor of the given order over blockSize samples.
+// Costs are estimated from each partition's sum, as is usual.
+func planFLACRice(residual []int64, blockSize, order int) flacRicePlan {
+	maxOrder := 0
+	for maxOrder < flacMaxPartitionOrder && blockSize%(2<<maxOrder) == 0 && blockSize>>(maxOrder+1) > order {
+		maxOrder++
+	}
+	// Sums of the zigzag-coded residuals per partition at maxOrder.
+	parts := 1 << maxOrder
+	sums := make([]uint64, parts)
+	counts := make([]int, parts)
+	for i, r := range residual {
+		p := (i + order) / (blockSize >> maxOrder)
+		sums[p] += uint64(r<<1 ^ r>>63)
+		counts[p]++
+	}
+
+	best := flacRicePlan{residual: residual, blockSize: blockSize, order: order, cost: math.MaxInt}
+	for po := maxOrder; po >= 0; po-- {
+		params := make([]uint, len(sums))
+		cost := 2 + 4
+		wide := false
+		for p := range sums {
+			k, c := flacRiceParam(sums[p], counts[p])
+			params[p], cost = k, cost+c
+			wide = wide || k > 14
+		}
+		if wide {
+			cost += 5 * len(sums)
+		} else {
+			cost += 4 * len(sums)
+		}
+		if cost < best.cost {
+			best.partitionOrder, best.params, best.cost = po, params, cost
+		}
+		// Merge pairs of partitions for the next order down.
+		for p := range len(sums) / 2 {
+			sums[p], counts[p] = sums[2*p]+sums[2*p+1], counts[2*p]+counts[2*p+1]
+		}
+		sums, counts = sums[:len(sums)/2], counts[:len(counts)/2]
+	}
+	return best
+}
+
+// flacRiceParam returns the Rice parameter for count values summing to
+// sum, with the estimated cost of coding them in bits.
+func flacRiceParam(sum uint64, count int) (uint, int) {
+	if count == 0 {
+		return 0, 0
+	}
+	bestK, bestCost := uint(0), math.MaxInt
+	guess := uint(bits.Len64(sum / uint64(count)))
+	for k := min(max(guess, 1)-1, 30); k <= min(guess+1, 30); k++ {
+		if cost := count*int(k+1) + int(min(sum>>k, math.MaxInt32)); cost < bestCost {
+			bestK, bestCost = k, cost
+		}
+	}
+	return bestK, bestCost
+}
+
+// write writes the planned residual coding.
+func (p flacRicePlan) write(bw *flacBitWriter) {
+	paramBits := uint(4)
+	if slices.Max(p.params) > 14 {
+		paramBits = 5
+	}
+	bw.bits(uint64(paramBits-4), 2)
+	bw.bits(uint64(p.partitionOrder), 4)
+	size := p.blockSize >> p.partitionOrder
+	start := 0
+	for i, k := range p.params {
+		end := (i+1)*size - p.order
+		bw.bits(uint64(k), paramBits)
+		bw.rice(p.residual[start:end], k)
+		start = end
+	}
+}
+
+// flacBitWriter writes a FLAC frame MSB first.
+type flacBitWriter struct {
+	buf []byte
+	cur uint64 // the low n bits are unwritten
+	n   uint
+}
+
+// bits writes the low n bits of v.
+func (bw *flacBitWriter) bits(v uint64, n uint) {
+	for n > 32 {
+		bw.bits(v>>32, n-32)
+		v, n = v&(1<<32-1), 32
+	}
+	bw.cur = bw.cur<<n | v&(1<<n-1)
+	bw.n += n
+	for bw.n >= 8 {
+		bw.n -= 8
+		bw.buf = append(bw.buf, byte(bw.cur>>bw.n))
+	}
+}
+
+// signed writes v as an n-bit two's complement value.
+func (bw *flacBitWriter) signed(v int64, n uint) {
+	bw.bits(uint64(v), n)
+}
+
+// rice writes residuals Rice-coded with parameter k.
+func (bw *flacBitWriter) rice(residuals []int64, k uint) {
+	for _, r := range residuals {
+		u := uint64(r<<1 ^ r>>63) // zigzag
+		for q := u >> k; q > 0; q -= min(q, 32) {
+			bw.bits(0, uint(min(q, 32)))
+		}
+		bw.bits(1, 1)
+		bw.bits(u, k)
+	}
+}
+
+// align pads the frame with zero bits to a byte boundary.
+func (bw *flacBitWriter) align() {
+	if bw.n > 0 {
+		bw.bits(0, 8-bw.n)
+	}
+}
+
+// appendFLACCodedNumber appends a frame number coded like UTF-8, as
+// skipCodedNumber reads it.
+func appendFLACCodedNumber(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	// Each continuation byte holds 6 bits, and the first byte 6 - extra.
+	extra := 1
+	for v >= 1<<(6*extra+6-extra) {
+		extra++
+	}
+	b = append(b, byte(0xFF<<(7-extra))|byte(v>>(6*extra)))
+	for i := extra - 1; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3F)
+	}
+	return b
+}
+
+// flacCRC8 returns the CRC-8 of a frame header.
+func flacCRC8(b []byte) byte {
+	var crc byte
+	for _, c := range b {
+		crc = flacCRC8Table[crc^c]
+	}
+	return crc
+}
+
+// flacCRC16 returns the CRC-16 of a frame.
+func flacCRC16(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc = crc<<8 ^ flacCRC16Table[byte(crc>>8)^c]
+	}
+	return crc
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"math"
+	"math/bits"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// residual writes the residual of a predictor in two partitions (one for
+// an odd block size), the second escaped (raw) if escape is set and the
+// residuals fit.
+func (bw *flacBitWriter) residual(residuals []int64, blockSize int, escape bool) {
+	bw.bits(0, 2) // 4-bit Rice parameters
+	parts := [][]int64{residuals}
+	if blockSize%2 == 0 {
+		split := blockSize/2 - (blockSize - len(residuals))
+		parts = [][]int64{residuals[:split], residuals[split:]}
+	}
+	bw.bits(uint64(len(parts)/2), 4) // partition order
+	for p, part := range parts {
+		width := uint(1)
+		for _, r := range part {
+			width = max(width, uint(bits.Len64(uint64(max(r, -r-1))))+1)
+		}
+		if p == 1 && escape && width < 32 {
+			bw.bits(15, 4)
+			bw.bits(uint64(width), 5)
+			for _, r := range part {
+				bw.signed(r, width)
+			}
+			continue
+		}
+		var sum uint64
+		for _, r := range part {
+			sum += uint64(r<<1 ^ r>>63)
+		}
+		k := uint(min(bits.Len64(sum/uint64(max(len(part), 1))), 14))
+		bw.bits(uint64(k), 4)
+		bw.rice(part, k)
+	}
+}
+
+// flacSubframe writes s as a subframe of bps-bit samples: constant if all
+// samples are equal, otherwise verbatim, fixed order 2 or LPC order 2 by
+// kind (0, 1 or 2).
+func flacSubframe(bw *flacBitWriter, s []int64, bps uint, kind int) {
+	if slices.Min(s) == slices.Max(s) {
+		bw.bits(0, 8)
+		bw.signed(s[0], bps)
+		return
+	}
+	residuals := make([]int64, 0, len(s))
+	for i := 2; i < len(s); i++ {
+		residuals = append(residuals, s[i]-2*s[i-1]+s[i-2])
+	}
+	switch kind {
+	case 0:
+		bw.bits(1<<1, 8)
+		for _, v := range s {
+			bw.signed(v, bps)
+		}
+	case 1:
+		bw.bits(10<<1, 8)
+		bw.signed(s[0], bps)
+		bw.signed(s[1], bps)
+		bw.residual(residuals, len(s), false)
+	case 2:
+		bw.bits(33<<1, 8)
+		bw.signed(s[0], bps)
+		bw.signed(s[1], bps)
+		bw.bits(3, 4) // 4-bit coefficients
+		bw.signed(0, 5)
+		bw.signed(2, 4)
+		bw.signed(-1, 4)
+		bw.residual(residuals, len(s), true)
+	}
+}
+
+// flacFile encodes channels of bps-bit samples as a FLAC stream in blocks
+// of blockSize, with the given channel assignment, cycling through the
+// subframe kinds flacSubframe writes. A padding block follows STREAMINFO.
+func flacFile(channels [][]int64, sampleRate, bps, blockSize, assignment int) []byte {
+	n := len(channels[0])
+	bw := &flacBitWriter{}
+	bw.bits(0, 8) // STREAMINFO, not last
+	bw.bits(flacStreamInfoSize, 24)
+	bw.bits(uint64(blockSize), 16)
+	bw.bits(uint64(blockSize), 16)
+	bw.bits(0, 48) // frame sizes unknown
+	bw.bits(uint64(sampleRate), 20)
+	bw.bits(uint64(len(channels)-1), 3)
+	bw.bits(uint64(bps-1), 5)
+	bw.bits(uint64(n), 36)
+	bw.bits(0, 128)  // no MD5
+	bw.bits(0x81, 8) // padding, last
+	bw.bits(5, 24)
+	bw.bits(0, 40)
+	out := append([]byte(flacMagic), bw.buf...)
+
+	for frame, start := 0, 0; start < n; frame, start = frame+1, start+blockSize {
+		end := min(start+blockSize, n)
+		bw := &flacBitWriter{}
+		bw.bits(0xFFF8, 16)
+		bw.bits(7, 4) // block size at the end of the header
+		bw.bits(0, 4) // sample rate from STREAMINFO
+		bw.bits(uint64(assignment), 4)
+		bw.bits(0, 4) // sample size from STREAMINFO
+		bw.bits(uint64(frame), 8)
+		bw.bits(uint64(end-start-1), 16)
+		bw.bits(uint64(flacCRC8(bw.buf)), 8)
+
+		subframes := make([][]int64, len(channels))
+		for ch := range channels {
+			subframes[ch] = channels[ch][start:end]
+		}
+		widths := []uint{uint(bps), uint(bps)}
+		if assignment >= flacLeftSide {
+			left, right := subframes[0], subframes[1]
+			side := make([]int64, len(left))
+			mid := make([]int64, len(left))
+			for i := range left {
+				side[i], mid[i] = left[i]-right[i], (left[i]+right[i])>>1
+			}
+			switch assignment {
+			case flacLeftSide:
+				subframes[1], widths[1] = side, uint(bps+1)
+			case flacRightSide:
+				subframes[0], widths[0] = side, uint(bps+1)
+			case flacMidSide:
+				subframes[0], subframes[1], widths[1] = mid, side, uint(bps+1)
+			}
+		}
+		for ch, s := range subframes {
+			flacSubframe(bw, s, widths[min(ch, 1)], (frame+ch)%3)
+		}
+		bw.align()
+		bw.bits(uint64(flacCRC16(bw.buf)), 16)
+		out = append(out, bw.buf...)
+	}
+	return out
+}
+
+// flacTestChannels returns n samples per channel of a bps-bit signal
+// with a silent stretch, so some blocks are constant.
+func flacTestChannels(numChannels, n, bps int) [][]int64 {
+	amp := float64(int64(1)<<(bps-1)) * 0.7
+	noise := pseudoNoise(n*numChannels, 11, 0.05)
+	channels := make([][]int64, numChannels)
+	for ch := range channels {
+		channels[ch] = make([]int64, n)
+		for i := range n {
+			if i >= 1000 && i < 2000 {
+				continue
+			}
+			v := math.Sin(float64(i)/(9+float64(ch))) + noise[i*numChannels+ch]
+			channels[ch][i] = int64(math.Round(amp * v / 1.05))
+		}
+	}
+	return channels
+}
+
+func TestDecodeFLAC(t *testing.T) {
+	for name, tc := range map[string]struct {
+		channels, bps, assignment int
+	}{
+		"mono24":      {1, 24, 0},
+		"stereo16":    {2, 16, 1},
+		"leftSide16":  {2, 16, flacLeftSide},
+		"rightSide16": {2, 16, flacRightSide},
+		"midSide24":   {2, 24, flacMidSide},
+		"midSide32":   {2, 32, flacMidSide},
+	} {
+		channels := flacTestChannels(tc.channels, 4321, tc.bps)
+		samples, header, err := DecodeFLAC(bytes.NewReader(flacFile(channels, 44100, tc.bps, 1000, tc.assignment)), 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if *header != (WAVHeader{SampleRate: 44100, NumChannels: tc.channels, BitsPerSample: tc.bps}) {
+			t.Fatalf("%s: header %+v", name, header)
+		}
+		scale := float64(int64(1) << (tc.bps - 1))
+		var want []float64
+		for i := range channels[0] {
+			for _, ch := range channels {
+				want = append(want, float64(ch[i])/scale)
+			}
+		}
+		if !slices.Equal(samples, want) {
+			t.Fatalf("%s: decoded samples differ (%d, want %d)", name, len(samples), len(want))
+		}
+	}
+}
+
+func TestDecodeFLACErrors(t *testing.T) {
+	data := flacFile(flacTestChannels(2, 3000, 16), 48000, 16, 1000, flacMidSide)
+	corrupt := slices.Clone(data)
+	corrupt[len(corrupt)-100] ^= 0x10
+	for name, tc := range map[string]struct {
+		data []byte
+		want string
+	}{
+		"notFLAC":   {WriteWAV(make([]float64, 100), 8000), "marker"},
+		"metadata":  {data[:20], "truncated metadata"},
+		"truncated": {data[:len(data)-10], "truncated frame"},
+		"corrupt":   {corrupt, "CRC mismatch"},
+	} {
+		_, _, err := DecodeFLAC(bytes.NewReader(tc.data), 0)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Fatalf("%s: expected error containing %q, got %v", name, tc.want, err)
+		}
+	}
+}
+
+func TestDecodeFLACLimit(t *testing.T) {
+	// A minute of silence compresses to a few kilobytes.
+	data, err := EncodeFLAC(make([]float64, 2*60*16000), 16000, 2, PCM16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 100_000 {
+		t.Fatalf("expected silence to compress, got %d bytes", len(data))
+	}
+	if got, _, err := DecodeFLAC(bytes.NewReader(data), 2*60*16000); err != nil || len(got) != 2*60*16000 {
+		t.Fatalf("at the limit: got %d samples, %v", len(got), err)
+	}
+
+	// Too long by STREAMINFO, and by the frames when STREAMINFO leaves the
+	// total unknown.
+	unknown := slices.Clone(data)
+	unknown[21] &^= 0x0F
+	clear(unknown[22:26])
+	for name, data := range map[string][]byte{"declared": data, "unknown": unknown} {
+		if _, _, err := DecodeFLAC(bytes.NewReader(data), 16000); !errors.Is(err, ErrFLACTooLong) {
+			t.Fatalf("%s: expected ErrFLACTooLong, got %v", name, err)
+		}
+	}
+
+	prev := maxAudioDuration
+	maxAudioDuration = time.Second
+	t.Cleanup(func() { maxAudioDuration = prev })
+	for name, data := range map[string][]byte{"declared": data, "unknown": unknown} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", data, nil))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%s: expected 413, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleDenoiseFLAC(t *testing.T) {
+	// The same audio as FLAC converts to the same output as WAV.
+	wav := toneWAV(16000, 0.5)
+	samples, _, err := ReadWAV(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ints := make([]int64, len(samples))
+	for i, s := range samples {
+		ints[i] = int64(math.Round(s * 32768))
+	}
+	flac := flacFile([][]int64{ints}, 16000, 16, 4096, 0)
+
+	convert := func(data []byte) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", data,
+			map[string]string{"passthrough": "1"}))
+		return rec
+	}
+	want, got := convert(wav), convert(flac)
+	if got.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", got.Code, got.Body.String())
+	}
+	if !bytes.Equal(got.Body.Bytes(), want.Body.Bytes()) {
+		t.Fatal("FLAC upload converts differently from the same WAV")
+	}
+
+	// And out_format=flac returns the same samples losslessly.
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", wav,
+		map[string]string{"passthrough": "1", "out_format": "flac"}))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/flac" {
+		t.Fatalf("out_format=flac: got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if got, _, err := DecodeFLAC(rec.Body, 0); err != nil || !slices.Equal(got, samples) {
+		t.Fatalf("out_format=flac: decoding: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", wav,
+		map[string]string{"out_format": "flac", "out_bits": "32f"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("float FLAC: expected 400, got %d", rec.Code)
+	}
+
+	if rec := convert(flac[:len(flac)-10]); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid FLAC") {
+		t.Fatalf("truncated FLAC: expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEncodeFLACRoundtrip(t *testing.T) {
+	tone := make([]float64, 2*9000)
+	for i := range tone {
+		tone[i] = 0.5 * math.Sin(float64(i/2)/(7+float64(i%2)))
+	}
+	for name, tc := range map[string]struct {
+		samples     []float64
+		numChannels int
+	}{
+		"stereo":  {tone, 2},
+		"noise":   {pseudoNoise(5000, 3, 0.9), 1},
+		"silence": {make([]float64, 3*4500), 3},
+		"clipped": {[]float64{-2, -1, 0, 1, 2}, 1},
+		"empty":   {nil, 1},
+	} {
+		for _, format := range []SampleFormat{PCM8, PCM16, PCM24, PCM32} {
+			// Decoding gives the samples the same WAV file holds.
+			r, err := NewWAVReader(bytes.NewReader(encodeWAV(tc.samples, 22050, tc.numChannels, format)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := r.ReadAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := EncodeFLAC(tc.samples, 22050, tc.numChannels, format)
+			if err != nil {
+				t.Fatalf("%s/%d: EncodeFLAC: %v", name, format.BitsPerSample(), err)
+			}
+			got, header, err := DecodeFLAC(bytes.NewReader(data), 0)
+			if err != nil {
+				t.Fatalf("%s/%d: DecodeFLAC: %v", name, format.BitsPerSample(), err)
+			}
+			wantHeader := WAVHeader{SampleRate: 22050, NumChannels: tc.numChannels, BitsPerSample: format.BitsPerSample()}
+			if *header != wantHeader || len(got) != len(want) || !slices.Equal(got, want) && len(want) > 0 {
+				t.Fatalf("%s/%d: roundtrip differs: header %+v, %d samples, want %d", name, format.BitsPerSample(), header, len(got), len(want))
+			}
+		}
+	}
+
+	if _, err := EncodeFLAC(tone, 22050, 2, Float32); err == nil {
+		t.Fatal("expected an error for float samples")
+	}
+}
+
+func TestEncodeFLACSize(t *testing.T) {
+	// A voice-like tone at 16 kHz takes well under the WAV size, and
+	// STREAMINFO carries the MD5 of the samples as the WAV stores them.
+	wav := toneWAV(16000, 40) // over 128 frames, for multi-byte frame numbers
+	samples, _, err := ReadWAV(wav)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeFLAC(samples, 16000, 1, PCM16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > len(wav)/2 {
+		t.Fatalf("FLAC is %d bytes, WAV %d", len(data), len(wav))
+	}
+	if sum := md5.Sum(wav[44:]); !bytes.Equal(data[26:42], sum[:]) {
+		t.Fatalf("STREAMINFO MD5 %x, want %x", data[26:42], sum)
+	}
+	got, _, err := DecodeFLAC(bytes.NewReader(data), 0)
+	if err != nil || !slices.Equal(got, samples) {
+		t.Fatalf("decoding: %v", err)
+	}
+}
+
+func TestFLACCodedNumber(t *testing.T) {
+	for _, v := range []uint64{0, 0x7F, 0x80, 0x7FF, 0x800, 0xFFFF, 1 << 20, 1<<36 - 1} {
+		b := appendFLACCodedNumber(nil, v)
+		br := flacBitReader{r: bytes.NewReader(append(b, 0xAA))}
+		if err := br.skipCodedNumber(); err != nil {
+			t.Fatalf("%#x: %v", v, err)
+		}
+		if next, _ := br.readByte(); next != 0xAA {
+			t.Fatalf("%#x: coded in %d bytes, read as fewer or more", v, len(b))
+		}
+	}
+}
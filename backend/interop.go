@@ -0,0 +1,67 @@
+package main
+
+import "errors"
+
+// Conversion helpers for exchanging samples and spectra with audio/FFT code
+// that works in float32, complex64 or interleaved [re, im, re, im, ...] form.
+
+// Float32ToComplex128 widens real float32 samples to complex128 with a
+// zero imaginary part, ready for FFT.
+func Float32ToComplex128(x []float32) []complex128 {
+	out := make([]complex128, len(x))
+	for i, v := range x {
+		out[i] = complex(float64(v), 0)
+	}
+	return out
+}
+
+// Complex128ToFloat32 narrows the real parts of x to float32, e.g. to hand
+// the output of IFFT back to a float32 audio buffer. Imaginary parts are
+// dropped.
+func Complex128ToFloat32(x []complex128) []float32 {
+	out := make([]float32, len(x))
+	for i, v := range x {
+		out[i] = float32(real(v))
+	}
+	return out
+}
+
+// Complex64ToComplex128 widens a complex64 slice.
+func Complex64ToComplex128(x []complex64) []complex128 {
+	out := make([]complex128, len(x))
+	for i, v := range x {
+		out[i] = complex128(v)
+	}
+	return out
+}
+
+// Complex128ToComplex64 narrows a complex128 slice.
+func Complex128ToComplex64(x []complex128) []complex64 {
+	out := make([]complex64, len(x))
+	for i, v := range x {
+		out[i] = complex64(v)
+	}
+	return out
+}
+
+// PackInterleaved flattens x into [re0, im0, re1, im1, ...] float32 pairs.
+func PackInterleaved(x []complex128) []float32 {
+	out := make([]float32, 2*len(x))
+	for i, v := range x {
+		out[2*i] = float32(real(v))
+		out[2*i+1] = float32(imag(v))
+	}
+	return out
+}
+
+// UnpackInterleaved is the inverse of PackInterleaved. len(x) must be even.
+func UnpackInterleaved(x []float32) ([]complex128, error) {
+	if len(x)%2 != 0 {
+		return nil, errors.New("interop: interleaved slice has odd length")
+	}
+	out := make([]complex128, len(x)/2)
+	for i := range out {
+		out[i] = complex(float64(x[2*i]), float64(x[2*i+1]))
+	}
+	return out, nil
+}
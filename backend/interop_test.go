@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat32ComplexRoundtrip(t *testing.T) {
+	in := []float32{0, 1, -1, 0.333333, -1e-7, 12345.678}
+	out := Complex128ToFloat32(Float32ToComplex128(in))
+	for i := range in {
+		if out[i] != in[i] {
+			t.Fatalf("sample %d: expected %v, got %v", i, in[i], out[i])
+		}
+	}
+
+	// Through a real FFT round trip, only float32 narrowing error remains.
+	signal := make([]float32, 256)
+	for i := range signal {
+		signal[i] = float32(math.Sin(2 * math.Pi * 5 * float64(i) / 256))
+	}
+	back := Complex128ToFloat32(IFFT(FFT(Float32ToComplex128(signal))))
+	for i := range signal {
+		if math.Abs(float64(back[i]-signal[i])) > 1e-6 {
+			t.Fatalf("sample %d: expected %v, got %v", i, signal[i], back[i])
+		}
+	}
+}
+
+func TestComplex64Roundtrip(t *testing.T) {
+	in := []complex128{complex(1.5, -2.25), complex(math.Pi, math.E), 0}
+	out := Complex64ToComplex128(Complex128ToComplex64(in))
+	for i := range in {
+		// Exact for values representable in float32, within float32
+		// precision otherwise.
+		if math.Abs(real(out[i])-real(in[i])) > 1e-6*math.Abs(real(in[i])) ||
+			math.Abs(imag(out[i])-imag(in[i])) > 1e-6*math.Abs(imag(in[i])) {
+			t.Fatalf("value %d: expected %v, got %v", i, in[i], out[i])
+		}
+	}
+	if out[0] != in[0] {
+		t.Fatalf("exact value changed: %v -> %v", in[0], out[0])
+	}
+}
+
+func TestInterleavedRoundtrip(t *testing.T) {
+	in := []complex128{complex(1, 2), complex(-3, 4.5), complex(0, -0.125)}
+	packed := PackInterleaved(in)
+	want := []float32{1, 2, -3, 4.5, 0, -0.125}
+	for i := range want {
+		if packed[i] != want[i] {
+			t.Fatalf("packed[%d]: expected %v, got %v", i, want[i], packed[i])
+		}
+	}
+
+	out, err := UnpackInterleaved(packed)
+	if err != nil {
+		t.Fatalf("UnpackInterleaved: %v", err)
+	}
+	for i := range in {
+		if out[i] != in[i] {
+			t.Fatalf("value %d: expected %v, got %v", i, in[i], out[i])
+		}
+	}
+
+	if _, err := UnpackInterleaved([]float32{1, 2, 3}); err == nil {
+		t.Fatal("expected error for odd length")
+	}
+}
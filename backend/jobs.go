@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Job states reported by GET /jobs/{id}.
+const (
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// job is one asynchronous denoise held by a jobStore.
+type job struct {
+	status   string
+	result   []byte // the cleaned WAV, once done
+	err      string // the failure, once failed
+	cancel   context.CancelFunc
+	finished time.Time
+}
+
+// jobStore runs denoise jobs in the background and holds their results
+// until they are fetched by id, deleted, or swept after the retention
+// period.
+type jobStore struct {
+	mu        sync.Mutex
+	jobs      map[string]*job
+	retention time.Duration
+	now       func() time.Time
+}
+
+// newJobStore returns an empty store that keeps finished jobs for
+// retention.
+func newJobStore(retention time.Duration) *jobStore {
+	return &jobStore{jobs: map[string]*job{}, retention: retention, now: time.Now}
+}
+
+// start runs run in the background under a new job and returns its id.
+// The context passed to run is cancelled if the job is deleted.
+func (s *jobStore) start(run func(ctx context.Context) ([]byte, error)) string {
+	var b [16]byte
+	rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{status: jobRunning, cancel: cancel}
+	s.mu.Lock()
+	s.jobs[id] = j
+	s.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		result, err := run(ctx)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			j.status, j.err = jobFailed, err.Error()
+		} else {
+			j.status, j.result = jobDone, result
+		}
+		j.finished = s.now()
+	}()
+	return id
+}
+
+// get returns a copy of the job with the given id.
+func (s *jobStore) get(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// remove cancels the job with the given id, if still running, and drops it
+// and its result. It reports whether the job existed.
+func (s *jobStore) remove(id string) bool {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	delete(s.jobs, id)
+	s.mu.Unlock()
+	if ok {
+		j.cancel()
+	}
+	return ok
+}
+
+// sweep drops finished jobs older than the retention period and returns
+// how many it dropped. Running jobs are never swept.
+func (s *jobStore) sweep() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cutoff := s.now().Add(-s.retention)
+	swept := 0
+	for id, j := range s.jobs {
+		if j.status != jobRunning && j.finished.Before(cutoff) {
+			delete(s.jobs, id)
+			swept++
+		}
+	}
+	return swept
+}
+
+// sweepEvery calls sweep every interval until ctx is done.
+func (s *jobStore) sweepEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n := s.sweep(); n > 0 {
+				slog.Debug("jobs: swept expired jobs", "count", n)
+			}
+		}
+	}
+}
+
+// register adds the /jobs endpoints to mux.
+func (s *jobStore) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /jobs", s.handleCreate)
+	mux.HandleFunc("GET /jobs/{id}", s.handleGet)
+	mux.HandleFunc("DELETE /jobs/{id}", s.handleDelete)
+}
+
+// handleCreate handles POST /jobs.
+// Expects the same multipart upload and fields as /denoise, starts
+// denoising in the background and returns 202 with the job's id as JSON.
+// Poll GET /jobs/{id} for the result.
+func (s *jobStore) handleCreate(w http.ResponseWriter, r *http.Request) {
+	samples, sampleRate, ok := readUploadedWAV(w, r, "jobs")
+	if !ok {
+		return
+	}
+
+	if !checkDuration(w, "jobs", len(samples), sampleRate) {
+		return
+	}
+
+	cfg, err := denoiseConfigFromForm(r, sampleRate)
+	if err != nil {
+		slog.Error("jobs: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := PCM16
+	if v := r.FormValue("out_bits"); v != "" {
+		if format, err = ParseSampleFormat(v); err != nil {
+			slog.Error("jobs: bad parameter", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	dither, err := ditherFromForm(r)
+	if err != nil {
+		slog.Error("jobs: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := s.start(func(ctx context.Context) ([]byte, error) {
+		cleaned, err := DenoiseContext(ctx, samples, sampleRate, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return WriteWAVFormat(ApplyDither(cleaned, 1, format, dither, cfg.Seed), sampleRate, format), nil
+	})
+	slog.Debug("jobs: started", "id", id, "samples", len(samples))
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "status": jobRunning})
+}
+
+// handleGet handles GET /jobs/{id}.
+// Returns the cleaned WAV once the job is done; until then, or if it
+// failed, its status as JSON. Unknown, deleted and expired jobs are 404.
+func (s *jobStore) handleGet(w http.ResponseWriter, r *http.Request) {
+	j, ok := s.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	switch j.status {
+	case jobDone:
+		setWAVHeaders(w, "cleaned.wav", len(j.result))
+		w.Write(j.result)
+	case jobFailed:
+		writeJSON(w, http.StatusOK, map[string]string{"status": j.status, "error": j.err})
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"status": j.status})
+	}
+}
+
+// handleDelete handles DELETE /jobs/{id}.
+// Cancels the job if it is still running and frees its result.
+func (s *jobStore) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !s.remove(r.PathValue("id")) {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveJobs routes req to the /jobs endpoints of s.
+func serveJobs(s *jobStore, req *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	s.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// waitForJob polls the job with the given id until it is no longer running.
+func waitForJob(t *testing.T, s *jobStore, id string) job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if j, ok := s.get(id); !ok || j.status != jobRunning {
+			return j
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("job %s still running", id)
+	return job{}
+}
+
+func TestJobsDenoise(t *testing.T) {
+	s := newJobStore(time.Minute)
+	rec := serveJobs(s, newUploadRequest(t, http.MethodPost, "/jobs", toneWAV(16000, 1), nil))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created["id"] == "" {
+		t.Fatalf("bad create response %q: %v", rec.Body.String(), err)
+	}
+	waitForJob(t, s, created["id"])
+
+	rec = serveJobs(s, httptest.NewRequest(http.MethodGet, "/jobs/"+created["id"], nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("expected the WAV result, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if _, err := ValidateWAV(rec.Body.Bytes()); err != nil {
+		t.Fatalf("result is not WAV: %v", err)
+	}
+}
+
+func TestJobsCancelRunning(t *testing.T) {
+	s := newJobStore(time.Minute)
+	cancelled := make(chan struct{})
+	id := s.start(func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+
+	rec := serveJobs(s, httptest.NewRequest(http.MethodGet, "/jobs/"+id, nil))
+	if rec.Code != http.StatusOK || !json.Valid(rec.Body.Bytes()) {
+		t.Fatalf("expected a JSON status for a running job, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = serveJobs(s, httptest.NewRequest(http.MethodDelete, "/jobs/"+id, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(10 * time.Second):
+		t.Fatal("running job was not cancelled")
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rec := serveJobs(s, httptest.NewRequest(method, "/jobs/"+id, nil)); rec.Code != http.StatusNotFound {
+			t.Fatalf("%s after delete: expected 404, got %d", method, rec.Code)
+		}
+	}
+}
+
+func TestJobsExpireAfterRetention(t *testing.T) {
+	s := newJobStore(time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	done := s.start(func(context.Context) ([]byte, error) { return []byte("result"), nil })
+	waitForJob(t, s, done)
+	release := make(chan struct{})
+	running := s.start(func(context.Context) ([]byte, error) {
+		<-release
+		return nil, nil
+	})
+	defer close(release)
+
+	now = now.Add(59 * time.Second)
+	if n := s.sweep(); n != 0 {
+		t.Fatalf("swept %d jobs before the retention period", n)
+	}
+	if rec := serveJobs(s, httptest.NewRequest(http.MethodGet, "/jobs/"+done, nil)); rec.Body.String() != "result" {
+		t.Fatalf("expected the result within the retention period, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	now = now.Add(2 * time.Second)
+	if n := s.sweep(); n != 1 {
+		t.Fatalf("expected 1 job swept, got %d", n)
+	}
+	if rec := serveJobs(s, httptest.NewRequest(http.MethodGet, "/jobs/"+done, nil)); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an expired job, got %d", rec.Code)
+	}
+	if _, ok := s.get(running); !ok {
+		t.Fatal("a running job was swept")
+	}
+}
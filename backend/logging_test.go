@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs routes the default slog logger into a buffer at the given
+// level for the duration of the test.
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	logger, err := newLogger(buf, level)
+	if err != nil {
+		t.Fatalf("newLogger: %v", err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return buf
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for in, want := range map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+	} {
+		got, err := parseLogLevel(in)
+		if err != nil || got != want {
+			t.Fatalf("parseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+func TestLogLevelWarnSuppressesRequestDetail(t *testing.T) {
+	logs := captureLogs(t, "warn")
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 0.5), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("expected no per-request logs at warn level, got:\n%s", logs.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", []byte("not a wav"), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+	if !strings.Contains(logs.String(), "level=ERROR") {
+		t.Fatalf("expected error log at warn level, got:\n%s", logs.String())
+	}
+}
+
+func TestLogLevelDebugShowsRequestDetail(t *testing.T) {
+	logs := captureLogs(t, "debug")
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 0.5), nil))
+	if !strings.Contains(logs.String(), "denoise: received audio") {
+		t.Fatalf("expected per-request detail at debug level, got:\n%s", logs.String())
+	}
+}
@@ -1,22 +1,50 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
 	port := flag.Int("port", 8080, "server port")
+	logLevel := flag.String("log-level", "info", "log verbosity: debug, info, warn or error")
+	flag.DurationVar(&maxAudioDuration, "max-duration", maxAudioDuration, "longest audio /denoise will process (0 for no limit)")
+	jobRetention := flag.Duration("job-retention", 10*time.Minute, "how long finished /jobs results are kept")
 	flag.Parse()
 
+	logger, err := newLogger(os.Stderr, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/denoise", handleDenoise)
+	mux.HandleFunc("/denoise/stereo", handleDenoiseStereo)
+	mux.HandleFunc("/denoise/reference", handleDenoiseReference)
+	mux.HandleFunc("/trim", handleTrim)
+	mux.HandleFunc("/compare", handleCompare)
+	mux.HandleFunc("/validate", handleValidate)
+	mux.HandleFunc("/peaks", handlePeaks)
+	mux.HandleFunc("/testfile", handleTestFile)
+	mux.HandleFunc("/capabilities", handleCapabilities)
+
+	jobs := newJobStore(*jobRetention)
+	go jobs.sweepEvery(context.Background(), time.Minute)
+	jobs.register(mux)
 
 	handler := corsMiddleware(mux)
 
 	addr := fmt.Sprintf(":%d", *port)
-	log.Printf("noise cancellation server listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, handler))
+	slog.Info("noise cancellation server listening", "addr", addr)
+	if err := http.ListenAndServe(addr, handler); err != nil {
+		slog.Error("server stopped", "err", err)
+		os.Exit(1)
+	}
 }
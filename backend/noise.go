@@ -0,0 +1,137 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// rayleighConfidence is the confidence (see NoiseProfile.Confidence) of a bin
+// whose magnitudes follow a Rayleigh distribution, as for stationary Gaussian
+// noise: CV² = 4/π - 1, so 1/(1+CV²) = π/4.
+const rayleighConfidence = 0.7853981633974483
+
+// NoiseProfile is the per-bin noise estimate used by spectral subtraction.
+type NoiseProfile struct {
+	// Mean is the average magnitude of each FFT bin over the noise frames.
+	Mean []float64
+	// Variance is the variance of each bin's magnitude over the noise frames.
+	Variance []float64
+}
+
+// Confidence returns how stable bin k's estimate is, in (0, 1]:
+// 1/(1+CV²), where CV is the coefficient of variation of its magnitude.
+// Bins with no energy are treated as fully confident.
+func (p *NoiseProfile) Confidence(k int) float64 {
+	m := p.Mean[k]
+	if m <= 0 {
+		return 1
+	}
+	return m * m / (m*m + p.Variance[k])
+}
+
+// levelDB returns the expected power of one noise frame relative to the mean
+// windowed frame power of samples, in dB, clamped at -120 dB.
+func (p *NoiseProfile) levelDB(samples, window []float64) float64 {
+	var noisePower float64
+	for k := range p.Mean {
+		noisePower += p.Mean[k]*p.Mean[k] + p.Variance[k]
+	}
+
+	// By Parseval, a windowed frame's spectral power is
+	// N * sum(w²x²) ≈ N * sum(w²) * mean(x²).
+	var windowPower float64
+	for _, w := range window {
+		windowPower += w * w
+	}
+	level := rms(samples)
+	signalPower := float64(len(window)) * windowPower * level * level
+
+	const minDB = -120
+	if noisePower <= 0 || signalPower <= 0 {
+		return minDB
+	}
+	return math.Max(minDB, 10*math.Log10(noisePower/signalPower))
+}
+
+// overSubtraction returns the per-bin over-subtraction factor. With
+// cfg.ConfidenceWeighting, bins noisier than stationary Gaussian noise have
+// cfg.OverSubtract scaled down in proportion to their confidence.
+func (p *NoiseProfile) overSubtraction(cfg DenoiseConfig) []float64 {
+	alpha := make([]float64, len(p.Mean))
+	for k := range alpha {
+		alpha[k] = cfg.OverSubtract
+		if cfg.ConfidenceWeighting {
+			if c := p.Confidence(k) / rayleighConfidence; c < 1 {
+				alpha[k] *= c
+			}
+		}
+	}
+	return alpha
+}
+
+// estimateNoise returns the mean and variance of the windowed magnitude
+// spectrum over the frames in region. A region shorter than one frame is
+// zero-padded.
+func estimateNoise(region []float64, window []float64, cfg DenoiseConfig) *NoiseProfile {
+	return estimateNoiseRegions([][]float64{region}, window, cfg)
+}
+
+// estimateNoiseRegions is like estimateNoise but pools the frames of
+// several regions, so each region counts in proportion to its length.
+func estimateNoiseRegions(regions [][]float64, window []float64, cfg DenoiseConfig) *NoiseProfile {
+	frameSize := cfg.FrameSize
+	mean := make([]float64, frameSize)
+	m2 := make([]float64, frameSize)
+
+	total := 0
+	for _, region := range regions {
+		frames := frameCount(len(region), cfg)
+		for fi := 0; fi < frames; fi++ {
+			start := fi * cfg.HopSize
+			frame := extractFrame(region, start, frameSize)
+			applyWindow(frame, window)
+
+			cx := realToComplex(frame)
+			spectrum := FFT(cx)
+
+			// Welford's running mean/variance.
+			total++
+			count := float64(total)
+			for k := 0; k < frameSize; k++ {
+				mag := cmplx.Abs(spectrum[k])
+				delta := mag - mean[k]
+				mean[k] += delta / count
+				m2[k] += delta * (mag - mean[k])
+			}
+		}
+	}
+
+	for k := range m2 {
+		if total > 0 {
+			m2[k] /= float64(total)
+		}
+	}
+	if cfg.NoiseSmoothingBins > 0 {
+		smoothBins(mean, cfg.NoiseSmoothingBins)
+	}
+
+	return &NoiseProfile{Mean: mean, Variance: m2}
+}
+
+// smoothBins replaces each of the non-negative frequency bins 0..N/2 of the
+// N-bin spectrum mag with the average of the bins within radius of it
+// (fewer at the edges), then mirrors them onto the negative frequencies.
+func smoothBins(mag []float64, radius int) {
+	half := len(mag) / 2
+	prefix := make([]float64, half+2)
+	for k := 0; k <= half; k++ {
+		prefix[k+1] = prefix[k] + mag[k]
+	}
+	for k := 0; k <= half; k++ {
+		lo, hi := max(k-radius, 0), min(k+radius, half)
+		mag[k] = (prefix[hi+1] - prefix[lo]) / float64(hi-lo+1)
+	}
+	for k := 1; k < half; k++ {
+		mag[len(mag)-k] = mag[k]
+	}
+}
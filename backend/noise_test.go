@@ -0,0 +1,174 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestNoiseProfileVariance(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	window := HannWindow(cfg.FrameSize)
+
+	// A constant tone has near-zero variance at its bin.
+	n := cfg.FrameSize * 6
+	steady := make([]float64, n)
+	for i := range steady {
+		steady[i] = 0.5 * math.Sin(2*math.Pi*64*float64(i)/float64(cfg.FrameSize))
+	}
+	p := estimateNoise(steady, window, cfg)
+	if c := p.Confidence(64); c < 0.999 {
+		t.Fatalf("steady tone: expected confidence ~1, got %f", c)
+	}
+
+	// White noise bins should sit near the Rayleigh confidence.
+	noise := pseudoNoise(cfg.FrameSize*200, 31337, 0.5)
+	p = estimateNoise(noise, window, cfg)
+	var avg float64
+	for k := 10; k < cfg.FrameSize/2-10; k++ {
+		avg += p.Confidence(k)
+	}
+	avg /= float64(cfg.FrameSize/2 - 20)
+	t.Logf("white noise mean confidence=%.3f (rayleigh=%.3f)", avg, rayleighConfidence)
+	if math.Abs(avg-rayleighConfidence) > 0.05 {
+		t.Fatalf("white noise confidence %.3f far from rayleigh %.3f", avg, rayleighConfidence)
+	}
+}
+
+// deepNotches counts bins in the averaged spectrum of x[start:end] that sit
+// more than depthDB below the same bin of ref, relative to overall level.
+func deepNotches(ref, x []float64, start, end, frameSize int, depthDB float64) int {
+	window := HannWindow(frameSize)
+	avg := func(s []float64) []float64 {
+		m := make([]float64, frameSize/2)
+		for pos := start; pos+frameSize <= end; pos += frameSize / 2 {
+			frame := extractFrame(s, pos, frameSize)
+			applyWindow(frame, window)
+			spec := FFT(realToComplex(frame))
+			for k := range m {
+				m[k] += cmplx.Abs(spec[k])
+			}
+		}
+		return m
+	}
+	r, c := avg(ref), avg(x)
+
+	// Compare shapes, not levels: the output is peak-normalized.
+	var rs, cs float64
+	for k := range r {
+		rs += r[k]
+		cs += c[k]
+	}
+	count := 0
+	for k := 1; k < len(r); k++ {
+		if c[k]/cs < r[k]/rs*math.Pow(10, -depthDB/20) {
+			count++
+		}
+	}
+	return count
+}
+
+func TestConfidenceWeightingReducesNotches(t *testing.T) {
+	sampleRate := 44100
+	cfg := DefaultDenoiseConfig()
+	noiseLen := cfg.FrameSize * 6
+	n := sampleRate * 2
+
+	// Noise: low white noise plus tones that flicker on and off during the
+	// noise region, giving their bins a high-variance estimate.
+	samples := pseudoNoise(n, 2024, 0.01)
+	burstFreqs := []float64{700, 1300, 2100, 3400, 5200}
+	for i := 0; i < noiseLen; i++ {
+		if (i/cfg.FrameSize)%2 == 0 {
+			continue
+		}
+		for _, f := range burstFreqs {
+			samples[i] += 0.05 * math.Sin(2*math.Pi*f*float64(i)/float64(sampleRate))
+		}
+	}
+
+	// Signal: broadband content plus steady tones at the same frequencies
+	// after the noise region.
+	broadband := pseudoNoise(n, 99, 0.2)
+	for i := noiseLen; i < n; i++ {
+		samples[i] += broadband[i]
+		for _, f := range burstFreqs {
+			samples[i] += 0.05 * math.Sin(2*math.Pi*f*float64(i)/float64(sampleRate))
+		}
+	}
+
+	fixed, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("fixed alpha: %v", err)
+	}
+	cfg.ConfidenceWeighting = true
+	weighted, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("confidence weighting: %v", err)
+	}
+
+	start := noiseLen + cfg.FrameSize
+	fixedNotches := deepNotches(samples, fixed, start, n, cfg.FrameSize, 20)
+	weightedNotches := deepNotches(samples, weighted, start, n, cfg.FrameSize, 20)
+	t.Logf("deep notches: fixed=%d, weighted=%d", fixedNotches, weightedNotches)
+
+	if weightedNotches >= fixedNotches {
+		t.Fatalf("expected fewer deep notches with confidence weighting: fixed=%d, weighted=%d",
+			fixedNotches, weightedNotches)
+	}
+}
+
+func TestNoiseSmoothingReducesNotches(t *testing.T) {
+	sampleRate := 44100
+	cfg := DefaultDenoiseConfig()
+	cfg.NoiseFrames = 2
+	window := HannWindow(cfg.FrameSize)
+	noiseLen := cfg.FrameSize * 6
+	n := sampleRate * 2
+
+	// White noise throughout; two noise frames give a spiky estimate.
+	samples := pseudoNoise(n, 4242, 0.05)
+	broadband := pseudoNoise(n, 77, 0.05)
+	for i := noiseLen; i < n; i++ {
+		samples[i] += broadband[i]
+	}
+
+	// Mean squared difference between adjacent bins of the estimate.
+	roughness := func(p *NoiseProfile) float64 {
+		var sum float64
+		for k := 1; k <= cfg.FrameSize/2; k++ {
+			d := p.Mean[k] - p.Mean[k-1]
+			sum += d * d
+		}
+		return sum / float64(cfg.FrameSize/2)
+	}
+	raw := estimateNoise(samples[:noiseLen], window, cfg)
+	smoothCfg := cfg
+	smoothCfg.NoiseSmoothingBins = 3
+	smooth := estimateNoise(samples[:noiseLen], window, smoothCfg)
+	t.Logf("bin-to-bin roughness: raw=%.4g, smoothed=%.4g", roughness(raw), roughness(smooth))
+	if roughness(smooth) > roughness(raw)/4 {
+		t.Fatalf("expected smoothing to cut roughness at least 4x: raw=%.4g, smoothed=%.4g", roughness(raw), roughness(smooth))
+	}
+	for k := 1; k < cfg.FrameSize/2; k++ {
+		if smooth.Mean[cfg.FrameSize-k] != smooth.Mean[k] {
+			t.Fatalf("bin %d: smoothed estimate not mirrored", k)
+		}
+	}
+
+	plain, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("unsmoothed: %v", err)
+	}
+	smoothed, err := DenoiseWithConfig(samples, sampleRate, smoothCfg)
+	if err != nil {
+		t.Fatalf("smoothed: %v", err)
+	}
+	start := noiseLen + cfg.FrameSize
+	plainNotches := deepNotches(samples, plain, start, n, cfg.FrameSize, 10)
+	smoothNotches := deepNotches(samples, smoothed, start, n, cfg.FrameSize, 10)
+	t.Logf("deep notches: unsmoothed=%d, smoothed=%d", plainNotches, smoothNotches)
+	if smoothNotches >= plainNotches {
+		t.Fatalf("expected fewer deep notches with noise smoothing: unsmoothed=%d, smoothed=%d", plainNotches, smoothNotches)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "bytes"
+
+// oggMagic starts every Ogg page.
+const oggMagic = "OggS"
+
+// oggHeaderSize is the size of an Ogg page header before its segment
+// table.
+const oggHeaderSize = 27
+
+// Ogg streams are recognized here, not decoded: the module has no Opus,
+// Vorbis or Speex decoder, so readUploadedWAVHeader rejects Ogg uploads
+// with 415, naming the codec, rather than as malformed WAV files.
+
+// isOgg reports whether b starts with an Ogg page.
+func isOgg(b []byte) bool {
+	return len(b) >= len(oggMagic) && string(b[:len(oggMagic)]) == oggMagic
+}
+
+// oggCodecs maps the start of a stream's first packet to its codec.
+var oggCodecs = []struct {
+	magic, name string
+}{
+	{"OpusHead", "Opus"},
+	{"\x01vorbis", "Vorbis"},
+	{"\x7fFLAC", "FLAC"},
+	{"Speex   ", "Speex"},
+}
+
+// oggCodec names the codec of the stream whose first page starts b, or
+// returns "" if it is not recognized.
+func oggCodec(b []byte) string {
+	if !isOgg(b) || len(b) < oggHeaderSize {
+		return ""
+	}
+	packet := b[oggHeaderSize:]
+	if segments := int(b[oggHeaderSize-1]); len(packet) >= segments {
+		packet = packet[segments:]
+	}
+	for _, c := range oggCodecs {
+		if bytes.HasPrefix(packet, []byte(c.magic)) {
+			return c.name
+		}
+	}
+	return ""
+}
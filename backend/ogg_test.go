@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// oggPage returns an Ogg page holding packet as its only segment.
+func oggPage(packet string) []byte {
+	page := []byte(oggMagic)
+	page = append(page, 0, 2) // version, beginning of stream
+	page = append(page, make([]byte, 8+4+4+4)...)
+	page = append(page, 1, byte(len(packet)))
+	return append(page, packet...)
+}
+
+func TestOggCodec(t *testing.T) {
+	for packet, want := range map[string]string{
+		"OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00": "Opus",
+		"\x01vorbis\x00\x00\x00\x00\x01\x44\xac\x00\x00":       "Vorbis",
+		"\x7fFLAC\x01\x00\x00\x01fLaC":                         "FLAC",
+		"fishead\x00":                                          "",
+	} {
+		if got := oggCodec(oggPage(packet)); got != want {
+			t.Fatalf("%q: got %q, want %q", packet, got, want)
+		}
+	}
+	if oggCodec(WriteWAV(make([]float64, 100), 8000)) != "" {
+		t.Fatal("WAV recognized as Ogg")
+	}
+}
+
+func TestHandleDenoiseOgg(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise",
+		oggPage("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00\x00\x00\x00\x00"), nil))
+	if rec.Code != http.StatusUnsupportedMediaType || !strings.Contains(rec.Body.String(), "Ogg/Opus") {
+		t.Fatalf("expected 415 naming Ogg/Opus, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
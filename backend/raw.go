@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// RawFormat describes headerless PCM input: interleaved samples of
+// NumChannels channels in Format, stored little- or big-endian. 8-bit
+// samples are unsigned, as in WAV.
+type RawFormat struct {
+	SampleRate  int
+	NumChannels int
+	Format      SampleFormat
+	BigEndian   bool
+}
+
+// DecodeRaw decodes headerless PCM data in format f into interleaved
+// samples, and returns the WAVHeader such a WAV file would have. A
+// trailing partial frame is dropped.
+func DecodeRaw(data []byte, f RawFormat) ([]float64, *WAVHeader, error) {
+	if f.SampleRate <= 0 {
+		return nil, nil, fmt.Errorf("raw: invalid sample rate %d", f.SampleRate)
+	}
+	if f.NumChannels < 1 {
+		return nil, nil, fmt.Errorf("raw: invalid channel count %d", f.NumChannels)
+	}
+	header := &WAVHeader{
+		SampleRate:    f.SampleRate,
+		NumChannels:   f.NumChannels,
+		BitsPerSample: f.Format.BitsPerSample(),
+		Float:         f.Format.isFloat(),
+	}
+	width := header.BitsPerSample / 8
+	data = data[:len(data)-len(data)%(width*f.NumChannels)]
+	if f.BigEndian && width > 1 {
+		data = slices.Clone(data)
+		for i := 0; i < len(data); i += width {
+			slices.Reverse(data[i : i+width])
+		}
+	}
+	return decodeSamples(data, header), header, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	for name, tc := range map[string]struct {
+		f    RawFormat
+		data []byte
+		want []float64
+	}{
+		"le16":    {RawFormat{8000, 1, PCM16, false}, []byte{0x00, 0x40, 0x00, 0xC0}, []float64{0.5, -0.5}},
+		"be16":    {RawFormat{8000, 1, PCM16, true}, []byte{0x40, 0x00, 0xC0, 0x00}, []float64{0.5, -0.5}},
+		"be24":    {RawFormat{8000, 1, PCM24, true}, []byte{0x40, 0x00, 0x00}, []float64{0.5}},
+		"8bit":    {RawFormat{8000, 1, PCM8, true}, []byte{0xC0, 0x40}, []float64{0.5, -0.5}},
+		"partial": {RawFormat{8000, 2, PCM16, false}, []byte{0x00, 0x40, 0x00, 0xC0, 0x00}, []float64{0.5, -0.5}},
+	} {
+		got, header, err := DecodeRaw(tc.data, tc.f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !slices.Equal(got, tc.want) || header.SampleRate != 8000 || header.NumChannels != tc.f.NumChannels {
+			t.Fatalf("%s: got %v, header %+v", name, got, header)
+		}
+	}
+	if _, _, err := DecodeRaw([]byte{0, 0}, RawFormat{0, 1, PCM16, false}); err == nil {
+		t.Fatal("expected an error for sample rate 0")
+	}
+}
+
+func TestHandleDenoiseRaw(t *testing.T) {
+	wav := toneWAV(16000, 0.5)
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", wav, map[string]string{"passthrough": "1"}))
+	want := rec.Body.Bytes()
+
+	// The same samples as big-endian raw PCM convert to the same WAV.
+	pcm := slices.Clone(wav[44:])
+	for i := 0; i < len(pcm); i += 2 {
+		pcm[i], pcm[i+1] = pcm[i+1], pcm[i]
+	}
+	raw := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/denoise?raw=1&passthrough=1&"+query, bytes.NewReader(pcm))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, req)
+		return rec
+	}
+	if rec := raw("sample_rate=16000&bits=16&endian=big"); rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), want) {
+		t.Fatalf("raw upload: got %d, %d bytes, want the WAV conversion", rec.Code, rec.Body.Len())
+	}
+
+	// The input rate and the output rate are separate parameters.
+	rec = raw("sample_rate=16000&bits=16&endian=big&out_rate=8000")
+	if got, rate, err := ReadWAV(rec.Body.Bytes()); rec.Code != http.StatusOK || err != nil || rate != 8000 || len(got) != len(pcm)/4 {
+		t.Fatalf("raw upload with out_rate: got %d, %d samples at %d Hz (err %v), want %d at 8000 Hz", rec.Code, len(got), rate, err, len(pcm)/4)
+	}
+
+	for _, query := range []string{"", "sample_rate=16000&endian=middle", "sample_rate=16000&bits=12", "sample_rate=16000&channels=0"} {
+		if rec := raw(query); rec.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// resampleZeros is how many zero crossings of the interpolating sinc
+	// the filter keeps either side of its centre, at the lower of the two
+	// rates. More sharpen the cutoff at the cost of speed.
+	resampleZeros = 16
+	// resampleCutoff is the passband edge as a fraction of the lower
+	// Nyquist frequency, leaving the rest for the filter to roll off in.
+	resampleCutoff = 0.95
+	// resampleKaiserBeta shapes the Kaiser window over the sinc, for
+	// about 80 dB of stopband attenuation.
+	resampleKaiserBeta = 8
+	// maxResamplePhases bounds the phases Resample tabulates; rate pairs
+	// with more, such as 44100 and 44101 Hz, compute each output sample's
+	// taps as they go.
+	maxResamplePhases = 4096
+)
+
+// Resample converts mono samples from the sample rate from to the sample
+// rate to with a polyphase windowed-sinc filter, and returns
+// ResampledLength(len(samples), from, to) samples. Content above the
+// lower of the two Nyquist frequencies is filtered out, so downsampling
+// does not alias. Samples beyond each end are taken as zero.
+func Resample(samples []float64, from, to int) ([]float64, error) {
+	if from <= 0 || to <= 0 {
+		return nil, fmt.Errorf("resample: invalid sample rates %d and %d", from, to)
+	}
+	if from == to {
+		return append([]float64(nil), samples...), nil
+	}
+	rs := newResampler(from, to)
+	out := make([]float64, ResampledLength(len(samples), from, to))
+	taps := make([]float64, rs.taps)
+	half := rs.taps / 2
+	for n := range out {
+		// Output sample n falls at input position i + p/up.
+		pos := int64(n) * int64(rs.down)
+		i, p := int(pos/int64(rs.up)), int(pos%int64(rs.up))
+		h := rs.phase(p, taps)
+		var v float64
+		for j, c := range h {
+			if k := i + j - half + 1; k >= 0 && k < len(samples) {
+				v += c * samples[k]
+			}
+		}
+		out[n] = v
+	}
+	return out, nil
+}
+
+// ResampledLength returns the number of samples Resample returns for n
+// samples: n scaled by to/from, rounded up.
+func ResampledLength(n, from, to int) int {
+	return int((int64(n)*int64(to) + int64(from) - 1) / int64(from))
+}
+
+// resampler is the polyphase filter for one pair of rates, reduced to the
+// ratio up/down: an output sample at input position i + p/up is computed
+// from the taps input samples around i with the coefficients of phase p.
+type resampler struct {
+	up, down int
+	taps     int
+	cutoff   float64 // in cycles per input sample, times 2
+	phases   [][]float64
+}
+
+// newResampler returns the resampler from the rate from to the rate to,
+// with its phases tabulated if there are at most maxResamplePhases.
+func newResampler(from, to int) *resampler {
+	g := gcd(from, to)
+	rs := &resampler{up: to / g, down: from / g}
+	rs.cutoff = resampleCutoff * min(1, float64(to)/float64(from))
+	rs.taps = 2 * int(math.Ceil(resampleZeros/rs.cutoff))
+	if rs.up <= maxResamplePhases {
+		rs.phases = make([][]float64, rs.up)
+		for p := range rs.phases {
+			rs.phases[p] = rs.compute(p, make([]float64, rs.taps))
+		}
+	}
+	return rs
+}
+
+// phase returns the coefficients of phase p, computed into dst if they
+// are not tabulated.
+func (rs *resampler) phase(p int, dst []float64) []float64 {
+	if rs.phases != nil {
+		return rs.phases[p]
+	}
+	return rs.compute(p, dst)
+}
+
+// compute fills dst with the coefficients of phase p: a Kaiser-windowed
+// sinc, normalized to unity gain at DC so a constant stays constant.
+func (rs *resampler) compute(p int, dst []float64) []float64 {
+	half := rs.taps / 2
+	width := float64(half)
+	frac := float64(p) / float64(rs.up)
+	var sum float64
+	for j := range dst {
+		// Tap j weights sample i+j-half+1 for the point i+frac.
+		x := frac + float64(half-1-j)
+		dst[j] = rs.cutoff * sinc(rs.cutoff*x) * kaiser(x/width, resampleKaiserBeta)
+		sum += dst[j]
+	}
+	for j := range dst {
+		dst[j] /= sum
+	}
+	return dst
+}
+
+// kaiser returns the Kaiser window of shape beta at x in [-1, 1], and 0
+// outside it.
+func kaiser(x, beta float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 returns the modified Bessel function of the first kind of
+// order zero, summing its power series to double precision.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-17; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// sine returns n samples of a unit sine at freq Hz sampled at sampleRate.
+func sine(n int, freq float64, sampleRate int) []float64 {
+	x := make([]float64, n)
+	for i := range x {
+		x[i] = math.Sin(2 * math.Pi * freq * float64(i) / float64(sampleRate))
+	}
+	return x
+}
+
+func TestResampleSine(t *testing.T) {
+	for _, tc := range []struct{ from, to int }{
+		{48000, 16000},
+		{16000, 44100},
+		{44100, 48000},
+		{22050, 8000},
+		{44100, 44101}, // too many phases to tabulate
+	} {
+		in := sine(tc.from/2, 440, tc.from)
+		out, err := Resample(in, tc.from, tc.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := ResampledLength(len(in), tc.from, tc.to); len(out) != want {
+			t.Fatalf("%d→%d: got %d samples, want %d", tc.from, tc.to, len(out), want)
+		}
+		// Away from the ends, which fade against the zeros beyond them,
+		// the output is the same sine at the new rate.
+		want := sine(len(out), 440, tc.to)
+		edge := tc.to / 100
+		for i := edge; i < len(out)-edge; i++ {
+			if math.Abs(out[i]-want[i]) > 1e-3 {
+				t.Fatalf("%d→%d: sample %d = %g, want %g", tc.from, tc.to, i, out[i], want[i])
+			}
+		}
+	}
+}
+
+func TestResampleRemovesAliases(t *testing.T) {
+	// A 7 kHz tone is above the 4 kHz Nyquist frequency of 8 kHz audio,
+	// where it would alias to 1 kHz.
+	out, err := Resample(sine(48000, 7000, 48000), 48000, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := 20 * math.Log10(rms(out[800:len(out)-800])); level > -60 {
+		t.Fatalf("aliased tone at %.1f dB, want below -60", level)
+	}
+}
+
+func TestResampleRoundTrip(t *testing.T) {
+	in := sine(16000, 1000, 16000)
+	up, err := Resample(in, 16000, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := Resample(up, 44100, 16000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back = fitLength(back, len(in))
+	for i := 200; i < len(in)-200; i++ {
+		if math.Abs(back[i]-in[i]) > 1e-3 {
+			t.Fatalf("sample %d = %g after the round trip, want %g", i, back[i], in[i])
+		}
+	}
+}
+
+func TestResampleInvalidRate(t *testing.T) {
+	for _, rates := range [][2]int{{0, 16000}, {16000, 0}, {-8000, 16000}} {
+		if _, err := Resample([]float64{0, 1}, rates[0], rates[1]); err == nil {
+			t.Errorf("%d→%d: expected an error", rates[0], rates[1])
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+// ringBuffer is a FIFO of samples backed by a circular slice, for holding
+// the unprocessed tail of a stream between writes. Capacity grows (by
+// doubling) only when a write would overflow it, so a buffer that is
+// drained at the rate it is filled stops allocating.
+type ringBuffer struct {
+	buf   []float64
+	start int // index of the oldest sample
+	n     int // samples held
+}
+
+// newRingBuffer returns an empty buffer with room for capacity samples.
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]float64, max(capacity, 1))}
+}
+
+// Len returns the number of samples held.
+func (r *ringBuffer) Len() int { return r.n }
+
+// Write appends samples, growing the buffer if needed.
+func (r *ringBuffer) Write(samples []float64) {
+	if need := r.n + len(samples); need > len(r.buf) {
+		r.grow(need)
+	}
+	end := (r.start + r.n) % len(r.buf)
+	copied := copy(r.buf[end:], samples)
+	copy(r.buf, samples[copied:])
+	r.n += len(samples)
+}
+
+// ReadN removes the n oldest samples (all of them, if fewer are held) and
+// appends them to dst in order, returning the extended slice. Passing a dst
+// with enough capacity avoids allocation.
+func (r *ringBuffer) ReadN(dst []float64, n int) []float64 {
+	n = min(n, r.n)
+	first := min(n, len(r.buf)-r.start)
+	dst = append(dst, r.buf[r.start:r.start+first]...)
+	dst = append(dst, r.buf[:n-first]...)
+	r.start = (r.start + n) % len(r.buf)
+	r.n -= n
+	return dst
+}
+
+// grow reallocates the buffer to hold at least need samples, unwrapping the
+// contents to the front.
+func (r *ringBuffer) grow(need int) {
+	size := len(r.buf)
+	for size < need {
+		size *= 2
+	}
+	n := r.n
+	r.buf = r.ReadN(make([]float64, 0, size), n)[:size]
+	r.start, r.n = 0, n
+}
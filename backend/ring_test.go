@@ -0,0 +1,80 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRingBufferWraparound(t *testing.T) {
+	r := newRingBuffer(8)
+	next := 0.0 // the next value to write
+	want := 0.0 // the next value expected back
+	write := func(n int) {
+		samples := make([]float64, n)
+		for i := range samples {
+			samples[i] = next
+			next++
+		}
+		r.Write(samples)
+	}
+	read := func(n int) {
+		got := r.ReadN(nil, n)
+		for i, v := range got {
+			if v != want {
+				t.Fatalf("sample %d of read: got %v, want %v", i, v, want)
+			}
+			want++
+		}
+	}
+
+	// Advance the start past the middle, then write across the end of
+	// the backing slice and read back across the wrap.
+	write(6)
+	read(5)
+	write(6)
+	if r.start+r.n <= len(r.buf) {
+		t.Fatalf("expected the contents to wrap: start %d, len %d, cap %d", r.start, r.n, len(r.buf))
+	}
+	if got := r.Len(); got != 7 {
+		t.Fatalf("expected 7 samples held, got %d", got)
+	}
+	read(7)
+	if r.Len() != 0 {
+		t.Fatalf("expected an empty buffer, got %d samples", r.Len())
+	}
+
+	// Growing while wrapped keeps the order.
+	write(6)
+	write(20)
+	if len(r.buf) < 26 {
+		t.Fatalf("expected the buffer to grow to at least 26, got %d", len(r.buf))
+	}
+	read(10)
+	write(3)
+	read(100) // more than held
+	if r.Len() != 0 || want != next {
+		t.Fatalf("expected everything read back: %d held, read up to %v of %v", r.Len(), want, next)
+	}
+}
+
+func TestRingBufferReadNAppendsWithoutAllocating(t *testing.T) {
+	r := newRingBuffer(16)
+	in := []float64{1, 2, 3, 4, 5}
+	dst := make([]float64, 0, 8)
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Write(in)
+		dst = r.ReadN(dst[:0], len(in))
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations in steady state, got %.0f", allocs)
+	}
+	if !slices.Equal(dst, in) {
+		t.Fatalf("got %v, want %v", dst, in)
+	}
+
+	// ReadN appends after what dst already holds.
+	r.Write([]float64{6, 7})
+	if got := r.ReadN([]float64{0}, 2); !slices.Equal(got, []float64{0, 6, 7}) {
+		t.Fatalf("got %v, want [0 6 7]", got)
+	}
+}
@@ -1,19 +1,59 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 const maxUploadSize = 50 << 20 // 50 MB
 
+// maxAudioDuration caps the length of audio /denoise will process, bounding
+// the time a single request can occupy a worker. Zero disables the limit.
+// Set from the -max-duration flag.
+var maxAudioDuration = 10 * time.Minute
+
+// checkDuration reports whether n samples at sampleRate are within
+// maxAudioDuration, answering 413 if they are not.
+func checkDuration(w http.ResponseWriter, op string, n, sampleRate int) bool {
+	duration := time.Duration(float64(n) / float64(sampleRate) * float64(time.Second))
+	if maxAudioDuration > 0 && duration > maxAudioDuration {
+		slog.Error(op+": audio too long", "duration", duration, "max", maxAudioDuration)
+		http.Error(w, fmt.Sprintf("audio is %.1f s long; maximum is %.1f s",
+			duration.Seconds(), maxAudioDuration.Seconds()), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	return true
+}
+
+// uploadSampleLimit returns the most interleaved samples an upload at
+// sampleRate with numChannels channels is decoded to: MaxSamples per
+// channel, or maxAudioDuration's worth if that is less.
+func uploadSampleLimit(sampleRate, numChannels int) int {
+	limit := MaxSamples
+	if maxAudioDuration > 0 {
+		limit = min(limit, int(maxAudioDuration.Seconds()*float64(sampleRate)))
+	}
+	return max(limit, 1) * numChannels
+}
+
 // corsMiddleware adds CORS headers so the Vite dev server (or any origin)
 // can make requests to this backend.
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 
 		if r.Method == http.MethodOptions {
@@ -26,58 +66,1335 @@ func corsMiddleware(next http.Handler) http.Handler {
 }
 
 // handleDenoise handles POST /denoise.
-// Expects a multipart form with a "file" field containing a WAV file.
-// Returns the denoised audio as a WAV response.
+// Expects a multipart form with a "file" field containing a WAV, FLAC or
+// AIFF file.
+// Returns the denoised audio as a WAV response, 16-bit unless the optional
+// "out_bits" field selects 8, 24, 32 or 32f (32-bit float). The optional
+// "amount" field (0..100) sets the reduction strength (see WithAmount), and
+// "normalize" (peak, rms, rolling or none; default peak) the output level
+// handling; "true_peak=1" normalizes against the true peak (see
+// DenoiseConfig.TruePeak).
+// HEAD with the same form returns only the response headers; the output
+// size is known from the decoded input, so denoising is skipped. With
+// "dry_run=1" no audio is returned either: the response is JSON metadata
+// from EstimateDenoise (noise level, SNR and expected reduction), for cheap
+// bulk triage. With "residual=1" the response is the removed component
+// instead (see DenoiseResidual), for checking that voice is not removed
+// along with the noise. "passthrough=1" skips denoising altogether and
+// returns the decoded input re-encoded, for using the server as a format
+// converter: stereo is mixed down to mono and "out_bits" applies.
+// "channel" (mix, left or right; default mix) picks which channel of a
+// stereo or multichannel input is denoised, for recordings with the voice
+// on one side only.
+// "dither" (none, tpdf or shaped; default none) adds triangular-PDF dither,
+// optionally noise-shaped, when quantizing to integer output (see
+// ApplyDither), so quiet passages do not distort.
+// "out_format=aiff" returns an AIFF file (AIFF-C for 32f) instead of WAV,
+// and "out_format=flac" a FLAC file (integer out_bits only); as its size is
+// only known once encoded, HEAD then sends no Content-Length. Opus output
+// is not implemented, and "out_format=opus" gets 501.
+// "process_rate" (in Hz) denoises at that sample rate instead of the
+// upload's, resampling there and back (see Resample), e.g. to trade
+// bandwidth for speed. The frame-counted defaults are scaled to the rate
+// denoised at either way (see ForSampleRate).
+// "out_rate" (in Hz) resamples the result to that rate, e.g. 16000 for
+// speech recognition; by default it is returned at the upload's rate.
+// With "raw=1" in the query the body is headerless PCM rather than a
+// multipart form (see readRawUpload).
+// "keep_metadata=1" carries the input's metadata chunks (bext, iXML, LIST,
+// cue, smpl and the like) over to the WAV returned, so editorial tools keep
+// timestamps and markers; sample positions stay valid as the length of the
+// audio is unchanged, or are rescaled with it by "out_rate".
+// Inputs longer than maxAudioDuration are rejected with 413; on success the
+// wall-clock processing time is reported in X-Processing-Ms.
 func handleDenoise(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	started := time.Now()
+
+	samples, header, ok := readUploadedWAVHeader(w, r, "denoise")
+	if !ok {
+		return
+	}
+	sampleRate := header.SampleRate
+
+	if !checkDuration(w, "denoise", len(samples), sampleRate) {
+		return
+	}
+
+	// Denoise at the processing rate, if one is given (see below).
+	processRate := sampleRate
+	if s := r.FormValue("process_rate"); s != "" {
+		rate, err := strconv.Atoi(s)
+		if err != nil || rate <= 0 || rate > maxResampleRate {
+			slog.Error("denoise: bad parameter", "process_rate", s)
+			http.Error(w, fmt.Sprintf("process_rate must be a sample rate in Hz up to %d, not %q", maxResampleRate, s), http.StatusBadRequest)
+			return
+		}
+		processRate = rate
+	}
+
+	cfg, err := denoiseConfigFromForm(r, processRate)
+	if err == nil {
+		// Check the length at the processing rate before resampling to it.
+		err = cfg.checkLength(ResampledLength(len(samples), sampleRate, processRate))
+	}
+	if err != nil {
+		slog.Error("denoise: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cfg, ok = checkInput(w, r, samples, sampleRate, cfg)
+	if !ok {
+		return
+	}
+
+	format, err := sampleFormatFromForm(r)
+	if err != nil {
+		slog.Error("denoise: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	dither, err := ditherFromForm(r)
+	if err != nil {
+		slog.Error("denoise: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := output{
+		header: WAVHeader{SampleRate: sampleRate, NumChannels: 1, BitsPerSample: format.BitsPerSample(), Float: format.isFloat()},
+		format: format,
+		dither: dither,
+		seed:   cfg.Seed,
+	}
+	switch f := r.FormValue("out_format"); f {
+	case "", "wav":
+	case "aiff", "flac":
+		out.container = f
+	case "opus":
+		// Lossy output would need an Opus encoder, which this module lacks.
+		slog.Error("denoise: unsupported output", "out_format", f)
+		http.Error(w, "out_format=opus is not implemented; out_format=flac compresses losslessly", http.StatusNotImplemented)
+		return
+	default:
+		slog.Error("denoise: bad parameter", "out_format", f)
+		http.Error(w, fmt.Sprintf("out_format must be wav, aiff or flac, not %q", f), http.StatusBadRequest)
+		return
+	}
+	if out.container == "flac" && format.isFloat() {
+		slog.Error("denoise: bad parameter", "err", "float FLAC")
+		http.Error(w, "out_format=flac needs integer out_bits", http.StatusBadRequest)
+		return
+	}
+	if s := r.FormValue("out_rate"); s != "" {
+		rate, err := strconv.Atoi(s)
+		if err != nil || rate <= 0 || rate > maxResampleRate {
+			slog.Error("denoise: bad parameter", "out_rate", s)
+			http.Error(w, fmt.Sprintf("out_rate must be a sample rate in Hz up to %d, not %q", maxResampleRate, s), http.StatusBadRequest)
+			return
+		}
+		out.header.SampleRate, out.rate = rate, sampleRate
+		out.length = ResampledLength(len(samples), sampleRate, rate)
+	}
+	if r.FormValue("keep_metadata") == "1" {
+		out.header.Sampler, out.header.Cue, out.header.Extra = header.resampledMetadata(out.header.SampleRate)
+	}
+
+	if processRate != sampleRate {
+		out.rate = processRate
+		if out.length == 0 {
+			out.length = len(samples)
+		}
+	}
+
+	// Echo the fully resolved configuration for debugging.
+	if r.FormValue("echo_config") == "1" {
+		if b, err := json.Marshal(cfg); err == nil {
+			w.Header().Set("X-Denoise-Config", string(b))
+		}
+	}
+
+	if r.FormValue("dry_run") == "1" {
+		processed := samples
+		if processRate != sampleRate {
+			processed, _ = Resample(samples, sampleRate, processRate)
+		}
+		est, err := EstimateDenoise(processed, processRate, cfg)
+		if err != nil {
+			slog.Error("denoise: estimate failed", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Debug("denoise: dry run", "noise_db", est.NoiseDB, "elapsed", time.Since(started))
+		writeJSON(w, http.StatusOK, map[string]any{
+			"sample_rate":      header.SampleRate,
+			"samples":          len(samples),
+			"noise_db":         est.NoiseDB,
+			"noise_negligible": est.NoiseNegligible,
+			"snr_db":           est.SNRDB,
+			"reduction_db":     est.ReductionDB,
+		})
+		return
+	}
+
+	passthrough := r.FormValue("passthrough") == "1"
+	residual := r.FormValue("residual") == "1"
+	name := "cleaned"
+	switch {
+	case passthrough:
+		name = "converted"
+	case residual:
+		name = "residual"
+	}
+
+	if r.Method == http.MethodHead {
+		out.setHeaders(w, name, out.size(len(samples)))
+		return
+	}
+
+	// Denoise at the processing rate; the result is converted back to the
+	// output rate on encoding.
+	if processRate != sampleRate {
+		samples, _ = Resample(samples, sampleRate, processRate)
+		sampleRate = processRate
+	}
+
+	if passthrough {
+		slog.Debug("denoise: returning converted audio", "elapsed", time.Since(started))
+		if err := out.send(w, name, samples); err != nil {
+			slog.Info("denoise: failed to send response", "err", err)
+		}
+		return
+	}
+
+	if residual {
+		removed, err := DenoiseResidual(samples, sampleRate, cfg)
+		if err != nil {
+			slog.Error("denoise: processing failed", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		elapsed := time.Since(started)
+		slog.Debug("denoise: returning residual", "elapsed", elapsed)
+		w.Header().Set("X-Processing-Ms", strconv.FormatInt(elapsed.Milliseconds(), 10))
+		if err := out.send(w, name, removed); err != nil {
+			slog.Info("denoise: failed to send response", "err", err)
+		}
+		return
+	}
+
+	if r.FormValue("progress") == "1" {
+		denoiseMultipart(w, r, samples, sampleRate, cfg, out, started)
+		return
+	}
+
+	// Run noise cancellation, giving up if the client goes away.
+	cleaned, err := DenoiseContext(r.Context(), samples, sampleRate, cfg)
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("denoise: client disconnected, processing stopped", "err", err, "elapsed", time.Since(started))
+		return
+	}
+	if err != nil {
+		slog.Error("denoise: processing failed", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	elapsed := time.Since(started)
+	slog.Debug("denoise: returning cleaned audio", "elapsed", elapsed)
+
+	// Send response, encoding it as it goes out.
+	w.Header().Set("X-Processing-Ms", strconv.FormatInt(elapsed.Milliseconds(), 10))
+	if err := out.send(w, "cleaned", cleaned); err != nil {
+		slog.Info("denoise: failed to send response", "err", err)
+	}
+}
+
+// maxResampleRate is the highest rate handleDenoise resamples to.
+const maxResampleRate = 384000
+
+// output describes the file handleDenoise returns: samples encoded as the
+// WAV file header describes, or as an AIFF or FLAC file in the same format,
+// with dither applied for integer formats. Mono samples processed at
+// another rate are resampled to the header's first.
+type output struct {
+	header    WAVHeader
+	format    SampleFormat
+	dither    Dither
+	seed      int64  // seeds the dither, as DenoiseConfig.Seed
+	container string // "aiff" or "flac"; WAV otherwise
+	rate      int    // rate of the samples encode is given, if not header.SampleRate
+	length    int    // if positive, the number of samples encoded, once resampled
+}
+
+// encode encodes samples as the file o describes. handleDenoise builds o
+// from a parsed output format and a decoded sample rate, so it is always
+// encodable.
+func (o output) encode(samples []float64) []byte {
+	samples = o.prepare(samples)
+	var result []byte
+	switch o.container {
+	case "aiff":
+		result, _ = EncodeAIFF(samples, o.header.SampleRate, o.header.NumChannels, o.format)
+	case "flac":
+		result, _ = EncodeFLAC(samples, o.header.SampleRate, o.header.NumChannels, o.format)
+	default:
+		result, _ = EncodeWAV(samples, o.header)
+	}
+	return result
+}
+
+// prepare returns samples resampled, fitted to length and dithered, ready
+// to be stored in the file o describes.
+func (o output) prepare(samples []float64) []float64 {
+	if o.rate != 0 && o.rate != o.header.SampleRate {
+		samples, _ = Resample(samples, o.rate, o.header.SampleRate)
+	}
+	if o.length > 0 {
+		samples = fitLength(samples, o.length)
+	}
+	return ApplyDither(samples, o.header.NumChannels, o.format, o.dither, o.seed)
+}
+
+// streamable reports whether the file o describes is a plain WAV file,
+// which a WAVWriter can write without first building it whole.
+func (o output) streamable() bool {
+	h := o.header
+	return o.container == "" && h.ChannelMask == 0 && h.Extra == nil && h.Sampler == nil && h.Cue == nil
+}
+
+// writeTo writes the file encode returns to w, streaming it through a
+// WAVWriter if it is streamable.
+func (o output) writeTo(w io.Writer, samples []float64) error {
+	if !o.streamable() {
+		_, err := w.Write(o.encode(samples))
+		return err
+	}
+	samples = o.prepare(samples)
+	ww, err := NewWAVWriterSize(w, o.header.SampleRate, o.header.NumChannels, o.format, len(samples))
+	if err != nil {
+		return err
+	}
+	if err := ww.Write(samples); err != nil {
+		return err
+	}
+	return ww.Close()
+}
+
+// send sets the headers for the file o describes, named after base, and
+// writes it to w: streamed, with a Content-Length worked out in advance,
+// if it is streamable, or else encoded whole first to learn its size.
+func (o output) send(w http.ResponseWriter, base string, samples []float64) error {
+	if !o.streamable() {
+		result := o.encode(samples)
+		o.setHeaders(w, base, len(result))
+		_, err := w.Write(result)
+		return err
+	}
+	o.setHeaders(w, base, o.size(len(samples)))
+	return o.writeTo(w, samples)
+}
+
+// size returns the size of the file encode returns for numSamples samples,
+// or -1 for FLAC, whose size depends on the samples.
+func (o output) size(numSamples int) int {
+	if o.length > 0 {
+		numSamples = o.length
+	}
+	switch o.container {
+	case "aiff":
+		return aiffSize(numSamples*o.format.BitsPerSample()/8, o.format)
+	case "flac":
+		return -1
+	}
+	return encodedWAVSize(numSamples, o.header, o.format)
+}
+
+// contentType returns the MIME type of the file o describes.
+func (o output) contentType() string {
+	switch o.container {
+	case "aiff":
+		return "audio/aiff"
+	case "flac":
+		return "audio/flac"
+	}
+	return "audio/wav"
+}
+
+// filename returns the attachment name for the file, given its base name.
+func (o output) filename(base string) string {
+	switch o.container {
+	case "aiff":
+		return base + ".aif"
+	case "flac":
+		return base + ".flac"
+	}
+	return base + ".wav"
+}
+
+// setHeaders sets the headers for a buffered attachment of size bytes
+// holding the file o describes, named after base. A negative size leaves
+// out Content-Length.
+func (o output) setHeaders(w http.ResponseWriter, base string, size int) {
+	setWAVHeaders(w, o.filename(base), size)
+	w.Header().Set("Content-Type", o.contentType())
+	if size < 0 {
+		w.Header().Del("Content-Length")
+	}
+}
+
+// handleTrim handles POST /trim.
+// Expects the same multipart upload as /denoise and returns the audio with
+// leading/trailing silence removed. Optional form fields:
+//
+//	pad_ms       silence kept next to the voice (default 50)
+//	threshold_db VAD threshold relative to the loudest frame (default -40)
+//	internal     "1" to also shorten internal silences
+//	max_gap_ms   longest internal silence kept (default 500)
+//
+// The amount removed is reported in X-Trim-* headers, in milliseconds.
+func handleTrim(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse multipart form.
-	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-		log.Printf("denoise: failed to parse form: %v", err)
-		http.Error(w, "failed to parse upload", http.StatusBadRequest)
+	samples, sampleRate, ok := readUploadedWAV(w, r, "trim")
+	if !ok {
 		return
 	}
 
-	file, _, err := r.FormFile("file")
+	cfg, err := trimConfigFromForm(r)
 	if err != nil {
-		log.Printf("denoise: no file in request: %v", err)
-		http.Error(w, "no file uploaded", http.StatusBadRequest)
+		slog.Error("trim: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trimmed := TrimSilence(samples, sampleRate, cfg)
+	result := WriteWAV(trimmed.Samples, sampleRate)
+
+	slog.Debug("trim: returning trimmed audio",
+		"removed_samples", trimmed.Removed(), "bytes", len(result))
+
+	ms := func(n int) string {
+		return strconv.FormatFloat(float64(n)*1000/float64(sampleRate), 'f', 1, 64)
+	}
+	w.Header().Set("X-Trim-Leading-Ms", ms(trimmed.LeadingRemoved))
+	w.Header().Set("X-Trim-Trailing-Ms", ms(trimmed.TrailingRemoved))
+	w.Header().Set("X-Trim-Internal-Ms", ms(trimmed.InternalRemoved))
+	w.Header().Set("X-Trim-Removed-Ms", ms(trimmed.Removed()))
+	setWAVHeaders(w, "trimmed.wav", len(result))
+	w.Write(result)
+}
+
+// compareAlignSeconds is how much audio from the start of the clip handleCompare
+// cross-correlates to find the delay between original and cleaned.
+const compareAlignSeconds = 10
+
+// handleCompare handles POST /compare.
+// Expects the same multipart upload and denoising fields as /denoise and
+// returns a 16-bit stereo WAV for A/B auditioning: the original on the left
+// channel and the cleaned audio on the right, time-aligned to the sample
+// by cross-correlation (see CrossCorrelate). The delay removed from the
+// cleaned channel is reported in X-Compare-Lag-Samples.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	samples, sampleRate, ok := readUploadedWAV(w, r, "compare")
+	if !ok {
+		return
+	}
+
+	if !checkDuration(w, "compare", len(samples), sampleRate) {
+		return
+	}
+
+	cfg, err := denoiseConfigFromForm(r, sampleRate)
+	if err != nil {
+		slog.Error("compare: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cleaned, err := DenoiseContext(r.Context(), samples, sampleRate, cfg)
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("compare: client disconnected, processing stopped", "err", err)
+		return
+	}
+	if err != nil {
+		slog.Error("compare: processing failed", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Line the cleaned audio up with the original; a delay of up to a
+	// frame is searched for.
+	head := min(len(samples), compareAlignSeconds*sampleRate)
+	lag := CrossCorrelate(samples[:head], cleaned[:min(len(cleaned), head)], cfg.FrameSize)
+	cleaned = alignTo(cleaned, lag, len(samples))
+
+	result, err := EncodeWAV(Interleave([][]float64{samples, cleaned}), WAVHeader{SampleRate: sampleRate, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		slog.Error("compare: encoding failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Debug("compare: returning A/B audio", "lag", lag, "bytes", len(result))
+
+	w.Header().Set("X-Compare-Lag-Samples", strconv.Itoa(lag))
+	setWAVHeaders(w, "compare.wav", len(result))
+	w.Write(result)
+}
+
+// handleDenoiseStereo handles POST /denoise/stereo.
+// Expects a stereo or multichannel upload (WAV, FLAC or AIFF, or raw PCM as
+// for /denoise) and the same denoising fields as /denoise, plus
+// "stereo_mode" ("lr", the default, or "ms" for mid/side, stereo only) and
+// "shared" ("1" to share one noise profile between the channels). Returns
+// the cleaned audio as a WAV with the upload's channels, and its channel
+// mask if it declares one, in the format "out_bits" gives (16-bit by
+// default) with the optional "dither".
+func handleDenoiseStereo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interleaved, header, ok := readUploadedAudio(w, r, "stereo")
+	if !ok {
+		return
+	}
+	sampleRate := header.SampleRate
+	channels := Deinterleave(interleaved, header.NumChannels)
+	if len(channels) < 2 {
+		slog.Error("stereo: not a stereo file", "channels", len(channels))
+		http.Error(w, fmt.Sprintf("expected stereo or multichannel audio, got %d channels", len(channels)), http.StatusBadRequest)
+		return
+	}
+
+	if !checkDuration(w, "stereo", len(channels[0]), sampleRate) {
+		return
+	}
+
+	cfg := DefaultStereoConfig()
+	var err error
+	cfg.DenoiseConfig, err = denoiseConfigFromForm(r, sampleRate)
+	if err == nil && r.FormValue("stereo_mode") != "" {
+		cfg.Mode, err = ParseStereoMode(r.FormValue("stereo_mode"))
+	}
+	cfg.SharedNoiseProfile = r.FormValue("shared") == "1"
+	if err == nil {
+		err = cfg.Validate()
+	}
+	format, dither := PCM16, NoDither
+	if err == nil {
+		format, err = sampleFormatFromForm(r)
+	}
+	if err == nil {
+		dither, err = ditherFromForm(r)
+	}
+	if err != nil {
+		slog.Error("stereo: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Run noise cancellation, giving up if the client goes away.
+	cleaned, err := DenoiseChannelsContext(r.Context(), channels, sampleRate, cfg)
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("stereo: client disconnected, processing stopped", "err", err)
+		return
+	}
+	if err != nil {
+		slog.Error("stereo: processing failed", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	out := output{
+		header: WAVHeader{SampleRate: sampleRate, NumChannels: len(cleaned), BitsPerSample: format.BitsPerSample(), Float: format.isFloat(), ChannelMask: header.ChannelMask},
+		format: format,
+		dither: dither,
+		seed:   cfg.Seed,
+	}
+	slog.Debug("stereo: returning cleaned audio", "mode", cfg.Mode)
+	if err := out.send(w, "cleaned", Interleave(cleaned)); err != nil {
+		slog.Info("stereo: failed to send response", "err", err)
+	}
+}
+
+// handleDenoiseReference handles POST /denoise/reference, for recordings
+// made with a second microphone pointed at the noise source. The noise
+// reference is either a "reference" file uploaded alongside "file", at the
+// same sample rate, or else the second channel of a stereo "file", whose
+// first channel is then the primary. An adaptive filter subtracts the
+// noise the primary shares with the reference (see CancelReference); the
+// optional "taps" and "step_size" fields tune it. With "denoise=1" the
+// result is then denoised as by /denoise, with the same fields, to remove
+// the noise the reference did not pick up. Returns a 16-bit mono WAV.
+func handleDenoiseReference(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, ok := readUpload(w, r, "reference")
+	if !ok {
+		return
+	}
+	interleaved, header, err := decodeWAVData(data)
+	if err != nil {
+		slog.Error("reference: invalid WAV", "err", err)
+		http.Error(w, "invalid WAV file: "+err.Error(), wavErrorStatus(err))
+		return
+	}
+	sampleRate := header.SampleRate
+
+	var primary, reference []float64
+	refFile, _, refErr := r.FormFile("reference")
+	switch {
+	case refErr == nil:
+		defer refFile.Close()
+		refData, err := io.ReadAll(refFile)
+		if err != nil {
+			slog.Error("reference: failed to read reference", "err", err)
+			http.Error(w, "failed to read reference", http.StatusInternalServerError)
+			return
+		}
+		refSamples, refHeader, err := decodeWAVData(refData)
+		if err != nil {
+			slog.Error("reference: invalid reference WAV", "err", err)
+			http.Error(w, "invalid reference WAV file: "+err.Error(), wavErrorStatus(err))
+			return
+		}
+		if refHeader.SampleRate != sampleRate {
+			slog.Error("reference: sample rates differ", "file", sampleRate, "reference", refHeader.SampleRate)
+			http.Error(w, fmt.Sprintf("reference is at %d Hz, file at %d Hz", refHeader.SampleRate, sampleRate), http.StatusBadRequest)
+			return
+		}
+		// Line the reference up with the primary: trim or pad with
+		// silence at the end.
+		primary = toMono(interleaved, header)
+		reference = make([]float64, len(primary))
+		copy(reference, toMono(refSamples, refHeader))
+	case errors.Is(refErr, http.ErrMissingFile):
+		if header.NumChannels != 2 {
+			slog.Error("reference: no reference", "channels", header.NumChannels)
+			http.Error(w, fmt.Sprintf("expected a stereo WAV or a reference file, got %d channels", header.NumChannels), http.StatusBadRequest)
+			return
+		}
+		channels := Deinterleave(interleaved, 2)
+		primary, reference = channels[0], channels[1]
+	default:
+		slog.Error("reference: bad reference field", "err", refErr)
+		http.Error(w, "failed to read reference", http.StatusBadRequest)
+		return
+	}
+	if !checkDuration(w, "reference", len(primary), sampleRate) {
 		return
 	}
+
+	rcfg := DefaultReferenceConfig()
+	if s := r.FormValue("taps"); s != "" {
+		if rcfg.Taps, err = strconv.Atoi(s); err != nil {
+			err = fmt.Errorf("invalid taps %q", s)
+		}
+	}
+	if err == nil {
+		rcfg.StepSize, err = formFloat(r, "step_size", rcfg.StepSize)
+	}
+	if err == nil {
+		err = rcfg.Validate()
+	}
+	if err != nil {
+		slog.Error("reference: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cleaned, err := CancelReference(primary, reference, rcfg)
+	if err == nil && r.FormValue("denoise") == "1" {
+		var cfg DenoiseConfig
+		if cfg, err = denoiseConfigFromForm(r, sampleRate); err == nil {
+			cleaned, err = DenoiseContext(r.Context(), cleaned, sampleRate, cfg)
+		}
+	}
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("reference: client disconnected, processing stopped", "err", err)
+		return
+	}
+	if err != nil {
+		slog.Error("reference: processing failed", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := EncodeWAV(cleaned, WAVHeader{SampleRate: sampleRate, NumChannels: 1, BitsPerSample: 16})
+	if err != nil {
+		slog.Error("reference: encoding failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	slog.Debug("reference: returning cleaned audio", "taps", rcfg.Taps, "bytes", len(result))
+	setWAVHeaders(w, "cleaned.wav", len(result))
+	w.Write(result)
+}
+
+// amountRange is the range of the /denoise "amount" field.
+var amountRange = paramRange{min: 0, max: 100}
+
+// denoiseConfigFromForm builds a DenoiseConfig from the optional /denoise
+// form fields, starting from DefaultDenoiseConfig, and scales the result to
+// sampleRate (see ForSampleRate).
+func denoiseConfigFromForm(r *http.Request, sampleRate int) (DenoiseConfig, error) {
+	cfg := DefaultDenoiseConfig()
+	if r.FormValue("amount") != "" {
+		amount, err := formFloat(r, "amount", 0)
+		if err != nil {
+			return cfg, err
+		}
+		if !amountRange.contains(amount) {
+			return cfg, fmt.Errorf("amount %g out of range %s", amount, amountRange)
+		}
+		cfg = cfg.WithAmount(amount)
+	}
+	if r.FormValue("low_latency") == "1" {
+		cfg = cfg.WithLowLatency()
+	}
+	// Scale last, so the smoothing WithAmount sets is scaled too.
+	cfg = cfg.ForSampleRate(sampleRate)
+	if r.FormValue("true_peak") == "1" {
+		cfg.TruePeak = true
+	}
+	if mode := r.FormValue("normalize"); mode != "" {
+		switch mode {
+		case NormalizePeak, NormalizeRMS, NormalizeRolling, NormalizeNone:
+			cfg.NormalizeMode = mode
+		default:
+			return cfg, fmt.Errorf("normalize must be %s, %s, %s or %s", NormalizePeak, NormalizeRMS, NormalizeRolling, NormalizeNone)
+		}
+	}
+	return cfg, nil
+}
+
+// channelSelectFromForm reads the optional "channel" field, defaulting to
+// MixChannels.
+func channelSelectFromForm(r *http.Request) (ChannelSelect, error) {
+	s := r.FormValue("channel")
+	if s == "" {
+		return MixChannels, nil
+	}
+	return ParseChannelSelect(s)
+}
+
+// sampleFormatFromForm reads the optional "out_bits" field, defaulting to
+// PCM16.
+func sampleFormatFromForm(r *http.Request) (SampleFormat, error) {
+	s := r.FormValue("out_bits")
+	if s == "" {
+		return PCM16, nil
+	}
+	return ParseSampleFormat(s)
+}
+
+// ditherFromForm reads the optional "dither" field, defaulting to NoDither.
+func ditherFromForm(r *http.Request) (Dither, error) {
+	s := r.FormValue("dither")
+	if s == "" {
+		return NoDither, nil
+	}
+	return ParseDither(s)
+}
+
+// progressParts is how many progress parts denoiseMultipart sends.
+const progressParts = 10
+
+// denoiseMultipart denoises samples and streams a multipart/mixed response:
+// JSON progress parts ({"done", "total"} frames) as processing advances,
+// then an audio/wav part holding the result, so a single response carries
+// both. An error after the response has started ends the stream with a
+// JSON {"error"} part.
+func denoiseMultipart(w http.ResponseWriter, r *http.Request, samples []float64, sampleRate int, cfg DenoiseConfig, out output, started time.Time) {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	sent := 0
+	progress := func(done, total int) {
+		if step := done * progressParts / total; step > sent {
+			sent = step
+			writeJSONPart(mw, map[string]int{"done": done, "total": total})
+			flush()
+		}
+	}
+
+	cleaned, err := DenoiseWithProgress(r.Context(), samples, sampleRate, cfg, progress)
+	if err != nil && r.Context().Err() != nil {
+		slog.Info("denoise: client disconnected, processing stopped", "err", err, "elapsed", time.Since(started))
+		return
+	}
+	if err != nil {
+		slog.Error("denoise: processing failed", "err", err)
+		writeJSONPart(mw, map[string]string{"error": err.Error()})
+		mw.Close()
+		return
+	}
+
+	elapsed := time.Since(started)
+	slog.Debug("denoise: returning cleaned audio", "elapsed", elapsed)
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {out.contentType()},
+		"Content-Disposition": {`attachment; filename="` + out.filename("cleaned") + `"`},
+		"X-Processing-Ms":     {strconv.FormatInt(elapsed.Milliseconds(), 10)},
+	})
+	if err == nil {
+		err = out.writeTo(part, cleaned)
+	}
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		slog.Info("denoise: failed to send response", "err", err)
+	}
+}
+
+// writeJSONPart writes v as an application/json part of mw.
+func writeJSONPart(mw *multipart.Writer, v any) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(part).Encode(v)
+}
+
+// checkInput analyzes the uploaded samples, reports the analysis in
+// X-Effective-Bandwidth-Hz and X-DC-Fraction headers and applies the
+// optional input_check form field: "adapt" adjusts cfg to the input (see
+// DenoiseConfig.AdaptToInput) and "reject" refuses DC-heavy or band-limited
+// input. It writes an error response and returns false on failure.
+func checkInput(w http.ResponseWriter, r *http.Request, samples []float64, sampleRate int, cfg DenoiseConfig) (DenoiseConfig, bool) {
+	a := AnalyzeInput(samples, sampleRate)
+	slog.Debug("denoise: analyzed input", "bandwidth_hz", a.EffectiveBandwidthHz, "dc_fraction", a.DCFraction)
+	w.Header().Set("X-Effective-Bandwidth-Hz", strconv.FormatFloat(a.EffectiveBandwidthHz, 'f', 0, 64))
+	w.Header().Set("X-DC-Fraction", strconv.FormatFloat(a.DCFraction, 'f', 4, 64))
+
+	switch check := r.FormValue("input_check"); check {
+	case "":
+	case "adapt":
+		cfg = cfg.AdaptToInput(a)
+	case "reject":
+		var msg string
+		switch {
+		case a.DCHeavy():
+			msg = fmt.Sprintf("input is DC-heavy (%.0f%% of power)", 100*a.DCFraction)
+		case a.Bandlimited():
+			msg = fmt.Sprintf("input is band-limited to %.0f Hz", a.EffectiveBandwidthHz)
+		}
+		if msg != "" {
+			slog.Error("denoise: input rejected", "reason", msg)
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return cfg, false
+		}
+	default:
+		slog.Error("denoise: bad parameter", "input_check", check)
+		http.Error(w, "input_check must be adapt or reject", http.StatusBadRequest)
+		return cfg, false
+	}
+	return cfg, true
+}
+
+// trimConfigFromForm builds a TrimConfig from the optional /trim form fields.
+func trimConfigFromForm(r *http.Request) (TrimConfig, error) {
+	cfg := DefaultTrimConfig()
+	var err error
+	if cfg.PadMs, err = formFloat(r, "pad_ms", cfg.PadMs); err != nil {
+		return cfg, err
+	}
+	if cfg.VAD.ThresholdDB, err = formFloat(r, "threshold_db", cfg.VAD.ThresholdDB); err != nil {
+		return cfg, err
+	}
+	if cfg.VAD.ThresholdDB >= 0 {
+		return cfg, fmt.Errorf("threshold_db must be negative")
+	}
+	if cfg.MaxGapMs, err = formFloat(r, "max_gap_ms", cfg.MaxGapMs); err != nil {
+		return cfg, err
+	}
+	cfg.Internal = r.FormValue("internal") == "1"
+	return cfg, nil
+}
+
+// handleCapabilities handles GET /capabilities, returning JSON describing
+// the accepted input and output formats and the valid range and default of
+// each denoise parameter (see capabilities).
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, capabilities())
+}
+
+// handleValidate handles POST /validate.
+// Expects the same multipart upload as /denoise and reports, as JSON,
+// whether the file is a supported WAV along with its header. Samples are
+// not decoded into memory; stereo files are read through once to set
+// effectively_mono (see ValidateWAV).
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, ok := readUpload(w, r, "validate")
+	if !ok {
+		return
+	}
+
+	header, err := ValidateWAV(data)
+	if err != nil {
+		slog.Debug("validate: unsupported file", "err", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+			"valid": false,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"valid":  true,
+		"header": header,
+	})
+}
+
+// defaultPeakBuckets and maxPeakBuckets bound the /peaks "buckets" field.
+const (
+	defaultPeakBuckets = 1000
+	maxPeakBuckets     = 100000
+)
+
+// handlePeaks handles POST /peaks.
+// Expects the same multipart upload as /denoise and returns, as JSON, the
+// [min, max] sample values of each of "buckets" (default 1000) equal slices
+// of the audio, for rendering a waveform without downloading it.
+func handlePeaks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	samples, sampleRate, ok := readUploadedWAV(w, r, "peaks")
+	if !ok {
+		return
+	}
+
+	buckets := defaultPeakBuckets
+	if s := r.FormValue("buckets"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < 1 || v > maxPeakBuckets {
+			slog.Error("peaks: bad parameter", "buckets", s)
+			http.Error(w, fmt.Sprintf("buckets must be an integer in 1..%d", maxPeakBuckets), http.StatusBadRequest)
+			return
+		}
+		buckets = v
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sample_rate": sampleRate,
+		"samples":     len(samples),
+		"peaks":       ComputePeaks(samples, buckets),
+	})
+}
+
+// Bounds and sample rate of the /testfile clip.
+const (
+	defaultTestFileSeconds = 5
+	maxTestFileSeconds     = 60
+	testFileSampleRate     = 16000
+)
+
+// handleTestFile handles GET /testfile.
+// Returns a 16 kHz mono WAV of "seconds" (default 5) seconds of a tone in
+// noise, after a noise-only lead-in (see SyntheticNoisyTone), for trying
+// out the pipeline without a recording of one's own.
+func handleTestFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	seconds, err := formFloat(r, "seconds", defaultTestFileSeconds)
+	if err == nil && (!(seconds > 0) || seconds > maxTestFileSeconds) {
+		err = fmt.Errorf("seconds must be in (0, %d]", maxTestFileSeconds)
+	}
+	if err != nil {
+		slog.Error("testfile: bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n := int(math.Round(seconds * testFileSampleRate))
+	result := WriteWAV(SyntheticNoisyTone(n, testFileSampleRate), testFileSampleRate)
+	setWAVHeaders(w, "testfile.wav", len(result))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(result)
+}
+
+// readUpload parses the multipart upload in r and returns the contents of
+// its "file" field. On failure it writes the error response, logs it under
+// op and returns ok == false.
+func readUpload(w http.ResponseWriter, r *http.Request, op string) (data []byte, ok bool) {
+	file, _, ok := openUpload(w, r, op)
+	if !ok {
+		return nil, false
+	}
 	defer file.Close()
 
 	// Read the entire file into memory.
 	data, err := io.ReadAll(file)
 	if err != nil {
-		log.Printf("denoise: failed to read file: %v", err)
+		slog.Error(op+": failed to read file", "err", err)
 		http.Error(w, "failed to read file", http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 
-	// Decode WAV.
-	samples, sampleRate, err := ReadWAV(data)
+	return data, true
+}
+
+// decodeWAVData decodes a WAV file held in memory, keeping its channels
+// interleaved.
+func decodeWAVData(data []byte) ([]float64, *WAVHeader, error) {
+	wr, err := NewWAVReader(bytes.NewReader(data))
 	if err != nil {
-		log.Printf("denoise: invalid WAV: %v", err)
-		http.Error(w, "invalid WAV file: "+err.Error(), http.StatusBadRequest)
-		return
+		return nil, nil, err
 	}
+	samples, err := wr.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	return samples, wr.Header(), nil
+}
 
-	log.Printf("denoise: received %d samples at %d Hz (%.2f seconds)",
-		len(samples), sampleRate, float64(len(samples))/float64(sampleRate))
+// minWAVSize is the size of the smallest valid WAV file: the RIFF header
+// and a PCM fmt chunk, followed by an empty data chunk.
+const minWAVSize = 44
 
-	// Run noise cancellation.
-	cleaned := Denoise(samples, sampleRate)
+// openUpload is readUpload returning the "file" field unread, along with
+// its size. The caller closes the file.
+func openUpload(w http.ResponseWriter, r *http.Request, op string) (file multipart.File, size int64, ok bool) {
+	// Parse multipart form.
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		slog.Error(op+": failed to parse form", "err", err)
+		http.Error(w, "failed to parse upload", http.StatusBadRequest)
+		return nil, 0, false
+	}
 
-	// Encode result as WAV.
-	result := WriteWAV(cleaned, sampleRate)
+	file, fh, err := r.FormFile("file")
+	if err != nil {
+		slog.Error(op+": no file in request", "err", err)
+		http.Error(w, "no file uploaded", http.StatusBadRequest)
+		return nil, 0, false
+	}
+	return file, fh.Size, true
+}
 
-	log.Printf("denoise: returning %d bytes of cleaned audio", len(result))
+// readUploadedWAV reads and decodes the upload with readUploadedWAVHeader.
+// Uploads too small to hold a WAV header are rejected with a plain
+// message rather than a decoding error.
+func readUploadedWAV(w http.ResponseWriter, r *http.Request, op string) (samples []float64, sampleRate int, ok bool) {
+	samples, header, ok := readUploadedWAVHeader(w, r, op)
+	if !ok {
+		return nil, 0, false
+	}
+	return samples, header.SampleRate, true
+}
 
-	// Send response.
+// readUploadedWAVHeader is readUploadedWAV returning the file's header,
+// including its metadata chunks, instead of just the sample rate. The
+// upload is decoded by readUploadedAudio, and multichannel audio is mixed
+// down to mono unless the optional "channel" field selects "left" or
+// "right" alone.
+func readUploadedWAVHeader(w http.ResponseWriter, r *http.Request, op string) (samples []float64, header *WAVHeader, ok bool) {
+	samples, header, ok = readUploadedAudio(w, r, op)
+	if !ok {
+		return nil, nil, false
+	}
+	sel, err := channelSelectFromForm(r)
+	if err != nil {
+		slog.Error(op+": bad parameter", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	samples, sampleRate := selectChannel(samples, header, sel), header.SampleRate
+
+	slog.Debug(op+": received audio",
+		"samples", len(samples), "sample_rate", sampleRate,
+		"seconds", float64(len(samples))/float64(sampleRate))
+
+	return samples, header, true
+}
+
+// maxFormFieldSize caps each form field other than the uploaded file.
+const maxFormFieldSize = 1 << 20
+
+// readUploadedAudio reads the "file" field of a multipart upload and
+// decodes it as it arrives, keeping its channels interleaved, rather than
+// buffering the whole form first as readUpload does. The other fields,
+// before or after the file, are stored in r.Form and r.PostForm, so
+// FormValue still finds them; other files are skipped. WAV uploads are
+// decoded with a WAVReader; FLAC and AIFF uploads are accepted too, and
+// decoded with DecodeFLAC and DecodeAIFF. With "raw=1" in the query the
+// body is headerless PCM (see readRawUpload).
+func readUploadedAudio(w http.ResponseWriter, r *http.Request, op string) (samples []float64, header *WAVHeader, ok bool) {
+	if r.URL.Query().Get("raw") == "1" {
+		return readRawUpload(w, r, op)
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		slog.Error(op+": failed to parse form", "err", err)
+		http.Error(w, "failed to parse upload", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	form := make(url.Values)
+	found := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			slog.Error(op+": failed to parse form", "err", err)
+			http.Error(w, "failed to parse upload", http.StatusBadRequest)
+			return nil, nil, false
+		}
+		switch name := part.FormName(); {
+		case name == "file" && !found:
+			found = true
+			if samples, header, ok = decodeUpload(w, part, op); !ok {
+				return nil, nil, false
+			}
+		case part.FileName() != "":
+		default:
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err == nil && len(value) > maxFormFieldSize {
+				err = fmt.Errorf("field %q is over %d bytes", name, maxFormFieldSize)
+			}
+			if err != nil {
+				slog.Error(op+": failed to parse form", "err", err)
+				http.Error(w, "failed to parse upload", http.StatusBadRequest)
+				return nil, nil, false
+			}
+			form.Add(name, string(value))
+		}
+		part.Close()
+	}
+	if !found {
+		slog.Error(op + ": no file in request")
+		http.Error(w, "no file uploaded", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// Fields in the body take precedence over the query, as with
+	// ParseMultipartForm.
+	r.PostForm = form
+	r.Form = make(url.Values)
+	for _, values := range []url.Values{form, r.URL.Query()} {
+		for k, v := range values {
+			r.Form[k] = append(r.Form[k], v...)
+		}
+	}
+	r.MultipartForm = &multipart.Form{Value: form}
+	return samples, header, true
+}
+
+// decodeUpload decodes an uploaded file from file, keeping its channels
+// interleaved, writing an error response and returning false if it is
+// empty, not a supported format or invalid.
+func decodeUpload(w http.ResponseWriter, file io.Reader, op string) (samples []float64, header *WAVHeader, ok bool) {
+	var err error
+
+	in := bufio.NewReader(file)
+	magic, _ := in.Peek(oggHeaderSize + 255 + len("OpusHead"))
+
+	// Catch an empty selection before the decoder reports it as a bad
+	// header.
+	if len(magic) == 0 {
+		slog.Error(op + ": empty upload")
+		http.Error(w, "uploaded file is empty", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	if len(magic) < minWAVSize {
+		slog.Error(op+": upload too small", "bytes", len(magic))
+		http.Error(w, fmt.Sprintf("uploaded file is too small to be a WAV file (%d bytes)", len(magic)), http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	// Decode FLAC or AIFF, recognized by their markers, or else WAV. Ogg is
+	// recognized only to say it is not decoded.
+	if isOgg(magic) {
+		kind := "Ogg"
+		if codec := oggCodec(magic); codec != "" {
+			kind += "/" + codec
+		}
+		slog.Error(op+": unsupported upload", "format", kind)
+		http.Error(w, kind+" input is not supported; convert it to WAV or FLAC", http.StatusUnsupportedMediaType)
+		return nil, nil, false
+	}
+	if isFLAC(magic) {
+		// Bound the decoding by the STREAMINFO block, which must come
+		// first: FLAC compresses silence thousands of times over.
+		limit := 0
+		if len(magic) >= 8+flacStreamInfoSize {
+			info, _ := parseFLACStreamInfo(magic[8:])
+			limit = uploadSampleLimit(info.SampleRate, info.NumChannels)
+		}
+		if samples, header, err = DecodeFLAC(in, limit); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrFLACTooLong) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			slog.Error(op+": invalid FLAC", "err", err)
+			http.Error(w, "invalid FLAC file: "+err.Error(), status)
+			return nil, nil, false
+		}
+	} else if isAIFF(magic) {
+		if samples, header, err = DecodeAIFF(in); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrAIFFUnsupported) {
+				status = http.StatusUnsupportedMediaType
+			}
+			slog.Error(op+": invalid AIFF", "err", err)
+			http.Error(w, "invalid AIFF file: "+err.Error(), status)
+			return nil, nil, false
+		}
+	} else {
+		var wr *WAVReader
+		wr, err = NewWAVReader(in)
+		if err == nil {
+			samples, err = wr.ReadAll()
+		}
+		if err != nil {
+			slog.Error(op+": invalid WAV", "err", err)
+			http.Error(w, "invalid WAV file: "+err.Error(), wavErrorStatus(err))
+			return nil, nil, false
+		}
+		header = wr.Header()
+	}
+	return samples, header, true
+}
+
+// readRawUpload reads a request whose body is headerless PCM, for
+// pipelines that have no container to send, keeping its channels
+// interleaved. The format comes from query
+// parameters: sample_rate (required), channels (default 1), bits (an
+// out_bits value; default 16) and endian (little or big; default little).
+// The other fields of the endpoint are query parameters too.
+func readRawUpload(w http.ResponseWriter, r *http.Request, op string) (samples []float64, header *WAVHeader, ok bool) {
+	f, err := rawFormatFromQuery(r.URL.Query())
+	if err != nil {
+		slog.Error(op+": bad raw format", "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if err != nil {
+		slog.Error(op+": failed to read body", "err", err)
+		http.Error(w, "failed to read upload", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	samples, header, err = DecodeRaw(data, f)
+	if err == nil && len(samples) == 0 {
+		err = errors.New("raw: no complete sample frames")
+	}
+	if err != nil {
+		slog.Error(op+": invalid raw PCM", "err", err)
+		http.Error(w, "invalid raw PCM: "+err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	slog.Debug(op+": received raw audio", "bytes", len(data), "channels", header.NumChannels)
+	return samples, header, true
+}
+
+// rawFormatFromQuery parses the format of a raw upload from q.
+func rawFormatFromQuery(q url.Values) (RawFormat, error) {
+	f := RawFormat{NumChannels: 1, Format: PCM16}
+	var err error
+	if f.SampleRate, err = strconv.Atoi(q.Get("sample_rate")); err != nil || f.SampleRate <= 0 {
+		return f, fmt.Errorf("raw input needs a positive sample_rate, not %q", q.Get("sample_rate"))
+	}
+	if s := q.Get("channels"); s != "" {
+		if f.NumChannels, err = strconv.Atoi(s); err != nil || f.NumChannels < 1 {
+			return f, fmt.Errorf("invalid channels %q", s)
+		}
+	}
+	if s := q.Get("bits"); s != "" {
+		if f.Format, err = ParseSampleFormat(s); err != nil {
+			return f, err
+		}
+	}
+	switch e := q.Get("endian"); e {
+	case "", "little":
+	case "big":
+		f.BigEndian = true
+	default:
+		return f, fmt.Errorf("endian must be little or big, not %q", e)
+	}
+	return f, nil
+}
+
+// wavErrorStatus returns the HTTP status for a ReadWAV error: 415 for a
+// well-formed WAV in an encoding we do not decode, 400 otherwise.
+func wavErrorStatus(err error) int {
+	var werr *WAVError
+	if errors.As(err, &werr) && (werr.Code == ErrUnsupportedFormat || werr.Code == ErrUnsupportedBits) {
+		return http.StatusUnsupportedMediaType
+	}
+	return http.StatusBadRequest
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to encode JSON response", "err", err)
+	}
+}
+
+// formFloat returns the named form value parsed as a float, or def if the
+// field is absent.
+func formFloat(r *http.Request, name string, def float64) (float64, error) {
+	s := r.FormValue(name)
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return v, nil
+}
+
+// setWAVHeaders sets the headers for a buffered WAV attachment of size bytes.
+func setWAVHeaders(w http.ResponseWriter, filename string, size int) {
 	w.Header().Set("Content-Type", "audio/wav")
-	w.Header().Set("Content-Disposition", "attachment; filename=\"cleaned.wav\"")
-	w.Write(result)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
 }
@@ -0,0 +1,1119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newUploadRequest builds a multipart request carrying data in the "file"
+// field plus any extra form fields.
+func newUploadRequest(t *testing.T, method, target string, data []byte, fields map[string]string) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatalf("WriteField: %v", err)
+		}
+	}
+	part, err := mw.CreateFormFile("file", "input.wav")
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write(data)
+	if err := mw.Close(); err != nil {
+		t.Fatalf("multipart close: %v", err)
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// toneWAV returns a WAV file holding seconds of a 440 Hz tone.
+func toneWAV(sampleRate int, seconds float64) []byte {
+	n := int(seconds * float64(sampleRate))
+	samples := make([]float64, n)
+	for i := range samples {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	return WriteWAV(samples, sampleRate)
+}
+
+func TestHandleDenoiseContentLength(t *testing.T) {
+	req := newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 1), nil)
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	got, err := strconv.Atoi(rec.Header().Get("Content-Length"))
+	if err != nil {
+		t.Fatalf("bad Content-Length %q: %v", rec.Header().Get("Content-Length"), err)
+	}
+	if got != rec.Body.Len() {
+		t.Fatalf("Content-Length %d does not match body size %d", got, rec.Body.Len())
+	}
+}
+
+func TestHandleDenoiseHead(t *testing.T) {
+	// A second of audio, and a clip shorter than one frame.
+	for _, seconds := range []float64{1, 0.01} {
+		data := toneWAV(16000, seconds)
+
+		post := httptest.NewRecorder()
+		handleDenoise(post, newUploadRequest(t, http.MethodPost, "/denoise", data, nil))
+
+		head := httptest.NewRecorder()
+		handleDenoise(head, newUploadRequest(t, http.MethodHead, "/denoise", data, nil))
+
+		if head.Code != http.StatusOK {
+			t.Fatalf("%g s: expected 200, got %d", seconds, head.Code)
+		}
+		if head.Body.Len() != 0 {
+			t.Fatalf("%g s: expected empty HEAD body, got %d bytes", seconds, head.Body.Len())
+		}
+		if head.Header().Get("Content-Length") != post.Header().Get("Content-Length") {
+			t.Fatalf("%g s: HEAD Content-Length %q differs from POST %q", seconds,
+				head.Header().Get("Content-Length"), post.Header().Get("Content-Length"))
+		}
+	}
+}
+
+func TestHandleDenoiseKeepMetadata(t *testing.T) {
+	plain := toneWAV(16000, 1)
+	header, err := ValidateWAV(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Extra = &ExtraChunks{Chunks: []WAVChunk{{"bext", []byte("origination 2024-05-01")}, {"iXML", []byte("<BWFXML/>")}}}
+	header.Cue = &CueList{Points: []CuePoint{{ID: 1, Position: 8000, SampleOffset: 8000}}}
+	samples, _, err := ReadWAV(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeWAV(samples, *header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range []map[string]string{
+		{"keep_metadata": "1"},
+		{"keep_metadata": "1", "out_bits": "32f"},
+		{"keep_metadata": "1", "passthrough": "1"},
+	} {
+		post := httptest.NewRecorder()
+		handleDenoise(post, newUploadRequest(t, http.MethodPost, "/denoise", data, fields))
+		if post.Code != http.StatusOK {
+			t.Fatalf("%v: expected 200, got %d: %s", fields, post.Code, post.Body.String())
+		}
+		got, err := ValidateWAV(post.Body.Bytes())
+		if err != nil {
+			t.Fatalf("%v: %v", fields, err)
+		}
+		if !reflect.DeepEqual(got.Extra, header.Extra) || !reflect.DeepEqual(got.Cue, header.Cue) {
+			t.Fatalf("%v: got metadata %+v %+v, want %+v %+v", fields, got.Extra, got.Cue, header.Extra, header.Cue)
+		}
+
+		head := httptest.NewRecorder()
+		handleDenoise(head, newUploadRequest(t, http.MethodHead, "/denoise", data, fields))
+		if want := strconv.Itoa(post.Body.Len()); head.Header().Get("Content-Length") != want {
+			t.Fatalf("%v: HEAD Content-Length %q, want %s", fields, head.Header().Get("Content-Length"), want)
+		}
+	}
+
+	// Without the option the output carries no metadata.
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", data, nil))
+	got, err := ValidateWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Extra != nil || got.Cue != nil {
+		t.Fatalf("expected no metadata, got %+v %+v", got.Extra, got.Cue)
+	}
+}
+
+func TestHandleDenoiseResidual(t *testing.T) {
+	const sampleRate = 16000
+	data := toneWAV(sampleRate, 1)
+	fields := map[string]string{"residual": "1"}
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", data, fields))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "residual.wav") {
+		t.Fatalf("Content-Disposition %q does not name residual.wav", cd)
+	}
+	residual, _, err := ReadWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(residual) != sampleRate {
+		t.Fatalf("got %d residual samples, want %d", len(residual), sampleRate)
+	}
+
+	head := httptest.NewRecorder()
+	handleDenoise(head, newUploadRequest(t, http.MethodHead, "/denoise", data, fields))
+	if head.Header().Get("Content-Disposition") != rec.Header().Get("Content-Disposition") {
+		t.Fatalf("HEAD Content-Disposition %q differs from POST %q",
+			head.Header().Get("Content-Disposition"), rec.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestHandleDenoiseProcessingTime(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 0.5), nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	ms, err := strconv.Atoi(rec.Header().Get("X-Processing-Ms"))
+	if err != nil || ms < 0 {
+		t.Fatalf("bad X-Processing-Ms %q", rec.Header().Get("X-Processing-Ms"))
+	}
+}
+
+func TestHandleDenoiseMaxDuration(t *testing.T) {
+	prev := maxAudioDuration
+	maxAudioDuration = time.Second
+	t.Cleanup(func() { maxAudioDuration = prev })
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 2), nil))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "maximum is 1.0 s") {
+		t.Fatalf("expected duration limit in message, got %q", rec.Body.String())
+	}
+	if rec.Header().Get("X-Processing-Ms") != "" {
+		t.Fatal("rejected request should not report processing time")
+	}
+
+	// The other denoising endpoints share the limit.
+	rec = httptest.NewRecorder()
+	handleCompare(rec, newUploadRequest(t, http.MethodPost, "/compare", toneWAV(16000, 2), nil))
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("compare: expected 413, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoisePassthrough(t *testing.T) {
+	const sampleRate = 16000
+	samples := SyntheticNoisyTone(sampleRate, sampleRate)
+	input := WriteWAVFormat(samples, sampleRate, PCM32)
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+		map[string]string{"passthrough": "1", "out_bits": "16"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	out := rec.Body.Bytes()
+	if bits := binary.LittleEndian.Uint16(out[34:36]); bits != 16 {
+		t.Fatalf("expected 16 bits per sample in header, got %d", bits)
+	}
+	got, _, err := ReadWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(samples) {
+		t.Fatalf("got %d samples, want %d", len(got), len(samples))
+	}
+	// The streamed file is the one WriteWAV builds, and as long as its
+	// Content-Length says.
+	if !bytes.Equal(out, WriteWAV(got, sampleRate)) {
+		t.Fatal("streamed WAV differs from WriteWAV")
+	}
+	if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(out)) {
+		t.Fatalf("Content-Length %s, body %d bytes", cl, len(out))
+	}
+	// Only quantization separates the output from the input; denoising
+	// would have removed the noise.
+	if in, out := rms(samples), rms(got); math.Abs(out-in) > 1e-3*in {
+		t.Fatalf("output rms %.5f, input %.5f", out, in)
+	}
+}
+
+func TestHandleDenoiseOutBits24(t *testing.T) {
+	input := toneWAV(16000, 1)
+
+	rec16 := httptest.NewRecorder()
+	handleDenoise(rec16, newUploadRequest(t, http.MethodPost, "/denoise", input, nil))
+	want, _, err := ReadWAV(rec16.Body.Bytes())
+	if err != nil {
+		t.Fatalf("ReadWAV 16-bit: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+		map[string]string{"out_bits": "24"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	out := rec.Body.Bytes()
+	if bits := binary.LittleEndian.Uint16(out[34:36]); bits != 24 {
+		t.Fatalf("expected 24 bits per sample in header, got %d", bits)
+	}
+	if size, _ := strconv.Atoi(rec.Header().Get("Content-Length")); size != len(out) {
+		t.Fatalf("Content-Length %d does not match body size %d", size, len(out))
+	}
+
+	// Decode the 24-bit data chunk by hand and compare to the 16-bit result.
+	data := out[44:]
+	if len(data) != len(want)*3 {
+		t.Fatalf("expected %d data bytes, got %d", len(want)*3, len(data))
+	}
+	for i := range want {
+		v := int32(data[i*3]) | int32(data[i*3+1])<<8 | int32(int8(data[i*3+2]))<<16
+		got := float64(v) / (1 << 23)
+		if math.Abs(got-want[i]) > 2.0/32768 {
+			t.Fatalf("sample %d: expected %.6f, got %.6f", i, want[i], got)
+		}
+	}
+}
+
+func TestHandleDenoiseBadOutBits(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 0.5),
+		map[string]string{"out_bits": "12"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoiseDither(t *testing.T) {
+	input := toneWAV(16000, 0.5)
+	convert := func(dither string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+			map[string]string{"passthrough": "1", "dither": dither}))
+		return rec
+	}
+	plain, dithered := convert("none"), convert("shaped")
+	if plain.Code != http.StatusOK || dithered.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d and %d", plain.Code, dithered.Code)
+	}
+	if plain.Body.Len() != dithered.Body.Len() || bytes.Equal(plain.Body.Bytes(), dithered.Body.Bytes()) {
+		t.Fatal("expected dithered output of the same size and different samples")
+	}
+	if rec := convert("rpdf"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown dither: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoiseChannel(t *testing.T) {
+	// Left carries 0.5, right -0.25: the mix is 0.125.
+	frames := 1600
+	stereo := make([]float64, 2*frames)
+	for i := 0; i < frames; i++ {
+		stereo[2*i], stereo[2*i+1] = 0.5, -0.25
+	}
+	input, err := EncodeWAV(stereo, WAVHeader{SampleRate: 16000, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for channel, want := range map[string]float64{"": 0.125, "mix": 0.125, "left": 0.5, "right": -0.25} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+			map[string]string{"passthrough": "1", "normalize": "none", "channel": channel}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("channel %q: expected 200, got %d: %s", channel, rec.Code, rec.Body.String())
+		}
+		got, _, err := ReadWAV(rec.Body.Bytes())
+		if err != nil {
+			t.Fatalf("channel %q: %v", channel, err)
+		}
+		if len(got) != frames || math.Abs(got[0]-want) > 1e-3 {
+			t.Fatalf("channel %q: expected %d samples of %g, got %d starting %g", channel, frames, want, len(got), got[0])
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, map[string]string{"channel": "center"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown channel: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoiseProcessRate(t *testing.T) {
+	input := toneWAV(16000, 1)
+	fields := map[string]string{"process_rate": "44100"}
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, fields))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	got, rate, err := ReadWAV(rec.Body.Bytes())
+	if err != nil || rate != 16000 || len(got) != 16000 {
+		t.Fatalf("expected 16000 samples at 16000 Hz, got %d at %d (err %v)", len(got), rate, err)
+	}
+
+	head := httptest.NewRecorder()
+	handleDenoise(head, newUploadRequest(t, http.MethodHead, "/denoise", input, fields))
+	if cl := head.Header().Get("Content-Length"); cl != strconv.Itoa(rec.Body.Len()) {
+		t.Fatalf("HEAD Content-Length %s, GET body %d bytes", cl, rec.Body.Len())
+	}
+
+	for _, rate := range []string{"0", "x", "1000000"} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, map[string]string{"process_rate": rate}))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("process_rate %s: expected 400, got %d", rate, rec.Code)
+		}
+	}
+
+	// Input within MaxSamples that would exceed it at the processing rate
+	// is rejected before it is resampled.
+	long := toneWAV(8000, float64(MaxSamples/48+8000)/8000)
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", long, map[string]string{"process_rate": "384000"}))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "exceeds the maximum") {
+		t.Fatalf("long input: expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHandleDenoiseSampleRate(t *testing.T) {
+	plain := toneWAV(48000, 1)
+	header, err := ValidateWAV(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bext := make([]byte, bextTimeReferenceOffset+8)
+	binary.LittleEndian.PutUint64(bext[bextTimeReferenceOffset:], 48000*3600) // 01:00:00
+	header.Extra = &ExtraChunks{Chunks: []WAVChunk{{"bext", bext}}}
+	header.Cue = &CueList{Points: []CuePoint{{ID: 1, Position: 24000, SampleOffset: 24000}}}
+	samples, _, err := ReadWAV(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := EncodeWAV(samples, *header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fields := range []map[string]string{
+		{"out_rate": "16000", "keep_metadata": "1"},
+		{"out_rate": "16000", "keep_metadata": "1", "process_rate": "44100"},
+	} {
+		post := httptest.NewRecorder()
+		handleDenoise(post, newUploadRequest(t, http.MethodPost, "/denoise", data, fields))
+		if post.Code != http.StatusOK {
+			t.Fatalf("%v: expected 200, got %d: %s", fields, post.Code, post.Body.String())
+		}
+		got, rate, err := ReadWAV(post.Body.Bytes())
+		if err != nil || rate != 16000 || len(got) != 16000 {
+			t.Fatalf("%v: expected 16000 samples at 16000 Hz, got %d at %d (err %v)", fields, len(got), rate, err)
+		}
+
+		// The markers move with the audio.
+		h, err := ValidateWAV(post.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := h.Cue.Points[0]; p.Position != 8000 || p.SampleOffset != 8000 {
+			t.Fatalf("%v: cue point at %d, want 8000", fields, p.Position)
+		}
+		if ref := binary.LittleEndian.Uint64(h.Extra.Chunks[0].Data[bextTimeReferenceOffset:]); ref != 16000*3600 {
+			t.Fatalf("%v: bext time reference %d, want %d", fields, ref, 16000*3600)
+		}
+
+		head := httptest.NewRecorder()
+		handleDenoise(head, newUploadRequest(t, http.MethodHead, "/denoise", data, fields))
+		if want := strconv.Itoa(post.Body.Len()); head.Header().Get("Content-Length") != want {
+			t.Fatalf("%v: HEAD Content-Length %q, want %s", fields, head.Header().Get("Content-Length"), want)
+		}
+	}
+
+	for _, rate := range []string{"0", "16k", "1000000"} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", data, map[string]string{"out_rate": rate}))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("out_rate %s: expected 400, got %d", rate, rec.Code)
+		}
+	}
+}
+
+func TestHandleDenoiseWAVErrorStatus(t *testing.T) {
+	adpcm := WriteWAV(make([]float64, 100), 16000)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+	pcm12 := WriteWAV(make([]float64, 100), 16000)
+	binary.LittleEndian.PutUint16(pcm12[34:36], 12)
+	for name, tc := range map[string]struct {
+		data []byte
+		want int
+	}{
+		"12-bit":  {pcm12, http.StatusUnsupportedMediaType},
+		"ADPCM":   {adpcm, http.StatusUnsupportedMediaType},
+		"not WAV": {[]byte(strings.Repeat("not a wav file ", 4)), http.StatusBadRequest},
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", tc.data, nil))
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestHandleDenoiseEmptyUpload(t *testing.T) {
+	for name, tc := range map[string]struct {
+		data []byte
+		want string
+	}{
+		"empty":     {nil, "uploaded file is empty"},
+		"too small": {[]byte("RIFF"), "uploaded file is too small to be a WAV file (4 bytes)"},
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", tc.data, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, rec.Code)
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tc.want {
+			t.Fatalf("%s: got message %q, want %q", name, got, tc.want)
+		}
+	}
+}
+
+func TestHandleDenoiseStreamedUpload(t *testing.T) {
+	// The form arrives through a pipe, with fields and another file after
+	// the audio; the fields still apply.
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, _ := mw.CreateFormFile("file", "input.wav")
+		part.Write(toneWAV(16000, 1))
+		notes, _ := mw.CreateFormFile("notes", "notes.txt")
+		notes.Write([]byte("take 2"))
+		mw.WriteField("echo_config", "1")
+		mw.WriteField("amount", "50")
+		pw.CloseWithError(mw.Close())
+	}()
+	req := httptest.NewRequest(http.MethodPost, "/denoise", pr)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var got DenoiseConfig
+	if err := json.Unmarshal([]byte(rec.Header().Get("X-Denoise-Config")), &got); err != nil {
+		t.Fatalf("fields after the file were not read: %q: %v", rec.Header().Get("X-Denoise-Config"), err)
+	}
+	if want := DefaultDenoiseConfig().WithAmount(50).OverSubtract; got.OverSubtract != want {
+		t.Fatalf("over_subtract %g, want %g from amount", got.OverSubtract, want)
+	}
+
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, httptest.NewRequest(http.MethodPost, "/denoise", strings.NewReader("not a form")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("not multipart: expected 400, got %d", rec.Code)
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleValidate(rec, newUploadRequest(t, http.MethodPost, "/validate", toneWAV(22050, 0.1), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var ok struct {
+		Valid  bool      `json:"valid"`
+		Header WAVHeader `json:"header"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &ok); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !ok.Valid || ok.Header.SampleRate != 22050 || ok.Header.BitsPerSample != 16 {
+		t.Fatalf("unexpected response %+v", ok)
+	}
+
+	rec = httptest.NewRecorder()
+	handleValidate(rec, newUploadRequest(t, http.MethodPost, "/validate", []byte("RIFF0000AVI "), nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "missing WAVE identifier") {
+		t.Fatalf("expected error detail, got %s", rec.Body.String())
+	}
+}
+
+func TestHandleDenoiseAmount(t *testing.T) {
+	for amount, want := range map[string]int{"0": http.StatusOK, "65": http.StatusOK, "101": http.StatusBadRequest, "lots": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 0.5),
+			map[string]string{"amount": amount}))
+		if rec.Code != want {
+			t.Fatalf("amount=%s: expected %d, got %d", amount, want, rec.Code)
+		}
+	}
+}
+
+func TestHandlePeaks(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handlePeaks(rec, newUploadRequest(t, http.MethodPost, "/peaks", toneWAV(8000, 1),
+		map[string]string{"buckets": "50"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Samples int          `json:"samples"`
+		Peaks   [][2]float64 `json:"peaks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.Samples != 8000 || len(resp.Peaks) != 50 {
+		t.Fatalf("expected 50 peaks over 8000 samples, got %d over %d", len(resp.Peaks), resp.Samples)
+	}
+	for i, p := range resp.Peaks {
+		if p[0] > -0.45 || p[1] < 0.45 {
+			t.Fatalf("bucket %d: expected tone envelope near ±0.5, got %v", i, p)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handlePeaks(rec, newUploadRequest(t, http.MethodPost, "/peaks", toneWAV(8000, 1),
+		map[string]string{"buckets": "0"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for zero buckets, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoiseNormalizeNone(t *testing.T) {
+	// A quiet tone, so its natural peak is far from the 0.95 target.
+	sampleRate := 16000
+	samples := make([]float64, sampleRate)
+	for i := range samples {
+		samples[i] = 0.2 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	input := WriteWAV(samples, sampleRate)
+
+	decoded, _, _ := ReadWAV(input)
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	natural, err := DenoiseWithConfig(decoded, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithConfig: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+		map[string]string{"normalize": "none"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	out, _, err := ReadWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+
+	peak := func(x []float64) float64 {
+		var p float64
+		for _, v := range x {
+			p = math.Max(p, math.Abs(v))
+		}
+		return p
+	}
+	t.Logf("output peak %.4f, natural peak %.4f", peak(out), peak(natural))
+	if math.Abs(peak(out)-peak(natural)) > 1e-3 {
+		t.Fatalf("expected natural peak %.4f, got %.4f", peak(natural), peak(out))
+	}
+	if math.Abs(peak(out)-PeakTarget) < 0.1 {
+		t.Fatalf("output was peak-normalized: %.4f", peak(out))
+	}
+
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+		map[string]string{"normalize": "loud"}))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown mode, got %d", rec.Code)
+	}
+}
+
+func TestHandleDenoiseInputCheck(t *testing.T) {
+	sampleRate := 44100
+	input := WriteWAV(bandlimitedNoise(65536, sampleRate, 4000), sampleRate)
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	bw, err := strconv.ParseFloat(rec.Header().Get("X-Effective-Bandwidth-Hz"), 64)
+	if err != nil || math.Abs(bw-4000) > 200 {
+		t.Fatalf("expected X-Effective-Bandwidth-Hz ~4000, got %q", rec.Header().Get("X-Effective-Bandwidth-Hz"))
+	}
+	if rec.Header().Get("X-DC-Fraction") == "" {
+		t.Fatal("missing X-DC-Fraction header")
+	}
+
+	for check, want := range map[string]int{
+		"adapt":  http.StatusOK,
+		"reject": http.StatusUnprocessableEntity,
+		"bogus":  http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input,
+			map[string]string{"input_check": check}))
+		if rec.Code != want {
+			t.Errorf("input_check=%s: expected %d, got %d: %s", check, want, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestHandleDenoiseClientDisconnect(t *testing.T) {
+	logs := captureLogs(t, "info")
+
+	// Five minutes of audio takes far longer to denoise than the delay
+	// before the client goes away.
+	sampleRate := 16000
+	input := WriteWAV(pseudoNoise(5*60*sampleRate, 9, 0.3), sampleRate)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := newUploadRequest(t, http.MethodPost, "/denoise", input, nil).WithContext(ctx)
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+	rec := httptest.NewRecorder()
+	started := time.Now()
+	handleDenoise(rec, req)
+	elapsed := time.Since(started)
+
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected no response body after disconnect, got %d bytes", rec.Body.Len())
+	}
+	if !strings.Contains(logs.String(), "processing stopped") {
+		t.Fatalf("expected processing to stop early; logs:\n%s", logs)
+	}
+	t.Logf("handler returned after %v", elapsed)
+}
+
+func TestHandleDenoiseProgressMultipart(t *testing.T) {
+	sampleRate := 16000
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(sampleRate, 3),
+		map[string]string{"progress": "1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("expected multipart/mixed, got %q (%v)", rec.Header().Get("Content-Type"), err)
+	}
+	mr := multipart.NewReader(rec.Body, params["boundary"])
+
+	var progress []map[string]int
+	var audio []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		if audio != nil {
+			t.Fatalf("part of type %q after the audio part", part.Header.Get("Content-Type"))
+		}
+		switch ct := part.Header.Get("Content-Type"); ct {
+		case "application/json":
+			var p map[string]int
+			if err := json.NewDecoder(part).Decode(&p); err != nil {
+				t.Fatalf("decode progress: %v", err)
+			}
+			progress = append(progress, p)
+		case "audio/wav":
+			if audio, err = io.ReadAll(part); err != nil {
+				t.Fatalf("read audio part: %v", err)
+			}
+		default:
+			t.Fatalf("unexpected part type %q", ct)
+		}
+	}
+
+	if len(progress) == 0 {
+		t.Fatal("no progress parts")
+	}
+	for i, p := range progress {
+		if i > 0 && p["done"] <= progress[i-1]["done"] {
+			t.Fatalf("progress not increasing: %v", progress)
+		}
+	}
+	if last := progress[len(progress)-1]; last["done"] != last["total"] {
+		t.Fatalf("final progress %v is not complete", last)
+	}
+
+	samples, sr, err := ReadWAV(audio)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != sampleRate || len(samples) != 3*sampleRate {
+		t.Fatalf("expected %d samples at %d Hz, got %d at %d", 3*sampleRate, sampleRate, len(samples), sr)
+	}
+}
+
+func TestHandleDenoiseEchoConfig(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 1),
+		map[string]string{"echo_config": "1", "amount": "50", "normalize": "rms"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var got DenoiseConfig
+	if err := json.Unmarshal([]byte(rec.Header().Get("X-Denoise-Config")), &got); err != nil {
+		t.Fatalf("bad X-Denoise-Config %q: %v", rec.Header().Get("X-Denoise-Config"), err)
+	}
+	// The smoothing amount sets is scaled to the rate with the rest.
+	want := DefaultDenoiseConfig().WithAmount(50).ForSampleRate(16000)
+	want.NormalizeMode = NormalizeRMS
+	if got != want {
+		t.Fatalf("expected config %+v, got %+v", want, got)
+	}
+	if got.GainSmoothing == DefaultDenoiseConfig().WithAmount(50).GainSmoothing {
+		t.Fatalf("gain_smoothing %g not scaled to 16 kHz", got.GainSmoothing)
+	}
+
+	// At 48 kHz the noise window is scaled to cover the same time as at
+	// the reference rate.
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(48000, 1), map[string]string{"echo_config": "1"}))
+	var echoed struct {
+		NoiseFrames int `json:"noise_frames"`
+	}
+	if err := json.Unmarshal([]byte(rec.Header().Get("X-Denoise-Config")), &echoed); err != nil {
+		t.Fatalf("bad X-Denoise-Config %q: %v", rec.Header().Get("X-Denoise-Config"), err)
+	}
+	if want := DefaultDenoiseConfig().ForSampleRate(48000).NoiseFrames; echoed.NoiseFrames != want || want == NoiseFrames {
+		t.Fatalf("48 kHz: noise_frames %d, want %d (not the 44.1 kHz %d)", echoed.NoiseFrames, want, NoiseFrames)
+	}
+
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", toneWAV(16000, 1), nil))
+	if h := rec.Header().Get("X-Denoise-Config"); h != "" {
+		t.Fatalf("config echoed without echo_config: %s", h)
+	}
+}
+
+func TestHandleDenoiseDryRun(t *testing.T) {
+	input := toneWAV(16000, 1)
+
+	rec := httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, map[string]string{"dry_run": "1"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected application/json, got %q", ct)
+	}
+	var meta map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &meta); err != nil {
+		t.Fatalf("bad JSON body: %v", err)
+	}
+	for _, key := range []string{"sample_rate", "samples", "noise_db", "noise_negligible", "snr_db", "reduction_db"} {
+		if _, ok := meta[key]; !ok {
+			t.Fatalf("missing %q in %v", key, meta)
+		}
+	}
+	if meta["samples"] != float64(16000) || bytes.Contains(rec.Body.Bytes(), []byte("RIFF")) {
+		t.Fatalf("unexpected dry-run body %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handleDenoise(rec, newUploadRequest(t, http.MethodPost, "/denoise", input, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("expected WAV audio without dry_run, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if _, err := ValidateWAV(rec.Body.Bytes()); err != nil {
+		t.Fatalf("normal response is not WAV: %v", err)
+	}
+}
+
+func TestHandleCompare(t *testing.T) {
+	// A noise lead-in, then a tone in noise.
+	samples := pseudoNoise(3*16000, 8, 0.05)
+	for i := 16000; i < len(samples); i++ {
+		samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/16000)
+	}
+	input := WriteWAV(samples, 16000)
+	rec := httptest.NewRecorder()
+	handleCompare(rec, newUploadRequest(t, http.MethodPost, "/compare", input, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if lag := rec.Header().Get("X-Compare-Lag-Samples"); lag != "0" {
+		t.Fatalf("expected no delay for the default config, got %q", lag)
+	}
+
+	header, err := ValidateWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("ValidateWAV: %v", err)
+	}
+	if header.NumChannels != 2 || header.SampleRate != 16000 {
+		t.Fatalf("expected 16 kHz stereo, got %+v", header)
+	}
+
+	// The left channel is the original.
+	original, _, _ := ReadWAV(input)
+	data := rec.Body.Bytes()[44:]
+	if len(data) != 4*len(original) {
+		t.Fatalf("expected %d frames, got %d bytes", len(original), len(data))
+	}
+	for i, want := range original {
+		got := float64(int16(binary.LittleEndian.Uint16(data[4*i:]))) / 32768
+		if math.Abs(got-want) > 1.0/32768 {
+			t.Fatalf("frame %d: left channel %f, want %f", i, got, want)
+		}
+	}
+}
+
+func TestHandleTestFile(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleTestFile(rec, httptest.NewRequest(http.MethodGet, "/testfile?seconds=2", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	samples, sampleRate, err := ReadWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := float64(len(samples)) / float64(sampleRate); got != 2 {
+		t.Fatalf("expected 2 s of audio, got %.3f s", got)
+	}
+
+	// Noise alone in the lead-in; the tone on top of it afterwards.
+	lead := samples[:sampleRate/2]
+	body := samples[sampleRate:]
+	if level := rms(lead); level < 0.01 {
+		t.Fatalf("expected noise in the lead-in, rms %.4f", level)
+	}
+	if amp := toneAmplitude(lead, syntheticToneHz, sampleRate); amp > 0.01 {
+		t.Fatalf("expected no tone in the lead-in, amplitude %.4f", amp)
+	}
+	if amp := toneAmplitude(body, syntheticToneHz, sampleRate); math.Abs(amp-syntheticToneAmp) > 0.01 {
+		t.Fatalf("expected a %g tone, amplitude %.4f", syntheticToneAmp, amp)
+	}
+
+	for _, query := range []string{"seconds=0", "seconds=61", "seconds=x"} {
+		rec := httptest.NewRecorder()
+		handleTestFile(rec, httptest.NewRequest(http.MethodGet, "/testfile?"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestHandleDenoiseStereo(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+	stereo, err := EncodeWAV(Interleave([][]float64{left, right}), WAVHeader{SampleRate: sampleRate, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handleDenoiseStereo(rec, newUploadRequest(t, http.MethodPost, "/denoise/stereo", stereo, map[string]string{"stereo_mode": "ms"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	channels, rate, err := ReadWAVChannels(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 2 || len(channels[0]) != len(left) || rate != sampleRate {
+		t.Fatalf("expected %d stereo samples at %d Hz, got %d channels of %d at %d Hz",
+			len(left), sampleRate, len(channels), len(channels[0]), rate)
+	}
+
+	// Quad input comes back as quad, with its channel layout.
+	quadHeader := WAVHeader{SampleRate: sampleRate, NumChannels: 4, BitsPerSample: 16, ChannelMask: 0x33}
+	quad, err := EncodeWAV(Interleave([][]float64{left, right, right, left}), quadHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handleDenoiseStereo(rec, newUploadRequest(t, http.MethodPost, "/denoise/stereo", quad, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("quad: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if header, err := ValidateWAV(rec.Body.Bytes()); err != nil || *header != quadHeader {
+		t.Fatalf("quad: expected header %+v, got %+v (err %v)", quadHeader, header, err)
+	}
+
+	// FLAC input is decoded as for /denoise, and out_bits is honoured.
+	flac, err := EncodeFLAC(Interleave([][]float64{left, right}), sampleRate, 2, PCM16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	handleDenoiseStereo(rec, newUploadRequest(t, http.MethodPost, "/denoise/stereo", flac, map[string]string{"out_bits": "24", "dither": "tpdf"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("FLAC: expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if header, err := ValidateWAV(rec.Body.Bytes()); err != nil || header.NumChannels != 2 || header.BitsPerSample != 24 {
+		t.Fatalf("FLAC: expected a 24-bit stereo WAV, got %+v (err %v)", header, err)
+	}
+
+	for name, tc := range map[string]struct {
+		data   []byte
+		fields map[string]string
+	}{
+		"mono":            {toneWAV(sampleRate, 1), nil},
+		"bad mode":        {stereo, map[string]string{"stereo_mode": "xy"}},
+		"shared mid/side": {stereo, map[string]string{"stereo_mode": "ms", "shared": "1"}},
+		"quad mid/side":   {quad, map[string]string{"stereo_mode": "ms"}},
+		"bad out_bits":    {stereo, map[string]string{"out_bits": "12"}},
+		"bad dither":      {stereo, map[string]string{"dither": "loud"}},
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoiseStereo(rec, newUploadRequest(t, http.MethodPost, "/denoise/stereo", tc.data, tc.fields))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+}
+
+func TestHandleDenoiseReference(t *testing.T) {
+	const sampleRate = 16000
+	_, primary, reference := referenceRecording()
+	encode := func(header WAVHeader, samples []float64) []byte {
+		t.Helper()
+		data, err := EncodeWAV(samples, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	mono := WAVHeader{SampleRate: sampleRate, NumChannels: 1, BitsPerSample: 16}
+	stereo := encode(WAVHeader{SampleRate: sampleRate, NumChannels: 2, BitsPerSample: 16}, Interleave([][]float64{primary, reference}))
+
+	// withReference returns a request uploading primary as "file" and a
+	// reference at refRate as "reference".
+	withReference := func(refRate int) *http.Request {
+		body := &bytes.Buffer{}
+		mw := multipart.NewWriter(body)
+		for field, data := range map[string][]byte{
+			"file":      encode(mono, primary),
+			"reference": encode(WAVHeader{SampleRate: refRate, NumChannels: 1, BitsPerSample: 16}, reference),
+		} {
+			part, err := mw.CreateFormFile(field, field+".wav")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(data)
+		}
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/denoise/reference", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return req
+	}
+
+	for name, req := range map[string]*http.Request{
+		"stereo":         newUploadRequest(t, http.MethodPost, "/denoise/reference", stereo, nil),
+		"reference file": withReference(sampleRate),
+		"denoised":       newUploadRequest(t, http.MethodPost, "/denoise/reference", stereo, map[string]string{"denoise": "1"}),
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoiseReference(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+		got, rate, err := ReadWAV(rec.Body.Bytes())
+		if err != nil || rate != sampleRate || len(got) != len(primary) {
+			t.Fatalf("%s: expected %d samples at %d Hz, got %d at %d (err %v)", name, len(primary), sampleRate, len(got), rate, err)
+		}
+		if rms(got[len(got)/2:]) >= rms(primary[len(primary)/2:]) {
+			t.Fatalf("%s: expected the noise to be reduced", name)
+		}
+	}
+
+	for name, req := range map[string]*http.Request{
+		"mono":         newUploadRequest(t, http.MethodPost, "/denoise/reference", encode(mono, primary), nil),
+		"rate differs": withReference(8000),
+		"bad taps":     newUploadRequest(t, http.MethodPost, "/denoise/reference", stereo, map[string]string{"taps": "0"}),
+		"bad step":     newUploadRequest(t, http.MethodPost, "/denoise/reference", stereo, map[string]string{"step_size": "x"}),
+	} {
+		rec := httptest.NewRecorder()
+		handleDenoiseReference(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+}
+
+func TestHandleCapabilities(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleCapabilities(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var caps struct {
+		Input struct {
+			Formats            []string `json:"formats"`
+			Encodings          []string `json:"encodings"`
+			BitsPerSample      []int    `json:"bits_per_sample"`
+			FloatBitsPerSample []int    `json:"float_bits_per_sample"`
+		} `json:"input"`
+		Parameters map[string]struct {
+			Min          *float64 `json:"min"`
+			Max          *float64 `json:"max"`
+			MinExclusive bool     `json:"min_exclusive"`
+			Default      any      `json:"default"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &caps); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if !slices.Contains(caps.Input.Formats, "wav") || !slices.Equal(caps.Input.BitsPerSample, []int{8, 16, 24, 32}) {
+		t.Fatalf("input formats %v, bits %v", caps.Input.Formats, caps.Input.BitsPerSample)
+	}
+	if !slices.Equal(caps.Input.Encodings, []string{"pcm", "float", "alaw", "mulaw", "ima_adpcm"}) || !slices.Equal(caps.Input.FloatBitsPerSample, []int{32, 64}) {
+		t.Fatalf("input encodings %v, float bits %v", caps.Input.Encodings, caps.Input.FloatBitsPerSample)
+	}
+
+	over, ok := caps.Parameters["over_subtract"]
+	if !ok || over.Min == nil || over.Max != nil || over.MinExclusive {
+		t.Fatalf("over_subtract: got %+v, want a minimum with no maximum", over)
+	}
+	if over.Default != OverSubtract {
+		t.Fatalf("over_subtract default %v, want %g", over.Default, OverSubtract)
+	}
+	// The reported range is the one Validate enforces.
+	cfg := DefaultDenoiseConfig()
+	cfg.OverSubtract = *over.Min
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("over_subtract at its reported minimum: %v", err)
+	}
+	cfg.OverSubtract = *over.Min - 0.01
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected over_subtract below its reported minimum to be rejected")
+	}
+
+	rec = httptest.NewRecorder()
+	handleCapabilities(rec, httptest.NewRequest(http.MethodPost, "/capabilities", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: expected 405, got %d", rec.Code)
+	}
+}
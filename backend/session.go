@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// DenoiseSession denoises several clips recorded in the same environment
+// with one noise profile, so they all get the same treatment. The profile
+// is estimated from the cfg.NoiseFrames quietest frames across all clips,
+// wherever they fall. It returns the cleaned clips in order.
+func DenoiseSession(clips [][]float64, sampleRate int, cfg DenoiseConfig) ([][]float64, error) {
+	cfg = cfg.forRate(sampleRate)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(clips) == 0 {
+		return nil, errors.New("denoise: no clips")
+	}
+
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// One scale for every clip keeps their relative levels.
+	fitted, level := fitInputRange(clips...)
+	padded := make([][]float64, len(clips))
+	for i, clip := range fitted {
+		if err := cfg.checkLength(len(clip)); err != nil {
+			return nil, err
+		}
+		if len(clip) > 0 {
+			padded[i] = padToFrame(applyInputGain(clip, cfg.InputGainDB), cfg.FrameSize)
+		}
+	}
+	noise := estimateNoiseRegions(quietestFrames(padded, cfg), window, cfg)
+
+	out := make([][]float64, len(clips))
+	for i, clip := range padded {
+		if clip == nil {
+			continue // empty clip
+		}
+		if out[i], _, err = subtractNoise(context.Background(), clip, sampleRate, noise, window, cfg, nil); err != nil {
+			return nil, err
+		}
+		out[i] = fitLength(out[i], len(clips[i]))
+	}
+	restoreInputLevel(out, level, cfg)
+	return out, nil
+}
+
+// quietestFrames returns the cfg.NoiseFrames frames with the least energy
+// across all clips (each empty or at least cfg.FrameSize long), quietest
+// first. Digitally silent frames, such as padding, say nothing about the
+// noise and are skipped.
+func quietestFrames(clips [][]float64, cfg DenoiseConfig) [][]float64 {
+	type frame struct {
+		samples []float64
+		energy  float64
+	}
+	var frames []frame
+	for _, clip := range clips {
+		if len(clip) == 0 {
+			continue
+		}
+		for fi := 0; fi < frameCount(len(clip), cfg); fi++ {
+			start := fi * cfg.HopSize
+			f := frame{samples: clip[start : start+cfg.FrameSize]}
+			for _, v := range f.samples {
+				f.energy += v * v
+			}
+			if f.energy > 0 {
+				frames = append(frames, f)
+			}
+		}
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].energy < frames[j].energy })
+
+	quiet := make([][]float64, min(cfg.NoiseFrames, len(frames)))
+	for i := range quiet {
+		quiet[i] = frames[i].samples
+	}
+	return quiet
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDenoiseSession(t *testing.T) {
+	sampleRate := 44100
+	n := 2 * sampleRate
+	gap := [2]int{sampleRate / 2, sampleRate} // noise-only in every clip
+
+	// Three clips from one room (same noise), with speech (a tone) in
+	// different places. The last two start talking immediately, so their
+	// own leading frames would be a poor noise estimate.
+	toneRanges := [][][2]int{
+		{{gap[1], n}},
+		{{0, gap[0]}, {gap[1], n}},
+		{{0, gap[0]}},
+	}
+	clips := make([][]float64, len(toneRanges))
+	for c, ranges := range toneRanges {
+		clips[c] = pseudoNoise(n, 7, 0.05)
+		for _, r := range ranges {
+			for i := r[0]; i < r[1]; i++ {
+				clips[c][i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+			}
+		}
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	cleaned, err := DenoiseSession(clips, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseSession: %v", err)
+	}
+	if len(cleaned) != len(clips) {
+		t.Fatalf("expected %d clips, got %d", len(clips), len(cleaned))
+	}
+
+	// Noise reduction in the shared noise-only gap of each clip.
+	mid := gap[0] + FrameSize
+	end := gap[1] - FrameSize
+	var reductions []float64
+	for c := range clips {
+		r := 20 * math.Log10(rms(clips[c][mid:end])/rms(cleaned[c][mid:end]))
+		t.Logf("clip %d: reduction %.1f dB", c, r)
+		reductions = append(reductions, r)
+		if r < 15 {
+			t.Errorf("clip %d: expected at least 15 dB of reduction, got %.1f", c, r)
+		}
+	}
+	for c := 1; c < len(reductions); c++ {
+		// Identical noise and profile should give identical treatment.
+		if math.Abs(reductions[c]-reductions[0]) > 0.5 {
+			t.Errorf("inconsistent reduction: clip 0 %.1f dB, clip %d %.1f dB", reductions[0], c, reductions[c])
+		}
+	}
+
+	// Estimated on its own, a clip that starts with speech mistakes the
+	// speech for noise and loses it.
+	alone, err := DenoiseWithConfig(clips[2], sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tone := make([]float64, gap[0])
+	for i := range tone {
+		tone[i] = math.Sin(2 * math.Pi * 440 * float64(i) / float64(sampleRate))
+	}
+	span := [2]int{FrameSize, gap[0] - FrameSize}
+	sessionCorr := correlation(tone[span[0]:span[1]], cleaned[2][span[0]:span[1]])
+	aloneCorr := correlation(tone[span[0]:span[1]], alone[span[0]:span[1]])
+	t.Logf("clip 2 tone correlation: session %.3f, alone %.3f", sessionCorr, aloneCorr)
+	if sessionCorr < 0.99 || sessionCorr <= aloneCorr {
+		t.Errorf("expected the session profile to preserve speech better: session %.3f, alone %.3f", sessionCorr, aloneCorr)
+	}
+}
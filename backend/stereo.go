@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// StereoConfig configures DenoiseStereo.
+type StereoConfig struct {
+	DenoiseConfig
+
+	// SharedNoiseProfile estimates a single noise profile, averaged across
+	// the channels, and applies the same per-bin gain to each so the
+	// stereo image does not wander. When false each channel is denoised
+	// independently with its own profile.
+	SharedNoiseProfile bool
+
+	// Parallel denoises independent channels concurrently, each on its
+	// own goroutine, bounded by channelWorkers. The output is identical to
+	// sequential processing. Channels sharing a noise profile are always
+	// processed together, frame by frame, as their gains are computed
+	// jointly.
+	Parallel bool
+
+	// Mode selects the channels that are denoised: left and right, or
+	// mid and side.
+	Mode StereoMode
+
+	// SideOverSubtract is the over-subtraction factor for the side
+	// channel in StereoMS, where it mostly carries decorrelated noise.
+	// Zero uses OverSubtract.
+	SideOverSubtract float64
+}
+
+// StereoMode selects the channel pair DenoiseStereo works on. The zero
+// value is StereoLR.
+type StereoMode int
+
+const (
+	// StereoLR denoises the left and right channels.
+	StereoLR StereoMode = iota
+	// StereoMS converts to mid (L+R)/2 and side (L-R)/2, denoises those,
+	// and converts back. Center-panned voice lives entirely in mid, so
+	// side can be cleaned harder without touching it, which keeps the
+	// stereo image steadier than denoising left and right separately.
+	StereoMS
+)
+
+// ParseStereoMode parses a stereo_mode value: "lr" or "ms".
+func ParseStereoMode(s string) (StereoMode, error) {
+	switch s {
+	case "lr":
+		return StereoLR, nil
+	case "ms":
+		return StereoMS, nil
+	}
+	return 0, fmt.Errorf("denoise: unknown stereo mode %q (want lr or ms)", s)
+}
+
+// channelWorkers bounds the channels being denoised concurrently across
+// all Parallel stereo calls, so they cannot oversubscribe the CPUs.
+var channelWorkers = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// DefaultStereoConfig returns the default stereo configuration: the
+// DefaultDenoiseConfig with independent left and right channels, and
+// twice the over-subtraction on side should StereoMS be chosen.
+func DefaultStereoConfig() StereoConfig {
+	return StereoConfig{DenoiseConfig: DefaultDenoiseConfig(), SideOverSubtract: 2 * OverSubtract}
+}
+
+// Validate checks the stereo options and the embedded DenoiseConfig.
+func (c StereoConfig) Validate() error {
+	if err := c.DenoiseConfig.Validate(); err != nil {
+		return err
+	}
+	switch c.Mode {
+	case StereoLR, StereoMS:
+	default:
+		return fmt.Errorf("denoise: unknown stereo mode %d", c.Mode)
+	}
+	if c.SideOverSubtract < 0 {
+		return fmt.Errorf("denoise: side over-subtraction %g must be non-negative", c.SideOverSubtract)
+	}
+	if c.Mode == StereoMS && c.SharedNoiseProfile {
+		return errors.New("denoise: a shared noise profile is not available in mid/side mode")
+	}
+	return nil
+}
+
+// DenoiseStereo denoises the two channels of a stereo recording, which must
+// be the same length. Both channels are normalized by the same gain.
+func DenoiseStereo(left, right []float64, sampleRate int, cfg StereoConfig) ([]float64, []float64, error) {
+	return denoiseStereo(left, right, sampleRate, cfg, nil)
+}
+
+// DenoiseChannels is DenoiseStereo for any number of channels, as from
+// multichannel field recorders: each is denoised with its own noise
+// profile, or all with one if cfg.SharedNoiseProfile is set, and all are
+// normalized by the same gain. StereoMS needs exactly two channels.
+func DenoiseChannels(channels [][]float64, sampleRate int, cfg StereoConfig) ([][]float64, error) {
+	return denoiseChannels(context.Background(), channels, sampleRate, cfg, nil)
+}
+
+// DenoiseChannelsContext is like DenoiseChannels but stops early, returning
+// ctx.Err(), once ctx is done.
+func DenoiseChannelsContext(ctx context.Context, channels [][]float64, sampleRate int, cfg StereoConfig) ([][]float64, error) {
+	return denoiseChannels(ctx, channels, sampleRate, cfg, nil)
+}
+
+// denoiseStereo is DenoiseStereo, calling onFrame (if non-nil) with the
+// per-bin gains applied to each channel's frames. With cfg.Parallel,
+// onFrame may be called for both channels concurrently.
+func denoiseStereo(left, right []float64, sampleRate int, cfg StereoConfig, onFrame func(ch, fi int, gains []float64)) ([]float64, []float64, error) {
+	outputs, err := denoiseChannels(context.Background(), [][]float64{left, right}, sampleRate, cfg, onFrame)
+	if err != nil {
+		return nil, nil, err
+	}
+	return outputs[0], outputs[1], nil
+}
+
+// denoiseChannels is DenoiseChannelsContext with denoiseStereo's onFrame.
+func denoiseChannels(ctx context.Context, inputs [][]float64, sampleRate int, cfg StereoConfig, onFrame func(ch, fi int, gains []float64)) ([][]float64, error) {
+	cfg.DenoiseConfig = cfg.DenoiseConfig.forRate(sampleRate)
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("denoise: no channels")
+	}
+	if cfg.Mode == StereoMS && len(inputs) != 2 {
+		return nil, fmt.Errorf("denoise: mid/side needs 2 channels, not %d", len(inputs))
+	}
+	n := len(inputs[0])
+	for _, ch := range inputs[1:] {
+		if len(ch) != n {
+			return nil, fmt.Errorf("denoise: channel lengths differ (%d and %d samples)", n, len(ch))
+		}
+	}
+	if err := cfg.checkLength(n); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return make([][]float64, len(inputs)), nil
+	}
+
+	dc := cfg.DenoiseConfig
+	fitted, level := fitInputRange(inputs...)
+	channels := make([][]float64, len(fitted))
+	for c, ch := range fitted {
+		channels[c] = padToFrame(applyInputGain(ch, dc.InputGainDB), dc.FrameSize)
+	}
+	inputPeak := peakLevel(channels...)
+
+	// Per-channel configuration: in mid/side, side gets its own
+	// over-subtraction.
+	cfgs := make([]DenoiseConfig, len(channels))
+	for c := range cfgs {
+		cfgs[c] = dc
+	}
+	if cfg.Mode == StereoMS {
+		channels = midSide(channels[0], channels[1])
+		if cfg.SideOverSubtract > 0 {
+			cfgs[1].OverSubtract = cfg.SideOverSubtract
+		}
+	}
+
+	window, err := NewWindow(dc.Window, dc.FrameSize, dc)
+	if err != nil {
+		return nil, err
+	}
+
+	noiseEnd := leadingNoiseEnd(len(channels[0]), dc)
+	profiles := make([]*NoiseProfile, len(channels))
+	for c, samples := range channels {
+		profiles[c] = estimateNoise(samples[:noiseEnd], window, dc)
+	}
+
+	var outputs [][]float64
+	if cfg.SharedNoiseProfile {
+		noise := averageNoise(profiles)
+		noise, _ = guardNoise(noise, averageChannels(channels), window, dc)
+		outputs, err = subtractFrames(ctx, channels, newSubtractor(noise, sampleRate, dc), window, dc, func(fi int, gains []float64) {
+			if onFrame != nil {
+				for c := range channels {
+					onFrame(c, fi, gains)
+				}
+			}
+		})
+	} else {
+		outputs = make([][]float64, len(channels))
+		denoiseChannel := func(c int) {
+			noise, _ := guardNoise(profiles[c], channels[c], window, dc)
+			out, err := subtractFrames(ctx, [][]float64{channels[c]}, newSubtractor(noise, sampleRate, cfgs[c]), window, cfgs[c], func(fi int, gains []float64) {
+				if onFrame != nil {
+					onFrame(c, fi, gains)
+				}
+			})
+			if err == nil {
+				outputs[c] = out[0]
+			}
+		}
+		if cfg.Parallel {
+			var wg sync.WaitGroup
+			for c := range channels {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					channelWorkers <- struct{}{}
+					defer func() { <-channelWorkers }()
+					denoiseChannel(c)
+				}()
+			}
+			wg.Wait()
+		} else {
+			for c := range channels {
+				denoiseChannel(c)
+			}
+		}
+	}
+	// Every channel stops early once ctx is done, so one check covers them.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if cfg.Mode == StereoMS {
+		outputs = leftRight(outputs[0], outputs[1])
+	}
+	finishOutput(outputs, inputPeak, sampleRate, dc)
+	for c := range outputs {
+		outputs[c] = fitLength(outputs[c], n)
+	}
+	restoreInputLevel(outputs, level, dc)
+	return outputs, nil
+}
+
+// midSide returns the mid (L+R)/2 and side (L-R)/2 channels of a stereo
+// pair.
+func midSide(left, right []float64) [][]float64 {
+	mid := make([]float64, len(left))
+	side := make([]float64, len(left))
+	for i := range left {
+		mid[i] = (left[i] + right[i]) / 2
+		side[i] = (left[i] - right[i]) / 2
+	}
+	return [][]float64{mid, side}
+}
+
+// leftRight is the inverse of midSide.
+func leftRight(mid, side []float64) [][]float64 {
+	left := make([]float64, len(mid))
+	right := make([]float64, len(mid))
+	for i := range mid {
+		left[i] = mid[i] + side[i]
+		right[i] = mid[i] - side[i]
+	}
+	return [][]float64{left, right}
+}
+
+// averageNoise returns the bin-by-bin mean of the noise profiles.
+func averageNoise(profiles []*NoiseProfile) *NoiseProfile {
+	bins := len(profiles[0].Mean)
+	avg := &NoiseProfile{
+		Mean:     make([]float64, bins),
+		Variance: make([]float64, bins),
+	}
+	for _, p := range profiles {
+		for k := 0; k < bins; k++ {
+			avg.Mean[k] += p.Mean[k] / float64(len(profiles))
+			avg.Variance[k] += p.Variance[k] / float64(len(profiles))
+		}
+	}
+	return avg
+}
+
+// averageChannels returns the sample-by-sample mean of equal-length
+// channels.
+func averageChannels(channels [][]float64) []float64 {
+	mix := make([]float64, len(channels[0]))
+	for _, ch := range channels {
+		for i, s := range ch {
+			mix[i] += s / float64(len(channels))
+		}
+	}
+	return mix
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+)
+
+// stereoToneNoise returns a stereo clip: one second of leading noise, then
+// a tone over the noise. The channels carry different noise.
+func stereoToneNoise(sampleRate int) ([]float64, []float64) {
+	n := 3 * sampleRate
+	left := pseudoNoise(n, 1, 0.05)
+	right := pseudoNoise(n, 2, 0.08)
+	for i := sampleRate; i < n; i++ {
+		tone := 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		left[i] += tone
+		right[i] += 0.7 * tone
+	}
+	return left, right
+}
+
+func TestDenoiseStereoSharedNoiseProfileGains(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+
+	gainsFor := func(shared bool) [2][][]float64 {
+		cfg := DefaultStereoConfig()
+		cfg.SharedNoiseProfile = shared
+		var gains [2][][]float64
+		_, _, err := denoiseStereo(left, right, sampleRate, cfg, func(ch, fi int, g []float64) {
+			gains[ch] = append(gains[ch], append([]float64(nil), g...))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gains
+	}
+
+	shared := gainsFor(true)
+	if len(shared[0]) == 0 || len(shared[0]) != len(shared[1]) {
+		t.Fatalf("got %d left and %d right frames", len(shared[0]), len(shared[1]))
+	}
+	for fi := range shared[0] {
+		for k := range shared[0][fi] {
+			if shared[0][fi][k] != shared[1][fi][k] {
+				t.Fatalf("shared: frame %d bin %d gain differs: L=%g R=%g", fi, k, shared[0][fi][k], shared[1][fi][k])
+			}
+		}
+	}
+
+	independent := gainsFor(false)
+	differ := false
+	for fi := range independent[0] {
+		for k := range independent[0][fi] {
+			if independent[0][fi][k] != independent[1][fi][k] {
+				differ = true
+			}
+		}
+	}
+	if !differ {
+		t.Error("independent: L and R gains are identical")
+	}
+}
+
+func TestDenoiseStereoMatchesMonoPerChannel(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+	cfg := DefaultStereoConfig()
+	cfg.NormalizeMode = NormalizeNone
+
+	gotL, gotR, err := DenoiseStereo(left, right, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name    string
+		in, got []float64
+	}{{"left", left, gotL}, {"right", right, gotR}} {
+		want, err := DenoiseWithConfig(tc.in, sampleRate, cfg.DenoiseConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if math.Abs(tc.got[i]-want[i]) > 1e-12 {
+				t.Fatalf("%s: sample %d = %g, mono gives %g", tc.name, i, tc.got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestDenoiseStereoLengthMismatch(t *testing.T) {
+	_, _, err := DenoiseStereo(make([]float64, 4096), make([]float64, 4000), 16000, DefaultStereoConfig())
+	if err == nil {
+		t.Error("expected an error for channels of different lengths")
+	}
+}
+
+func TestDenoiseChannelsMatchesMonoPerChannel(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+	center := pseudoNoise(len(left), 3, 0.02)
+	cfg := DefaultStereoConfig()
+	cfg.NormalizeMode = NormalizeNone
+
+	got, err := DenoiseChannels([][]float64{left, right, center}, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d channels, want 3", len(got))
+	}
+	for ch, in := range [][]float64{left, right, center} {
+		want, err := DenoiseWithConfig(in, sampleRate, cfg.DenoiseConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range want {
+			if math.Abs(got[ch][i]-want[i]) > 1e-12 {
+				t.Fatalf("channel %d: sample %d = %g, mono gives %g", ch, i, got[ch][i], want[i])
+			}
+		}
+	}
+
+	cfg.Mode = StereoMS
+	if _, err := DenoiseChannels([][]float64{left, right, center}, sampleRate, cfg); err == nil {
+		t.Error("expected an error for mid/side with three channels")
+	}
+}
+
+func TestDenoiseChannelsContextCancelled(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, shared := range []bool{false, true} {
+		cfg := DefaultStereoConfig()
+		cfg.SharedNoiseProfile = shared
+		if _, err := DenoiseChannelsContext(ctx, [][]float64{left, right}, sampleRate, cfg); !errors.Is(err, context.Canceled) {
+			t.Fatalf("shared %v: expected context.Canceled, got %v", shared, err)
+		}
+	}
+}
+
+func TestDenoiseStereoParallelMatchesSequential(t *testing.T) {
+	const sampleRate = 16000
+	left, right := stereoToneNoise(sampleRate)
+	for _, shared := range []bool{false, true} {
+		cfg := DefaultStereoConfig()
+		cfg.SharedNoiseProfile = shared
+		wantL, wantR, err := DenoiseStereo(left, right, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Parallel = true
+		gotL, gotR, err := DenoiseStereo(left, right, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(gotL, wantL) || !slices.Equal(gotR, wantR) {
+			t.Fatalf("shared=%v: parallel output differs from sequential", shared)
+		}
+	}
+}
+
+func BenchmarkDenoiseStereo(b *testing.B) {
+	const sampleRate = 44100
+	n := 60 * sampleRate
+	left, right := pseudoNoise(n, 1, 0.05), pseudoNoise(n, 2, 0.08)
+	for _, parallel := range []bool{false, true} {
+		name := "sequential"
+		if parallel {
+			name = "parallel"
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg := DefaultStereoConfig()
+			cfg.Parallel = parallel
+			for range b.N {
+				if _, _, err := DenoiseStereo(left, right, sampleRate, cfg); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestDenoiseStereoMidSide(t *testing.T) {
+	const sampleRate = 16000
+	n := 3 * sampleRate
+	// A center-panned tone after a second of noise; the noise in each
+	// channel is independent, so half of it is side.
+	left, right := pseudoNoise(n, 11, 0.05), pseudoNoise(n, 12, 0.05)
+	for i := sampleRate; i < n; i++ {
+		tone := 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		left[i] += tone
+		right[i] += tone
+	}
+
+	// The tone's amplitude in mid, and the rms of side, over the tone.
+	measure := func(mode StereoMode) (tone, side float64) {
+		cfg := DefaultStereoConfig()
+		cfg.NormalizeMode = NormalizeNone
+		cfg.Mode = mode
+		l, r, err := DenoiseStereo(left, right, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms := midSide(l[sampleRate+cfg.FrameSize:n-cfg.FrameSize], r[sampleRate+cfg.FrameSize:n-cfg.FrameSize])
+		return toneAmplitude(ms[0], 440, sampleRate), rms(ms[1])
+	}
+	lrTone, lrSide := measure(StereoLR)
+	msTone, msSide := measure(StereoMS)
+	t.Logf("LR: tone %.4f, side %.5f; MS: tone %.4f, side %.5f", lrTone, lrSide, msTone, msSide)
+	if math.Abs(msTone-0.5) > 0.01 {
+		t.Fatalf("expected mid/side to keep the 0.5 tone, got %.4f", msTone)
+	}
+	if msSide >= lrSide/2 {
+		t.Fatalf("expected mid/side to remove more side noise: LR %.5f, MS %.5f", lrSide, msSide)
+	}
+
+	cfg := DefaultStereoConfig()
+	cfg.Mode, cfg.SharedNoiseProfile = StereoMS, true
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for a shared profile in mid/side mode")
+	}
+}
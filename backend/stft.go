@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Spectrogram is the short-time Fourier transform of a signal.
+type Spectrogram struct {
+	// Frames holds the full FFT of each windowed frame.
+	Frames [][]complex128
+	// FrameSize and HopSize are the framing the frames were taken with.
+	FrameSize int
+	HopSize   int
+}
+
+// Bins returns the number of non-negative frequency bins, 0..FrameSize/2.
+func (s *Spectrogram) Bins() int {
+	return s.FrameSize/2 + 1
+}
+
+// Power returns |X|² of the non-negative frequency bins of each frame.
+func (s *Spectrogram) Power() [][]float64 {
+	power := make([][]float64, len(s.Frames))
+	for fi, frame := range s.Frames {
+		power[fi] = make([]float64, s.Bins())
+		for k := range power[fi] {
+			power[fi][k] = real(frame[k])*real(frame[k]) + imag(frame[k])*imag(frame[k])
+		}
+	}
+	return power
+}
+
+// MagnitudeDB returns the magnitude in dB of the non-negative frequency
+// bins of each frame, clamped at -120 dB.
+func (s *Spectrogram) MagnitudeDB() [][]float64 {
+	db := s.Power()
+	for _, frame := range db {
+		for k, p := range frame {
+			frame[k] = math.Max(10*math.Log10(p), -120)
+		}
+	}
+	return db
+}
+
+// FrequencyAxis returns the frequency in Hz of each non-negative bin at
+// sampleRate, from 0 to the Nyquist frequency.
+func (s *Spectrogram) FrequencyAxis(sampleRate int) []float64 {
+	axis := make([]float64, s.Bins())
+	for k := range axis {
+		axis[k] = float64(k) * float64(sampleRate) / float64(s.FrameSize)
+	}
+	return axis
+}
+
+// TimeAxis returns the time in seconds of the center of each frame at
+// sampleRate.
+func (s *Spectrogram) TimeAxis(sampleRate int) []float64 {
+	axis := make([]float64, len(s.Frames))
+	for fi := range axis {
+		axis[fi] = float64(fi*s.HopSize+s.FrameSize/2) / float64(sampleRate)
+	}
+	return axis
+}
+
+// STFT returns the windowed spectra of every frame of samples, using the
+// framing and window of cfg. Samples shorter than a frame are zero-padded.
+func STFT(samples []float64, cfg DenoiseConfig) (*Spectrogram, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return nil, err
+	}
+	samples = padToFrame(samples, cfg.FrameSize)
+
+	spec := &Spectrogram{
+		Frames:    make([][]complex128, frameCount(len(samples), cfg)),
+		FrameSize: cfg.FrameSize,
+		HopSize:   cfg.HopSize,
+	}
+	for fi := range spec.Frames {
+		frame := extractFrame(samples, fi*cfg.HopSize, cfg.FrameSize)
+		applyWindow(frame, window)
+		spec.Frames[fi] = FFT(realToComplex(frame))
+	}
+	return spec, nil
+}
+
+// ISTFT reconstructs n samples from a spectrogram produced by STFT with the
+// same cfg, by weighted overlap-add.
+func ISTFT(spec *Spectrogram, n int, cfg DenoiseConfig) ([]float64, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if spec.FrameSize != cfg.FrameSize || spec.HopSize != cfg.HopSize {
+		return nil, fmt.Errorf("stft: spectrogram framing %d/%d does not match config %d/%d",
+			spec.FrameSize, spec.HopSize, cfg.FrameSize, cfg.HopSize)
+	}
+	window, err := NewWindow(cfg.Window, cfg.FrameSize, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ola := newOverlapAdder(n, window)
+	for fi, spectrum := range spec.Frames {
+		if len(spectrum) != cfg.FrameSize {
+			return nil, fmt.Errorf("stft: frame %d has %d bins, want %d", fi, len(spectrum), cfg.FrameSize)
+		}
+		ola.add(fi*cfg.HopSize, IFFT(spectrum))
+	}
+	return ola.finish(), nil
+}
+
+// ReconstructionError runs samples through STFT and ISTFT with no
+// processing in between and returns the RMS difference from the input.
+// It is near zero when cfg's window and hop reconstruct the signal
+// cleanly; a large value means the framing itself distorts the audio.
+func ReconstructionError(samples []float64, cfg DenoiseConfig) (float64, error) {
+	if len(samples) == 0 {
+		return 0, errors.New("stft: no samples")
+	}
+	spec, err := STFT(samples, cfg)
+	if err != nil {
+		return 0, err
+	}
+	out, err := ISTFT(spec, len(samples), cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	var sum float64
+	for i, s := range samples {
+		d := out[i] - s
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(len(samples))), nil
+}
+
+// overlapAdder accumulates inverse-transformed frames into an output
+// signal, applying the synthesis window and tracking the summed window
+// energy for normalization.
+type overlapAdder struct {
+	out       []float64
+	windowSum []float64
+	window    []float64
+}
+
+func newOverlapAdder(n int, window []float64) *overlapAdder {
+	return &overlapAdder{
+		out:       make([]float64, n),
+		windowSum: make([]float64, n),
+		window:    window,
+	}
+}
+
+// add overlap-adds the real part of frame at offset start. Samples past
+// the end of the output are dropped.
+func (o *overlapAdder) add(start int, frame []complex128) {
+	for j, w := range o.window {
+		if idx := start + j; idx < len(o.out) {
+			o.out[idx] += real(frame[j]) * w
+			o.windowSum[idx] += w * w
+		}
+	}
+}
+
+// finish normalizes the output by the accumulated window energy and
+// returns it. Samples no frame covered stay zero.
+func (o *overlapAdder) finish() []float64 {
+	for i := range o.out {
+		if o.windowSum[i] > 1e-8 {
+			o.out[i] /= o.windowSum[i]
+		}
+	}
+	return o.out
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestReconstructionError(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	n := 9*cfg.HopSize + cfg.FrameSize
+	samples := pseudoNoise(n, 3, 0.5)
+	// The outermost samples sit where only one window edge, close to
+	// zero, covers them, so no hop recovers them; silence them.
+	for i := 0; i < 16; i++ {
+		samples[i], samples[n-1-i] = 0, 0
+	}
+
+	errHann, err := ReconstructionError(samples, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errHann > 1e-9 {
+		t.Errorf("Hann at 50%% overlap: error %g, want ~0", errHann)
+	}
+
+	// No overlap: every frame boundary lands on a window zero.
+	cfg.HopSize = cfg.FrameSize
+	errGap, err := ReconstructionError(samples, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errGap < 1000*errHann || errGap < 1e-3 {
+		t.Errorf("Hann without overlap: error %g, want much larger than %g", errGap, errHann)
+	}
+}
+
+func TestSpectrogramAxes(t *testing.T) {
+	sampleRate := 16000
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize, cfg.HopSize = 512, 256
+
+	// A 1 kHz tone between 1 s and 2 s of a 3 s clip.
+	samples := make([]float64, 3*sampleRate)
+	for i := sampleRate; i < 2*sampleRate; i++ {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*1000*float64(i)/float64(sampleRate))
+	}
+	spec, err := STFT(samples, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	freqs := spec.FrequencyAxis(sampleRate)
+	if len(freqs) != cfg.FrameSize/2+1 || freqs[0] != 0 || freqs[len(freqs)-1] != float64(sampleRate)/2 {
+		t.Fatalf("frequency axis should span 0..%d Hz in %d bins, got %g..%g in %d",
+			sampleRate/2, cfg.FrameSize/2+1, freqs[0], freqs[len(freqs)-1], len(freqs))
+	}
+	times := spec.TimeAxis(sampleRate)
+	if len(times) != len(spec.Frames) {
+		t.Fatalf("time axis has %d entries for %d frames", len(times), len(spec.Frames))
+	}
+
+	db := spec.MagnitudeDB()
+	power := spec.Power()
+	peakF, peakK := 0, 0
+	for fi := range db {
+		for k := range db[fi] {
+			if db[fi][k] > db[peakF][peakK] {
+				peakF, peakK = fi, k
+			}
+		}
+	}
+	t.Logf("peak at %.3f s, %.1f Hz (%.1f dB)", times[peakF], freqs[peakK], db[peakF][peakK])
+	if times[peakF] < 1 || times[peakF] > 2 {
+		t.Errorf("peak at %.3f s, expected within the tone (1-2 s)", times[peakF])
+	}
+	if math.Abs(freqs[peakK]-1000) > freqs[1] {
+		t.Errorf("peak at %.1f Hz, expected 1000 Hz within one bin", freqs[peakK])
+	}
+	if want := 10 * math.Log10(power[peakF][peakK]); math.Abs(db[peakF][peakK]-want) > 1e-9 {
+		t.Errorf("MagnitudeDB %.3f disagrees with Power (%.3f dB)", db[peakF][peakK], want)
+	}
+	if db[0][peakK] != -120 {
+		t.Errorf("silent cell should clamp to -120 dB, got %.1f", db[0][peakK])
+	}
+}
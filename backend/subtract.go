@@ -0,0 +1,530 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"math/rand/v2"
+)
+
+// subtractor applies spectral subtraction to successive frames, holding
+// the per-bin state (gain smoothing, comfort-noise generator) between them.
+type subtractor struct {
+	cfg          DenoiseConfig
+	sampleRate   int
+	noiseMag     []float64 // whitened, if cfg.Whitening is set
+	noisePow     []float64 // noiseMag^SubtractionExponent
+	whiten       []float64 // the Whitening gains, or nil
+	alpha        []float64
+	comfortLevel float64
+	prevGain     []float64
+	highPassBins int         // bins below HighPassHz, removed outright
+	bandLow      int         // first bin processed (ProcessBandLow)
+	bandHigh     int         // last bin processed (ProcessBandHigh)
+	prevPhase    [][]float64 // per channel, the previous frame's output phase
+	open         bool        // skip gain smoothing for the current frame
+	floor        float64     // the current frame's spectral floor
+	rng          *rand.Rand
+	frames       int         // frames processed so far
+	maskHistory  [][]float64 // recent raw gain masks, for MaskSmoothingFrames
+}
+
+// newSubtractor prepares a subtractor that removes noise from audio at
+// sampleRate using cfg.
+func newSubtractor(noise *NoiseProfile, sampleRate int, cfg DenoiseConfig) *subtractor {
+	s := &subtractor{
+		cfg:          cfg,
+		sampleRate:   sampleRate,
+		noiseMag:     noise.Mean,
+		alpha:        noise.overSubtraction(cfg),
+		prevGain:     make([]float64, cfg.FrameSize/2+1),
+		highPassBins: int(math.Ceil(cfg.HighPassHz * float64(cfg.FrameSize) / float64(sampleRate))),
+		rng:          rand.New(rand.NewPCG(uint64(cfg.Seed), 0)),
+	}
+
+	if cfg.Whitening != nil {
+		s.whiten = cfg.Whitening.Gains
+	}
+
+	binsPerHz := float64(cfg.FrameSize) / float64(sampleRate)
+	s.bandLow = int(math.Ceil(cfg.ProcessBandLow * binsPerHz))
+	s.bandHigh = cfg.FrameSize / 2
+	if cfg.ProcessBandHigh > 0 {
+		s.bandHigh = min(s.bandHigh, int(math.Floor(cfg.ProcessBandHigh*binsPerHz)))
+	}
+
+	if s.whiten != nil {
+		s.noiseMag = make([]float64, len(noise.Mean))
+		for k, m := range noise.Mean {
+			s.noiseMag[k] = m * s.whitenGain(k)
+		}
+	}
+	s.noisePow = make([]float64, len(s.noiseMag))
+	for k, m := range s.noiseMag {
+		s.noisePow[k] = math.Pow(m, cfg.SubtractionExponent)
+	}
+
+	// Comfort noise is flat at the average noise magnitude (in the
+	// whitened domain, so it follows the noise's color once undone).
+	for _, m := range s.noiseMag {
+		s.comfortLevel += m / float64(len(s.noiseMag))
+	}
+	return s
+}
+
+// whitenGain returns the whitening gain of bin k of the full spectrum
+// (1 without Whitening); negative-frequency bins mirror the positive.
+func (s *subtractor) whitenGain(k int) float64 {
+	if s.whiten == nil {
+		return 1
+	}
+	if half := len(s.whiten) - 1; k > half {
+		k = 2*half - k
+	}
+	return s.whiten[k]
+}
+
+// process applies spectral subtraction in place to the spectra of one frame
+// from each channel. Every channel receives the same per-bin gain, computed
+// from the channels' mean magnitude, which keeps multichannel audio
+// coherent. Returns the gains of bins 0..N/2.
+func (s *subtractor) process(spectra ...[]complex128) []float64 {
+	cfg := s.cfg
+	frameSize := len(spectra[0])
+	half := frameSize / 2
+	gains := make([]float64, half+1)
+	fresh := s.prevPhase == nil // no previous frame's phase to predict from
+	if fresh {
+		s.prevPhase = make([][]float64, len(spectra))
+		for c := range s.prevPhase {
+			s.prevPhase[c] = make([]float64, half+1)
+		}
+	}
+
+	s.floor = cfg.SpectralFloor
+	if cfg.AdaptiveFloorMax > 0 {
+		s.floor = s.adaptiveFloor(spectra)
+	}
+
+	// Spectral subtraction over the non-negative frequencies; the
+	// negative half is mirrored so the output stays real.
+	mags := make([]float64, half+1)
+	cleanMags := make([]float64, half+1)
+	comforts := make([]bool, half+1)
+	for k := 0; k <= half; k++ {
+		var mag float64
+		for _, spectrum := range spectra {
+			mag += cmplx.Abs(spectrum[k])
+		}
+		mag /= float64(len(spectra))
+		mag *= s.whitenGain(k)
+
+		// Subtract over-estimated noise in the magnitude domain raised
+		// to SubtractionExponent. A negative result is floored below, as
+		// are bins gated by GateBelowNoise.
+		var cleanMag float64
+		if !cfg.GateBelowNoise || mag >= s.alpha[k]*s.noiseMag[k] {
+			cleanMag = math.Pow(mag, cfg.SubtractionExponent) - s.alpha[k]*s.noisePow[k]
+			if cleanMag > 0 {
+				cleanMag = math.Pow(cleanMag, 1/cfg.SubtractionExponent)
+			}
+		}
+
+		// Gain floor: keep at least the FloorMode's floor level.
+		floor := s.floor * mag
+		switch cfg.FloorMode {
+		case FloorNoiseShaped:
+			floor = s.floor * s.noiseMag[k]
+		case FloorComfortNoise:
+			floor = s.floor * s.comfortLevel
+			if cfg.ShapeComfortNoise {
+				floor = s.floor * s.noiseMag[k]
+			}
+		}
+		if cleanMag < floor {
+			cleanMag = floor
+			// Comfort noise gets a random phase (DC and Nyquist
+			// must stay real).
+			comforts[k] = cfg.FloorMode == FloorComfortNoise && k > 0 && k < half
+		}
+		mags[k], cleanMags[k] = mag, cleanMag
+	}
+	if cfg.MaskSmoothingFrames > 1 || cfg.MaskSmoothingBins > 0 {
+		s.smoothMask(mags, cleanMags, comforts)
+	}
+
+	for k := 0; k <= half; k++ {
+		mag, cleanMag, comfort := mags[k], cleanMags[k], comforts[k]
+		w := s.whitenGain(k)
+
+		// Smooth the gain over time to suppress musical noise.
+		gain := 0.0
+		if mag > 0 {
+			gain = cleanMag / mag
+			if s.frames > 0 && cfg.GainSmoothing > 0 && !s.open {
+				gain = cfg.GainSmoothing*s.prevGain[k] + (1-cfg.GainSmoothing)*gain
+				cleanMag = gain * mag
+			}
+			s.prevGain[k] = gain
+		}
+
+		// Bins outside the processing band pass through untouched.
+		if k < s.bandLow || k > s.bandHigh {
+			gain, cleanMag, comfort = 1, mag, false
+			s.prevGain[k] = 1
+		}
+
+		// Bins below the high-pass cutoff are removed outright.
+		if k < s.highPassBins {
+			gain, cleanMag, comfort = 0, 0, false
+		}
+		gains[k] = gain
+
+		var comfortPhase float64
+		if comfort {
+			comfortPhase = s.rng.Float64() * 2 * math.Pi
+		}
+		estimate := !comfort && !fresh && s.estimatePhase(k, mag)
+		for c, spectrum := range spectra {
+			chMag := cleanMag / w
+			if mag > 0 {
+				chMag = gain * cmplx.Abs(spectrum[k])
+			}
+			phase := cmplx.Phase(spectrum[k])
+			switch {
+			case comfort:
+				phase = comfortPhase
+			case estimate:
+				phase = s.prevPhase[c][k] + 2*math.Pi*float64(k*cfg.HopSize)/float64(frameSize)
+			}
+			s.prevPhase[c][k] = phase
+
+			// Reconstruct with the original (or substituted) phase. DC
+			// and Nyquist are real; cmplx.Rect would leave a rounding
+			// residue in their imaginary part for a phase of π.
+			if k == 0 || k == half {
+				spectrum[k] = complex(math.Copysign(chMag, real(spectrum[k])), 0)
+				continue
+			}
+			spectrum[k] = cmplx.Rect(chMag, phase)
+		}
+	}
+	for _, spectrum := range spectra {
+		for k := 1; k < half; k++ {
+			spectrum[frameSize-k] = cmplx.Conj(spectrum[k])
+		}
+	}
+
+	s.frames++
+	return gains
+}
+
+// smoothMask replaces each bin's gain, cleanMags[k]/mags[k], with its mean
+// over the MaskSmoothingFrames frames up to this one and the
+// MaskSmoothingBins bins either side, and rescales cleanMags to match.
+// The mean is taken of the gains in dB: a plain mean would be dominated by
+// the isolated peaks it is meant to suppress, whereas in dB a peak among
+// floored bins falls to a fraction of its height and a region of uniformly
+// high gain, like voice, keeps its level. Only this frame is used across a
+// LookAheadMs onset. Bins whose gain the smoothing raises lose their
+// comfort noise, as they no longer sit at the floor.
+func (s *subtractor) smoothMask(mags, cleanMags []float64, comforts []bool) {
+	cfg := s.cfg
+	logGains := make([]float64, len(mags))
+	for k, m := range mags {
+		g := minMaskGain
+		if m > 0 {
+			g = max(cleanMags[k]/m, minMaskGain)
+		}
+		logGains[k] = math.Log(g)
+	}
+
+	// Average over time, from a ring of the recent masks.
+	depth := max(cfg.MaskSmoothingFrames, 1)
+	if len(s.maskHistory) < depth {
+		s.maskHistory = append(s.maskHistory, logGains)
+	} else {
+		s.maskHistory[s.frames%depth] = logGains
+	}
+	history := s.maskHistory
+	if s.open {
+		history = [][]float64{logGains}
+	}
+	timeAvg := make([]float64, len(logGains))
+	for _, mask := range history {
+		for k, g := range mask {
+			timeAvg[k] += g / float64(len(history))
+		}
+	}
+
+	// Then over frequency, with a moving sum.
+	width := cfg.MaskSmoothingBins
+	var sum float64
+	for k := 0; k < min(width, len(timeAvg)); k++ {
+		sum += timeAvg[k]
+	}
+	for k, m := range mags {
+		if hi := k + width; hi < len(timeAvg) {
+			sum += timeAvg[hi]
+		}
+		if lo := k - width - 1; lo >= 0 {
+			sum -= timeAvg[lo]
+		}
+		if m == 0 {
+			continue
+		}
+		n := min(k+width, len(timeAvg)-1) - max(k-width, 0) + 1
+		g := math.Exp(sum / float64(n))
+		if g > cleanMags[k]/m {
+			comforts[k] = false
+		}
+		cleanMags[k] = g * m
+	}
+}
+
+// minMaskGain bounds the gains smoothMask averages in dB from below
+// (-120 dB), so a bin with no output does not pull its neighbors to zero.
+const minMaskGain = 1e-6
+
+// SNRs, in dB, at which the adaptive floor reaches AdaptiveFloorMin and
+// AdaptiveFloorMax.
+const (
+	adaptiveFloorLowSNRDB  = 0
+	adaptiveFloorHighSNRDB = 20
+)
+
+// adaptiveFloor returns the spectral floor for a frame from its estimated
+// SNR: the frame's power over the processed band in excess of the noise
+// estimate's, relative to the noise estimate's.
+func (s *subtractor) adaptiveFloor(spectra [][]complex128) float64 {
+	var power, noisePower float64
+	for k := s.bandLow; k <= s.bandHigh; k++ {
+		var mag float64
+		for _, spectrum := range spectra {
+			mag += cmplx.Abs(spectrum[k])
+		}
+		mag *= s.whitenGain(k) / float64(len(spectra))
+		power += mag * mag
+		noisePower += s.noiseMag[k] * s.noiseMag[k]
+	}
+	lo, hi := s.cfg.AdaptiveFloorMin, s.cfg.AdaptiveFloorMax
+	if noisePower == 0 || power <= noisePower {
+		if noisePower == 0 && power > 0 {
+			return hi
+		}
+		return lo
+	}
+	snr := 10 * math.Log10(power/noisePower-1)
+	t := math.Max(0, math.Min(1, (snr-adaptiveFloorLowSNRDB)/(adaptiveFloorHighSNRDB-adaptiveFloorLowSNRDB)))
+	if lo == 0 {
+		return t * hi
+	}
+	return lo * math.Pow(hi/lo, t)
+}
+
+// estimatePhase reports whether bin k, with magnitude mag, should take the
+// phase predicted from the previous frame instead of its noisy phase: with
+// PhaseEstimate set, bins below PhaseSNRThresholdDB do, except DC and
+// Nyquist.
+func (s *subtractor) estimatePhase(k int, mag float64) bool {
+	if !s.cfg.PhaseEstimate || k == 0 || k == s.cfg.FrameSize/2 {
+		return false
+	}
+	return 20*math.Log10(mag/s.noiseMag[k]) < s.cfg.PhaseSNRThresholdDB
+}
+
+// warmUp runs the frames of the channels from 0 to end (the noise region)
+// through s without keeping the output, so gain smoothing starts from its
+// steady state on noise instead of from the first frame's raw gain. The
+// phase history is then cleared, as the next frame processed is not the
+// one after the last warm-up frame.
+func (s *subtractor) warmUp(channels [][]float64, end int, window []float64) {
+	cfg := s.cfg
+	spectra := make([][]complex128, len(channels))
+	for start := 0; start+cfg.FrameSize <= end; start += cfg.HopSize {
+		for c, samples := range channels {
+			frame := extractFrame(samples, start, cfg.FrameSize)
+			applyWindow(frame, window)
+			spectra[c] = FFT(realToComplex(frame))
+		}
+		s.process(spectra...)
+	}
+	s.prevPhase = nil
+}
+
+// onsetRiseDB is how much louder than the hop-length block before it a
+// block must be to count as a loud onset for LookAheadMs.
+const onsetRiseDB = 12
+
+// lookAheadFrames returns cfg.LookAheadMs at sampleRate in whole hops.
+func lookAheadFrames(cfg DenoiseConfig, sampleRate int) int {
+	return int(math.Ceil(cfg.LookAheadMs / 1000 * float64(sampleRate) / float64(cfg.HopSize)))
+}
+
+// openFrames returns, for each frame of the channels, whether gain
+// smoothing must be skipped so that a loud onset is not attenuated while
+// the smoothed gain catches up: frames containing an onset, and the
+// LookAheadMs of frames before them. The whole input is at hand, so the
+// look-ahead adds no delay here; a streaming implementation would have to
+// buffer its output by LookAheadMs instead.
+func openFrames(channels [][]float64, sampleRate int, cfg DenoiseConfig) []bool {
+	n := len(channels[0])
+	hop := cfg.HopSize
+	open := make([]bool, frameCount(n, cfg))
+	lookAhead := lookAheadFrames(cfg, sampleRate)
+	rise := math.Pow(10, onsetRiseDB/10.0)
+
+	var prev float64
+	for b := 0; b*hop < n; b++ {
+		var energy float64
+		for _, ch := range channels {
+			for _, v := range ch[b*hop : min((b+1)*hop, n)] {
+				energy += v * v
+			}
+		}
+		if b > 0 && energy > rise*prev {
+			// Frames whose span covers the block start, plus the look-ahead.
+			first := (b*hop-cfg.FrameSize)/hop + 1 - lookAhead
+			for fi := max(first, 0); fi <= b && fi < len(open); fi++ {
+				open[fi] = true
+			}
+		}
+		prev = energy
+	}
+	return open
+}
+
+// subtractFrames runs sub over every frame of the channels (of equal length,
+// at least cfg.FrameSize) and reconstructs them by overlap-add. If onFrame
+// is non-nil it is called with each frame's gains. It stops with ctx.Err()
+// once ctx is done.
+func subtractFrames(ctx context.Context, channels [][]float64, sub *subtractor, window []float64, cfg DenoiseConfig, onFrame func(fi int, gains []float64)) ([][]float64, error) {
+	n := len(channels[0])
+	frameSize := cfg.FrameSize
+	hopSize := cfg.HopSize
+	totalFrames := frameCount(n, cfg)
+
+	var open []bool
+	if cfg.LookAheadMs > 0 {
+		open = openFrames(channels, sub.sampleRate, cfg)
+	}
+
+	// With PreserveDC each channel's offset is taken out here and restored
+	// after reconstruction. Passing bin 0 through alone would not do: the
+	// window spreads DC into the neighbouring bins.
+	var offsets []float64
+	if cfg.PreserveDC && cfg.HighPassHz == 0 {
+		offsets = make([]float64, len(channels))
+		centered := make([][]float64, len(channels))
+		for c, ch := range channels {
+			offsets[c] = mean(ch)
+			centered[c] = make([]float64, n)
+			for i, v := range ch {
+				centered[c][i] = v - offsets[c]
+			}
+		}
+		channels = centered
+	}
+
+	if cfg.WarmUp {
+		sub.warmUp(channels, leadingNoiseEnd(n, cfg), window)
+	}
+
+	// ---------------------------------------------------------------
+	// Step 2: Process every frame via spectral subtraction.
+	// ---------------------------------------------------------------
+	adders := make([]*overlapAdder, len(channels))
+	for c := range adders {
+		adders[c] = newOverlapAdder(n, window)
+	}
+	spectra := make([][]complex128, len(channels))
+
+	for fi := 0; fi < totalFrames; fi++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		start := fi * hopSize
+
+		// Extract, window and transform each channel's frame.
+		for c, samples := range channels {
+			frame := extractFrame(samples, start, frameSize)
+			applyWindow(frame, window)
+			spectra[c] = FFT(realToComplex(frame))
+		}
+
+		sub.open = open != nil && open[fi]
+		gains := sub.process(spectra...)
+		if onFrame != nil {
+			onFrame(fi, gains)
+		}
+
+		// Inverse FFT and overlap-add with synthesis window.
+		for c, spectrum := range spectra {
+			adders[c].add(start, IFFT(spectrum))
+		}
+	}
+
+	// ---------------------------------------------------------------
+	// Step 3: Normalize by the accumulated window energy.
+	// ---------------------------------------------------------------
+	outputs := make([][]float64, len(channels))
+	for c, ola := range adders {
+		outputs[c] = ola.finish()
+		if offsets != nil {
+			for i := range outputs[c] {
+				outputs[c][i] += offsets[c]
+			}
+		}
+	}
+	return outputs, nil
+}
+
+// finishOutput applies the edge fades and output normalization to the
+// channels. Normalization uses one gain for all channels so their balance
+// is preserved. inputPeak is the ceiling PreserveHeadroom holds the
+// output to.
+func finishOutput(channels [][]float64, inputPeak float64, sampleRate int, cfg DenoiseConfig) {
+	// Fade the edges so processed clips start and end without a click.
+	for _, output := range channels {
+		applyFade(output, msToSamples(cfg.FadeInMs, sampleRate), msToSamples(cfg.FadeOutMs, sampleRate))
+	}
+
+	// ---------------------------------------------------------------
+	// Step 4: Output normalization (see NormalizeMode). By default
+	// scale so the loudest sample hits the target level, maximizing
+	// voice volume without clipping.
+	// ---------------------------------------------------------------
+	switch {
+	case cfg.PreserveHeadroom:
+		if peak := peakLevel(channels...); peak > inputPeak {
+			scale(inputPeak/peak, channels...)
+		}
+	case cfg.NormalizeMode == NormalizePeak:
+		scale(peakGain(PeakTarget, cfg.TruePeak, channels...), channels...)
+	case cfg.NormalizeMode == NormalizeRMS:
+		scale(rmsGain(RMSTarget, PeakTarget, cfg.TruePeak, channels...), channels...)
+	case cfg.NormalizeMode == NormalizeRolling:
+		rn := newRollingNormalizer(sampleRate)
+		if cfg.WarmUp {
+			rn.prime(cfg.HopSize, channels...)
+		}
+		rn.process(channels...)
+	}
+}
+
+// spectralSubtract removes the noise profile from every frame of samples
+// and reconstructs the result by overlap-add, reporting each frame to
+// progress (if non-nil). len(samples) must be at least cfg.FrameSize.
+func spectralSubtract(ctx context.Context, samples []float64, sampleRate int, noise *NoiseProfile, window []float64, cfg DenoiseConfig, progress DenoiseProgress) ([]float64, error) {
+	var onFrame func(fi int, gains []float64)
+	if progress != nil {
+		total := frameCount(len(samples), cfg)
+		onFrame = func(fi int, _ []float64) { progress(fi+1, total) }
+	}
+	outputs, err := subtractFrames(ctx, [][]float64{samples}, newSubtractor(noise, sampleRate, cfg), window, cfg, onFrame)
+	if err != nil {
+		return nil, err
+	}
+	finishOutput(outputs, peakLevel(samples), sampleRate, cfg)
+	return outputs[0], nil
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"slices"
+	"testing"
+)
+
+func TestPhaseEstimateBySNR(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize, cfg.HopSize = 64, 32
+	cfg.PhaseEstimate = true
+	cfg.PhaseSNRThresholdDB = 6
+
+	bins := cfg.FrameSize
+	noise := &NoiseProfile{Mean: make([]float64, bins), Variance: make([]float64, bins)}
+	for k := range noise.Mean {
+		noise.Mean[k] = 1
+	}
+	sub := newSubtractor(noise, 16000, cfg)
+
+	// Bin 5 is far above the noise; bin 9 is within the threshold.
+	const loud, quiet = 5, 9
+	frame := func(phase float64) []complex128 {
+		spectrum := make([]complex128, bins)
+		spectrum[loud] = cmplx.Rect(100, phase)
+		spectrum[quiet] = cmplx.Rect(1.5, phase)
+		return spectrum
+	}
+
+	first := frame(0.3)
+	sub.process(first)
+	second := frame(-1.1)
+	sub.process(second)
+
+	if got := cmplx.Phase(second[loud]); math.Abs(got-(-1.1)) > 1e-9 {
+		t.Errorf("high-SNR bin: expected noisy phase -1.1, got %.4f", got)
+	}
+	advance := 2 * math.Pi * float64(quiet*cfg.HopSize) / float64(cfg.FrameSize)
+	want := cmplx.Phase(cmplx.Rect(1, cmplx.Phase(first[quiet])+advance))
+	if got := cmplx.Phase(second[quiet]); math.Abs(got-want) > 1e-9 {
+		t.Errorf("low-SNR bin: expected predicted phase %.4f, got %.4f", want, got)
+	}
+	if got := cmplx.Phase(first[quiet]); math.Abs(got-0.3) > 1e-9 {
+		t.Errorf("first frame: expected noisy phase 0.3, got %.4f", got)
+	}
+}
+
+func TestDCAndNyquistStayReal(t *testing.T) {
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize, cfg.HopSize = 64, 32
+	half := cfg.FrameSize / 2
+	noise := &NoiseProfile{Mean: make([]float64, cfg.FrameSize), Variance: make([]float64, cfg.FrameSize)}
+	for k := range noise.Mean {
+		noise.Mean[k] = 0.1
+	}
+	sub := newSubtractor(noise, 16000, cfg)
+
+	spectrum := make([]complex128, cfg.FrameSize)
+	spectrum[0], spectrum[half] = -3, -2 // phase π
+	sub.process(spectrum)
+	for _, k := range []int{0, half} {
+		if imag(spectrum[k]) != 0 || real(spectrum[k]) >= 0 {
+			t.Fatalf("bin %d: expected a negative real value, got %v", k, spectrum[k])
+		}
+	}
+}
+
+func TestDenoiseDCArtifact(t *testing.T) {
+	sampleRate := 16000
+	n := sampleRate * 3
+	tone := pseudoNoise(n, 5, 0.05)
+	for i := sampleRate; i < n; i++ {
+		tone[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+
+	// The mean over 660 whole periods of the tone, away from the edges.
+	mean := func(x []float64) float64 {
+		var sum float64
+		seg := x[sampleRate : n-sampleRate/2]
+		for _, v := range seg {
+			sum += v
+		}
+		return sum / float64(len(seg))
+	}
+
+	// A DC-free tone gains no DC.
+	cleaned, err := DenoiseWithConfig(tone, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dc := mean(cleaned); math.Abs(dc) > 1e-3 {
+		t.Fatalf("expected negligible DC from a DC-free tone, got %.5f (input %.5f)", dc, mean(tone))
+	}
+
+	// An offset is subtracted with the noise unless PreserveDC is set.
+	offset := make([]float64, n)
+	for i, v := range tone {
+		offset[i] = v + 0.2
+	}
+	removed, err := DenoiseWithConfig(offset, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PreserveDC = true
+	kept, err := DenoiseWithConfig(offset, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("DC: tone %.5f, offset removed %.4f, preserved %.4f", mean(cleaned), mean(removed), mean(kept))
+	if math.Abs(mean(kept)-0.2) > 0.01 {
+		t.Fatalf("expected PreserveDC to keep the 0.2 offset, got %.4f", mean(kept))
+	}
+	if mean(removed) > 0.05 {
+		t.Fatalf("expected the offset to be subtracted, got %.4f", mean(removed))
+	}
+}
+
+func TestAdaptiveFloorFollowsSNR(t *testing.T) {
+	sampleRate := 16000
+	n := sampleRate * 2
+	samples := pseudoNoise(n, 21, 0.05)
+	for i := sampleRate; i < n; i++ {
+		samples[i] += 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize, cfg.HopSize, cfg.NoiseFrames = 512, 256, 20
+	cfg.AdaptiveFloorMin, cfg.AdaptiveFloorMax = 0.005, 0.2
+	window := HannWindow(cfg.FrameSize)
+	noise := estimateNoise(samples[:leadingNoiseEnd(n, cfg)], window, cfg)
+	sub := newSubtractor(noise, sampleRate, cfg)
+
+	// Mean effective floor over the frames starting in [from, to).
+	meanFloor := func(from, to int) float64 {
+		var sum float64
+		var count int
+		for start := from; start < to; start += cfg.HopSize {
+			frame := extractFrame(samples, start, cfg.FrameSize)
+			applyWindow(frame, window)
+			sub.process(FFT(realToComplex(frame)))
+			sum += sub.floor
+			count++
+		}
+		return sum / float64(count)
+	}
+	quiet := meanFloor(0, sampleRate-cfg.FrameSize)
+	voiced := meanFloor(sampleRate, n-cfg.FrameSize)
+	t.Logf("effective floor: noise %.4f, tone %.4f", quiet, voiced)
+	if quiet > 2*cfg.AdaptiveFloorMin || voiced < cfg.AdaptiveFloorMax/2 || voiced > cfg.AdaptiveFloorMax {
+		t.Fatalf("expected a floor near %g in noise and near %g in the tone, got %.4f and %.4f",
+			cfg.AdaptiveFloorMin, cfg.AdaptiveFloorMax, quiet, voiced)
+	}
+
+	// Against a fixed floor at either bound, the noise-only passage is
+	// reduced as far as the low bound does, and the tone kept as well as
+	// the high bound keeps it.
+	cfg.NormalizeMode = NormalizeNone
+	run := func(c DenoiseConfig) (residual, tone float64) {
+		out, err := DenoiseWithConfig(samples, sampleRate, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rms(out[cfg.FrameSize : sampleRate-cfg.FrameSize]), rms(out[sampleRate+cfg.FrameSize : n-cfg.FrameSize])
+	}
+	adaptiveResidual, adaptiveTone := run(cfg)
+	fixed := cfg
+	fixed.AdaptiveFloorMax = 0
+	fixed.SpectralFloor = cfg.AdaptiveFloorMax
+	highResidual, highTone := run(fixed)
+	fixed.SpectralFloor = cfg.AdaptiveFloorMin
+	lowResidual, _ := run(fixed)
+	t.Logf("noise residual: adaptive %.5f, low floor %.5f, high floor %.5f", adaptiveResidual, lowResidual, highResidual)
+	t.Logf("tone level: adaptive %.4f, high floor %.4f", adaptiveTone, highTone)
+	if adaptiveResidual > 1.1*lowResidual || adaptiveResidual > highResidual/2 {
+		t.Fatalf("expected the adaptive floor to reduce noise like the low floor: adaptive %.5f, low %.5f, high %.5f",
+			adaptiveResidual, lowResidual, highResidual)
+	}
+	if math.Abs(adaptiveTone/highTone-1) > 0.05 {
+		t.Fatalf("expected the adaptive floor to keep the tone like the high floor: adaptive %.4f, high %.4f", adaptiveTone, highTone)
+	}
+}
+
+func TestWhiteningReducesMusicalNoise(t *testing.T) {
+	sampleRate := 16000
+	n := 4 * sampleRate
+
+	// Pink-ish noise: white noise through a one-pole low-pass, so the
+	// noise falls steeply with frequency. A tone in the second second.
+	white := pseudoNoise(n, 9, 0.05)
+	samples := make([]float64, n)
+	var y float64
+	for i, v := range white {
+		y = 0.95*y + v
+		samples[i] = 0.3 * y
+	}
+	for i := sampleRate; i < 2*sampleRate; i++ {
+		samples[i] += 0.3 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	cfg.FloorMode = FloorComfortNoise
+	noise := estimateNoise(samples[:leadingNoiseEnd(n, cfg)], HannWindow(cfg.FrameSize), cfg)
+	whitening := &WhiteningFilter{Gains: make([]float64, cfg.FrameSize/2+1)}
+	for k := range whitening.Gains {
+		whitening.Gains[k] = 1 / noise.Mean[k]
+	}
+
+	// The noise-only stretch after the tone.
+	score := func(c DenoiseConfig) float64 {
+		out, err := DenoiseWithConfig(samples, sampleRate, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return MusicalNoiseScore(out[2*sampleRate+cfg.FrameSize:n-cfg.FrameSize], 512)
+	}
+	plain := score(cfg)
+	cfg.Whitening = whitening
+	whitened := score(cfg)
+	t.Logf("musical noise score: plain %.2f, whitened %.2f", plain, whitened)
+	if whitened >= plain {
+		t.Fatalf("expected whitened-domain subtraction to leave less musical noise: plain %.2f, whitened %.2f", plain, whitened)
+	}
+
+	// Per-bin subtraction on its own is unaffected by whitening.
+	cfg.FloorMode = FloorConstant
+	cfg.Whitening = nil
+	want, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Whitening = whitening
+	got, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > 1e-9 {
+			t.Fatalf("constant floor: sample %d is %v with whitening, %v without", i, got[i], want[i])
+		}
+	}
+
+	cfg.Whitening = &WhiteningFilter{Gains: whitening.Gains[1:]}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for whitening gains of the wrong length")
+	}
+}
+
+func TestGateBelowNoiseReducesFlicker(t *testing.T) {
+	sampleRate := 16000
+	samples := pseudoNoise(4*sampleRate, 31, 0.05)
+	cfg := DefaultDenoiseConfig()
+	cfg.SubtractionExponent = 2
+
+	// Bin-frame transitions between floored and passed gains over the
+	// noise-only input.
+	flips := func(gate bool) int {
+		c := cfg
+		c.GateBelowNoise = gate
+		window := HannWindow(c.FrameSize)
+		noise := estimateNoise(samples[:leadingNoiseEnd(len(samples), c)], window, c)
+		var prev []bool
+		count := 0
+		_, err := subtractFrames(context.Background(), [][]float64{samples}, newSubtractor(noise, sampleRate, c), window, c, func(_ int, gains []float64) {
+			passed := make([]bool, len(gains))
+			for k, g := range gains {
+				passed[k] = g > c.SpectralFloor+1e-12
+				if prev != nil && passed[k] != prev[k] {
+					count++
+				}
+			}
+			prev = passed
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+	plain, gated := flips(false), flips(true)
+	t.Logf("floor transitions on noise: plain %d, gated %d", plain, gated)
+	if gated*2 > plain {
+		t.Fatalf("expected gating to at least halve the transitions: plain %d, gated %d", plain, gated)
+	}
+
+	// At an exponent of 1 gating changes nothing.
+	cfg.SubtractionExponent = 1
+	gatedCfg := cfg
+	gatedCfg.GateBelowNoise = true
+	want, _ := DenoiseWithConfig(samples, sampleRate, cfg)
+	got, _ := DenoiseWithConfig(samples, sampleRate, gatedCfg)
+	if !slices.Equal(got, want) {
+		t.Fatal("gating changed the output at an exponent of 1")
+	}
+}
+
+func TestMaskSmoothing2DReducesMusicalNoise(t *testing.T) {
+	sampleRate := 16000
+	n := 8 * sampleRate
+	samples := pseudoNoise(n, 41, 0.05)
+	for i := sampleRate; i < 2*sampleRate; i++ {
+		samples[i] += 0.3 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	type result struct{ score, residual, tone float64 }
+	run := func(frames, bins int) result {
+		cfg := DefaultDenoiseConfig()
+		cfg.NormalizeMode = NormalizeNone
+		cfg.MaskSmoothingFrames, cfg.MaskSmoothingBins = frames, bins
+		out, err := DenoiseWithConfig(samples, sampleRate, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		noise := out[2*sampleRate+cfg.FrameSize : n-cfg.FrameSize]
+		tone := out[sampleRate+cfg.FrameSize : 2*sampleRate-cfg.FrameSize]
+		return result{MusicalNoiseScore(noise, 512), rms(noise), toneAmplitude(tone, 440, sampleRate)}
+	}
+	timeOnly, freqOnly, both := run(3, 0), run(1, 1), run(3, 1)
+	t.Logf("time %+v, frequency %+v, 2D %+v", timeOnly, freqOnly, both)
+
+	for name, r := range map[string]result{"time": timeOnly, "frequency": freqOnly} {
+		if both.score >= r.score {
+			t.Errorf("2D smoothing scores %.2f, no better than %s alone at %.2f", both.score, name, r.score)
+		}
+		if r.residual > 2*both.residual || both.residual > 2*r.residual {
+			t.Errorf("residual noise not comparable: 2D %.5f, %s %.5f", both.residual, name, r.residual)
+		}
+	}
+	if both.tone < 0.95*0.3 {
+		t.Errorf("2D smoothing left %.4f of the 0.3 tone", both.tone)
+	}
+}
+
+func TestShapedComfortNoiseFollowsNoiseSpectrum(t *testing.T) {
+	sampleRate := 16000
+	n := 4 * sampleRate
+	// Colored noise: white noise through a one-pole low-pass.
+	white := pseudoNoise(n, 13, 0.05)
+	samples := make([]float64, n)
+	var y float64
+	for i, v := range white {
+		y = 0.9*y + v
+		samples[i] = 0.3 * y
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.NormalizeMode = NormalizeNone
+	cfg.FloorMode = FloorComfortNoise
+	cfg.OverSubtract = 4              // floor nearly every bin
+	cfg.NoiseFrames = 2 * NoiseFrames // a steady profile to follow
+	window := HannWindow(cfg.FrameSize)
+	noise := estimateNoise(samples[:leadingNoiseEnd(n, cfg)], window, cfg)
+
+	// Correlation of the residual's log spectrum with the noise profile's,
+	// over the bins above DC.
+	shapeMatch := func(c DenoiseConfig) float64 {
+		out, err := DenoiseWithConfig(samples, sampleRate, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		residual := estimateNoise(out[cfg.FrameSize:n-cfg.FrameSize], window, cfg)
+		bins := cfg.FrameSize / 2
+		a, b := make([]float64, bins), make([]float64, bins)
+		for k := 1; k <= bins; k++ {
+			a[k-1], b[k-1] = math.Log(residual.Mean[k]), math.Log(noise.Mean[k])
+		}
+		ma, mb := mean(a), mean(b)
+		for i := range a {
+			a[i] -= ma
+			b[i] -= mb
+		}
+		return correlation(a, b)
+	}
+	flat := shapeMatch(cfg)
+	cfg.ShapeComfortNoise = true
+	shaped := shapeMatch(cfg)
+	t.Logf("log-spectrum correlation with the noise: flat %.3f, shaped %.3f", flat, shaped)
+	if shaped < 0.9 || shaped <= flat {
+		t.Fatalf("expected shaped comfort noise to follow the noise spectrum: flat %.3f, shaped %.3f", flat, shaped)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "math"
+
+// Parameters of the clip SyntheticNoisyTone generates.
+const (
+	syntheticLeadSeconds = 0.5   // noise-only lead-in, for the noise estimate
+	syntheticToneHz      = 440   // tone frequency
+	syntheticToneAmp     = 0.3   // tone amplitude
+	syntheticNoiseAmp    = 0.05  // peak noise amplitude
+	syntheticNoiseSeed   = 12345 // xorshift seed, fixed so clips are reproducible
+)
+
+// pseudoNoise returns n samples of deterministic xorshift noise in [-amp, amp].
+func pseudoNoise(n int, seed uint32, amp float64) []float64 {
+	out := make([]float64, n)
+	state := seed
+	for i := range out {
+		state ^= state << 13
+		state ^= state >> 17
+		state ^= state << 5
+		out[i] = (float64(int32(state)) / float64(math.MaxInt32)) * amp
+	}
+	return out
+}
+
+// SyntheticNoisyTone returns n samples at sampleRate of a test clip for
+// demonstrating the denoiser: white noise throughout, with a steady tone
+// added after a noise-only lead-in. The output is the same on every call.
+func SyntheticNoisyTone(n, sampleRate int) []float64 {
+	out := pseudoNoise(n, syntheticNoiseSeed, syntheticNoiseAmp)
+	for i := int(syntheticLeadSeconds * float64(sampleRate)); i < n; i++ {
+		out[i] += syntheticToneAmp * math.Sin(2*math.Pi*syntheticToneHz*float64(i)/float64(sampleRate))
+	}
+	return out
+}
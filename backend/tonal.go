@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"slices"
+)
+
+// Tonal interference detection parameters (see SuppressTonalInterference).
+const (
+	// tonalResolutionHz is the frequency resolution aimed for: fine enough
+	// that a notch removes little besides the tone.
+	tonalResolutionHz = 8
+	// tonalPeakDB is how far above the median of its neighbourhood a bin
+	// must stand to count as a narrow peak in a frame.
+	tonalPeakDB = 15
+	// tonalNeighbourhood is the number of bins either side of a bin whose
+	// median sets its local floor.
+	tonalNeighbourhood = 16
+	// tonalPersistence is the fraction of frames a peak must appear in to
+	// count as interference rather than program material.
+	tonalPersistence = 0.9
+	// tonalNotchBins is the number of bins either side of a peak that are
+	// notched, covering the main lobe of the Hann window.
+	tonalNotchBins = 2
+)
+
+// SuppressTonalInterference removes steady narrow tones, such as a fan
+// whine or CRT flyback, that broadband spectral subtraction misses. Bins
+// that stand out as narrow peaks in at least 90% of frames are notched
+// down to their neighbourhood's level in every frame. Sounds that come and
+// go, like voice, are left alone; a musical note held for the whole
+// recording would be removed too. Returns a new slice; input shorter than
+// one analysis frame is returned unchanged (copied).
+func SuppressTonalInterference(samples []float64, sampleRate int) []float64 {
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize = NextPowerOf2(max(sampleRate/tonalResolutionHz, minFrameSize))
+	cfg.HopSize = cfg.FrameSize / 2
+	if sampleRate <= 0 || len(samples) < cfg.FrameSize {
+		return slices.Clone(samples)
+	}
+
+	spec, err := STFT(samples, cfg)
+	if err != nil {
+		return slices.Clone(samples)
+	}
+	power := spec.Power()
+	bins := spec.Bins()
+
+	// Local floor of every bin of every frame, and how often each bin is
+	// a narrow peak above it.
+	floors := make([][]float64, len(power))
+	counts := make([]int, bins)
+	hood := make([]float64, 0, 2*tonalNeighbourhood+1)
+	threshold := math.Pow(10, tonalPeakDB/10.0)
+	for fi, p := range power {
+		floors[fi] = make([]float64, bins)
+		for k := range p {
+			hood = append(hood[:0], p[max(k-tonalNeighbourhood, 0):min(k+tonalNeighbourhood+1, bins)]...)
+			slices.Sort(hood)
+			floors[fi][k] = hood[len(hood)/2]
+		}
+		for k := 1; k < bins-1; k++ {
+			if p[k] > p[k-1] && p[k] >= p[k+1] && p[k] > threshold*floors[fi][k] {
+				counts[k]++
+			}
+		}
+	}
+
+	// Notch the persistent peaks, keeping each bin's phase.
+	notch := make([]bool, bins)
+	for k, c := range counts {
+		if float64(c) >= tonalPersistence*float64(len(power)) {
+			for j := max(k-tonalNotchBins, 1); j <= min(k+tonalNotchBins, bins-1); j++ {
+				notch[j] = true
+			}
+		}
+	}
+	for fi, frame := range spec.Frames {
+		for k, ok := range notch {
+			if !ok || power[fi][k] <= floors[fi][k] {
+				continue
+			}
+			frame[k] = cmplx.Rect(math.Sqrt(floors[fi][k]), cmplx.Phase(frame[k]))
+			if k < cfg.FrameSize/2 {
+				frame[cfg.FrameSize-k] = cmplx.Conj(frame[k])
+			} else {
+				frame[k] = complex(real(frame[k]), 0)
+			}
+		}
+	}
+
+	out, err := ISTFT(spec, len(samples), cfg)
+	if err != nil {
+		return slices.Clone(samples)
+	}
+	return out
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+// toneAmplitude returns the amplitude of the freq Hz component of x.
+func toneAmplitude(x []float64, freq float64, sampleRate int) float64 {
+	var sum complex128
+	for i, v := range x {
+		sum += complex(v, 0) * cmplx.Rect(1, -2*math.Pi*freq*float64(i)/float64(sampleRate))
+	}
+	return 2 * cmplx.Abs(sum) / float64(len(x))
+}
+
+func TestSuppressTonalInterference(t *testing.T) {
+	sampleRate := 16000
+	n := sampleRate * 4
+	samples := pseudoNoise(n, 64, 0.02)
+	voice := make([]float64, n)
+	for i := range samples {
+		// A steady 3 kHz whine throughout; a 440 Hz tone that comes and
+		// goes every half second, like speech.
+		samples[i] += 0.1 * math.Sin(2*math.Pi*3000*float64(i)/float64(sampleRate))
+		if (i/(sampleRate/2))%2 == 1 {
+			voice[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+			samples[i] += voice[i]
+		}
+	}
+
+	out := SuppressTonalInterference(samples, sampleRate)
+	if len(out) != n {
+		t.Fatalf("expected %d samples, got %d", n, len(out))
+	}
+	inner := func(x []float64) []float64 { return x[sampleRate/2 : n-sampleRate/2] }
+	whineBefore, whineAfter := toneAmplitude(inner(samples), 3000, sampleRate), toneAmplitude(inner(out), 3000, sampleRate)
+	toneBefore, toneAfter := toneAmplitude(inner(samples), 440, sampleRate), toneAmplitude(inner(out), 440, sampleRate)
+	t.Logf("3 kHz whine %.4f -> %.4f, 440 Hz tone %.4f -> %.4f", whineBefore, whineAfter, toneBefore, toneAfter)
+
+	if reduction := 20 * math.Log10(whineBefore/whineAfter); reduction < 20 {
+		t.Fatalf("expected the whine suppressed by at least 20 dB, got %.1f dB", reduction)
+	}
+	if change := 20 * math.Log10(toneAfter/toneBefore); math.Abs(change) > 0.5 {
+		t.Fatalf("expected the tone to survive within 0.5 dB, changed %.2f dB", change)
+	}
+	if c := correlation(inner(voice), inner(out)); c < 0.95 {
+		t.Fatalf("expected the output to follow the tone, correlation %.3f", c)
+	}
+
+	// Without a steady tone nothing is notched.
+	plain := pseudoNoise(n, 65, 0.1)
+	var diff float64
+	for i, v := range SuppressTonalInterference(plain, sampleRate)[sampleRate/2 : n-sampleRate/2] {
+		diff = math.Max(diff, math.Abs(v-plain[sampleRate/2+i]))
+	}
+	if diff > 1e-9 {
+		t.Fatalf("noise without tones was altered by up to %g", diff)
+	}
+}
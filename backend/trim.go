@@ -0,0 +1,114 @@
+package main
+
+// TrimConfig controls TrimSilence.
+type TrimConfig struct {
+	VAD VADConfig
+	// PadMs is how much silence to keep before the first and after the
+	// last voiced frame.
+	PadMs float64
+	// Internal enables shortening silences between voiced regions.
+	Internal bool
+	// MaxGapMs is the longest internal silence kept when Internal is set;
+	// longer gaps are shortened to this length.
+	MaxGapMs float64
+}
+
+// DefaultTrimConfig returns edge-only trimming with 50 ms of padding.
+func DefaultTrimConfig() TrimConfig {
+	return TrimConfig{
+		VAD:      DefaultVADConfig(),
+		PadMs:    50,
+		MaxGapMs: 500,
+	}
+}
+
+// TrimResult is the output of TrimSilence.
+type TrimResult struct {
+	Samples []float64
+	// LeadingRemoved, TrailingRemoved and InternalRemoved are the number of
+	// samples cut from each part of the input.
+	LeadingRemoved  int
+	TrailingRemoved int
+	InternalRemoved int
+}
+
+// Removed returns the total number of samples cut.
+func (t TrimResult) Removed() int {
+	return t.LeadingRemoved + t.TrailingRemoved + t.InternalRemoved
+}
+
+// TrimSilence removes leading and trailing silence found by DetectVoice,
+// keeping cfg.PadMs next to the voice, and with cfg.Internal shortens
+// internal silences to cfg.MaxGapMs. Input with no voice is removed entirely.
+func TrimSilence(samples []float64, sampleRate int, cfg TrimConfig) TrimResult {
+	voiced := DetectVoice(samples, sampleRate, cfg.VAD)
+	frameLen := vadFrameLen(sampleRate, cfg.VAD)
+
+	first, last := -1, -1
+	for fi, v := range voiced {
+		if v {
+			if first < 0 {
+				first = fi
+			}
+			last = fi
+		}
+	}
+	if first < 0 {
+		return TrimResult{Samples: []float64{}, LeadingRemoved: len(samples)}
+	}
+
+	pad := msToSamples(cfg.PadMs, sampleRate)
+	start := first*frameLen - pad
+	if start < 0 {
+		start = 0
+	}
+	end := (last+1)*frameLen + pad
+	if end > len(samples) {
+		end = len(samples)
+	}
+
+	result := TrimResult{
+		LeadingRemoved:  start,
+		TrailingRemoved: len(samples) - end,
+	}
+	if !cfg.Internal {
+		result.Samples = append([]float64(nil), samples[start:end]...)
+		return result
+	}
+
+	// Walk the voiced span, shortening each silent run longer than maxGap
+	// by dropping its middle.
+	maxGap := msToSamples(cfg.MaxGapMs, sampleRate)
+	out := make([]float64, 0, end-start)
+	pos := start
+	for fi := first; fi <= last; {
+		if voiced[fi] {
+			fi++
+			continue
+		}
+		gapFrom := fi
+		for fi <= last && !voiced[fi] {
+			fi++
+		}
+		gapStart, gapEnd := gapFrom*frameLen, fi*frameLen
+		if gapEnd-gapStart <= maxGap {
+			continue
+		}
+		keepHead := maxGap / 2
+		keepTail := maxGap - keepHead
+		out = append(out, samples[pos:gapStart+keepHead]...)
+		pos = gapEnd - keepTail
+		result.InternalRemoved += (gapEnd - gapStart) - maxGap
+	}
+	out = append(out, samples[pos:end]...)
+	result.Samples = fitLength(out, len(samples)-result.Removed())
+	return result
+}
+
+// msToSamples converts a duration in milliseconds to a sample count.
+func msToSamples(ms float64, sampleRate int) int {
+	if ms <= 0 {
+		return 0
+	}
+	return int(ms * float64(sampleRate) / 1000)
+}
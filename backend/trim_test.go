@@ -0,0 +1,83 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrimSilenceInternal(t *testing.T) {
+	sampleRate := 16000
+	tone := func(n int) []float64 {
+		s := make([]float64, n)
+		for i := range s {
+			s[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+		}
+		return s
+	}
+
+	// 1 s tone, 2 s silence, 1 s tone.
+	var samples []float64
+	samples = append(samples, tone(sampleRate)...)
+	samples = append(samples, make([]float64, 2*sampleRate)...)
+	samples = append(samples, tone(sampleRate)...)
+
+	cfg := DefaultTrimConfig()
+	edgesOnly := TrimSilence(samples, sampleRate, cfg)
+	if edgesOnly.Removed() != 0 {
+		t.Fatalf("expected nothing removed without internal trimming, got %d", edgesOnly.Removed())
+	}
+
+	cfg.Internal = true
+	cfg.MaxGapMs = 200
+	trimmed := TrimSilence(samples, sampleRate, cfg)
+	wantRemoved := 2*sampleRate - sampleRate/5
+	if trimmed.InternalRemoved != wantRemoved {
+		t.Fatalf("expected %d internal samples removed, got %d", wantRemoved, trimmed.InternalRemoved)
+	}
+	if len(trimmed.Samples) != len(samples)-wantRemoved {
+		t.Fatalf("expected %d samples, got %d", len(samples)-wantRemoved, len(trimmed.Samples))
+	}
+}
+
+func TestHandleTrim(t *testing.T) {
+	sampleRate := 16000
+	toneLen := sampleRate // 1 s
+
+	// 1 s silence, 1 s tone, 1 s silence.
+	samples := make([]float64, 3*sampleRate)
+	for i := 0; i < toneLen; i++ {
+		samples[sampleRate+i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	req := newUploadRequest(t, http.MethodPost, "/trim", WriteWAV(samples, sampleRate),
+		map[string]string{"pad_ms": "0"})
+	rec := httptest.NewRecorder()
+	handleTrim(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	out, sr, err := ReadWAV(rec.Body.Bytes())
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != sampleRate {
+		t.Fatalf("sample rate mismatch: %d", sr)
+	}
+	if len(out) >= len(samples) {
+		t.Fatalf("expected shorter output, got %d samples from %d", len(out), len(samples))
+	}
+	if len(out) != toneLen {
+		t.Fatalf("expected %d samples of tone, got %d", toneLen, len(out))
+	}
+	for i := range out {
+		if math.Abs(out[i]-samples[sampleRate+i]) > 0.001 {
+			t.Fatalf("tone sample %d altered: expected %.4f, got %.4f", i, samples[sampleRate+i], out[i])
+		}
+	}
+	if got := rec.Header().Get("X-Trim-Removed-Ms"); got != "2000.0" {
+		t.Fatalf("expected X-Trim-Removed-Ms 2000.0, got %q", got)
+	}
+}
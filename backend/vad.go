@@ -0,0 +1,61 @@
+package main
+
+import "math"
+
+// VADConfig controls the energy-based voice activity detector.
+type VADConfig struct {
+	// FrameMs is the analysis frame length in milliseconds.
+	FrameMs float64
+	// ThresholdDB is the level, relative to the loudest frame, below which
+	// a frame is classified as silence. Must be negative.
+	ThresholdDB float64
+}
+
+// DefaultVADConfig returns 20 ms frames with a -40 dB threshold.
+func DefaultVADConfig() VADConfig {
+	return VADConfig{
+		FrameMs:     20,
+		ThresholdDB: -40,
+	}
+}
+
+// vadFrameLen returns the VAD frame length in samples (at least 1).
+func vadFrameLen(sampleRate int, cfg VADConfig) int {
+	n := int(cfg.FrameMs * float64(sampleRate) / 1000)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// DetectVoice classifies consecutive, non-overlapping frames of samples as
+// voice (true) or silence (false). A frame is voice when its RMS is within
+// cfg.ThresholdDB of the loudest frame. The last frame may be partial.
+// Silent input yields all-false.
+func DetectVoice(samples []float64, sampleRate int, cfg VADConfig) []bool {
+	frameLen := vadFrameLen(sampleRate, cfg)
+	frames := (len(samples) + frameLen - 1) / frameLen
+
+	levels := make([]float64, frames)
+	var loudest float64
+	for fi := range levels {
+		end := (fi + 1) * frameLen
+		if end > len(samples) {
+			end = len(samples)
+		}
+		levels[fi] = rms(samples[fi*frameLen : end])
+		if levels[fi] > loudest {
+			loudest = levels[fi]
+		}
+	}
+
+	voiced := make([]bool, frames)
+	if loudest < 1e-10 {
+		return voiced
+	}
+	threshold := loudest * math.Pow(10, cfg.ThresholdDB/20)
+	for fi, level := range levels {
+		voiced[fi] = level >= threshold
+	}
+	return voiced
+}
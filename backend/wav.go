@@ -3,36 +3,822 @@ package main
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
 	"math"
+	"slices"
 )
 
 // WAVHeader holds metadata extracted from a WAV file.
 type WAVHeader struct {
-	SampleRate    int
-	NumChannels   int
-	BitsPerSample int
+	SampleRate    int `json:"sample_rate"`
+	NumChannels   int `json:"channels"`
+	BitsPerSample int `json:"bits_per_sample"`
+	// Float is set for IEEE float (format 3) sample data.
+	Float bool `json:"float,omitempty"`
+	// Companding is set for 8-bit G.711 telephony data: A-law (format 6)
+	// or mu-law (format 7).
+	Companding Companding `json:"companding,omitempty"`
+	// ADPCMBlockAlign is the size in bytes of each block of IMA ADPCM
+	// (format 17) data, and zero for other encodings.
+	ADPCMBlockAlign int `json:"adpcm_block_align,omitempty"`
+	// ValidBitsPerSample is the number of significant bits in each
+	// sample, as declared by a WAVE_FORMAT_EXTENSIBLE header, if fewer
+	// than BitsPerSample (e.g. 24-bit audio in 32-bit containers).
+	ValidBitsPerSample int `json:"valid_bits_per_sample,omitempty"`
+	// ChannelMask is the speaker position of each channel, as declared by
+	// a WAVE_FORMAT_EXTENSIBLE header (SPEAKER_FRONT_LEFT = 0x1 and so
+	// on), and zero if the file does not say. EncodeWAV writes an
+	// extensible header carrying it.
+	ChannelMask uint32 `json:"channel_mask,omitempty"`
+	// EffectivelyMono is set by ValidateWAV for stereo files whose
+	// channels are (nearly) identical, perfectly correlated at the same
+	// level, i.e. mono audio labeled as stereo.
+	EffectivelyMono bool `json:"effectively_mono,omitempty"`
+	// Sampler and Cue hold the file's smpl and cue chunks, if any, which
+	// sample libraries use for loop points. EncodeWAV writes them back.
+	Sampler *SamplerInfo `json:"sampler,omitempty"`
+	Cue     *CueList     `json:"cue,omitempty"`
+	// Extra holds the file's other metadata chunks, such as bext, iXML
+	// and LIST, which EncodeWAV writes back ahead of the data chunk.
+	Extra *ExtraChunks `json:"extra,omitempty"`
 }
 
-// ReadWAV parses a 16-bit PCM WAV file from raw bytes.
-// Returns samples normalized to [-1.0, +1.0] and the sample rate.
+// ExtraChunks is the metadata chunks of a WAV file this package does not
+// interpret, in file order.
+type ExtraChunks struct {
+	Chunks []WAVChunk `json:"chunks"`
+}
+
+// WAVChunk is a RIFF chunk kept verbatim.
+type WAVChunk struct {
+	ID   string `json:"id"`
+	Data []byte `json:"-"`
+}
+
+// isExtraChunk reports whether a chunk with the given ID is metadata to
+// keep in ExtraChunks: anything but the chunks that describe the audio
+// (and are rewritten on encoding), smpl and cue, which have fields of
+// their own, and padding.
+func isExtraChunk(id []byte) bool {
+	switch string(id) {
+	case "fmt ", "data", "fact", "ds64", "smpl", "cue ", "JUNK", "junk", "PAD ":
+		return false
+	}
+	return true
+}
+
+// appendChunk appends a chunk with the given ID and body to b, padded to
+// an even length.
+func appendChunk(b []byte, id string, body []byte) []byte {
+	b = binary.LittleEndian.AppendUint32(append(b, id...), uint32(len(body)))
+	b = append(b, body...)
+	if len(body)%2 != 0 {
+		b = append(b, 0) // padding byte
+	}
+	return b
+}
+
+// Companding is a G.711 companding law.
+type Companding string
+
+const (
+	ALaw  Companding = "alaw"
+	MuLaw Companding = "mulaw"
+)
+
+// SamplerInfo is the contents of a smpl chunk: how a sampler should play
+// the file, and the regions it loops.
+type SamplerInfo struct {
+	Manufacturer      uint32       `json:"manufacturer"`
+	Product           uint32       `json:"product"`
+	SamplePeriod      uint32       `json:"sample_period"` // nanoseconds per sample
+	MIDIUnityNote     uint32       `json:"midi_unity_note"`
+	MIDIPitchFraction uint32       `json:"midi_pitch_fraction"`
+	SMPTEFormat       uint32       `json:"smpte_format"`
+	SMPTEOffset       uint32       `json:"smpte_offset"`
+	Loops             []SampleLoop `json:"loops"`
+	// Data is the manufacturer-specific data after the loops, kept
+	// verbatim.
+	Data []byte `json:"-"`
+}
+
+// SampleLoop is one loop of a smpl chunk. Start and End are sample frame
+// offsets, End inclusive.
+type SampleLoop struct {
+	CueID     uint32 `json:"cue_id"`
+	Type      uint32 `json:"type"` // 0 forward, 1 alternating, 2 backward
+	Start     uint32 `json:"start"`
+	End       uint32 `json:"end"`
+	Fraction  uint32 `json:"fraction"`
+	PlayCount uint32 `json:"play_count"` // 0 loops forever
+}
+
+// CueList is the contents of a cue chunk.
+type CueList struct {
+	Points []CuePoint `json:"points"`
+}
+
+// CuePoint is one point of a cue chunk, at sample frame Position. Only
+// points into the data chunk are kept, as the sample data is all this
+// package reads.
+type CuePoint struct {
+	ID           uint32 `json:"id"`
+	Position     uint32 `json:"position"`
+	SampleOffset uint32 `json:"sample_offset"`
+}
+
+const (
+	smplHeaderSize = 36 // smpl chunk fields before the loops
+	smplLoopSize   = 24
+	cuePointSize   = 24
+)
+
+// parseSampler parses the body of a smpl chunk, returning nil if it is
+// malformed.
+func parseSampler(body []byte) *SamplerInfo {
+	if len(body) < smplHeaderSize {
+		return nil
+	}
+	u := func(off int) uint32 { return binary.LittleEndian.Uint32(body[off : off+4]) }
+	numLoops, dataSize := int(u(28)), int(u(32))
+	end := smplHeaderSize + numLoops*smplLoopSize
+	if numLoops < 0 || end > len(body) || dataSize > len(body)-end {
+		return nil
+	}
+	info := &SamplerInfo{
+		Manufacturer:      u(0),
+		Product:           u(4),
+		SamplePeriod:      u(8),
+		MIDIUnityNote:     u(12),
+		MIDIPitchFraction: u(16),
+		SMPTEFormat:       u(20),
+		SMPTEOffset:       u(24),
+		Loops:             make([]SampleLoop, numLoops),
+		Data:              slices.Clone(body[end : end+dataSize]),
+	}
+	for i := range info.Loops {
+		off := smplHeaderSize + i*smplLoopSize
+		info.Loops[i] = SampleLoop{
+			CueID:     u(off),
+			Type:      u(off + 4),
+			Start:     u(off + 8),
+			End:       u(off + 12),
+			Fraction:  u(off + 16),
+			PlayCount: u(off + 20),
+		}
+	}
+	return info
+}
+
+// parseCue parses the body of a cue chunk, returning nil if it is
+// malformed.
+func parseCue(body []byte) *CueList {
+	if len(body) < 4 {
+		return nil
+	}
+	n := int(binary.LittleEndian.Uint32(body))
+	if n < 0 || n > (len(body)-4)/cuePointSize {
+		return nil
+	}
+	cue := &CueList{Points: []CuePoint{}}
+	for i := 0; i < n; i++ {
+		p := body[4+i*cuePointSize:]
+		if string(p[8:12]) != "data" {
+			continue
+		}
+		cue.Points = append(cue.Points, CuePoint{
+			ID:           binary.LittleEndian.Uint32(p[0:4]),
+			Position:     binary.LittleEndian.Uint32(p[4:8]),
+			SampleOffset: binary.LittleEndian.Uint32(p[20:24]),
+		})
+	}
+	return cue
+}
+
+// appendSampler appends info to b as a smpl chunk.
+func appendSampler(b []byte, info *SamplerInfo) []byte {
+	size := smplHeaderSize + len(info.Loops)*smplLoopSize + len(info.Data)
+	b = binary.LittleEndian.AppendUint32(append(b, "smpl"...), uint32(size))
+	for _, v := range []uint32{
+		info.Manufacturer, info.Product, info.SamplePeriod, info.MIDIUnityNote,
+		info.MIDIPitchFraction, info.SMPTEFormat, info.SMPTEOffset,
+		uint32(len(info.Loops)), uint32(len(info.Data)),
+	} {
+		b = binary.LittleEndian.AppendUint32(b, v)
+	}
+	for _, l := range info.Loops {
+		for _, v := range []uint32{l.CueID, l.Type, l.Start, l.End, l.Fraction, l.PlayCount} {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+	}
+	b = append(b, info.Data...)
+	if size%2 != 0 {
+		b = append(b, 0) // padding byte
+	}
+	return b
+}
+
+// appendCue appends cue to b as a cue chunk of points into the data chunk.
+func appendCue(b []byte, cue *CueList) []byte {
+	b = binary.LittleEndian.AppendUint32(append(b, "cue "...), uint32(4+len(cue.Points)*cuePointSize))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(cue.Points)))
+	for _, p := range cue.Points {
+		b = binary.LittleEndian.AppendUint32(b, p.ID)
+		b = binary.LittleEndian.AppendUint32(b, p.Position)
+		b = append(b, "data"...)
+		b = binary.LittleEndian.AppendUint32(b, 0) // chunk start
+		b = binary.LittleEndian.AppendUint32(b, 0) // block start
+		b = binary.LittleEndian.AppendUint32(b, p.SampleOffset)
+	}
+	return b
+}
+
+// bextTimeReferenceOffset is the offset in a bext chunk of its time
+// reference: the first sample's position, in samples since midnight.
+const bextTimeReferenceOffset = 338
+
+// resampledMetadata returns the metadata of h with its sample positions
+// scaled from h.SampleRate to rate, so markers stay on the same moments
+// once the audio is resampled: cue and loop points, the sampler's sample
+// period, and the time reference of a bext chunk. The metadata is copied
+// if it changes, and returned as is at h.SampleRate.
+func (h WAVHeader) resampledMetadata(rate int) (*SamplerInfo, *CueList, *ExtraChunks) {
+	if rate == h.SampleRate || h.SampleRate <= 0 {
+		return h.Sampler, h.Cue, h.Extra
+	}
+	ratio := float64(rate) / float64(h.SampleRate)
+	pos := func(v uint32) uint32 { return uint32(math.Round(float64(v) * ratio)) }
+
+	sampler, cue, extra := h.Sampler, h.Cue, h.Extra
+	if sampler != nil {
+		s := *sampler
+		s.SamplePeriod = uint32(math.Round(1e9 / float64(rate)))
+		s.Loops = slices.Clone(s.Loops)
+		for i := range s.Loops {
+			s.Loops[i].Start, s.Loops[i].End = pos(s.Loops[i].Start), pos(s.Loops[i].End)
+		}
+		sampler = &s
+	}
+	if cue != nil {
+		c := CueList{Points: slices.Clone(cue.Points)}
+		for i := range c.Points {
+			c.Points[i].Position, c.Points[i].SampleOffset = pos(c.Points[i].Position), pos(c.Points[i].SampleOffset)
+		}
+		cue = &c
+	}
+	if extra != nil {
+		e := ExtraChunks{Chunks: slices.Clone(extra.Chunks)}
+		for i, c := range e.Chunks {
+			if c.ID != "bext" || len(c.Data) < bextTimeReferenceOffset+8 {
+				continue
+			}
+			data := slices.Clone(c.Data)
+			ref := binary.LittleEndian.Uint64(data[bextTimeReferenceOffset:])
+			binary.LittleEndian.PutUint64(data[bextTimeReferenceOffset:], uint64(math.Round(float64(ref)*ratio)))
+			e.Chunks[i].Data = data
+		}
+		extra = &e
+	}
+	return sampler, cue, extra
+}
+
+// decodableBits lists the integer PCM sample widths ReadWAV decodes.
+var decodableBits = []int{8, 16, 24, 32}
+
+// decodableFloatBits lists the IEEE float sample widths ReadWAV decodes.
+var decodableFloatBits = []int{32, 64}
+
+// WAVErrorCode classifies why a WAV file could not be decoded.
+type WAVErrorCode int
+
+const (
+	// ErrNotRIFF: the data is not a RIFF/WAVE file at all.
+	ErrNotRIFF WAVErrorCode = iota + 1
+	// ErrMalformed: the file is truncated, or its chunks are missing or
+	// inconsistent.
+	ErrMalformed
+	// ErrUnsupportedFormat: the audio is not integer PCM, IEEE float,
+	// A-law, mu-law or IMA ADPCM.
+	ErrUnsupportedFormat
+	// ErrUnsupportedBits: the PCM sample width is not 8, 16, 24 or 32 bits,
+	// the float sample width not 32 or 64 bits, the A-law or mu-law
+	// sample width not 8 bits, or the IMA ADPCM sample width not 4 bits.
+	ErrUnsupportedBits
+	// ErrNoData: the file has no data chunk.
+	ErrNoData
+)
+
+// WAVError is the error ReadWAV, DecodeWAVFrom and ValidateWAV return for a
+// file they cannot decode. Use errors.As to get at its Code.
+type WAVError struct {
+	Code WAVErrorCode
+	Msg  string
+}
+
+func (e *WAVError) Error() string { return "wav: " + e.Msg }
+
+// wavError returns a *WAVError with the given code and formatted message.
+func wavError(code WAVErrorCode, format string, args ...any) error {
+	return &WAVError{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ValidateWAV checks that data is a WAV file ReadWAV can decode and returns
+// its header. Only the chunk headers are parsed, without allocating the
+// sample array; stereo samples are read in place, a frame at a time, to
+// detect effectively-mono files.
+func ValidateWAV(data []byte) (*WAVHeader, error) {
+	header, pcmData, err := scanWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.NumChannels == 2 {
+		header.EffectivelyMono = stereoSums(pcmData, header).effectivelyMono()
+	}
+	return header, nil
+}
+
+// ReadWAV parses an 8-, 16-, 24- or 32-bit integer PCM or a 32- or 64-bit IEEE
+// float WAV file from raw bytes. Returns samples normalized to [-1.0, +1.0]
+// and the sample rate. Float samples are full scale at 1.0 already and are
+// kept as is, over-range peaks included, as the denoiser scales its input
+// into range itself; only NaN and infinite samples are clamped. 8-bit A-law
+// and mu-law telephony files are expanded to linear samples, and 4-bit IMA
+// ADPCM decompressed. RF64 and
+// BW64 files, which give their sizes in a ds64 chunk, are read too.
 // Stereo inputs are mixed down to mono by averaging left and right channels.
 func ReadWAV(data []byte) ([]float64, int, error) {
+	header, pcmData, err := scanWAV(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return toMono(decodeSamples(pcmData, header), header), header.SampleRate, nil
+}
+
+// ReadWAVChannels is ReadWAV without the stereo mixdown: it returns the
+// samples of each channel separately.
+func ReadWAVChannels(data []byte) ([][]float64, int, error) {
+	header, pcmData, err := scanWAV(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return Deinterleave(decodeSamples(pcmData, header), header.NumChannels), header.SampleRate, nil
+}
+
+// DecodeWAVFrom is ReadWAV for a file of size bytes read from r. Only the
+// chunk headers and the fmt chunk are read ahead of the samples, which are
+// decoded straight from r, so the file itself is never held in memory.
+func DecodeWAVFrom(r io.ReaderAt, size int64) ([]float64, int, error) {
+	header, dataOff, dataLen, err := scanWAVAt(r, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	unit := int64(header.decodeUnit())
+	rawSamples := make([]float64, 0, dataLen*8/int64(header.BitsPerSample))
+	buf := make([]byte, 64*1024)
+	for off := int64(0); off < dataLen; {
+		n := min(int64(len(buf)), dataLen-off)
+		n -= n % unit
+		if n == 0 {
+			break // trailing partial sample or block
+		}
+		if _, err := r.ReadAt(buf[:n], dataOff+off); err != nil {
+			return nil, 0, fmt.Errorf("wav: reading data chunk: %w", err)
+		}
+		rawSamples = append(rawSamples, decodeSamples(buf[:n], header)...)
+		off += n
+	}
+	return toMono(rawSamples, header), header.SampleRate, nil
+}
+
+// toMono returns decoded samples laid out as in header as mono. Stereo and
+// multichannel audio is mixed down by averaging the channels; a trailing
+// partial frame is dropped. Fake stereo (identical channels, the exact
+// case of WAVHeader.EffectivelyMono) is returned as its left channel;
+// nearly identical channels average to much the same signal anyway.
+func toMono(rawSamples []float64, header *WAVHeader) []float64 {
+	if header.NumChannels < 2 {
+		return rawSamples
+	}
+	ch := Deinterleave(rawSamples, header.NumChannels)
+	if header.NumChannels == 2 && slices.Equal(ch[0], ch[1]) {
+		return ch[0]
+	}
+	return averageChannels(ch)
+}
+
+// ChannelSelect selects the channel selectChannel takes from
+// multichannel audio.
+type ChannelSelect int
+
+const (
+	// MixChannels averages all channels, as toMono does.
+	MixChannels ChannelSelect = iota
+	// LeftChannel takes the first channel alone.
+	LeftChannel
+	// RightChannel takes the second channel alone.
+	RightChannel
+)
+
+// channelSelectNames lists the channel form value of each ChannelSelect.
+var channelSelectNames = []struct {
+	name string
+	sel  ChannelSelect
+}{
+	{"mix", MixChannels},
+	{"left", LeftChannel},
+	{"right", RightChannel},
+}
+
+// ParseChannelSelect parses a channel value: "mix", "left" or "right".
+func ParseChannelSelect(s string) (ChannelSelect, error) {
+	for _, c := range channelSelectNames {
+		if c.name == s {
+			return c.sel, nil
+		}
+	}
+	return 0, fmt.Errorf("wav: unsupported channel %q (want mix, left or right)", s)
+}
+
+// selectChannel returns the channel sel selects from decoded samples laid
+// out as in header: the mix toMono returns, or a single channel. Mono
+// audio is returned as is whatever sel is.
+func selectChannel(rawSamples []float64, header *WAVHeader, sel ChannelSelect) []float64 {
+	if sel == MixChannels || header.NumChannels < 2 {
+		return toMono(rawSamples, header)
+	}
+	ch := Deinterleave(rawSamples, header.NumChannels)
+	if sel == RightChannel {
+		return ch[1]
+	}
+	return ch[0]
+}
+
+// decodeUnit returns the size in bytes of the units of data decodeSamples
+// decodes independently: samples, or IMA ADPCM blocks.
+func (h *WAVHeader) decodeUnit() int {
+	if h.ADPCMBlockAlign > 0 {
+		return h.ADPCMBlockAlign
+	}
+	return h.BitsPerSample / 8
+}
+
+// decodeSamples parses the samples of a data chunk laid out as in header.
+// A trailing partial sample (or ADPCM block) is dropped.
+func decodeSamples(pcmData []byte, header *WAVHeader) []float64 {
+	if header.ADPCMBlockAlign > 0 {
+		return decodeIMAADPCM(pcmData, header.NumChannels, header.ADPCMBlockAlign)
+	}
+	bytesPerSample := header.BitsPerSample / 8
+	samples := make([]float64, len(pcmData)/bytesPerSample)
+	for i := range samples {
+		samples[i] = header.sampleValue(pcmData[i*bytesPerSample : (i+1)*bytesPerSample])
+	}
+	return samples
+}
+
+// sampleValue decodes one sample laid out as in h, which is not IMA ADPCM.
+func (h *WAVHeader) sampleValue(b []byte) float64 {
+	switch {
+	case h.Float:
+		return decodeFloatSample(b, h.BitsPerSample)
+	case h.Companding == ALaw:
+		return alawTable[b[0]]
+	case h.Companding == MuLaw:
+		return mulawTable[b[0]]
+	}
+	return decodeSample(b, h.BitsPerSample)
+}
+
+// Deinterleave splits interleaved samples [c0, c1, ..., c0, c1, ...] of
+// the given number of channels (at least 1) into one slice per channel,
+// dropping a trailing partial frame.
+func Deinterleave(samples []float64, channels int) [][]float64 {
+	n := len(samples) / channels
+	out := make([][]float64, channels)
+	for c := range out {
+		out[c] = make([]float64, n)
+		for i := range n {
+			out[c][i] = samples[i*channels+c]
+		}
+	}
+	return out
+}
+
+// Interleave is the inverse of Deinterleave. The channels must be the same
+// length.
+func Interleave(channels [][]float64) []float64 {
+	if len(channels) == 0 {
+		return nil
+	}
+	out := make([]float64, len(channels)*len(channels[0]))
+	for c, ch := range channels {
+		for i, v := range ch {
+			out[i*len(channels)+c] = v
+		}
+	}
+	return out
+}
+
+// Thresholds for channelSums.effectivelyMono.
+const (
+	// monoCorrelation is the channel correlation at or above which a
+	// stereo file may count as effectively mono.
+	monoCorrelation = 0.9999
+	// monoEnergyTolerance is the largest relative difference between the
+	// channel energies of an effectively mono file.
+	monoEnergyTolerance = 0.001
+)
+
+// channelSums accumulates the sums of products of two channels' samples
+// that effectivelyMono compares.
+type channelSums struct {
+	ab, aa, bb float64
+}
+
+// add adds one frame of the two channels.
+func (s *channelSums) add(a, b float64) {
+	s.ab += a * b
+	s.aa += a * a
+	s.bb += b * b
+}
+
+// effectivelyMono reports whether the two channels are (nearly) the same
+// signal: correlated at monoCorrelation or more, with energies within
+// monoEnergyTolerance of each other. A source panned by level alone, one
+// channel a scaled copy of the other, is fully correlated but stays
+// stereo. Two silent channels count as mono.
+func (s channelSums) effectivelyMono() bool {
+	switch {
+	case s.aa == 0 && s.bb == 0:
+		return true
+	case s.aa == 0 || s.bb == 0:
+		return false
+	}
+	return s.ab/math.Sqrt(s.aa*s.bb) >= monoCorrelation &&
+		math.Abs(s.aa-s.bb) <= monoEnergyTolerance*max(s.aa, s.bb)
+}
+
+// stereoSums returns the channelSums of the stereo data chunk pcmData laid
+// out as in header, decoding a frame (or an IMA ADPCM block) at a time
+// rather than the whole chunk.
+func stereoSums(pcmData []byte, header *WAVHeader) channelSums {
+	var s channelSums
+	if align := header.ADPCMBlockAlign; align > 0 {
+		frames := make([]float64, imaBlockSamples(2, align))
+		for off := 0; off+align <= len(pcmData); off += align {
+			decodeIMAADPCMBlock(frames, pcmData[off:off+align], 2)
+			for i := 0; i < len(frames); i += 2 {
+				s.add(frames[i], frames[i+1])
+			}
+		}
+		return s
+	}
+	width := header.BitsPerSample / 8
+	for off := 0; off+2*width <= len(pcmData); off += 2 * width {
+		s.add(header.sampleValue(pcmData[off:off+width]), header.sampleValue(pcmData[off+width:off+2*width]))
+	}
+	return s
+}
+
+// decodeSample converts one little-endian PCM sample of the given width to
+// [-1.0, +1.0). 8-bit samples are unsigned, centered on 128; wider ones
+// are signed.
+func decodeSample(b []byte, bits int) float64 {
+	switch bits {
+	case 8:
+		return float64(int(b[0])-128) / 128.0
+	case 32:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648.0
+	case 24:
+		// Sign-extend from the top byte.
+		v := int32(b[0]) | int32(b[1])<<8 | int32(int8(b[2]))<<16
+		return float64(v) / 8388608.0
+	default:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / 32768.0
+	}
+}
+
+// decodeFloatSample converts one little-endian IEEE float sample of the
+// given width to float64. NaN decodes as silence and infinities as full
+// scale, so one corrupt sample cannot poison the whole spectrum.
+func decodeFloatSample(b []byte, bits int) float64 {
+	var v float64
+	if bits == 64 {
+		v = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	} else {
+		v = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	}
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case math.IsInf(v, 0):
+		return math.Copysign(1, v)
+	}
+	return v
+}
+
+// fmt chunk format tags.
+const (
+	formatPCM   = 1
+	formatFloat = 3
+	formatALaw  = 6
+	formatMuLaw = 7
+	// formatIMAADPCM is IMA (DVI) ADPCM.
+	formatIMAADPCM = 0x11
+	// formatExtensible is the WAVE_FORMAT_EXTENSIBLE format tag, whose fmt
+	// chunk names the actual format in a SubFormat GUID.
+	formatExtensible = 0xFFFE
+	// extensibleFmtSize is the size of an extensible fmt chunk.
+	extensibleFmtSize = 40
+)
+
+// ksDataFormatSuffix is the part of a SubFormat GUID after the format tag
+// it embeds, shared by all the KSDATAFORMAT_SUBTYPE_* GUIDs for plain WAV
+// formats (…-0000-0010-8000-00AA00389B71).
+var ksDataFormatSuffix = []byte{0, 0, 0, 0, 0x10, 0, 0x80, 0, 0, 0xAA, 0, 0x38, 0x9B, 0x71}
+
+// parseExtensible parses an extensible fmt chunk body, returning the
+// format tag its SubFormat GUID stands for, its valid bits per sample and
+// its channel mask.
+func parseExtensible(body []byte) (uint16, int, uint32, error) {
+	validBits := int(binary.LittleEndian.Uint16(body[18:20]))
+	mask := binary.LittleEndian.Uint32(body[20:24])
+	guid := body[24:40]
+	if !bytes.Equal(guid[2:], ksDataFormatSuffix) {
+		return 0, 0, 0, wavError(ErrUnsupportedFormat, "unsupported extensible sub-format %x", guid)
+	}
+	return binary.LittleEndian.Uint16(guid[:2]), validBits, mask, nil
+}
+
+// appendExtensibleFmt appends an extensible fmt chunk for samples in the
+// given format carrying channelMask.
+func appendExtensibleFmt(b []byte, sampleRate, numChannels int, format SampleFormat, channelMask uint32) []byte {
+	bits := format.BitsPerSample()
+	blockAlign := numChannels * bits / 8
+	tag := uint16(formatPCM)
+	if format.isFloat() {
+		tag = formatFloat
+	}
+	b = binary.LittleEndian.AppendUint32(append(b, "fmt "...), extensibleFmtSize)
+	b = binary.LittleEndian.AppendUint16(b, formatExtensible)
+	b = binary.LittleEndian.AppendUint16(b, uint16(numChannels))
+	b = binary.LittleEndian.AppendUint32(b, uint32(sampleRate))
+	b = binary.LittleEndian.AppendUint32(b, uint32(sampleRate*blockAlign))
+	b = binary.LittleEndian.AppendUint16(b, uint16(blockAlign))
+	b = binary.LittleEndian.AppendUint16(b, uint16(bits))
+	b = binary.LittleEndian.AppendUint16(b, extensibleFmtSize-18) // extension size
+	b = binary.LittleEndian.AppendUint16(b, uint16(bits))         // valid bits
+	b = binary.LittleEndian.AppendUint32(b, channelMask)
+	b = binary.LittleEndian.AppendUint16(b, tag)
+	return append(b, ksDataFormatSuffix...)
+}
+
+// alawTable and mulawTable map each G.711 code to its linear value: the
+// 16-bit sample the standard expands it to, over 32768.
+var (
+	alawTable  = companderTable(decodeALaw)
+	mulawTable = companderTable(decodeMuLaw)
+)
+
+func companderTable(decode func(byte) int) (table [256]float64) {
+	for code := range table {
+		table[code] = float64(decode(byte(code))) / 32768.0
+	}
+	return table
+}
+
+// decodeALaw expands an A-law code to a 16-bit sample. Codes are stored
+// with their even bits inverted, and a set sign bit means positive.
+func decodeALaw(code byte) int {
+	a := code ^ 0x55
+	exponent := int(a>>4) & 7
+	v := int(a&0x0F)<<4 + 8
+	if exponent > 0 {
+		v = (v + 0x100) << (exponent - 1)
+	}
+	if a&0x80 == 0 {
+		return -v
+	}
+	return v
+}
+
+// decodeMuLaw expands a mu-law code to a 16-bit sample. Codes are stored
+// inverted, and a set sign bit means negative.
+func decodeMuLaw(code byte) int {
+	u := ^code
+	exponent := int(u>>4) & 7
+	v := (int(u&0x0F)<<3+0x84)<<exponent - 0x84
+	if u&0x80 != 0 {
+		return -v
+	}
+	return v
+}
+
+// imaStepTable and imaIndexTable are the IMA ADPCM quantizer step sizes
+// and the step index change for each code.
+var (
+	imaStepTable = [89]int{
+		7, 8, 9, 10, 11, 12, 13, 14, 16, 17, 19, 21, 23, 25, 28, 31, 34, 37, 41, 45,
+		50, 55, 60, 66, 73, 80, 88, 97, 107, 118, 130, 143, 157, 173, 190, 209, 230,
+		253, 279, 307, 337, 371, 408, 449, 494, 544, 598, 658, 724, 796, 876, 963,
+		1060, 1166, 1282, 1411, 1552, 1707, 1878, 2066, 2272, 2499, 2749, 3024, 3327,
+		3660, 4026, 4428, 4871, 5358, 5894, 6484, 7132, 7845, 8630, 9493, 10442,
+		11487, 12635, 13899, 15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794,
+		32767,
+	}
+	imaIndexTable = [16]int{-1, -1, -1, -1, 2, 4, 6, 8, -1, -1, -1, -1, 2, 4, 6, 8}
+)
+
+// imaStep applies the 4-bit IMA ADPCM code to the predicted 16-bit sample
+// and step index, returning the next ones.
+func imaStep(predictor, index int, code byte) (int, int) {
+	step := imaStepTable[index]
+	diff := step >> 3
+	if code&1 != 0 {
+		diff += step >> 2
+	}
+	if code&2 != 0 {
+		diff += step >> 1
+	}
+	if code&4 != 0 {
+		diff += step
+	}
+	if code&8 != 0 {
+		predictor -= diff
+	} else {
+		predictor += diff
+	}
+	return max(-32768, min(predictor, 32767)), max(0, min(index+imaIndexTable[code], 88))
+}
+
+// decodeIMAADPCM decodes IMA ADPCM data of the given channel count and
+// block size to interleaved samples, dropping a trailing partial block.
+// Each block starts with a 4-byte header per channel, the initial sample
+// and step index, followed by 4-byte groups of eight codes for each
+// channel in turn, low nibble first.
+func decodeIMAADPCM(data []byte, channels, blockAlign int) []float64 {
+	n := imaBlockSamples(channels, blockAlign)
+	out := make([]float64, len(data)/blockAlign*n)
+	for blk := range len(data) / blockAlign {
+		decodeIMAADPCMBlock(out[blk*n:(blk+1)*n], data[blk*blockAlign:(blk+1)*blockAlign], channels)
+	}
+	return out
+}
+
+// imaBlockSamples returns the number of samples, across all channels, in
+// an IMA ADPCM block of blockAlign bytes.
+func imaBlockSamples(channels, blockAlign int) int {
+	groups := (blockAlign - 4*channels) / (4 * channels)
+	return (1 + 8*groups) * channels
+}
+
+// decodeIMAADPCMBlock decodes one IMA ADPCM block into dst, which holds
+// imaBlockSamples samples.
+func decodeIMAADPCMBlock(dst []float64, block []byte, channels int) {
+	groups := (len(block) - 4*channels) / (4 * channels)
+	for c := range channels {
+		predictor := int(int16(binary.LittleEndian.Uint16(block[4*c:])))
+		index := min(int(block[4*c+2]), 88)
+		dst[c] = float64(predictor) / 32768.0
+		frame := 1
+		for g := range groups {
+			off := 4*channels + (g*channels+c)*4
+			for _, b := range block[off : off+4] {
+				for _, code := range [2]byte{b & 0x0F, b >> 4} {
+					predictor, index = imaStep(predictor, index, code)
+					dst[frame*channels+c] = float64(predictor) / 32768.0
+					frame++
+				}
+			}
+		}
+	}
+}
+
+// scanWAV walks the RIFF chunks of data, validating the fmt chunk, and
+// returns the header and the (unparsed) contents of the data chunk.
+func scanWAV(data []byte) (*WAVHeader, []byte, error) {
 	if len(data) < 12 {
-		return nil, 0, errors.New("wav: file too short")
+		return nil, nil, wavError(ErrNotRIFF, "file too short")
 	}
 
 	// Validate RIFF header.
-	if string(data[0:4]) != "RIFF" {
-		return nil, 0, errors.New("wav: missing RIFF header")
+	rf64 := isRF64(data[0:4])
+	if string(data[0:4]) != "RIFF" && !rf64 {
+		return nil, nil, wavError(ErrNotRIFF, "missing RIFF header")
 	}
 	if string(data[8:12]) != "WAVE" {
-		return nil, 0, errors.New("wav: missing WAVE identifier")
+		return nil, nil, wavError(ErrNotRIFF, "missing WAVE identifier")
 	}
 
 	var header *WAVHeader
 	var pcmData []byte
+	var sampler *SamplerInfo
+	var cue *CueList
+	var extra []WAVChunk
+	dataSize64 := -1 // from the ds64 chunk of an RF64 file
 
 	// Walk through chunks.
 	pos := 12
@@ -40,26 +826,92 @@ func ReadWAV(data []byte) ([]float64, int, error) {
 		chunkID := string(data[pos : pos+4])
 		chunkSize := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
 		chunkStart := pos + 8
+		if chunkID == "data" && chunkSize == rf64SizePlaceholder && dataSize64 >= 0 {
+			chunkSize = dataSize64
+		}
 
 		switch chunkID {
+		case "ds64":
+			if !rf64 {
+				break
+			}
+			if chunkSize < ds64Size || chunkStart+ds64Size > len(data) {
+				return nil, nil, wavError(ErrMalformed, "ds64 chunk too small")
+			}
+			dataSize64 = int(ds64DataSize(data[chunkStart : chunkStart+ds64Size]))
+
 		case "fmt ":
 			if chunkSize < 16 {
-				return nil, 0, errors.New("wav: fmt chunk too small")
+				return nil, nil, wavError(ErrMalformed, "fmt chunk too small")
 			}
 			if chunkStart+16 > len(data) {
-				return nil, 0, errors.New("wav: fmt chunk truncated")
+				return nil, nil, wavError(ErrMalformed, "fmt chunk truncated")
 			}
 			audioFormat := binary.LittleEndian.Uint16(data[chunkStart : chunkStart+2])
-			if audioFormat != 1 {
-				return nil, 0, fmt.Errorf("wav: unsupported audio format %d (only PCM/1 supported)", audioFormat)
+			validBits := 0
+			var channelMask uint32
+			if audioFormat == formatExtensible {
+				if chunkSize < extensibleFmtSize {
+					return nil, nil, wavError(ErrMalformed, "extensible fmt chunk too small")
+				}
+				if chunkStart+extensibleFmtSize > len(data) {
+					return nil, nil, wavError(ErrMalformed, "fmt chunk truncated")
+				}
+				var err error
+				if audioFormat, validBits, channelMask, err = parseExtensible(data[chunkStart : chunkStart+extensibleFmtSize]); err != nil {
+					return nil, nil, err
+				}
 			}
 			header = &WAVHeader{
 				NumChannels:   int(binary.LittleEndian.Uint16(data[chunkStart+2 : chunkStart+4])),
 				SampleRate:    int(binary.LittleEndian.Uint32(data[chunkStart+4 : chunkStart+8])),
 				BitsPerSample: int(binary.LittleEndian.Uint16(data[chunkStart+14 : chunkStart+16])),
+				ChannelMask:   channelMask,
+			}
+			switch audioFormat {
+			case formatPCM:
+				if !slices.Contains(decodableBits, header.BitsPerSample) {
+					return nil, nil, wavError(ErrUnsupportedBits, "unsupported bits per sample %d (only 8, 16, 24 and 32 supported)", header.BitsPerSample)
+				}
+			case formatFloat:
+				header.Float = true
+				if !slices.Contains(decodableFloatBits, header.BitsPerSample) {
+					return nil, nil, wavError(ErrUnsupportedBits, "unsupported float bits per sample %d (only 32 and 64 supported)", header.BitsPerSample)
+				}
+			case formatIMAADPCM:
+				header.ADPCMBlockAlign = int(binary.LittleEndian.Uint16(data[chunkStart+12 : chunkStart+14]))
+				if header.BitsPerSample != 4 {
+					return nil, nil, wavError(ErrUnsupportedBits, "unsupported IMA ADPCM bits per sample %d (only 4 supported)", header.BitsPerSample)
+				}
+			case formatALaw, formatMuLaw:
+				header.Companding = ALaw
+				if audioFormat == formatMuLaw {
+					header.Companding = MuLaw
+				}
+				if header.BitsPerSample != 8 {
+					return nil, nil, wavError(ErrUnsupportedBits, "unsupported %s bits per sample %d (only 8 supported)", header.Companding, header.BitsPerSample)
+				}
+			default:
+				return nil, nil, wavError(ErrUnsupportedFormat, "unsupported audio format %d (only PCM/1, IEEE float/3, A-law/6, mu-law/7 and IMA ADPCM/17 supported)", audioFormat)
+			}
+			if validBits > header.BitsPerSample {
+				return nil, nil, wavError(ErrMalformed, "fmt chunk declares %d valid bits in %d-bit samples", validBits, header.BitsPerSample)
 			}
-			if header.BitsPerSample != 16 {
-				return nil, 0, fmt.Errorf("wav: unsupported bits per sample %d (only 16 supported)", header.BitsPerSample)
+			if validBits > 0 && validBits < header.BitsPerSample {
+				header.ValidBitsPerSample = validBits
+			}
+			if header.NumChannels < 1 {
+				return nil, nil, wavError(ErrMalformed, "fmt chunk declares no channels")
+			}
+			if header.SampleRate <= 0 {
+				return nil, nil, wavError(ErrMalformed, "fmt chunk declares no sample rate")
+			}
+			if audioFormat == formatIMAADPCM {
+				// Headers, then whole groups of 4 bytes, per channel.
+				perChannel := 4 * header.NumChannels
+				if header.ADPCMBlockAlign < 2*perChannel || header.ADPCMBlockAlign%perChannel != 0 {
+					return nil, nil, wavError(ErrMalformed, "IMA ADPCM block of %d bytes does not fit %d channels", header.ADPCMBlockAlign, header.NumChannels)
+				}
 			}
 
 		case "data":
@@ -68,87 +920,541 @@ func ReadWAV(data []byte) ([]float64, int, error) {
 				end = len(data) // allow truncated data chunks
 			}
 			pcmData = data[chunkStart:end]
+
+		// Loop metadata is optional: a malformed chunk is dropped rather
+		// than failing the file.
+		case "smpl":
+			if chunkStart+chunkSize <= len(data) {
+				sampler = parseSampler(data[chunkStart : chunkStart+chunkSize])
+			}
+		case "cue ":
+			if chunkStart+chunkSize <= len(data) {
+				cue = parseCue(data[chunkStart : chunkStart+chunkSize])
+			}
+		default:
+			// Match on the bytes, not chunkID, which would then escape
+			// and cost an allocation for every chunk.
+			if id := data[pos : pos+4]; isExtraChunk(id) && chunkStart+chunkSize <= len(data) {
+				extra = append(extra, WAVChunk{ID: string(id), Data: slices.Clone(data[chunkStart : chunkStart+chunkSize])})
+			}
 		}
 
-		// Advance to next chunk (chunks are word-aligned).
+		// A chunk other than data that runs past the end of the file means
+		// the file is corrupt, unless everything needed was already found.
+		if chunkStart+chunkSize > len(data) && chunkID != "data" && (header == nil || pcmData == nil) {
+			return nil, nil, wavError(ErrMalformed, "%q chunk of %d bytes overruns file", data[pos:pos+4], chunkSize)
+		}
+
+		// Advance to next chunk (chunks are word-aligned). Some writers
+		// omit the padding byte after an odd-sized chunk; if the next
+		// chunk ID is found unpadded, follow it.
 		pos = chunkStart + chunkSize
-		if chunkSize%2 != 0 {
+		if chunkSize%2 != 0 && !(isChunkID(data, pos) && !isChunkID(data, pos+1)) {
 			pos++ // padding byte
 		}
 	}
 
 	if header == nil {
-		return nil, 0, errors.New("wav: no fmt chunk found")
+		return nil, nil, wavError(ErrMalformed, "no fmt chunk found")
 	}
 	if pcmData == nil {
-		return nil, 0, errors.New("wav: no data chunk found")
+		return nil, nil, wavError(ErrNoData, "no data chunk found")
+	}
+	header.Sampler, header.Cue = sampler, cue
+	if extra != nil {
+		header.Extra = &ExtraChunks{Chunks: extra}
+	}
+
+	return header, pcmData, nil
+}
+
+// rf64SizePlaceholder is the 32-bit size an RF64 file declares for its
+// RIFF header and data chunk, whose real sizes are in the ds64 chunk.
+const rf64SizePlaceholder = 0xFFFFFFFF
+
+// ds64Size is the size of the ds64 chunk fields this package reads: the
+// 64-bit RIFF size, data size and sample count.
+const ds64Size = 24
+
+// isRF64 reports whether id, the first four bytes of a file, marks an RF64
+// file (or BW64, its EBU successor with the same layout).
+func isRF64(id []byte) bool {
+	return string(id) == "RF64" || string(id) == "BW64"
+}
+
+// ds64DataSize returns the 64-bit data chunk size from a ds64 chunk body.
+// Sizes past the int64 range are clamped so they read as truncated data.
+func ds64DataSize(body []byte) int64 {
+	return int64(min(binary.LittleEndian.Uint64(body[8:16]), math.MaxInt64))
+}
+
+// isChunkID reports whether data[pos:pos+4] looks like a RIFF chunk ID:
+// four printable ASCII characters.
+func isChunkID(data []byte, pos int) bool {
+	if pos < 0 || pos+4 > len(data) {
+		return false
 	}
+	for _, c := range data[pos : pos+4] {
+		if c < ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
 
-	// Parse int16 samples.
-	numSamples := len(pcmData) / 2
-	rawSamples := make([]float64, numSamples)
-	for i := 0; i < numSamples; i++ {
-		s := int16(binary.LittleEndian.Uint16(pcmData[i*2 : i*2+2]))
-		rawSamples[i] = float64(s) / 32768.0
+// RepairWAVSizes returns a copy of the WAV file data with its RIFF and
+// data chunk sizes recomputed from its content, for files from tools that
+// leave them wrong. A data chunk size that runs past the end of the file,
+// or is zero with no chunk following (placeholders streaming writers
+// leave, such as 0xFFFFFFFF), becomes the rest of the file in whole sample
+// frames, padded to an even length; a valid data size is kept. The RIFF
+// size then covers everything after it. RF64 files, whose 0xFFFFFFFF
+// sizes are deliberate, are returned unchanged.
+func RepairWAVSizes(data []byte) ([]byte, error) {
+	header, _, err := scanWAV(data)
+	if err != nil {
+		return nil, err
+	}
+	if isRF64(data[0:4]) {
+		return slices.Clone(data), nil
 	}
 
-	// Mix to mono if stereo.
-	if header.NumChannels == 2 {
-		monoLen := numSamples / 2
-		mono := make([]float64, monoLen)
-		for i := 0; i < monoLen; i++ {
-			mono[i] = (rawSamples[i*2] + rawSamples[i*2+1]) / 2.0
+	// Find the data chunk, stepping over chunks as scanWAV does.
+	pos := 12
+	for string(data[pos:pos+4]) != "data" {
+		chunkSize := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8 + chunkSize
+		if chunkSize%2 != 0 && !(isChunkID(data, pos) && !isChunkID(data, pos+1)) {
+			pos++ // padding byte
 		}
-		return mono, header.SampleRate, nil
 	}
+	dataStart := pos + 8
+	declared := int64(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+	remaining := len(data) - dataStart
 
-	return rawSamples, header.SampleRate, nil
+	out := slices.Clone(data)
+	if declared > int64(remaining) || declared == 0 && remaining > 0 && !isChunkID(data, dataStart) {
+		blockAlign := header.NumChannels * header.BitsPerSample / 8
+		if header.ADPCMBlockAlign > 0 {
+			blockAlign = header.ADPCMBlockAlign
+		}
+		size := remaining - remaining%blockAlign
+		out = out[:dataStart+size]
+		if size%2 != 0 {
+			out = append(out, 0) // padding byte
+		}
+		binary.LittleEndian.PutUint32(out[pos+4:pos+8], uint32(size))
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// scanWAVAt is scanWAV for a file of size bytes read from r. It returns the
+// offset and length of the PCM data instead of the data itself. The chunk
+// headers and fmt chunks are gathered into a minimal file that scanWAV
+// validates, so both accept exactly the same files.
+func scanWAVAt(r io.ReaderAt, size int64) (*WAVHeader, int64, int64, error) {
+	meta := make([]byte, 12, 64)
+	if _, err := r.ReadAt(meta, 0); err != nil {
+		return nil, 0, 0, wavError(ErrNotRIFF, "file too short")
+	}
+
+	var dataChunk []byte
+	var dataOff, dataLen int64
+	haveFmt := false
+	rf64 := isRF64(meta[0:4])
+	dataSize64 := int64(-1)
+	var buf [8]byte
+	pos := int64(12)
+	for pos+8 <= size {
+		if _, err := r.ReadAt(buf[:], pos); err != nil {
+			return nil, 0, 0, fmt.Errorf("wav: reading chunk header: %w", err)
+		}
+		chunkID := string(buf[:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(buf[4:8]))
+		chunkStart := pos + 8
+		if chunkID == "data" && chunkSize == rf64SizePlaceholder && dataSize64 >= 0 {
+			chunkSize = dataSize64
+		}
+
+		switch chunkID {
+		case "ds64":
+			if !rf64 {
+				break
+			}
+			if chunkSize < ds64Size || chunkStart+ds64Size > size {
+				return nil, 0, 0, wavError(ErrMalformed, "ds64 chunk too small")
+			}
+			body := make([]byte, ds64Size)
+			if _, err := r.ReadAt(body, chunkStart); err != nil {
+				return nil, 0, 0, fmt.Errorf("wav: reading ds64 chunk: %w", err)
+			}
+			meta = binary.LittleEndian.AppendUint32(append(meta, "ds64"...), ds64Size)
+			meta = append(meta, body...)
+			dataSize64 = ds64DataSize(body)
+		case "fmt ":
+			// Keep the bytes scanWAV reads, up to the end of an extensible
+			// fmt chunk.
+			keep := min(chunkSize, extensibleFmtSize)
+			body := make([]byte, min(keep, max(size-chunkStart, 0)))
+			if int64(len(body)) < keep {
+				return nil, 0, 0, wavError(ErrMalformed, "fmt chunk truncated")
+			}
+			if _, err := r.ReadAt(body, chunkStart); err != nil {
+				return nil, 0, 0, fmt.Errorf("wav: reading fmt chunk: %w", err)
+			}
+			meta = binary.LittleEndian.AppendUint32(append(meta, "fmt "...), uint32(keep))
+			meta = append(meta, body...)
+			haveFmt = true
+		case "data":
+			dataChunk = slices.Clone(buf[:])
+			dataOff, dataLen = chunkStart, min(chunkSize, size-chunkStart)
+		}
+
+		if chunkStart+chunkSize > size && chunkID != "data" && (!haveFmt || dataChunk == nil) {
+			return nil, 0, 0, wavError(ErrMalformed, "%q chunk of %d bytes overruns file", chunkID, chunkSize)
+		}
+
+		pos = chunkStart + chunkSize
+		if chunkSize%2 != 0 {
+			var next [5]byte
+			n, _ := r.ReadAt(next[:], pos)
+			if !(isChunkID(next[:n], 0) && !isChunkID(next[:n], 1)) {
+				pos++ // padding byte
+			}
+		}
+	}
+
+	// The data chunk goes last, declaring its full size but holding none of
+	// it, which scanWAV accepts as a truncated data chunk.
+	meta = append(meta, dataChunk...)
+	header, _, err := scanWAV(meta)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return header, dataOff, dataLen, nil
+}
+
+// SampleFormat selects how WriteWAVFormat encodes samples.
+// The zero value is 16-bit PCM.
+type SampleFormat int
+
+const (
+	PCM16 SampleFormat = iota
+	PCM8
+	PCM24
+	Float32
+	PCM32
+)
+
+// sampleFormatNames lists the out_bits value of each SampleFormat.
+var sampleFormatNames = []struct {
+	name   string
+	format SampleFormat
+}{
+	{"8", PCM8},
+	{"16", PCM16},
+	{"24", PCM24},
+	{"32", PCM32},
+	{"32f", Float32},
+}
+
+// ParseSampleFormat parses an out_bits value: "8", "16", "24", "32"
+// (integer) or "32f" (float).
+func ParseSampleFormat(s string) (SampleFormat, error) {
+	for _, f := range sampleFormatNames {
+		if f.name == s {
+			return f.format, nil
+		}
+	}
+	return 0, fmt.Errorf("wav: unsupported output format %q (want 8, 16, 24, 32 or 32f)", s)
+}
+
+// BitsPerSample returns the sample width of f in bits.
+func (f SampleFormat) BitsPerSample() int {
+	switch f {
+	case PCM8:
+		return 8
+	case PCM24:
+		return 24
+	case Float32, PCM32:
+		return 32
+	}
+	return 16
+}
+
+// isFloat reports whether f is an IEEE float format (WAV format 3).
+func (f SampleFormat) isFloat() bool {
+	return f == Float32
+}
+
+// headerSize returns the size of the header WriteWAVFormat emits before the
+// sample data. Float files carry an extended fmt chunk and a fact chunk.
+func (f SampleFormat) headerSize() int {
+	if f.isFloat() {
+		return 58
+	}
+	return 44
+}
+
+// wavSize returns the size in bytes of the file WriteWAVFormat produces for
+// numSamples samples (across all channels).
+func wavSize(numSamples int, format SampleFormat) int {
+	return format.headerSize() + numSamples*format.BitsPerSample()/8
 }
 
 // WriteWAV encodes mono float64 samples (in [-1.0, +1.0]) as a 16-bit PCM WAV file.
 func WriteWAV(samples []float64, sampleRate int) []byte {
+	return WriteWAVFormat(samples, sampleRate, PCM16)
+}
+
+// WriteWAVFormat encodes mono float64 samples as a WAV file in the given
+// sample format. Integer formats clamp samples to [-1.0, +1.0]; Float32
+// stores them unchanged.
+func WriteWAVFormat(samples []float64, sampleRate int, format SampleFormat) []byte {
+	return encodeWAV(samples, sampleRate, 1, format)
+}
+
+// EncodeWAV encodes samples as a WAV file described by header: its sample
+// rate, channel count, bits per sample (8, 16, 24 or 32 integer PCM, or 32
+// float), any smpl and cue chunks, which follow the data chunk, and any
+// extra chunks, which precede it. A ChannelMask makes the fmt chunk
+// WAVE_FORMAT_EXTENSIBLE.
+// Multichannel samples are interleaved, so len(samples) must be a multiple
+// of header.NumChannels.
+func EncodeWAV(samples []float64, header WAVHeader) ([]byte, error) {
+	if header.SampleRate <= 0 {
+		return nil, fmt.Errorf("wav: invalid sample rate %d", header.SampleRate)
+	}
+	if header.NumChannels < 1 {
+		return nil, fmt.Errorf("wav: invalid channel count %d", header.NumChannels)
+	}
+	if header.Companding != "" {
+		return nil, fmt.Errorf("wav: %s encoding is not supported", header.Companding)
+	}
+	if header.ADPCMBlockAlign > 0 {
+		return nil, fmt.Errorf("wav: IMA ADPCM encoding is not supported")
+	}
+	format, err := header.sampleFormat()
+	if err != nil {
+		return nil, err
+	}
+	if len(samples)%header.NumChannels != 0 {
+		return nil, fmt.Errorf("wav: %d samples do not divide into %d channels", len(samples), header.NumChannels)
+	}
+	data := encodeWAV(samples, header.SampleRate, header.NumChannels, format)
+	if header.Extra != nil {
+		dataStart := format.headerSize() - 8
+		withExtra := slices.Clone(data[:dataStart])
+		for _, c := range header.Extra.Chunks {
+			withExtra = appendChunk(withExtra, c.ID, c.Data)
+		}
+		data = append(withExtra, data[dataStart:]...)
+		binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	}
+	if header.ChannelMask != 0 {
+		// Swap the fmt chunk for an extensible one carrying the mask.
+		fmtEnd := 20 + int(binary.LittleEndian.Uint32(data[16:20]))
+		withMask := appendExtensibleFmt(slices.Clone(data[:12]), header.SampleRate, header.NumChannels, format, header.ChannelMask)
+		data = append(withMask, data[fmtEnd:]...)
+		binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	}
+	if header.Sampler == nil && header.Cue == nil {
+		return data, nil
+	}
+	if len(data)%2 != 0 {
+		data = append(data, 0) // pad the data chunk
+	}
+	if header.Sampler != nil {
+		data = appendSampler(data, header.Sampler)
+	}
+	if header.Cue != nil {
+		data = appendCue(data, header.Cue)
+	}
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(data)-8))
+	return data, nil
+}
+
+// sampleFormat returns the SampleFormat EncodeWAV writes h's samples in.
+func (h WAVHeader) sampleFormat() (SampleFormat, error) {
+	var format SampleFormat
+	switch h.BitsPerSample {
+	case 8:
+		format = PCM8
+	case 16:
+		format = PCM16
+	case 24:
+		format = PCM24
+	case 32:
+		format = PCM32
+	default:
+		return 0, fmt.Errorf("wav: unsupported bits per sample %d (want 8, 16, 24 or 32)", h.BitsPerSample)
+	}
+	if h.Float {
+		if format != PCM32 {
+			return 0, fmt.Errorf("wav: unsupported float bits per sample %d (want 32)", h.BitsPerSample)
+		}
+		format = Float32
+	}
+	return format, nil
+}
+
+// encodedWAVSize returns the size in bytes of the file EncodeWAV produces
+// for numSamples samples (across all channels) in format, with the
+// metadata chunks of header.
+func encodedWAVSize(numSamples int, header WAVHeader, format SampleFormat) int {
+	size := wavSize(numSamples, format)
+	if header.ChannelMask != 0 {
+		size += extensibleFmtSize - 16
+		if format.isFloat() {
+			size -= 2 // the float fmt chunk's extension size
+		}
+	}
+	if header.Extra != nil {
+		for _, c := range header.Extra.Chunks {
+			size += 8 + len(c.Data) + len(c.Data)%2
+		}
+	}
+	if header.Sampler != nil || header.Cue != nil {
+		size += size % 2 // data chunk padding
+	}
+	if header.Sampler != nil {
+		size += len(appendSampler(nil, header.Sampler))
+	}
+	if header.Cue != nil {
+		size += len(appendCue(nil, header.Cue))
+	}
+	return size
+}
+
+// EncodeWAVTo is WriteWAVFormat writing the file to w. Samples are encoded
+// in blocks, so the file is never held in memory.
+func EncodeWAVTo(w io.Writer, samples []float64, sampleRate int, format SampleFormat) error {
+	return writeWAV(w, samples, sampleRate, 1, format)
+}
+
+// encodeWAV encodes interleaved samples of numChannels channels as a WAV
+// file in the given sample format.
+func encodeWAV(samples []float64, sampleRate, numChannels int, format SampleFormat) []byte {
+	buf := &bytes.Buffer{}
+	buf.Grow(wavSize(len(samples), format))
+	writeWAV(buf, samples, sampleRate, numChannels, format) // a bytes.Buffer never fails
+	return buf.Bytes()
+}
+
+// writeWAVBlock is how many bytes of samples writeWAV encodes per write.
+const writeWAVBlock = 64 * 1024
+
+// writeWAV writes interleaved samples of numChannels channels to w as a WAV
+// file in the given sample format.
+func writeWAV(w io.Writer, samples []float64, sampleRate, numChannels int, format SampleFormat) error {
 	numSamples := len(samples)
-	dataSize := numSamples * 2 // 16-bit = 2 bytes per sample
-	fileSize := 36 + dataSize  // total file size minus 8 bytes for RIFF header
+	dataSize := numSamples * format.BitsPerSample() / 8
+	fileSize := wavSize(numSamples, format) - 8 // total file size minus 8 bytes for RIFF header
+	if err := writeWAVHeader(w, uint32(fileSize), uint32(dataSize), uint32(numSamples/numChannels), sampleRate, numChannels, format); err != nil {
+		return err
+	}
+	return writeSamples(w, samples, format)
+}
+
+// writeWAVHeader writes the header of a WAV file in the given sample format
+// to w, up to the start of the samples, with the given RIFF size, data
+// chunk size and, for float formats, frame count for the fact chunk.
+func writeWAVHeader(w io.Writer, fileSize, dataSize, frames uint32, sampleRate, numChannels int, format SampleFormat) error {
+	blockAlign := numChannels * format.BitsPerSample() / 8
 
 	buf := &bytes.Buffer{}
-	buf.Grow(44 + dataSize)
+	buf.Grow(format.headerSize())
 
 	// RIFF header.
 	buf.WriteString("RIFF")
-	binary.Write(buf, binary.LittleEndian, uint32(fileSize))
+	binary.Write(buf, binary.LittleEndian, fileSize)
 	buf.WriteString("WAVE")
 
 	// fmt chunk.
 	buf.WriteString("fmt ")
-	binary.Write(buf, binary.LittleEndian, uint32(16)) // chunk size
-	binary.Write(buf, binary.LittleEndian, uint16(1))  // PCM format
-	binary.Write(buf, binary.LittleEndian, uint16(1))  // mono
+	if format.isFloat() {
+		binary.Write(buf, binary.LittleEndian, uint32(18)) // chunk size
+		binary.Write(buf, binary.LittleEndian, uint16(3))  // IEEE float format
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint32(16)) // chunk size
+		binary.Write(buf, binary.LittleEndian, uint16(1))  // PCM format
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(numChannels))
 	binary.Write(buf, binary.LittleEndian, uint32(sampleRate))
-	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*2)) // byte rate
-	binary.Write(buf, binary.LittleEndian, uint16(2))            // block align
-	binary.Write(buf, binary.LittleEndian, uint16(16))           // bits per sample
+	binary.Write(buf, binary.LittleEndian, uint32(sampleRate*blockAlign))  // byte rate
+	binary.Write(buf, binary.LittleEndian, uint16(blockAlign))             // block align
+	binary.Write(buf, binary.LittleEndian, uint16(format.BitsPerSample())) // bits per sample
+	if format.isFloat() {
+		binary.Write(buf, binary.LittleEndian, uint16(0)) // extension size
+
+		// fact chunk (required for non-PCM formats).
+		buf.WriteString("fact")
+		binary.Write(buf, binary.LittleEndian, uint32(4))
+		binary.Write(buf, binary.LittleEndian, frames)
+	}
 
 	// data chunk.
 	buf.WriteString("data")
-	binary.Write(buf, binary.LittleEndian, uint32(dataSize))
+	binary.Write(buf, binary.LittleEndian, dataSize)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
 
-	for _, s := range samples {
-		// Clamp to [-1, 1].
-		if s > 1.0 {
-			s = 1.0
-		} else if s < -1.0 {
-			s = -1.0
-		}
-		// Convert to int16.
-		var i16 int16
-		if s >= 0 {
-			i16 = int16(math.Round(s * 32767))
-		} else {
-			i16 = int16(math.Round(s * 32768))
+// writeSamples encodes samples in the given format and writes them to w,
+// a block at a time.
+func writeSamples(w io.Writer, samples []float64, format SampleFormat) error {
+	bytesPerSample := format.BitsPerSample() / 8
+	block := make([]byte, 0, min(len(samples)*bytesPerSample, writeWAVBlock))
+	for i, s := range samples {
+		block = block[:len(block)+bytesPerSample]
+		encodeSample(block[len(block)-bytesPerSample:], s, format)
+		if len(block)+bytesPerSample > cap(block) || i == len(samples)-1 {
+			if _, err := w.Write(block); err != nil {
+				return err
+			}
+			block = block[:0]
 		}
-		binary.Write(buf, binary.LittleEndian, i16)
 	}
+	return nil
+}
 
-	return buf.Bytes()
+// encodeSample writes s into dst (len = bytes per sample) in the given format.
+func encodeSample(dst []byte, s float64, format SampleFormat) {
+	if format == Float32 {
+		binary.LittleEndian.PutUint32(dst, math.Float32bits(float32(s)))
+		return
+	}
+
+	// Clamp to [-1, 1].
+	if s > 1.0 {
+		s = 1.0
+	} else if s < -1.0 {
+		s = -1.0
+	}
+
+	switch format {
+	case PCM8:
+		// 8-bit WAV is unsigned with a 128 offset.
+		dst[0] = byte(quantize(s, 7) + 128)
+	case PCM24:
+		v := quantize(s, 23)
+		dst[0] = byte(v)
+		dst[1] = byte(v >> 8)
+		dst[2] = byte(v >> 16)
+	case PCM32:
+		binary.LittleEndian.PutUint32(dst, uint32(quantize(s, 31)))
+	default:
+		binary.LittleEndian.PutUint16(dst, uint16(int16(quantize(s, 15))))
+	}
+}
+
+// quantize maps s in [-1, 1] to a signed integer with the given number of
+// magnitude bits, using the full negative range (e.g. -32768..32767).
+func quantize(s float64, bits uint) int32 {
+	scale := float64(int64(1) << bits)
+	if s >= 0 {
+		return int32(math.Round(s * (scale - 1)))
+	}
+	return int32(math.Round(s * scale))
 }
@@ -0,0 +1,1100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWriteWAVFormatLayout(t *testing.T) {
+	samples := []float64{0, 0.5, -0.5, 1, -1, 2}
+	for _, tc := range []struct {
+		format SampleFormat
+		tag    uint16
+		bits   uint16
+	}{
+		{PCM8, 1, 8},
+		{PCM16, 1, 16},
+		{PCM24, 1, 24},
+		{Float32, 3, 32},
+		{PCM32, 1, 32},
+	} {
+		data := WriteWAVFormat(samples, 8000, tc.format)
+		if len(data) != wavSize(len(samples), tc.format) {
+			t.Fatalf("format %d: expected %d bytes, got %d", tc.format, wavSize(len(samples), tc.format), len(data))
+		}
+		if riff := binary.LittleEndian.Uint32(data[4:8]); int(riff) != len(data)-8 {
+			t.Fatalf("format %d: RIFF size %d, want %d", tc.format, riff, len(data)-8)
+		}
+		if tag := binary.LittleEndian.Uint16(data[20:22]); tag != tc.tag {
+			t.Fatalf("format %d: expected format tag %d, got %d", tc.format, tc.tag, tag)
+		}
+		if bits := binary.LittleEndian.Uint16(data[34:36]); bits != tc.bits {
+			t.Fatalf("format %d: expected %d bits, got %d", tc.format, tc.bits, bits)
+		}
+	}
+
+	// Spot-check sample encodings.
+	pcm8 := WriteWAVFormat(samples, 8000, PCM8)[44:]
+	if pcm8[0] != 128 || pcm8[3] != 255 || pcm8[4] != 0 || pcm8[5] != 255 {
+		t.Fatalf("unexpected 8-bit encoding %v", pcm8)
+	}
+	f32 := WriteWAVFormat(samples, 8000, Float32)[58:]
+	if v := math.Float32frombits(binary.LittleEndian.Uint32(f32[5*4:])); v != 2 {
+		t.Fatalf("float samples should not be clamped, got %f", v)
+	}
+}
+
+func TestParseSampleFormat(t *testing.T) {
+	for in, want := range map[string]SampleFormat{"8": PCM8, "16": PCM16, "24": PCM24, "32": PCM32, "32f": Float32} {
+		got, err := ParseSampleFormat(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseSampleFormat(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseSampleFormat("64"); err == nil {
+		t.Fatal("expected error for 64")
+	}
+}
+
+func TestValidateWAV(t *testing.T) {
+	data := WriteWAV(make([]float64, 44100*10), 44100)
+
+	header, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("ValidateWAV: %v", err)
+	}
+	if header.SampleRate != 44100 || header.NumChannels != 1 || header.BitsPerSample != 16 {
+		t.Fatalf("unexpected header %+v", header)
+	}
+
+	// Only the header is allocated, however long the file, even when a
+	// stereo file is read through for effectively-mono detection.
+	stereo, err := EncodeWAV(make([]float64, 2*44100*10), WAVHeader{SampleRate: 44100, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, d := range map[string][]byte{"mono": data, "stereo": stereo} {
+		allocs := testing.AllocsPerRun(10, func() { ValidateWAV(d) })
+		if allocs > 1 {
+			t.Fatalf("%s: expected at most 1 allocation, got %.0f", name, allocs)
+		}
+	}
+
+	// Unsupported format: ADPCM.
+	adpcm := WriteWAV(make([]float64, 100), 44100)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+	if _, err := ValidateWAV(adpcm); err == nil || !strings.Contains(err.Error(), "unsupported audio format 2") {
+		t.Fatalf("expected unsupported format error, got %v", err)
+	}
+
+	// Unsupported bit depth.
+	pcm12 := WriteWAV(make([]float64, 100), 44100)
+	binary.LittleEndian.PutUint16(pcm12[34:36], 12)
+	if _, err := ValidateWAV(pcm12); err == nil || !strings.Contains(err.Error(), "bits per sample 12") {
+		t.Fatalf("expected unsupported bits error, got %v", err)
+	}
+}
+
+func TestWAVRoundtrip8Bit(t *testing.T) {
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = 0.9 * math.Sin(2*math.Pi*float64(i)/100)
+	}
+	samples[0], samples[1] = 1, -1
+
+	data := WriteWAVFormat(samples, 8000, PCM8)
+	header, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("8-bit PCM rejected: %v", err)
+	}
+	if header.BitsPerSample != 8 {
+		t.Fatalf("expected 8 bits, got %d", header.BitsPerSample)
+	}
+
+	recovered, sr, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != 8000 || len(recovered) != len(samples) {
+		t.Fatalf("expected %d samples at 8000 Hz, got %d at %d", len(samples), len(recovered), sr)
+	}
+	for i := range samples {
+		if diff := math.Abs(samples[i] - recovered[i]); diff > 2.0/(1<<7) {
+			t.Fatalf("sample %d: expected %.4f, got %.4f (diff=%e)", i, samples[i], recovered[i], diff)
+		}
+	}
+
+	// Unsigned: 128 is silence, 0 full-scale negative.
+	if got, _, _ := ReadWAV(append(data[:44:44], 128, 0, 255)); !slices.Equal(got, []float64{0, -1, 127.0 / 128}) {
+		t.Fatalf("expected 128, 0 and 255 to decode to 0, -1 and 127/128, got %v", got)
+	}
+}
+
+func TestWAVRoundtrip24Bit(t *testing.T) {
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = 0.9 * math.Sin(2*math.Pi*float64(i)/100)
+	}
+	samples[0], samples[1] = 1, -1
+
+	data := WriteWAVFormat(samples, 48000, PCM24)
+	header, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("24-bit PCM rejected: %v", err)
+	}
+	if header.BitsPerSample != 24 {
+		t.Fatalf("expected 24 bits, got %d", header.BitsPerSample)
+	}
+
+	recovered, sr, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != 48000 || len(recovered) != len(samples) {
+		t.Fatalf("expected %d samples at 48000 Hz, got %d at %d", len(samples), len(recovered), sr)
+	}
+	for i := range samples {
+		if diff := math.Abs(samples[i] - recovered[i]); diff > 2.0/(1<<23) {
+			t.Fatalf("sample %d: expected %.9f, got %.9f (diff=%e)", i, samples[i], recovered[i], diff)
+		}
+	}
+	if recovered[1] != -1 {
+		t.Fatalf("expected the most negative sample to decode to -1, got %g", recovered[1])
+	}
+}
+
+// floatWAV builds a mono IEEE float WAV file of the given sample width.
+func floatWAV(samples []float64, sampleRate, bits int) []byte {
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, 3)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 1)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate*bits/8))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(bits/8))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(bits))
+	var data []byte
+	for _, s := range samples {
+		if bits == 64 {
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(s))
+		} else {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(s)))
+		}
+	}
+	return riffFile(riffChunk("fmt ", fmtChunk, true), riffChunk("data", data, true))
+}
+
+func TestReadFloatWAV(t *testing.T) {
+	samples := []float64{0, 0.5, -0.25, 1, -1, 1.5, math.NaN(), math.Inf(1), math.Inf(-1)}
+	want := []float64{0, 0.5, -0.25, 1, -1, 1.5, 0, 1, -1}
+	for name, data := range map[string][]byte{
+		"float32":        floatWAV(samples, 44100, 32),
+		"float64":        floatWAV(samples, 44100, 64),
+		"WriteWAVFormat": WriteWAVFormat(samples, 44100, Float32),
+	} {
+		header, err := ValidateWAV(data)
+		if err != nil {
+			t.Fatalf("%s: rejected: %v", name, err)
+		}
+		if !header.Float {
+			t.Fatalf("%s: expected a float header, got %+v", name, header)
+		}
+		got, sr, err := ReadWAV(data)
+		if err != nil || sr != 44100 || !slices.Equal(got, want) {
+			t.Fatalf("%s: got %v at %d Hz (err %v), want %v", name, got, sr, err, want)
+		}
+		streamed, _, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil || !slices.Equal(streamed, want) {
+			t.Fatalf("%s: DecodeWAVFrom got %v (err %v), want %v", name, streamed, err, want)
+		}
+	}
+
+	// Float files re-encode as float.
+	data, err := EncodeWAV(want, WAVHeader{SampleRate: 44100, NumChannels: 1, BitsPerSample: 32, Float: true})
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	if got, _, err := ReadWAV(data); err != nil || !slices.Equal(got, want) {
+		t.Fatalf("re-encoded: got %v (err %v), want %v", got, err, want)
+	}
+
+	// Float widths other than 32 and 64 bits are rejected.
+	var werr *WAVError
+	if _, err := ValidateWAV(floatWAV(samples, 44100, 16)); !errors.As(err, &werr) || werr.Code != ErrUnsupportedBits {
+		t.Fatalf("16-bit float: expected ErrUnsupportedBits, got %v", err)
+	}
+}
+
+// extensibleWAV rewrites a mono WAV file from WriteWAVFormat with a
+// WAVE_FORMAT_EXTENSIBLE fmt chunk naming the same format as its
+// SubFormat, and the given valid bits per sample.
+func extensibleWAV(data []byte, validBits int) []byte {
+	fmtChunk := slices.Clone(data[20:36])
+	tag := binary.LittleEndian.Uint16(fmtChunk[0:2])
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], formatExtensible)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 22) // extension size
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(validBits))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 0x4) // front center
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, tag)
+	fmtChunk = append(fmtChunk, ksDataFormatSuffix...)
+	dataStart := bytes.Index(data, []byte("data"))
+	return riffFile(riffChunk("fmt ", fmtChunk, true), data[dataStart:])
+}
+
+func TestReadExtensibleWAV(t *testing.T) {
+	samples := []float64{0, 0.5, -0.25, 0.75, -1}
+	for _, tc := range []struct {
+		format    SampleFormat
+		validBits int
+	}{
+		{PCM16, 16},
+		{PCM32, 24},
+		{Float32, 32},
+	} {
+		plain := WriteWAVFormat(samples, 48000, tc.format)
+		data := extensibleWAV(plain, tc.validBits)
+		want, _, err := ReadWAV(plain)
+		if err != nil {
+			t.Fatalf("format %d: ReadWAV of the plain file: %v", tc.format, err)
+		}
+		got, sr, err := ReadWAV(data)
+		if err != nil || sr != 48000 || !slices.Equal(got, want) {
+			t.Fatalf("format %d: got %v at %d Hz (err %v), want %v", tc.format, got, sr, err, want)
+		}
+		streamed, _, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil || !slices.Equal(streamed, want) {
+			t.Fatalf("format %d: DecodeWAVFrom got %v (err %v), want %v", tc.format, streamed, err, want)
+		}
+
+		header, err := ValidateWAV(data)
+		if err != nil {
+			t.Fatalf("format %d: ValidateWAV: %v", tc.format, err)
+		}
+		wantValid := 0
+		if tc.validBits < tc.format.BitsPerSample() {
+			wantValid = tc.validBits
+		}
+		if header.Float != tc.format.isFloat() || header.ValidBitsPerSample != wantValid {
+			t.Fatalf("format %d: got header %+v", tc.format, header)
+		}
+	}
+
+	// Sub-formats other than PCM and float are still rejected, as are
+	// more valid bits than the samples hold.
+	adpcm := extensibleWAV(WriteWAV(samples, 48000), 16)
+	adpcm[44] = 2
+	var werr *WAVError
+	if _, err := ValidateWAV(adpcm); !errors.As(err, &werr) || werr.Code != ErrUnsupportedFormat {
+		t.Fatalf("ADPCM sub-format: expected ErrUnsupportedFormat, got %v", err)
+	}
+	if _, err := ValidateWAV(extensibleWAV(WriteWAV(samples, 48000), 24)); !errors.As(err, &werr) || werr.Code != ErrMalformed {
+		t.Fatalf("24 valid bits in 16: expected ErrMalformed, got %v", err)
+	}
+}
+
+// rf64WAV rewrites a WAV file from WriteWAV as an RF64 file with the given
+// ID, whose sizes are in a ds64 chunk, followed by a trailing chunk.
+func rf64WAV(data []byte, id string) []byte {
+	dataStart := bytes.Index(data, []byte("data"))
+	samples := data[dataStart+8:]
+	ds64 := binary.LittleEndian.AppendUint64(nil, 0) // RIFF size, patched below
+	ds64 = binary.LittleEndian.AppendUint64(ds64, uint64(len(samples)))
+	ds64 = binary.LittleEndian.AppendUint64(ds64, uint64(len(samples)/2))
+	ds64 = binary.LittleEndian.AppendUint32(ds64, 0) // table length
+
+	out := append([]byte(id+"\xff\xff\xff\xffWAVE"), riffChunk("ds64", ds64, true)...)
+	out = append(out, data[12:dataStart]...)
+	out = append(out, "data\xff\xff\xff\xff"...)
+	out = append(out, samples...)
+	out = append(out, riffChunk("LIST", []byte("INFO"), true)...)
+	binary.LittleEndian.PutUint64(out[20:28], uint64(len(out)-8))
+	return out
+}
+
+func TestReadRF64(t *testing.T) {
+	samples := pseudoNoise(1000, 7, 0.5)
+	plain := WriteWAV(samples, 44100)
+	want, _, err := ReadWAV(plain)
+	if err != nil {
+		t.Fatalf("ReadWAV of the plain file: %v", err)
+	}
+	for _, id := range []string{"RF64", "BW64"} {
+		data := rf64WAV(plain, id)
+		// The data chunk size comes from ds64, so the trailing chunk is
+		// not read as samples.
+		got, sr, err := ReadWAV(data)
+		if err != nil || sr != 44100 || !slices.Equal(got, want) {
+			t.Fatalf("%s: got %d samples at %d Hz (err %v), want %d", id, len(got), sr, err, len(want))
+		}
+		streamed, _, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil || !slices.Equal(streamed, want) {
+			t.Fatalf("%s: DecodeWAVFrom got %d samples (err %v), want %d", id, len(streamed), err, len(want))
+		}
+	}
+
+	// Its placeholder sizes are not "repaired".
+	data := rf64WAV(plain, "RF64")
+	if repaired, err := RepairWAVSizes(data); err != nil || !bytes.Equal(repaired, data) {
+		t.Fatalf("RepairWAVSizes changed an RF64 file (err %v)", err)
+	}
+
+	// A ds64 chunk too short to hold the sizes is malformed.
+	short := rf64WAV(plain, "RF64")
+	binary.LittleEndian.PutUint32(short[16:20], 8)
+	for name, err := range map[string]error{
+		"ReadWAV":       func() error { _, _, err := ReadWAV(short); return err }(),
+		"DecodeWAVFrom": func() error { _, _, err := DecodeWAVFrom(bytes.NewReader(short), int64(len(short))); return err }(),
+	} {
+		var werr *WAVError
+		if !errors.As(err, &werr) || werr.Code != ErrMalformed {
+			t.Fatalf("%s: short ds64: expected ErrMalformed, got %v", name, err)
+		}
+	}
+}
+
+// g711WAV builds a mono 8 kHz G.711 WAV file of the given format tag
+// (6 for A-law, 7 for mu-law) holding codes.
+func g711WAV(tag uint16, codes []byte) []byte {
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, tag)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 1)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000)
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, 8000)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 1)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 8)
+	return riffFile(riffChunk("fmt ", fmtChunk, true), riffChunk("data", codes, true))
+}
+
+func TestReadG711WAV(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		tag   uint16
+		codes []byte
+		want  []int // 16-bit values per G.711
+	}{
+		{"A-law", 6, []byte{0xD5, 0x55, 0xAA, 0x2A, 0xD4}, []int{8, -8, 32256, -32256, 24}},
+		{"mu-law", 7, []byte{0xFF, 0x7F, 0x80, 0x00, 0xFE}, []int{0, 0, 32124, -32124, 8}},
+	} {
+		data := g711WAV(tc.tag, tc.codes)
+		header, err := ValidateWAV(data)
+		if err != nil {
+			t.Fatalf("%s: rejected: %v", tc.name, err)
+		}
+		if header.Companding == "" {
+			t.Fatalf("%s: expected a companded header, got %+v", tc.name, header)
+		}
+		got, sr, err := ReadWAV(data)
+		if err != nil || sr != 8000 || len(got) != len(tc.want) {
+			t.Fatalf("%s: got %v at %d Hz (err %v)", tc.name, got, sr, err)
+		}
+		for i, v := range tc.want {
+			if got[i] != float64(v)/32768 {
+				t.Fatalf("%s: code %#x decoded to %g, want %d/32768", tc.name, tc.codes[i], got[i]*32768, v)
+			}
+		}
+
+		// Every code decodes within full scale, and the 128 codes of
+		// each sign to distinct levels.
+		all := make([]byte, 256)
+		for i := range all {
+			all[i] = byte(i)
+		}
+		decoded, _, err := ReadWAV(g711WAV(tc.tag, all))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slices.Max(decoded) > 1 || slices.Min(decoded) < -1 {
+			t.Fatalf("%s: decoded range [%g, %g] exceeds full scale", tc.name, slices.Min(decoded), slices.Max(decoded))
+		}
+		mags := make(map[float64]bool)
+		for _, v := range decoded {
+			mags[math.Abs(v)] = true
+		}
+		if len(mags) != 128 {
+			t.Fatalf("%s: expected 128 distinct magnitudes, got %d", tc.name, len(mags))
+		}
+	}
+
+	// G.711 is 8 bits per sample only.
+	data := g711WAV(7, make([]byte, 10))
+	binary.LittleEndian.PutUint16(data[34:36], 16)
+	var werr *WAVError
+	if _, err := ValidateWAV(data); !errors.As(err, &werr) || werr.Code != ErrUnsupportedBits {
+		t.Fatalf("16-bit mu-law: expected ErrUnsupportedBits, got %v", err)
+	}
+}
+
+// imaADPCMWAV encodes interleaved 16-bit samples of the given channel
+// count as an IMA ADPCM WAV file with blocks of blockAlign bytes, padding
+// the last block with silence.
+func imaADPCMWAV(samples []int, channels, sampleRate, blockAlign int) []byte {
+	groups := (blockAlign - 4*channels) / (4 * channels)
+	frames := 1 + 8*groups
+	var data []byte
+	indexes := make([]int, channels) // carried across blocks, as encoders do
+	for start := 0; start < len(samples); start += frames * channels {
+		block := make([]byte, blockAlign)
+		sample := func(frame, c int) int {
+			if i := start + frame*channels + c; i < len(samples) {
+				return samples[i]
+			}
+			return 0
+		}
+		for c := range channels {
+			predictor, index := sample(0, c), indexes[c]
+			binary.LittleEndian.PutUint16(block[4*c:], uint16(int16(predictor)))
+			block[4*c+2] = byte(index)
+			for f := 1; f < frames; f++ {
+				diff := sample(f, c) - predictor
+				var code byte
+				if diff < 0 {
+					code, diff = 8, -diff
+				}
+				for bit, step := byte(4), imaStepTable[index]; bit > 0; bit, step = bit>>1, step>>1 {
+					if diff >= step {
+						code |= bit
+						diff -= step
+					}
+				}
+				predictor, index = imaStep(predictor, index, code)
+				g, k := (f-1)/8, (f-1)%8
+				block[4*channels+(g*channels+c)*4+k/2] |= code << (4 * (k % 2))
+			}
+			indexes[c] = index
+		}
+		data = append(data, block...)
+	}
+
+	fmtChunk := binary.LittleEndian.AppendUint16(nil, 0x11)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(channels))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate))
+	fmtChunk = binary.LittleEndian.AppendUint32(fmtChunk, uint32(sampleRate*blockAlign/frames))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(blockAlign))
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 4)
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, 2) // extension size
+	fmtChunk = binary.LittleEndian.AppendUint16(fmtChunk, uint16(frames))
+	return riffFile(riffChunk("fmt ", fmtChunk, true), riffChunk("data", data, true))
+}
+
+func TestReadIMAADPCMWAV(t *testing.T) {
+	const sampleRate = 8000
+	for _, channels := range []int{1, 2} {
+		blockAlign := 256 * channels
+		frames := 1 + 8*(blockAlign-4*channels)/(4*channels)
+		n := 3 * frames // whole blocks
+		want := make([]float64, n*channels)
+		pcm := make([]int, n*channels)
+		for i := range n {
+			for c := range channels {
+				v := int(16000 * math.Sin(2*math.Pi*float64((c+1)*440*i)/sampleRate))
+				pcm[i*channels+c], want[i*channels+c] = v, float64(v)/32768
+			}
+		}
+		data := imaADPCMWAV(pcm, channels, sampleRate, blockAlign)
+
+		header, err := ValidateWAV(data)
+		if err != nil {
+			t.Fatalf("%d channels: rejected: %v", channels, err)
+		}
+		if header.ADPCMBlockAlign != blockAlign {
+			t.Fatalf("%d channels: got header %+v", channels, header)
+		}
+		got, sr, err := ReadWAVChannels(data)
+		if err != nil || sr != sampleRate || len(got) != channels || len(got[0]) != n {
+			t.Fatalf("%d channels: got %d channels at %d Hz (err %v)", channels, len(got), sr, err)
+		}
+		errs := Interleave(got)
+		for i := range errs {
+			errs[i] -= want[i]
+		}
+		// 4-bit ADPCM keeps a sine some 20 dB above its quantization
+		// noise; a misplaced code or block would swamp it.
+		if snr := 20 * math.Log10(rms(want)/rms(errs)); snr < 20 {
+			t.Fatalf("%d channels: SNR %.1f dB against the encoded signal", channels, snr)
+		}
+
+		mono, _, err := ReadWAV(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		streamed, _, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil || !slices.Equal(streamed, mono) {
+			t.Fatalf("%d channels: DecodeWAVFrom differs from ReadWAV (err %v)", channels, err)
+		}
+
+		// A trailing partial block is dropped.
+		partial := slices.Clone(data)
+		binary.LittleEndian.PutUint32(partial[4:], uint32(len(partial)-8+100))
+		partial = append(partial, make([]byte, 100)...)
+		binary.LittleEndian.PutUint32(partial[bytes.Index(partial, []byte("data"))+4:], uint32(3*blockAlign+100))
+		if got, _, err := ReadWAV(partial); err != nil || !slices.Equal(got, mono) {
+			t.Fatalf("%d channels: partial block: got %d samples (err %v), want %d", channels, len(got), err, len(mono))
+		}
+	}
+
+	// Blocks that cannot hold a header and a group per channel are
+	// malformed.
+	data := imaADPCMWAV(make([]int, 100), 1, sampleRate, 256)
+	binary.LittleEndian.PutUint16(data[32:34], 6)
+	var werr *WAVError
+	if _, err := ValidateWAV(data); !errors.As(err, &werr) || werr.Code != ErrMalformed {
+		t.Fatalf("6-byte blocks: expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestWAVRoundtrip32BitInt(t *testing.T) {
+	samples := make([]float64, 1000)
+	for i := range samples {
+		samples[i] = 0.9 * math.Sin(2*math.Pi*float64(i)/100)
+	}
+	samples[0], samples[1] = 1, -1
+
+	data := WriteWAVFormat(samples, 96000, PCM32)
+	header, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("32-bit PCM rejected: %v", err)
+	}
+	if header.BitsPerSample != 32 {
+		t.Fatalf("expected 32 bits, got %d", header.BitsPerSample)
+	}
+
+	recovered, sr, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != 96000 || len(recovered) != len(samples) {
+		t.Fatalf("expected %d samples at 96000 Hz, got %d at %d", len(samples), len(recovered), sr)
+	}
+	for i := range samples {
+		if diff := math.Abs(samples[i] - recovered[i]); diff > 1e-9 {
+			t.Fatalf("sample %d: expected %.12f, got %.12f (diff=%e)", i, samples[i], recovered[i], diff)
+		}
+	}
+}
+
+func TestReadWAVStereoOddLength(t *testing.T) {
+	// A stereo 32-bit file whose data chunk ends mid-frame.
+	data := WriteWAVFormat([]float64{0.5, -0.5, 0.25, 0.75, 0.1}, 8000, PCM32)
+	binary.LittleEndian.PutUint16(data[22:24], 2) // channels
+	binary.LittleEndian.PutUint16(data[32:34], 8) // block align
+
+	mono, _, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if len(mono) != 2 {
+		t.Fatalf("expected 2 mono samples, got %d", len(mono))
+	}
+	if math.Abs(mono[0]) > 1e-9 || math.Abs(mono[1]-0.5) > 1e-9 {
+		t.Fatalf("unexpected downmix %v", mono)
+	}
+}
+
+func TestEncodeWAVHeader(t *testing.T) {
+	// Interleaved stereo: left ramps up, right ramps down.
+	frames := 500
+	samples := make([]float64, 2*frames)
+	for i := 0; i < frames; i++ {
+		samples[2*i] = float64(i) / float64(frames)
+		samples[2*i+1] = -float64(i) / float64(frames)
+	}
+
+	want := WAVHeader{SampleRate: 22050, NumChannels: 2, BitsPerSample: 32}
+	data, err := EncodeWAV(samples, want)
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	got, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("ValidateWAV: %v", err)
+	}
+	if *got != want {
+		t.Fatalf("expected header %+v, got %+v", want, *got)
+	}
+
+	mono, sr, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	if sr != want.SampleRate || len(mono) != frames {
+		t.Fatalf("expected %d frames at %d Hz, got %d at %d", frames, want.SampleRate, len(mono), sr)
+	}
+	for i, s := range mono {
+		if math.Abs(s) > 1e-9 {
+			t.Fatalf("frame %d: expected channels to cancel, got %g", i, s)
+		}
+	}
+}
+
+func TestEncodeWAVChannelMask(t *testing.T) {
+	// Quad: front left, front right, back left, back right.
+	const mask = 0x33
+	frames := 300
+	samples := make([]float64, 4*frames)
+	for i := range samples {
+		samples[i] = float64(i%4+1) / 8
+	}
+
+	for _, bits := range []int{16, 32} {
+		want := WAVHeader{SampleRate: 48000, NumChannels: 4, BitsPerSample: bits, Float: bits == 32, ChannelMask: mask}
+		data, err := EncodeWAV(samples, want)
+		if err != nil {
+			t.Fatalf("%d bits: EncodeWAV: %v", bits, err)
+		}
+		got, err := ValidateWAV(data)
+		if err != nil {
+			t.Fatalf("%d bits: ValidateWAV: %v", bits, err)
+		}
+		if *got != want {
+			t.Fatalf("%d bits: expected header %+v, got %+v", bits, want, *got)
+		}
+		format, _ := want.sampleFormat()
+		if size := encodedWAVSize(len(samples), want, format); size != len(data) {
+			t.Fatalf("%d bits: encodedWAVSize %d for a %d-byte file", bits, size, len(data))
+		}
+
+		// ReadWAV averages all four channels.
+		mono, _, err := ReadWAV(data)
+		if err != nil {
+			t.Fatalf("%d bits: ReadWAV: %v", bits, err)
+		}
+		if len(mono) != frames || math.Abs(mono[0]-0.3125) > 1e-3 {
+			t.Fatalf("%d bits: expected %d frames of 0.3125, got %d starting %g", bits, frames, len(mono), mono[0])
+		}
+	}
+}
+
+func TestSelectChannel(t *testing.T) {
+	stereo := []float64{0.5, -0.5, 0.25, 0.75}
+	header := &WAVHeader{SampleRate: 8000, NumChannels: 2, BitsPerSample: 16}
+	for _, tc := range []struct {
+		sel  ChannelSelect
+		want []float64
+	}{
+		{MixChannels, []float64{0, 0.5}},
+		{LeftChannel, []float64{0.5, 0.25}},
+		{RightChannel, []float64{-0.5, 0.75}},
+	} {
+		if got := selectChannel(stereo, header, tc.sel); !slices.Equal(got, tc.want) {
+			t.Errorf("channel %d: got %v, want %v", tc.sel, got, tc.want)
+		}
+	}
+
+	// Mono has only the one channel to give.
+	mono := []float64{0.1, 0.2}
+	if got := selectChannel(mono, &WAVHeader{NumChannels: 1}, RightChannel); !slices.Equal(got, mono) {
+		t.Errorf("mono: got %v, want %v", got, mono)
+	}
+	if _, err := ParseChannelSelect("center"); err == nil {
+		t.Error("expected an error for channel \"center\"")
+	}
+}
+
+func TestEncodeWAVInvalidHeader(t *testing.T) {
+	for _, h := range []WAVHeader{
+		{SampleRate: 0, NumChannels: 1, BitsPerSample: 16},
+		{SampleRate: 8000, NumChannels: 0, BitsPerSample: 16},
+		{SampleRate: 8000, NumChannels: 1, BitsPerSample: 12},
+		{SampleRate: 8000, NumChannels: 1, BitsPerSample: 64, Float: true},
+		{SampleRate: 8000, NumChannels: 1, BitsPerSample: 8, Companding: MuLaw},
+		{SampleRate: 8000, NumChannels: 2, BitsPerSample: 16}, // 3 samples
+	} {
+		if _, err := EncodeWAV([]float64{0, 0.5, -0.5}, h); err == nil {
+			t.Errorf("%+v: expected an error", h)
+		}
+	}
+}
+
+func TestFakeStereoDetected(t *testing.T) {
+	frames := 1000
+	channel := make([]float64, frames)
+	for i := range channel {
+		channel[i] = 0.6 * math.Sin(2*math.Pi*float64(i)/37)
+	}
+	fake := make([]float64, 2*frames)
+	stereo := make([]float64, 2*frames)
+	for i, s := range channel {
+		fake[2*i], fake[2*i+1] = s, s
+		stereo[2*i], stereo[2*i+1] = s, 0.6*math.Sin(2*math.Pi*float64(i)/53)
+	}
+	header := WAVHeader{SampleRate: 16000, NumChannels: 2, BitsPerSample: 16}
+
+	data, err := EncodeWAV(fake, header)
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	h, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatalf("ValidateWAV: %v", err)
+	}
+	if !h.EffectivelyMono {
+		t.Fatal("fake stereo not detected")
+	}
+
+	// The decoded mono is exactly the (quantized) channel.
+	mono, _, err := ReadWAV(data)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	want, _, _ := ReadWAV(WriteWAV(channel, 16000))
+	if !slices.Equal(mono, want) {
+		t.Fatal("decoded fake stereo differs from its channel")
+	}
+
+	data, err = EncodeWAV(stereo, header)
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	if h, err := ValidateWAV(data); err != nil || h.EffectivelyMono {
+		t.Fatalf("true stereo reported as mono (err %v)", err)
+	}
+
+	// A source panned by level, right a tenth of left, is fully
+	// correlated but not mono.
+	for i, s := range channel {
+		stereo[2*i], stereo[2*i+1] = s, 0.1*s
+	}
+	data, err = EncodeWAV(stereo, header)
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	if h, err := ValidateWAV(data); err != nil || h.EffectivelyMono {
+		t.Fatalf("level-panned stereo reported as mono (err %v)", err)
+	}
+}
+
+// riffChunk encodes one RIFF chunk, with the padding byte if body is odd
+// unless pad is false.
+func riffChunk(id string, body []byte, pad bool) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(body)))
+	out = append(out, body...)
+	if pad && len(body)%2 != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// riffFile wraps chunks in a RIFF/WAVE header.
+func riffFile(chunks ...[]byte) []byte {
+	out := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for _, c := range chunks {
+		out = append(out, c...)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func TestReadWAVSkipsJunkAndBext(t *testing.T) {
+	samples := []float64{0.5, -0.25, 0.125, -1}
+	plain := WriteWAV(samples, 48000)
+	want, _, err := ReadWAV(plain)
+	if err != nil {
+		t.Fatalf("ReadWAV: %v", err)
+	}
+	fmtAndData := plain[12:] // the fmt and data chunks
+
+	bext := make([]byte, 603) // odd-sized, so followed by a padding byte
+	copy(bext, "Broadcast description")
+
+	for name, data := range map[string][]byte{
+		"padded":   riffFile(riffChunk("JUNK", make([]byte, 200), true), riffChunk("bext", bext, true), fmtAndData),
+		"unpadded": riffFile(riffChunk("JUNK", make([]byte, 200), true), riffChunk("bext", bext, false), fmtAndData),
+	} {
+		got, sr, err := ReadWAV(data)
+		if err != nil {
+			t.Fatalf("%s: ReadWAV: %v", name, err)
+		}
+		if sr != 48000 || !slices.Equal(got, want) {
+			t.Fatalf("%s: expected %v at 48000 Hz, got %v at %d", name, want, got, sr)
+		}
+	}
+
+	// A chunk before fmt declaring more bytes than the file holds.
+	junk := riffChunk("JUNK", make([]byte, 200), true)
+	binary.LittleEndian.PutUint32(junk[4:], 1<<30)
+	if _, err := ValidateWAV(riffFile(junk, fmtAndData)); err == nil || !strings.Contains(err.Error(), "overruns") {
+		t.Fatalf("expected an overrun error, got %v", err)
+	}
+}
+
+func TestDecodeWAVFromMatchesReadWAV(t *testing.T) {
+	stereo := make([]float64, 2*50000) // more than one read block
+	for i := range stereo {
+		stereo[i] = 0.8 * math.Sin(float64(i)/7)
+	}
+	encoded, err := EncodeWAV(stereo, WAVHeader{SampleRate: 32000, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	plain := WriteWAVFormat(pseudoNoise(1001, 9, 0.5), 44100, PCM32)
+	bext := make([]byte, 603)
+
+	for name, data := range map[string][]byte{
+		"stereo":    encoded,
+		"mono32":    plain,
+		"unpadded":  riffFile(riffChunk("bext", bext, false), plain[12:]),
+		"fmtLast":   riffFile(riffChunk("data", plain[44:], true), riffChunk("fmt ", plain[20:36], true)),
+		"truncated": plain[:len(plain)-5],
+	} {
+		want, wantSR, err := ReadWAV(data)
+		if err != nil {
+			t.Fatalf("%s: ReadWAV: %v", name, err)
+		}
+		got, sr, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatalf("%s: DecodeWAVFrom: %v", name, err)
+		}
+		if sr != wantSR || !slices.Equal(got, want) {
+			t.Fatalf("%s: DecodeWAVFrom differs from ReadWAV (%d samples at %d Hz, want %d at %d)", name, len(got), sr, len(want), wantSR)
+		}
+	}
+
+	// Both reject the same malformed files with the same error.
+	junk := riffChunk("JUNK", make([]byte, 200), true)
+	binary.LittleEndian.PutUint32(junk[4:], 1<<30)
+	adpcm := slices.Clone(plain)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+	for name, data := range map[string][]byte{
+		"short":   plain[:8],
+		"overrun": riffFile(junk, plain[12:]),
+		"adpcm":   adpcm,
+		"noData":  plain[:36],
+		"noFmt":   riffFile(plain[36:]),
+	} {
+		_, _, want := ReadWAV(data)
+		_, _, err := DecodeWAVFrom(bytes.NewReader(data), int64(len(data)))
+		if want == nil || err == nil || err.Error() != want.Error() {
+			t.Fatalf("%s: expected error %v, got %v", name, want, err)
+		}
+	}
+}
+
+func TestEncodeWAVToMatchesWriteWAVFormat(t *testing.T) {
+	samples := pseudoNoise(40000, 4, 0.7) // more than one write block
+	for _, format := range []SampleFormat{PCM8, PCM16, PCM24, PCM32, Float32} {
+		var buf bytes.Buffer
+		if err := EncodeWAVTo(&buf, samples, 48000, format); err != nil {
+			t.Fatalf("format %d: EncodeWAVTo: %v", format, err)
+		}
+		if !bytes.Equal(buf.Bytes(), WriteWAVFormat(samples, 48000, format)) {
+			t.Fatalf("format %d: EncodeWAVTo differs from WriteWAVFormat", format)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWAVTo(&buf, nil, 8000, PCM16); err != nil || !bytes.Equal(buf.Bytes(), WriteWAV(nil, 8000)) {
+		t.Fatalf("empty input: expected a bare header, got %d bytes (err %v)", buf.Len(), err)
+	}
+}
+
+func TestInterleaveRoundTrip(t *testing.T) {
+	for _, channels := range []int{1, 2, 6} {
+		samples := pseudoNoise(channels*100, uint32(channels), 0.5)
+		split := Deinterleave(samples, channels)
+		if len(split) != channels {
+			t.Fatalf("%d channels: got %d slices", channels, len(split))
+		}
+		for c, ch := range split {
+			if len(ch) != 100 {
+				t.Fatalf("%d channels: channel %d has %d samples, want 100", channels, c, len(ch))
+			}
+			if ch[3] != samples[3*channels+c] {
+				t.Fatalf("%d channels: channel %d frame 3 is %v, want %v", channels, c, ch[3], samples[3*channels+c])
+			}
+		}
+		if got := Interleave(split); !slices.Equal(got, samples) {
+			t.Fatalf("%d channels: round trip did not reproduce the input", channels)
+		}
+	}
+
+	// A trailing partial frame is dropped.
+	if split := Deinterleave([]float64{1, 2, 3, 4, 5}, 2); !slices.Equal(split[0], []float64{1, 3}) || !slices.Equal(split[1], []float64{2, 4}) {
+		t.Fatalf("expected the partial frame dropped, got %v", split)
+	}
+}
+
+func TestWAVErrorCodes(t *testing.T) {
+	valid := WriteWAV(make([]float64, 100), 16000)
+	fmtChunk := valid[12 : 12+8+16]
+	notRIFF := slices.Clone(valid)
+	copy(notRIFF, "RIFX")
+	overrun := riffChunk("LIST", make([]byte, 4), true)
+	binary.LittleEndian.PutUint32(overrun[4:], 1000)
+	adpcm := slices.Clone(valid)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+	pcm12 := slices.Clone(valid)
+	binary.LittleEndian.PutUint16(pcm12[34:36], 12)
+
+	for name, tc := range map[string]struct {
+		data []byte
+		want WAVErrorCode
+	}{
+		"too short": {[]byte("RIF"), ErrNotRIFF},
+		"not RIFF":  {notRIFF, ErrNotRIFF},
+		"ADPCM":     {adpcm, ErrUnsupportedFormat},
+		"12-bit":    {pcm12, ErrUnsupportedBits},
+		"no data":   {riffFile(fmtChunk), ErrNoData},
+		"no fmt":    {riffFile(riffChunk("data", make([]byte, 20), true)), ErrMalformed},
+		"overrun":   {riffFile(overrun, fmtChunk), ErrMalformed},
+	} {
+		_, _, readErr := ReadWAV(tc.data)
+		_, _, fromErr := DecodeWAVFrom(bytes.NewReader(tc.data), int64(len(tc.data)))
+		for _, err := range []error{readErr, fromErr} {
+			var werr *WAVError
+			if !errors.As(err, &werr) {
+				t.Fatalf("%s: expected a *WAVError, got %v", name, err)
+			}
+			if werr.Code != tc.want {
+				t.Fatalf("%s: expected code %d, got %d (%v)", name, tc.want, werr.Code, err)
+			}
+		}
+	}
+}
+
+func TestExtraChunksSurviveEncoding(t *testing.T) {
+	plain := WriteWAV(pseudoNoise(1000, 3, 0.5), 48000)
+	bext := []byte("Description and origination time")
+	ixml := []byte("<BWFXML/>") // odd length
+	list := []byte("INFOISFT\x04\x00\x00\x00test")
+	data := riffFile(
+		plain[12:36], // fmt
+		riffChunk("bext", bext, true),
+		riffChunk("JUNK", make([]byte, 32), true),
+		riffChunk("iXML", ixml, true),
+		plain[36:], // data
+		riffChunk("LIST", list, true),
+	)
+
+	header, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ExtraChunks{Chunks: []WAVChunk{{"bext", bext}, {"iXML", ixml}, {"LIST", list}}}
+	if !reflect.DeepEqual(header.Extra, want) {
+		t.Fatalf("got extra chunks %+v, want %+v", header.Extra, want)
+	}
+
+	samples, _, err := ReadWAV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := EncodeWAV(samples, *header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ValidateWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Extra, want) {
+		t.Fatalf("re-encoded extra chunks %+v, want %+v", got.Extra, want)
+	}
+	// They go ahead of the samples, where readers that stop at the data
+	// chunk find them.
+	if bytes.Index(out, []byte("bext")) > bytes.Index(out, []byte("data")) {
+		t.Fatal("extra chunks written after the data chunk")
+	}
+	if decoded, _, err := ReadWAV(out); err != nil || !slices.Equal(decoded, samples) {
+		t.Fatalf("samples changed (err %v)", err)
+	}
+	if size := encodedWAVSize(len(samples), *header, PCM16); size != len(out) {
+		t.Fatalf("encodedWAVSize %d for a %d-byte file", size, len(out))
+	}
+}
+
+func TestLoopPointsSurviveDenoising(t *testing.T) {
+	const sampleRate = 16000
+	header := WAVHeader{
+		SampleRate:    sampleRate,
+		NumChannels:   1,
+		BitsPerSample: 16,
+		Sampler: &SamplerInfo{
+			SamplePeriod:  1e9 / sampleRate,
+			MIDIUnityNote: 69,
+			Loops:         []SampleLoop{{CueID: 1, Start: 12000, End: 27999}},
+			Data:          []byte{1, 2, 3},
+		},
+		Cue: &CueList{Points: []CuePoint{{ID: 1, Position: 12000, SampleOffset: 12000}}},
+	}
+	data, err := EncodeWAV(SyntheticNoisyTone(2*sampleRate, sampleRate), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ValidateWAV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, _, err := ReadWAV(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, DefaultDenoiseConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := EncodeWAV(cleaned, *read)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ValidateWAV(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Sampler, header.Sampler) || !reflect.DeepEqual(got.Cue, header.Cue) {
+		t.Fatalf("loop metadata changed:\n got %+v %+v\nwant %+v %+v", got.Sampler, got.Cue, header.Sampler, header.Cue)
+	}
+	if size := binary.LittleEndian.Uint32(out[4:8]); int(size) != len(out)-8 {
+		t.Fatalf("RIFF size %d for a %d-byte file", size, len(out))
+	}
+	if got, _, err := ReadWAV(out); err != nil || len(got) != len(samples) {
+		t.Fatalf("re-read %d samples (err %v), want %d", len(got), err, len(samples))
+	}
+}
+
+func TestRepairWAVSizes(t *testing.T) {
+	samples := []float64{0.5, -0.25, 0.125, -1, 0.75}
+	good := WriteWAV(samples, 16000)
+	list := riffChunk("LIST", []byte("INFOtest"), true)
+	withList := riffFile(riffChunk("fmt ", good[20:36], true), riffChunk("data", good[44:], true), list)
+
+	for name, tc := range map[string]struct {
+		file     []byte
+		riffSize uint32
+		dataSize uint32
+		want     []byte
+	}{
+		"streaming":   {good, 0xFFFFFFFF, 0xFFFFFFFF, good},
+		"zero":        {good, 0, 0, good},
+		"wrong riff":  {good, 12345, 10, good},
+		"chunk after": {withList, 0, 10, withList},
+		// A trailing partial sample is dropped.
+		"partial frame": {append(slices.Clone(good), 0x7f), 0, 0xFFFFFFFF, good},
+	} {
+		broken := slices.Clone(tc.file)
+		binary.LittleEndian.PutUint32(broken[4:8], tc.riffSize)
+		binary.LittleEndian.PutUint32(broken[40:44], tc.dataSize)
+
+		repaired, err := RepairWAVSizes(broken)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(repaired, tc.want) {
+			t.Fatalf("%s: repaired file differs:\n got % x\nwant % x", name, repaired, tc.want)
+		}
+		got, _, err := ReadWAV(repaired)
+		want, _, _ := ReadWAV(tc.want)
+		if err != nil || !slices.Equal(got, want) {
+			t.Fatalf("%s: repaired file decodes to %v (err %v), want %v", name, got, err, want)
+		}
+	}
+
+	if _, err := RepairWAVSizes([]byte("RIFF\x00\x00\x00\x00WAVE")); err == nil {
+		t.Error("expected an error for a file with no chunks")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// wavReaderBlock is how many bytes of samples a WAVReader decodes at a time.
+const wavReaderBlock = 64 * 1024
+
+// WAVReader decodes a WAV file from an io.Reader as it arrives, without
+// holding the file in memory. It accepts exactly the files ReadWAV does.
+type WAVReader struct {
+	r      *bufio.Reader
+	header *WAVHeader
+	// meta is the file's chunks up to the data chunk, as gathered for
+	// scanWAV, which the chunks after the samples are later added to.
+	meta      []byte
+	dataSize  int64 // declared size of the data chunk
+	remaining int64 // bytes of the data chunk not yet read
+	buf       []byte
+	pending   []float64 // decoded samples not yet returned
+	done      bool
+}
+
+// NewWAVReader reads the chunks of a WAV file from r up to the start of the
+// samples and returns a WAVReader for them. The error for a file ReadWAV
+// would reject is the same *WAVError.
+func NewWAVReader(r io.Reader) (*WAVReader, error) {
+	wr := &WAVReader{r: bufio.NewReader(r), meta: make([]byte, 12, 64)}
+	if _, err := io.ReadFull(wr.r, wr.meta); err != nil {
+		return nil, wavError(ErrNotRIFF, "file too short")
+	}
+	var werr *WAVError
+	if _, _, err := scanWAV(wr.meta); errors.As(err, &werr) && werr.Code == ErrNotRIFF {
+		return nil, err
+	}
+
+	// Gather the chunks scanWAV reads into a minimal file, as scanWAVAt
+	// does, so both accept the same files. Padding is left out, as every
+	// chunk is followed by a chunk ID there.
+	rf64 := isRF64(wr.meta[0:4])
+	dataSize64 := int64(-1)
+	haveFmt := false
+	var held []byte // samples found ahead of the fmt chunk
+	var heldChunk []byte
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(wr.r, chunk[:]); err != nil {
+			break // scanWAV reports what is missing
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if id == "data" && size == rf64SizePlaceholder && dataSize64 >= 0 {
+			size = dataSize64
+		}
+		if id == "data" && !haveFmt {
+			// The samples cannot be decoded before the fmt chunk is
+			// found, so hold them in memory and read on.
+			body, err := wr.readChunk(size, size)
+			held, heldChunk = body, slices.Clone(chunk[:])
+			if err != nil {
+				break // truncated data chunk
+			}
+			continue
+		}
+		if id == "data" {
+			wr.meta = append(wr.meta, chunk[:]...)
+			header, _, err := scanWAV(wr.meta)
+			if err != nil {
+				return nil, err
+			}
+			wr.header, wr.dataSize, wr.remaining = header, size, size
+			return wr, nil
+		}
+
+		keep := size
+		switch {
+		case id == "fmt ":
+			keep = min(size, extensibleFmtSize) // as much as scanWAV reads
+		case id == "ds64" && rf64, id == "smpl", id == "cue ", isExtraChunk(chunk[:4]):
+			// kept whole
+		default:
+			keep = 0
+		}
+		body, err := wr.readChunk(size, keep)
+		if err != nil {
+			// The chunk overruns the file, which scanWAV ignores once the
+			// fmt and data chunks are found and reports otherwise: keep
+			// its declared size for it to do so.
+			if held == nil || !haveFmt {
+				wr.meta = binary.LittleEndian.AppendUint32(append(wr.meta, id...), uint32(size))
+				wr.meta = append(wr.meta, body...)
+			}
+			break
+		}
+		wr.meta = binary.LittleEndian.AppendUint32(append(wr.meta, id...), uint32(keep))
+		wr.meta = append(wr.meta, body...)
+		if id == "ds64" && rf64 && len(body) >= ds64Size {
+			dataSize64 = ds64DataSize(body)
+		}
+		haveFmt = haveFmt || id == "fmt "
+	}
+
+	// The data chunk goes last, as in scanWAVAt; scanWAV accepts it holding
+	// none of its declared size.
+	wr.meta = append(wr.meta, heldChunk...)
+	header, _, err := scanWAV(wr.meta)
+	if err != nil {
+		return nil, err
+	}
+	wr.r = bufio.NewReader(bytes.NewReader(held))
+	wr.header, wr.remaining = header, int64(len(held))
+	return wr, nil
+}
+
+// readChunk reads a chunk body of size bytes, returning the first keep of
+// them and discarding the rest along with any padding byte. It returns
+// io.ErrUnexpectedEOF, with what was read, if the input ends first.
+func (wr *WAVReader) readChunk(size, keep int64) ([]byte, error) {
+	body := make([]byte, keep)
+	n, err := io.ReadFull(wr.r, body)
+	if err != nil {
+		return body[:n], io.ErrUnexpectedEOF
+	}
+	if skipped, _ := io.CopyN(io.Discard, wr.r, size-keep); skipped < size-keep {
+		return body, io.ErrUnexpectedEOF
+	}
+	wr.skipPadding(size)
+	return body, nil
+}
+
+// skipPadding skips the padding byte after a chunk of the given size, if
+// any. Like scanWAV, it follows writers that omit it when the next chunk ID
+// is found unpadded.
+func (wr *WAVReader) skipPadding(size int64) {
+	if size%2 == 0 {
+		return
+	}
+	next, _ := wr.r.Peek(5)
+	if !(isChunkID(next, 0) && !isChunkID(next, 1)) {
+		wr.r.Discard(1)
+	}
+}
+
+// Header returns the header of the file. The smpl, cue and other metadata
+// chunks after the samples are only included once Read has returned
+// io.EOF.
+func (wr *WAVReader) Header() *WAVHeader {
+	return wr.header
+}
+
+// Read decodes up to len(samples) samples into samples, interleaved as in
+// the file, and returns how many it decoded. At the end of the samples it
+// returns 0, io.EOF. A data chunk cut short by the end of the file ends
+// the samples early, as for ReadWAV.
+func (wr *WAVReader) Read(samples []float64) (int, error) {
+	n := 0
+	for n < len(samples) {
+		if len(wr.pending) == 0 {
+			if err := wr.fill(); err != nil {
+				if n > 0 && err == io.EOF {
+					return n, nil
+				}
+				return n, err
+			}
+		}
+		c := copy(samples[n:], wr.pending)
+		wr.pending = wr.pending[c:]
+		n += c
+	}
+	return n, nil
+}
+
+// ReadAll reads the rest of the samples, interleaved as in the file.
+func (wr *WAVReader) ReadAll() ([]float64, error) {
+	var all []float64
+	for {
+		if err := wr.fill(); err == io.EOF {
+			return all, nil
+		} else if err != nil {
+			return nil, err
+		}
+		all = append(all, wr.pending...)
+		wr.pending = nil
+	}
+}
+
+// fill decodes the next block of the data chunk into pending, returning
+// io.EOF once there is none left.
+func (wr *WAVReader) fill() error {
+	if wr.done {
+		return io.EOF
+	}
+	unit := int64(wr.header.decodeUnit())
+	if wr.buf == nil {
+		wr.buf = make([]byte, max(wavReaderBlock-wavReaderBlock%unit, unit))
+	}
+	for len(wr.pending) == 0 {
+		want := min(int64(len(wr.buf)), wr.remaining)
+		want -= want % unit
+		if want == 0 {
+			wr.finish()
+			return io.EOF
+		}
+		n, err := io.ReadFull(wr.r, wr.buf[:want])
+		wr.remaining -= int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			wr.remaining = 0 // truncated data chunk
+		} else if err != nil {
+			return fmt.Errorf("wav: reading data chunk: %w", err)
+		}
+		wr.pending = decodeSamples(wr.buf[:int64(n)-int64(n)%unit], wr.header)
+	}
+	return nil
+}
+
+// finish reads the chunks after the samples and adds their metadata to the
+// header. Like scanWAV, it ignores chunks that are cut short.
+func (wr *WAVReader) finish() {
+	wr.done = true
+	// Skip a trailing partial sample and the data chunk's padding.
+	if _, err := io.CopyN(io.Discard, wr.r, wr.remaining); err != nil {
+		return
+	}
+	wr.skipPadding(wr.dataSize)
+
+	// Rescan the gathered chunks with an empty data chunk, so scanWAV
+	// steps over it to the chunks that follow.
+	binary.LittleEndian.PutUint32(wr.meta[len(wr.meta)-4:], 0)
+	var chunk [8]byte
+	for {
+		if _, err := io.ReadFull(wr.r, chunk[:]); err != nil {
+			break
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		keep := size
+		if id := chunk[:4]; string(id) != "smpl" && string(id) != "cue " && !isExtraChunk(id) {
+			keep = 0
+		}
+		body, err := wr.readChunk(size, keep)
+		if err != nil {
+			break
+		}
+		wr.meta = binary.LittleEndian.AppendUint32(append(wr.meta, chunk[:4]...), uint32(keep))
+		wr.meta = append(wr.meta, body...)
+	}
+	if header, _, err := scanWAV(wr.meta); err == nil {
+		wr.header.Sampler, wr.header.Cue, wr.header.Extra = header.Sampler, header.Cue, header.Extra
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+	"testing/iotest"
+)
+
+// readAllSamples reads r to the end, a block at a time.
+func readAllSamples(t *testing.T, r *WAVReader) []float64 {
+	t.Helper()
+	var all []float64
+	block := make([]float64, 1000)
+	for {
+		n, err := r.Read(block)
+		all = append(all, block[:n]...)
+		if err == io.EOF {
+			return all
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+func TestWAVReaderMatchesReadWAV(t *testing.T) {
+	stereo := make([]float64, 2*50000) // more than one decode block
+	for i := range stereo {
+		stereo[i] = 0.8 * math.Sin(float64(i)/7)
+	}
+	encoded, err := EncodeWAV(stereo, WAVHeader{SampleRate: 32000, NumChannels: 2, BitsPerSample: 16})
+	if err != nil {
+		t.Fatalf("EncodeWAV: %v", err)
+	}
+	plain := WriteWAVFormat(pseudoNoise(1001, 9, 0.5), 44100, PCM32)
+	bext := make([]byte, 603)
+
+	for name, data := range map[string][]byte{
+		"stereo":    encoded,
+		"mono32":    plain,
+		"float":     WriteWAVFormat(pseudoNoise(999, 2, 0.5), 48000, Float32),
+		"adpcm":     imaADPCMWAV(make([]int, 2000), 1, 8000, 256),
+		"unpadded":  riffFile(riffChunk("bext", bext, false), plain[12:]),
+		"fmtLast":   riffFile(riffChunk("data", plain[44:], true), riffChunk("fmt ", plain[20:36], true)),
+		"truncated": plain[:len(plain)-5],
+		"rf64":      rf64WAV(WriteWAV(pseudoNoise(500, 4, 0.5), 8000), "RF64"),
+	} {
+		want, wantSR, err := ReadWAV(data)
+		if err != nil {
+			t.Fatalf("%s: ReadWAV: %v", name, err)
+		}
+		// Byte-at-a-time input stresses the chunk walking.
+		r, err := NewWAVReader(iotest.OneByteReader(bytes.NewReader(data)))
+		if err != nil {
+			t.Fatalf("%s: NewWAVReader: %v", name, err)
+		}
+		got := toMono(readAllSamples(t, r), r.Header())
+		if r.Header().SampleRate != wantSR || !slices.Equal(got, want) {
+			t.Fatalf("%s: WAVReader differs from ReadWAV (%d samples at %d Hz, want %d at %d)", name, len(got), r.Header().SampleRate, len(want), wantSR)
+		}
+	}
+
+	// Both reject the same files with the same error.
+	junk := riffChunk("JUNK", make([]byte, 200), true)
+	binary.LittleEndian.PutUint32(junk[4:], 1<<30)
+	adpcm := slices.Clone(plain)
+	binary.LittleEndian.PutUint16(adpcm[20:22], 2)
+	for name, data := range map[string][]byte{
+		"short":   plain[:8],
+		"notRIFF": append([]byte("RIFX"), plain[4:]...),
+		"overrun": riffFile(junk, plain[12:]),
+		"adpcm":   adpcm,
+		"noData":  plain[:36],
+		"noFmt":   riffFile(plain[36:]),
+	} {
+		_, _, want := ReadWAV(data)
+		_, err := NewWAVReader(bytes.NewReader(data))
+		var werr *WAVError
+		if want == nil || !errors.As(err, &werr) || err.Error() != want.Error() {
+			t.Fatalf("%s: expected error %v, got %v", name, want, err)
+		}
+	}
+}
+
+func TestWAVReaderMetadata(t *testing.T) {
+	header := WAVHeader{
+		SampleRate:    16000,
+		NumChannels:   1,
+		BitsPerSample: 16,
+		Cue:           &CueList{Points: []CuePoint{{ID: 1, Position: 100, SampleOffset: 100}}},
+		Extra:         &ExtraChunks{Chunks: []WAVChunk{{"bext", []byte("origination")}}},
+	}
+	data, err := EncodeWAV(pseudoNoise(1001, 5, 0.5), header) // odd: padded
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewWAVReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Chunks ahead of the samples are known from the start, the cue chunk
+	// after them once they are read.
+	if !reflect.DeepEqual(r.Header().Extra, header.Extra) || r.Header().Cue != nil {
+		t.Fatalf("before reading: got %+v %+v", r.Header().Extra, r.Header().Cue)
+	}
+	readAllSamples(t, r)
+	if !reflect.DeepEqual(r.Header().Extra, header.Extra) || !reflect.DeepEqual(r.Header().Cue, header.Cue) {
+		t.Fatalf("after reading: got %+v %+v", r.Header().Extra, r.Header().Cue)
+	}
+}
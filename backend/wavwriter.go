@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// streamingSizePlaceholder is the size a WAVWriter declares for chunks
+// whose size it does not know yet.
+const streamingSizePlaceholder = 0xFFFFFFFF
+
+// WAVWriter encodes a WAV file to an io.Writer as samples become
+// available, so the start of the file can be sent before the end is
+// known.
+//
+// The header goes out first, with the RIFF and data chunk sizes set to
+// the 0xFFFFFFFF placeholder streaming writers use (ReadWAV reads such a
+// data chunk to the end of the file). If the writer is an io.WriteSeeker,
+// Close goes back and patches in the real sizes, so the file is then
+// byte-for-byte what WriteWAVFormat would have produced.
+type WAVWriter struct {
+	w           io.Writer
+	format      SampleFormat
+	numChannels int
+	start       int64 // offset of the file in w, if w is seekable
+	seekable    bool
+	written     int64 // samples written
+	declared    int64 // samples the header declares, or -1 for placeholders
+	closed      bool
+}
+
+// NewWAVWriter writes the header of a WAV file of numChannels interleaved
+// channels in the given sample format to w, and returns a WAVWriter for
+// its samples.
+func NewWAVWriter(w io.Writer, sampleRate, numChannels int, format SampleFormat) (*WAVWriter, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("wav: invalid sample rate %d", sampleRate)
+	}
+	if numChannels < 1 {
+		return nil, fmt.Errorf("wav: invalid channel count %d", numChannels)
+	}
+	ww := &WAVWriter{w: w, format: format, numChannels: numChannels, declared: -1}
+	if s, ok := w.(io.WriteSeeker); ok {
+		if start, err := s.Seek(0, io.SeekCurrent); err == nil {
+			ww.start, ww.seekable = start, true
+		}
+	}
+	if err := writeWAVHeader(w, streamingSizePlaceholder, streamingSizePlaceholder, streamingSizePlaceholder, sampleRate, numChannels, format); err != nil {
+		return nil, err
+	}
+	return ww, nil
+}
+
+// NewWAVWriterSize is NewWAVWriter for a file whose length is known in
+// advance: the header declares numSamples samples (across all channels),
+// so the file is what WriteWAVFormat would produce even on a writer that
+// cannot seek, such as an HTTP response. Close reports an error if a
+// different number of samples was written.
+func NewWAVWriterSize(w io.Writer, sampleRate, numChannels int, format SampleFormat, numSamples int) (*WAVWriter, error) {
+	if sampleRate <= 0 {
+		return nil, fmt.Errorf("wav: invalid sample rate %d", sampleRate)
+	}
+	if numChannels < 1 {
+		return nil, fmt.Errorf("wav: invalid channel count %d", numChannels)
+	}
+	if numSamples < 0 || numSamples%numChannels != 0 {
+		return nil, fmt.Errorf("wav: %d samples do not divide into %d channels", numSamples, numChannels)
+	}
+	dataSize := numSamples * format.BitsPerSample() / 8
+	fileSize := wavSize(numSamples, format) - 8
+	if err := writeWAVHeader(w, uint32(fileSize), uint32(dataSize), uint32(numSamples/numChannels), sampleRate, numChannels, format); err != nil {
+		return nil, err
+	}
+	return &WAVWriter{w: w, format: format, numChannels: numChannels, declared: int64(numSamples)}, nil
+}
+
+// Write encodes samples, interleaved, and writes them out.
+func (ww *WAVWriter) Write(samples []float64) error {
+	if ww.closed {
+		return fmt.Errorf("wav: write to closed WAVWriter")
+	}
+	if err := writeSamples(ww.w, samples, ww.format); err != nil {
+		return err
+	}
+	ww.written += int64(len(samples))
+	return nil
+}
+
+// Close finishes the file: on a seekable writer it patches the sizes into
+// the header, leaving the writer positioned at the end of the file. It
+// does not close the underlying writer. Files too large for 32-bit sizes
+// keep the placeholders.
+func (ww *WAVWriter) Close() error {
+	if ww.closed {
+		return nil
+	}
+	ww.closed = true
+	if ww.declared >= 0 {
+		if ww.written != ww.declared {
+			return fmt.Errorf("wav: wrote %d samples, header declares %d", ww.written, ww.declared)
+		}
+		return nil
+	}
+	dataSize := ww.written * int64(ww.format.BitsPerSample()/8)
+	fileSize := int64(ww.format.headerSize()) + dataSize - 8
+	if !ww.seekable || fileSize >= math.MaxUint32 {
+		return nil
+	}
+
+	s := ww.w.(io.WriteSeeker)
+	type patch struct {
+		off   int
+		value uint32
+	}
+	patches := []patch{
+		{4, uint32(fileSize)},
+		{ww.format.headerSize() - 4, uint32(dataSize)},
+	}
+	if ww.format.isFloat() {
+		frames := uint32(ww.written / int64(ww.numChannels))
+		patches = append(patches, patch{ww.format.headerSize() - 12, frames}) // fact chunk
+	}
+	for _, p := range patches {
+		if _, err := s.Seek(ww.start+int64(p.off), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := s.Write(binary.LittleEndian.AppendUint32(nil, p.value)); err != nil {
+			return err
+		}
+	}
+	_, err := s.Seek(ww.start+8+fileSize, io.SeekStart)
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestWAVWriterSeekableMatchesEncodeWAV(t *testing.T) {
+	samples := pseudoNoise(2*30001, 6, 0.7) // odd frame count, several blocks
+	prefix := []byte("prefix")
+	for _, format := range []SampleFormat{PCM8, PCM16, PCM24, PCM32, Float32} {
+		f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		f.Write(prefix) // the file need not start at offset 0
+
+		w, err := NewWAVWriter(f, 22050, 2, format)
+		if err != nil {
+			t.Fatalf("format %d: NewWAVWriter: %v", format, err)
+		}
+		for chunk := range slices.Chunk(samples, 7000) {
+			if err := w.Write(chunk); err != nil {
+				t.Fatalf("format %d: Write: %v", format, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("format %d: Close: %v", format, err)
+		}
+		// Writing continues at the end of the file.
+		f.Write([]byte("x"))
+
+		got, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append(append(slices.Clone(prefix), encodeWAV(samples, 22050, 2, format)...), 'x')
+		if !bytes.Equal(got, want) {
+			t.Fatalf("format %d: WAVWriter output differs from encodeWAV", format)
+		}
+	}
+}
+
+func TestWAVWriterStreamingHeader(t *testing.T) {
+	samples := pseudoNoise(10000, 8, 0.5)
+	var buf bytes.Buffer
+	w, err := NewWAVWriter(&buf, 16000, 1, PCM16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header goes out before any samples.
+	if buf.Len() != PCM16.headerSize() {
+		t.Fatalf("expected a %d-byte header up front, got %d bytes", PCM16.headerSize(), buf.Len())
+	}
+	if err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(samples); err == nil {
+		t.Fatal("expected an error writing after Close")
+	}
+
+	// Without seeking, the sizes stay placeholders, which readers take as
+	// running to the end of the file.
+	data := buf.Bytes()
+	if size := binary.LittleEndian.Uint32(data[40:44]); size != 0xFFFFFFFF {
+		t.Fatalf("data size %#x, want the 0xFFFFFFFF placeholder", size)
+	}
+	want, _, err := ReadWAV(WriteWAV(samples, 16000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, sr, err := ReadWAV(data)
+	if err != nil || sr != 16000 || !slices.Equal(got, want) {
+		t.Fatalf("ReadWAV: got %d samples at %d Hz (err %v), want %d", len(got), sr, err, len(want))
+	}
+	r, err := NewWAVReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streamed, err := r.ReadAll(); err != nil || !slices.Equal(streamed, want) {
+		t.Fatalf("WAVReader: got %d samples (err %v), want %d", len(streamed), err, len(want))
+	}
+	if repaired, err := RepairWAVSizes(data); err != nil || !bytes.Equal(repaired, WriteWAV(samples, 16000)) {
+		t.Fatalf("RepairWAVSizes did not restore the sizes (err %v)", err)
+	}
+
+	if _, err := NewWAVWriter(io.Discard, 0, 1, PCM16); err == nil {
+		t.Fatal("expected an error for a zero sample rate")
+	}
+}
+
+func TestWAVWriterSize(t *testing.T) {
+	samples := pseudoNoise(2*5001, 9, 0.7)
+	for _, format := range []SampleFormat{PCM16, PCM24, Float32} {
+		var buf bytes.Buffer
+		w, err := NewWAVWriterSize(&buf, 44100, 2, format, len(samples))
+		if err != nil {
+			t.Fatalf("format %d: NewWAVWriterSize: %v", format, err)
+		}
+		for chunk := range slices.Chunk(samples, 3000) {
+			if err := w.Write(chunk); err != nil {
+				t.Fatalf("format %d: Write: %v", format, err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("format %d: Close: %v", format, err)
+		}
+		// Without seeking, the declared sizes are the real ones.
+		if !bytes.Equal(buf.Bytes(), encodeWAV(samples, 44100, 2, format)) {
+			t.Fatalf("format %d: output differs from encodeWAV", format)
+		}
+	}
+
+	w, err := NewWAVWriterSize(io.Discard, 16000, 1, PCM16, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]float64, 99))
+	if err := w.Close(); err == nil {
+		t.Fatal("expected an error for fewer samples than declared")
+	}
+	if _, err := NewWAVWriterSize(io.Discard, 16000, 2, PCM16, 101); err == nil {
+		t.Fatal("expected an error for a partial frame")
+	}
+}
@@ -1,6 +1,35 @@
 package main
 
-import "math"
+import (
+	"fmt"
+	"math"
+)
+
+// Window names accepted by DenoiseConfig.Window.
+const (
+	WindowHann  = "hann"
+	WindowTukey = "tukey"
+)
+
+// windowRegistry maps a window name to its constructor. Parameterized
+// windows (e.g. Tukey) read their extra parameters from the config.
+var windowRegistry = map[string]func(n int, cfg DenoiseConfig) []float64{
+	WindowHann: func(n int, _ DenoiseConfig) []float64 {
+		return HannWindow(n)
+	},
+	WindowTukey: func(n int, cfg DenoiseConfig) []float64 {
+		return TukeyWindow(n, cfg.TukeyAlpha)
+	},
+}
+
+// NewWindow builds the named window of length n using any parameters in cfg.
+func NewWindow(name string, n int, cfg DenoiseConfig) ([]float64, error) {
+	build, ok := windowRegistry[name]
+	if !ok {
+		return nil, fmt.Errorf("window: unknown window %q", name)
+	}
+	return build(n, cfg), nil
+}
 
 // HannWindow returns a Hann (raised-cosine) window of length n.
 //
@@ -8,13 +37,55 @@ import "math"
 //
 // When used with 50% overlap, adjacent Hann windows sum to 1.0 (COLA property),
 // enabling artifact-free overlap-add reconstruction.
+//
+// The symmetric formula is all zeros at n == 2, so that size uses the
+// periodic form (denominator n), giving [0, 1]. Frames need at least 4
+// samples to be useful for denoising (see DenoiseConfig.Validate).
 func HannWindow(n int) []float64 {
 	if n <= 1 {
 		return []float64{1.0}
 	}
+	if n == 2 {
+		return []float64{0, 1}
+	}
 	w := make([]float64, n)
 	for i := 0; i < n; i++ {
 		w[i] = 0.5 * (1 - math.Cos(2*math.Pi*float64(i)/float64(n-1)))
 	}
 	return w
 }
+
+// TukeyWindow returns a Tukey (tapered cosine) window of length n.
+// alpha is the fraction of the window inside the cosine tapers: the first
+// and last alpha/2 of the window follow a raised cosine, the interior is 1.0.
+// alpha <= 0 yields a rectangular window and alpha >= 1 yields a Hann window.
+func TukeyWindow(n int, alpha float64) []float64 {
+	if n <= 1 {
+		return []float64{1.0}
+	}
+	if alpha >= 1 {
+		return HannWindow(n)
+	}
+	w := make([]float64, n)
+	for i := 0; i < n; i++ {
+		w[i] = 1.0
+	}
+	if alpha <= 0 {
+		return w
+	}
+	if n == 2 {
+		return HannWindow(n) // the tapers would cover both samples
+	}
+
+	edge := alpha * float64(n-1) / 2 // taper length in samples
+	for i := 0; i < n; i++ {
+		x := float64(i)
+		switch {
+		case x < edge:
+			w[i] = 0.5 * (1 + math.Cos(math.Pi*(x/edge-1)))
+		case x > float64(n-1)-edge:
+			w[i] = 0.5 * (1 + math.Cos(math.Pi*((float64(n-1)-x)/edge-1)))
+		}
+	}
+	return w
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTukeyWindowShape(t *testing.T) {
+	n := 1024
+	alpha := 0.5
+	w := TukeyWindow(n, alpha)
+	if len(w) != n {
+		t.Fatalf("expected %d samples, got %d", n, len(w))
+	}
+
+	edge := alpha * float64(n-1) / 2
+
+	for i := 0; i < n; i++ {
+		x := float64(i)
+		var want float64
+		switch {
+		case x < edge:
+			want = 0.5 * (1 - math.Cos(math.Pi*x/edge))
+		case x > float64(n-1)-edge:
+			want = 0.5 * (1 - math.Cos(math.Pi*(float64(n-1)-x)/edge))
+		default:
+			want = 1.0
+		}
+		if math.Abs(w[i]-want) > 1e-12 {
+			t.Fatalf("sample %d: expected %.12f, got %.12f", i, want, w[i])
+		}
+	}
+
+	// Interior is flat.
+	for i := int(math.Ceil(edge)); i <= n-1-int(math.Ceil(edge)); i++ {
+		if w[i] != 1.0 {
+			t.Fatalf("interior sample %d: expected 1.0, got %f", i, w[i])
+		}
+	}
+	if w[0] != 0 || w[n-1] != 0 {
+		t.Fatalf("expected zero endpoints, got %f and %f", w[0], w[n-1])
+	}
+}
+
+func TestTukeyWindowLimits(t *testing.T) {
+	n := 64
+	rect := TukeyWindow(n, 0)
+	for i, v := range rect {
+		if v != 1.0 {
+			t.Fatalf("alpha=0 sample %d: expected 1.0, got %f", i, v)
+		}
+	}
+
+	hann := HannWindow(n)
+	tukey := TukeyWindow(n, 1)
+	for i := range hann {
+		if math.Abs(hann[i]-tukey[i]) > 1e-12 {
+			t.Fatalf("alpha=1 sample %d: expected %f, got %f", i, hann[i], tukey[i])
+		}
+	}
+}
+
+func TestDenoiseWithTukeyWindow(t *testing.T) {
+	sampleRate := 44100
+	n := sampleRate
+
+	samples := make([]float64, n)
+	for i := sampleRate / 4; i < n; i++ {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate))
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.Window = WindowTukey
+	cfg.TukeyAlpha = 0.5
+
+	cleaned, err := DenoiseWithConfig(samples, sampleRate, cfg)
+	if err != nil {
+		t.Fatalf("DenoiseWithConfig: %v", err)
+	}
+	if len(cleaned) != n {
+		t.Fatalf("length mismatch: input=%d, cleaned=%d", n, len(cleaned))
+	}
+
+	cfg.Window = "nope"
+	if _, err := DenoiseWithConfig(samples, sampleRate, cfg); err == nil {
+		t.Fatal("expected error for unknown window")
+	}
+}
+
+func TestHannWindowSmallN(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		peak []int // indices holding the maximum
+	}{
+		{1, []int{0}},
+		{2, []int{1}},
+		{3, []int{1}},
+		{4, []int{1, 2}},
+	} {
+		w := HannWindow(tc.n)
+		if len(w) != tc.n {
+			t.Fatalf("n=%d: got %d samples", tc.n, len(w))
+		}
+		var max float64
+		for _, v := range w {
+			max = math.Max(max, v)
+		}
+		if max == 0 {
+			t.Fatalf("n=%d: window is all zeros", tc.n)
+		}
+		for _, i := range tc.peak {
+			if math.Abs(w[i]-max) > 1e-12 {
+				t.Fatalf("n=%d: expected peak at %v, got %v", tc.n, tc.peak, w)
+			}
+		}
+		if tukey := TukeyWindow(tc.n, 0.5); tukey[tc.peak[0]] == 0 {
+			t.Fatalf("n=%d: Tukey window is degenerate: %v", tc.n, tukey)
+		}
+	}
+
+	cfg := DefaultDenoiseConfig()
+	cfg.FrameSize, cfg.HopSize = 2, 1
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected frame size 2 to be rejected")
+	}
+}